@@ -16,7 +16,7 @@ func TestErrorReturnedWhenErrorDuringAttach(t *testing.T) {
 	mockEC2Service := testhelpers.NewMockEC2Service()
 
 	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
-		testhelpers.NewDescribeTagsOutputBuilder().DetachVolumes(instanceID).WithVolume("/dev/sda", instanceID, "vol-1234567").Build())
+		testhelpers.NewDescribeTagsOutputBuilder().DetachVolumes(instanceID).WithVolume("/dev/sda", instanceID, "vol-12345678").Build())
 
 	mockEC2Service.DescribeVolumesFunc = func(*ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
 		return nil, errors.New("Whoops")
@@ -15,6 +15,9 @@ var commandtests = []struct {
 	{infoCmd, "infoCmd"},
 	{detachCmd, "detachCmd"},
 	{attachCmd, "attachCmd"},
+	{modifyCmd, "modifyCmd"},
+	{validateCmd, "validateCmd"},
+	{renewCmd, "renewCmd"},
 }
 
 func TestCommandErrorsWhenNoInstanceFound(t *testing.T) {
@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultConfigFile is read when present, unless another config file is given
+var defaultConfigFile = "/etc/ebs-volumes/config.yaml"
+
+// envPrefix prefixes the name of environment variables giving settings, such as EBS_VOLUMES_REGION
+const envPrefix = "EBS_VOLUMES_"
+
+// configSettings names the flags which can also be set in the environment or the config file
+var configSettings = []string{
+	"region",
+	"instance-id",
+	"tag-source",
+	"tag-prefix",
+	"manifest",
+	"match-policy",
+	"imds-v1-fallback",
+	"metadata-timeout",
+	"imds-endpoint",
+	"endpoint-url",
+	"fips",
+	"proxy",
+	"ca-bundle",
+	"role-arn",
+	"external-id",
+	"role-session-name",
+	"retries",
+	"modify-timeout",
+	"lease-duration",
+	"authorization",
+	"require-encryption",
+	"kms-key-ids",
+	"audit",
+	"parallelism",
+	"verbose",
+	"log-level",
+	"log-format",
+	"debug-aws",
+}
+
+// Where the value of a setting came from, in order of precedence
+const (
+	sourceFlag        = "flag"
+	sourceEnvironment = "environment"
+	sourceConfigFile  = "config file"
+	sourceDefault     = "default"
+)
+
+var configFile string
+
+// effectiveConfig is the configuration resolved before running a command
+var effectiveConfig = &config{}
+
+// configuredVolumes allocates volumes keyed by device name, taking precedence over tags
+var configuredVolumes map[string]string
+
+type setting struct {
+	name   string
+	value  string
+	source string
+}
+
+type config struct {
+	file     string
+	found    bool
+	err      error
+	settings []setting
+	volumes  map[string]string
+}
+
+// fileConfig is the content of a config file
+type fileConfig struct {
+	Volumes  map[string]string      `yaml:"volumes"`
+	Settings map[string]interface{} `yaml:",inline"`
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Configuration",
+	Long: `Settings are taken from flags, then EBS_VOLUMES_ environment variables, then the config file, and defaults otherwise.
+The config file is YAML, read from ` + defaultConfigFile + ` when present or from --config, for example
+
+	region: eu-west-1
+	tag-prefix: ebs-volumes:
+	retries: 5
+	modify-timeout: 45m
+	kms-key-ids:
+	  - 1234abcd-12ab-34cd-56ef-1234567890ab
+	volumes:
+	  /dev/sdg: vol-049df61146c4d7901
+
+Volumes in the config file take precedence over volumes allocated to the same device by tags`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective configuration and where each setting came from",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		effectiveConfig.write(cmd.OutOrStdout())
+		return nil
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+}
+
+// configureCommand resolves the configuration of a command from its flags, the environment and the config file
+func configureCommand(cmd *cobra.Command) error {
+
+	// The version is shown whatever the configuration
+	if cmd == versionCmd {
+		return nil
+	}
+
+	flags := pflag.NewFlagSet(cmd.Name(), pflag.ContinueOnError)
+	flags.AddFlagSet(cmd.Flags())
+	flags.AddFlagSet(fleetCmd.PersistentFlags())
+
+	c, err := resolveConfig(flags, os.LookupEnv)
+
+	// config show reports a config file which can't be used rather than failing, so the problem can be seen
+	if err != nil && (cmd != configShowCmd || c == nil) {
+		return err
+	}
+
+	effectiveConfig, configuredVolumes = c, c.volumes
+
+	return nil
+}
+
+// resolveConfig sets each setting not given as a flag from the environment or the config file,
+// recording where each value came from. When the config file can't be used the settings are resolved
+// without it, returning the config along with the error.
+func resolveConfig(flags *pflag.FlagSet, lookupEnv func(string) (string, bool)) (*config, error) {
+
+	c := &config{file: defaultConfigFile}
+	required := false
+
+	if flag := flags.Lookup("config"); flag != nil && flag.Changed {
+		c.file, required = flag.Value.String(), true
+	} else if file, ok := lookupEnv(envName("config")); ok && file != "" {
+		c.file, required = file, true
+	}
+
+	file, err := readConfigFile(c.file, required)
+
+	c.found, c.err = file != nil, err
+
+	if file == nil {
+		file = &fileConfig{}
+	}
+
+	for _, name := range configSettings {
+
+		flag := flags.Lookup(name)
+
+		if flag == nil {
+			continue
+		}
+
+		s := setting{name: name, value: flag.Value.String(), source: sourceDefault}
+
+		if flag.Changed {
+			s.source = sourceFlag
+		} else if value, ok := lookupEnv(envName(name)); ok {
+			s.value, s.source = value, sourceEnvironment
+		} else if value, ok := file.Settings[name]; ok {
+			if s.value, err = fileSettingValue(flag, value); err != nil {
+				return nil, fmt.Errorf("setting '%s' in config file (%s) %v", name, c.file, err)
+			}
+			s.source = sourceConfigFile
+		}
+
+		if s.source != sourceDefault && s.source != sourceFlag {
+			if err := flag.Value.Set(s.value); err != nil {
+				return nil, fmt.Errorf("invalid value '%s' for %s from the %s : %v", s.value, name, s.source, err)
+			}
+			s.value = flag.Value.String()
+		}
+
+		c.settings = append(c.settings, s)
+	}
+
+	c.volumes = file.Volumes
+
+	return c, c.err
+}
+
+// readConfigFile reads a config file, returning nil when it doesn't exist and isn't required
+func readConfigFile(path string, required bool) (*fileConfig, error) {
+
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) && !required {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file (%s) : %v", path, err)
+	}
+
+	file := &fileConfig{}
+
+	if err := yaml.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("unable to parse config file (%s) : %v", path, err)
+	}
+
+	known := make(map[string]bool)
+	for _, name := range configSettings {
+		known[name] = true
+	}
+
+	for name, value := range file.Settings {
+
+		if !known[name] {
+			return nil, fmt.Errorf("unknown setting '%s' in config file (%s)", name, path)
+		}
+
+		items, ok := value.([]interface{})
+
+		if !ok {
+			items = []interface{}{value}
+		}
+
+		for _, item := range items {
+			switch item.(type) {
+			case string, bool, int, float64:
+			default:
+				return nil, fmt.Errorf("setting '%s' in config file (%s) should be a single value or a list of them", name, path)
+			}
+		}
+	}
+
+	return file, nil
+}
+
+// fileSettingValue returns the value of a setting in the config file as it would be given as a flag, joining
+// the items of a list with commas. Only flags taking a list can be given one.
+func fileSettingValue(flag *pflag.Flag, value interface{}) (string, error) {
+
+	items, ok := value.([]interface{})
+
+	if !ok {
+		return fmt.Sprint(value), nil
+	}
+
+	if !strings.HasSuffix(flag.Value.Type(), "Slice") {
+		return "", errors.New("should be a single value")
+	}
+
+	values := make([]string, len(items))
+
+	for i, item := range items {
+		values[i] = fmt.Sprint(item)
+	}
+
+	return strings.Join(values, ","), nil
+}
+
+// envName returns the name of the environment variable giving a setting
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+func (c config) write(w io.Writer) {
+
+	status := "not found"
+	if c.err != nil {
+		status = fmt.Sprintf("not used, %v", c.err)
+	} else if c.found {
+		status = "found"
+	}
+
+	fmt.Fprintf(w, "Config file (%s) : %s\n", c.file, status)
+
+	for _, s := range c.settings {
+
+		source := s.source
+		if source == sourceEnvironment {
+			source = fmt.Sprintf("%s %s", source, envName(s.name))
+		}
+
+		fmt.Fprintf(w, "%s = %s (%s)\n", s.name, s.value, source)
+	}
+
+	var devices []string
+	for device := range c.volumes {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	for _, device := range devices {
+		fmt.Fprintf(w, "volume %s = %s (%s)\n", device, c.volumes[device], sourceConfigFile)
+	}
+}
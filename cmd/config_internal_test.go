@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type configFlags struct {
+	config        string
+	region        string
+	retries       int
+	modifyTimeout time.Duration
+	verbose       bool
+	kmsKeyIDs     []string
+}
+
+func newConfigFlags(target *configFlags) *pflag.FlagSet {
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringVar(&target.config, "config", "", "")
+	flags.StringVar(&target.region, "region", "", "")
+	flags.IntVar(&target.retries, "retries", -1, "")
+	flags.DurationVar(&target.modifyTimeout, "modify-timeout", 30*time.Minute, "")
+	flags.BoolVar(&target.verbose, "verbose", false, "")
+	flags.StringSliceVar(&target.kmsKeyIDs, "kms-key-ids", nil, "")
+
+	return flags
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Unable to write config file : %v", err)
+	}
+
+	return path
+}
+
+func noEnv(string) (string, bool) {
+	return "", false
+}
+
+func settingSource(c *config, name string) string {
+	for _, s := range c.settings {
+		if s.name == name {
+			return s.source
+		}
+	}
+	return ""
+}
+
+func TestResolveConfigPrecedence(t *testing.T) {
+
+	path := writeConfigFile(t, `
+region: from-file
+retries: 5
+modify-timeout: 45m
+verbose: true
+volumes:
+  /dev/sdg: vol-12345678
+`)
+
+	var target configFlags
+	flags := newConfigFlags(&target)
+
+	if err := flags.Parse([]string{"--config", path, "--region", "from-flag"}); err != nil {
+		t.Fatalf("Unable to parse flags : %v", err)
+	}
+
+	env := map[string]string{"EBS_VOLUMES_RETRIES": "7", "EBS_VOLUMES_REGION": "from-env"}
+
+	c, err := resolveConfig(flags, func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+
+	if err != nil {
+		t.Fatalf("Resolving the config shouldn't have failed, but I got %v", err)
+	}
+
+	if target.region != "from-flag" || settingSource(c, "region") != sourceFlag {
+		t.Errorf("Expected the region from the flag, but got %s from %s", target.region, settingSource(c, "region"))
+	}
+
+	if target.retries != 7 || settingSource(c, "retries") != sourceEnvironment {
+		t.Errorf("Expected retries from the environment, but got %d from %s", target.retries, settingSource(c, "retries"))
+	}
+
+	if target.modifyTimeout != 45*time.Minute || !target.verbose || settingSource(c, "verbose") != sourceConfigFile {
+		t.Errorf("Expected the modify timeout and verbose from the config file, but got %v and %v", target.modifyTimeout, target.verbose)
+	}
+
+	if c.volumes["/dev/sdg"] != "vol-12345678" {
+		t.Errorf("Expected the volumes from the config file, but got %v", c.volumes)
+	}
+}
+
+func TestResolveConfigDefaults(t *testing.T) {
+
+	saved := defaultConfigFile
+	defer func() {
+		defaultConfigFile = saved
+	}()
+
+	defaultConfigFile = filepath.Join(t.TempDir(), "missing.yaml")
+
+	var target configFlags
+
+	c, err := resolveConfig(newConfigFlags(&target), noEnv)
+
+	if err != nil {
+		t.Fatalf("A missing default config file should be ignored, but I got %v", err)
+	}
+
+	if c.found || target.retries != -1 || settingSource(c, "retries") != sourceDefault {
+		t.Errorf("Expected the defaults, but got retries of %d from %s", target.retries, settingSource(c, "retries"))
+	}
+}
+
+func TestResolveConfigLists(t *testing.T) {
+
+	for _, content := range []string{`
+kms-key-ids: 1234abcd-12ab-34cd-56ef-1234567890ab,arn:aws:kms:eu-west-1:123456789012:key/5678efgh-12ab-34cd-56ef-1234567890ab
+`, `
+kms-key-ids:
+  - 1234abcd-12ab-34cd-56ef-1234567890ab
+  - arn:aws:kms:eu-west-1:123456789012:key/5678efgh-12ab-34cd-56ef-1234567890ab
+`} {
+
+		var target configFlags
+		flags := newConfigFlags(&target)
+
+		if err := flags.Parse([]string{"--config", writeConfigFile(t, content)}); err != nil {
+			t.Fatalf("Unable to parse flags : %v", err)
+		}
+
+		if _, err := resolveConfig(flags, noEnv); err != nil {
+			t.Fatalf("Resolving the config shouldn't have failed, but I got %v", err)
+		}
+
+		if len(target.kmsKeyIDs) != 2 || target.kmsKeyIDs[1] != "arn:aws:kms:eu-west-1:123456789012:key/5678efgh-12ab-34cd-56ef-1234567890ab" {
+			t.Errorf("Expected both KMS keys from the config file %s, but got %v", content, target.kmsKeyIDs)
+		}
+	}
+}
+
+func TestResolveConfigErrors(t *testing.T) {
+
+	var tests = []struct {
+		name    string
+		content string
+		env     map[string]string
+	}{
+		{"unknown setting", "colour: blue\n", nil},
+		{"invalid value", "retries: lots\n", nil},
+		{"nested value", "region:\n  name: erewhon\n", nil},
+		{"list of values for a single value", "region:\n  - erewhon\n  - gondwanaland\n", nil},
+		{"list of nested values", "kms-key-ids:\n  - id: 1234abcd\n", nil},
+		{"invalid yaml", "region: [\n", nil},
+		{"invalid environment", "", map[string]string{"EBS_VOLUMES_MODIFY_TIMEOUT": "soon"}},
+	}
+
+	for _, tt := range tests {
+
+		var target configFlags
+		flags := newConfigFlags(&target)
+
+		if err := flags.Parse([]string{"--config", writeConfigFile(t, tt.content)}); err != nil {
+			t.Fatalf("Unable to parse flags : %v", err)
+		}
+
+		_, err := resolveConfig(flags, func(name string) (string, bool) {
+			value, ok := tt.env[name]
+			return value, ok
+		})
+
+		if err == nil {
+			t.Errorf("Resolving a config with an %s should have failed", tt.name)
+		}
+	}
+
+	var target configFlags
+	flags := newConfigFlags(&target)
+
+	if err := flags.Parse([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}); err != nil {
+		t.Fatalf("Unable to parse flags : %v", err)
+	}
+
+	if _, err := resolveConfig(flags, noEnv); err == nil {
+		t.Error("A missing config file given by --config should have been rejected")
+	}
+}
+
+func TestConfigShow(t *testing.T) {
+
+	c := &config{
+		file:     "/etc/ebs-volumes/config.yaml",
+		found:    true,
+		settings: []setting{{name: "region", value: "erewhon", source: sourceEnvironment}, {name: "retries", value: "5", source: sourceConfigFile}},
+		volumes:  map[string]string{"/dev/sdg": "vol-12345678"},
+	}
+
+	var buf bytes.Buffer
+	c.write(&buf)
+
+	expected := `Config file (/etc/ebs-volumes/config.yaml) : found
+region = erewhon (environment EBS_VOLUMES_REGION)
+retries = 5 (config file)
+volume /dev/sdg = vol-12345678 (config file)
+`
+
+	if buf.String() != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, buf.String())
+	}
+}
+
+func TestConfigShowReportsUnusableConfigFile(t *testing.T) {
+
+	var target configFlags
+	flags := newConfigFlags(&target)
+
+	if err := flags.Parse([]string{"--config", writeConfigFile(t, "region: [\n"), "--region", "erewhon"}); err != nil {
+		t.Fatalf("Unable to parse flags : %v", err)
+	}
+
+	c, err := resolveConfig(flags, noEnv)
+
+	if err == nil || c == nil || c.err != err {
+		t.Fatalf("Expected the config to be resolved without the config file along with its error, but got %v, %v", c, err)
+	}
+
+	var buf bytes.Buffer
+	c.write(&buf)
+
+	if !strings.Contains(buf.String(), "not used, unable to parse config file") || !strings.Contains(buf.String(), "region = erewhon (flag)") {
+		t.Errorf("Expected the config file's problem to be reported along with the settings, but got\n%s", buf.String())
+	}
+}
+
+func TestConfigureCommandWithUnusableDefaultConfigFile(t *testing.T) {
+
+	savedFile, savedConfig, savedVolumes := defaultConfigFile, effectiveConfig, configuredVolumes
+	defer func() {
+		defaultConfigFile, effectiveConfig, configuredVolumes = savedFile, savedConfig, savedVolumes
+	}()
+
+	defaultConfigFile = writeConfigFile(t, "region: [\n")
+
+	if err := configureCommand(versionCmd); err != nil {
+		t.Errorf("The version should have been shown whatever the config file, but got %v", err)
+	}
+
+	if err := configureCommand(configShowCmd); err != nil || effectiveConfig.err == nil {
+		t.Errorf("config show should have reported the config file's problem rather than failing, but got %v", err)
+	}
+
+	if err := configureCommand(attachCmd); err == nil {
+		t.Error("Commands using the config should have failed when the config file can't be used")
+	}
+}
@@ -0,0 +1,368 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/sneakybeaky/ebs-volumes/shared/imds"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers/ec2fake"
+)
+
+const fakeInstanceID = "i-0123456789abcdef0"
+
+// useFake points the commands at a fake EC2 API and instance metadata service, getting the
+// instance as they do but with the endpoints overridden
+func useFake(t *testing.T, tagsInMetadata bool) *ec2fake.Server {
+
+	fake := ec2fake.New("erewhon")
+
+	fake.AddInstance(ec2fake.Instance{
+		ID:               fakeInstanceID,
+		AvailabilityZone: "erewhona",
+		TagsInMetadata:   tagsInMetadata,
+		Tags: map[string]string{
+			"volume_/dev/sdf": "vol-11111111",
+			"volume_/dev/sdg": "tag:Name=data",
+			"detach_volumes":  "true",
+		},
+	})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-11111111", AvailabilityZone: "erewhona"})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-22222222", AvailabilityZone: "erewhona", Tags: map[string]string{"Name": "data"}})
+
+	saved := getInstance
+
+	t.Cleanup(func() {
+		getInstance = saved
+		fake.Close()
+	})
+
+	getInstance = func() (*shared.EC2Instance, error) {
+
+		opts, err := instanceOptions()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// The fake's config comes first, so flags such as --retries override it
+		opts = append([]shared.InstanceOption{shared.WithAWSConfig(fake.AWSConfig())}, opts...)
+
+		return shared.GetInstance(append(opts,
+			shared.WithMetadata(imds.New(imds.WithEndpoint(fake.MetadataEndpoint()))),
+			shared.WithWaitDelay(0))...)
+	}
+
+	return fake
+}
+
+func attachment(fake *ec2fake.Server, volumeID string) string {
+
+	volume := fake.Volume(volumeID)
+
+	if len(volume.Attachments) == 0 {
+		return *volume.State
+	}
+
+	return *volume.Attachments[0].InstanceId + " " + *volume.Attachments[0].Device + " " + *volume.Attachments[0].State
+}
+
+func TestAttachInfoAndDetachEndToEnd(t *testing.T) {
+
+	for _, tagsInMetadata := range []bool{false, true} {
+
+		fake := useFake(t, tagsInMetadata)
+
+		if err := attachCmd.Execute(); err != nil {
+			t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+		}
+
+		for volumeID, device := range map[string]string{"vol-11111111": "/dev/sdf", "vol-22222222": "/dev/sdg"} {
+			if got := attachment(fake, volumeID); got != fakeInstanceID+" "+device+" attached" {
+				t.Errorf("Expected %s to be attached at %s, but got %s", volumeID, device, got)
+			}
+		}
+
+		if tagsInMetadata && fake.Calls("DescribeTags") != 0 {
+			t.Errorf("Expected tags to be read from the instance metadata, but DescribeTags was called %d times", fake.Calls("DescribeTags"))
+		}
+
+		buf := &bytes.Buffer{}
+		infoCmd.SetOutput(buf)
+		err := infoCmd.Execute()
+		infoCmd.SetOutput(os.Stdout)
+
+		if err != nil {
+			t.Fatalf("Showing info shouldn't have failed, but I got %v", err)
+		}
+
+		if !strings.Contains(buf.String(), "Volume ID (vol-22222222), Device Name (/dev/sdg), Status is in-use") {
+			t.Errorf("Expected info about vol-22222222, but got %s", buf)
+		}
+
+		if err := detachCmd.Execute(); err != nil {
+			t.Fatalf("Detaching shouldn't have failed, but I got %v", err)
+		}
+
+		for _, volumeID := range []string{"vol-11111111", "vol-22222222"} {
+			if got := attachment(fake, volumeID); got != ec2.VolumeStateAvailable {
+				t.Errorf("Expected %s to be detached, but got %s", volumeID, got)
+			}
+		}
+	}
+}
+
+func TestAttachReportsEC2ErrorsEndToEnd(t *testing.T) {
+
+	fake := useFake(t, false)
+
+	fake.Fail("AttachVolume", &ec2fake.Error{Code: "UnauthorizedOperation", Message: "You are not authorized to perform this operation."})
+
+	err := attachCmd.Execute()
+
+	if err == nil {
+		t.Fatal("Attaching should have failed")
+	}
+
+	if fake.Calls("AttachVolume") != 2 {
+		t.Errorf("Expected both volumes to be attached, but AttachVolume was called %d times", fake.Calls("AttachVolume"))
+	}
+
+	attached := 0
+
+	for _, volumeID := range []string{"vol-11111111", "vol-22222222"} {
+		if fake.Volume(volumeID).Attachments != nil {
+			attached++
+		}
+	}
+
+	if attached != 1 {
+		t.Errorf("Expected only one volume to be attached, but %d were", attached)
+	}
+}
+
+func TestAttachRetriesThrottledRequestsEndToEnd(t *testing.T) {
+
+	saved := retries
+	defer func() {
+		retries = saved
+	}()
+
+	retries = 2
+
+	fake := useFake(t, false)
+	fake.Script(ec2fake.Throttling("DescribeTags", 1), ec2fake.StaleReads("vol-22222222", 2))
+
+	if err := attachCmd.Execute(); err != nil {
+		t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+	}
+
+	// Tags are read once, and the throttled read is retried
+	if calls := fake.Calls("DescribeTags"); calls != 2 {
+		t.Errorf("Expected DescribeTags to be retried once, but it was called %d times", calls)
+	}
+
+	if got := attachment(fake, "vol-22222222"); got != fakeInstanceID+" /dev/sdg attached" {
+		t.Errorf("Expected vol-22222222 to be attached, but got %s", got)
+	}
+}
+
+func TestIAMPolicyEndToEnd(t *testing.T) {
+
+	fake := useFake(t, false)
+
+	buf := &bytes.Buffer{}
+	iamPolicyCmd.SetOutput(buf)
+	defer iamPolicyCmd.SetOutput(os.Stdout)
+
+	if err := iamPolicyCmd.Execute(); err != nil {
+		t.Fatalf("Writing the policy shouldn't have failed, but I got %v", err)
+	}
+
+	if !strings.Contains(buf.String(), `"arn:aws:ec2:erewhon:123456789012:volume/vol-11111111"`) {
+		t.Errorf("Expected the policy to grant permissions on vol-11111111, but got %s", buf)
+	}
+
+	saved := checkPolicy
+	defer func() {
+		checkPolicy = saved
+	}()
+
+	checkPolicy = true
+	buf.Reset()
+
+	if err := iamPolicyCmd.Execute(); err != nil {
+		t.Fatalf("Checking the policy shouldn't have failed, but I got %v: %s", err, buf)
+	}
+
+	fake.Fail("DetachVolume", ec2fake.Unauthorized())
+	buf.Reset()
+
+	if err := iamPolicyCmd.Execute(); err == nil {
+		t.Error("Checking the policy should have failed when a permission is missing")
+	}
+
+	if !strings.Contains(buf.String(), "permission ec2:DetachVolume has not been granted") {
+		t.Errorf("Expected the missing permission to be reported, but got %s", buf)
+	}
+}
+
+func TestInfoWithGeneratedPolicyEndToEnd(t *testing.T) {
+
+	fake := useFake(t, false)
+
+	buf := &bytes.Buffer{}
+	iamPolicyCmd.SetOutput(buf)
+	defer iamPolicyCmd.SetOutput(os.Stdout)
+
+	if err := iamPolicyCmd.Execute(); err != nil {
+		t.Fatalf("Writing the policy shouldn't have failed, but I got %v", err)
+	}
+
+	var policy shared.Policy
+
+	if err := json.Unmarshal(buf.Bytes(), &policy); err != nil {
+		t.Fatalf("Expected the policy to be JSON, but I got %v: %s", err, buf)
+	}
+
+	var actions []string
+
+	for _, statement := range policy.Statement {
+		actions = append(actions, statement.Action...)
+	}
+
+	fake.Permit(actions...)
+
+	if err := infoCmd.Execute(); err != nil {
+		t.Errorf("Showing info shouldn't have needed more than the policy's actions %v, but I got %v", actions, err)
+	}
+}
+
+func TestIAMPolicyWritesOnlyThePolicyToStdout(t *testing.T) {
+
+	fake := useFake(t, false)
+	fake.AddInstance(ec2fake.Instance{
+		ID:               fakeInstanceID,
+		AvailabilityZone: "erewhona",
+		Tags: map[string]string{
+			"volume_/dev/sdf": "vol-11111111",
+			"volume_/dev/sdh": `[{"Name":"size","Values":["100"]}]`,
+			"size_/dev/sdf":   "lots",
+		},
+	})
+
+	savedLogOutput, savedSeparateLogOutput := logOutput, separateLogOutput
+	defer func() {
+		logOutput, separateLogOutput = savedLogOutput, savedSeparateLogOutput
+	}()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	logOutput, separateLogOutput = stdout, stderr
+
+	iamPolicyCmd.SetOutput(stdout)
+	defer iamPolicyCmd.SetOutput(os.Stdout)
+
+	if err := iamPolicyCmd.Execute(); err != nil {
+		t.Fatalf("Writing the policy shouldn't have failed, but I got %v", err)
+	}
+
+	var policy shared.Policy
+
+	if err := json.Unmarshal(stdout.Bytes(), &policy); err != nil {
+		t.Errorf("Expected only the policy to be written to stdout, but got %v : %s", err, stdout)
+	}
+
+	for _, notice := range []string{"Ignoring tag 'size_/dev/sdf'", "Volumes for (/dev/sdh) are only selected by tags they don't have"} {
+		if !strings.Contains(stderr.String(), notice) {
+			t.Errorf("Expected the notice %s to be logged to stderr, but got %s", notice, stderr)
+		}
+	}
+}
+
+func TestAttachRefusesUnencryptedVolumesEndToEnd(t *testing.T) {
+
+	fake := useFake(t, false)
+
+	saved := requireEncryption
+	defer func() {
+		requireEncryption = saved
+	}()
+
+	requireEncryption = true
+
+	if err := attachCmd.Execute(); err == nil {
+		t.Error("Attaching volumes which aren't encrypted should have failed")
+	}
+
+	if got := attachment(fake, "vol-11111111"); got != ec2.VolumeStateAvailable {
+		t.Errorf("Expected vol-11111111 not to be attached, but got %s", got)
+	}
+
+	buf := &bytes.Buffer{}
+	infoCmd.SetOutput(buf)
+	err := infoCmd.Execute()
+	infoCmd.SetOutput(os.Stdout)
+
+	if err != nil {
+		t.Fatalf("Showing info shouldn't have failed, but I got %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "Doesn't satisfy the encryption policy - it isn't encrypted") {
+		t.Errorf("Expected info to report the encryption policy, but got %s", buf)
+	}
+}
+
+func TestAuditEndToEnd(t *testing.T) {
+
+	useFake(t, false)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	saved := audit
+	defer func() {
+		audit = saved
+	}()
+
+	audit = "file:" + path
+
+	if err := attachCmd.Execute(); err != nil {
+		t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+	}
+
+	if err := detachCmd.Execute(); err != nil {
+		t.Fatalf("Detaching shouldn't have failed, but I got %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		t.Fatalf("Unable to read the audit file : %v", err)
+	}
+
+	for _, expected := range []string{`"action":"attach","result":"success"`, `"action":"detach","result":"success"`,
+		`"run_id":"` + auditRunID + `"`, `"caller_arn":"` + ec2fake.CallerARN + `"`} {
+		if strings.Count(string(data), expected) == 0 {
+			t.Errorf("Expected the audit file to contain %s, but got %s", expected, data)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err := verifyAuditFile(path, buf); err != nil || !strings.Contains(buf.String(), "All 4 records") {
+		t.Errorf("Expected the four records to be verified, but got %v : %s", err, buf)
+	}
+
+	if err := ioutil.WriteFile(path, bytes.Replace(data, []byte("vol-11111111"), []byte("vol-99999999"), 1), 0600); err != nil {
+		t.Fatalf("Unable to change the audit file : %v", err)
+	}
+
+	if err := verifyAuditFile(path, buf); err == nil {
+		t.Error("Expected a changed record to be detected")
+	}
+}
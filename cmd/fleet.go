@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/spf13/cobra"
+)
+
+var fleetFilters []string
+var fleetParallelism int
+
+var fleetCmd = &cobra.Command{
+	Use:   "fleet",
+	Short: "Operate on many instances",
+	Long: `Runs an operation against every instance selected by DescribeInstances filters, reporting the outcome for each.
+Filters are of the form name=value[,value...] and an instance must match them all, for example
+
+	ebs-volumes fleet info --filter tag:role=db --filter availability-zone=eu-west-1a
+
+Terminated instances are never selected. Use --region when the region isn't set in the environment`,
+}
+
+var fleetInfoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Information about the volumes of each instance",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFleet(cmd.OutOrStdout(), func(instance *shared.EC2Instance, w io.Writer) error {
+			return instance.WriteVolumesInfo(w)
+		})
+	},
+}
+
+var fleetAttachCmd = &cobra.Command{
+	Use:   "attach",
+	Short: "Attach the volumes of each instance",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFleet(cmd.OutOrStdout(), func(instance *shared.EC2Instance, w io.Writer) error {
+			return instance.AttachVolumes()
+		})
+	},
+}
+
+var fleetDetachCmd = &cobra.Command{
+	Use:   "detach",
+	Short: "Detach the volumes of each instance, if enabled via tags",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFleet(cmd.OutOrStdout(), func(instance *shared.EC2Instance, w io.Writer) error {
+			return instance.DetachVolumes()
+		})
+	},
+}
+
+var fleetValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the volume setup of each instance",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFleet(cmd.OutOrStdout(), writeFindings)
+	},
+}
+
+func init() {
+	fleetCmd.PersistentFlags().StringArrayVar(&fleetFilters, "filter", nil, "DescribeInstances filter selecting instances, such as tag:role=db")
+	fleetCmd.PersistentFlags().IntVar(&fleetParallelism, "parallelism", shared.DefaultParallelism, "how many instances to work on at once")
+
+	fleetCmd.AddCommand(fleetInfoCmd)
+	fleetCmd.AddCommand(fleetAttachCmd)
+	fleetCmd.AddCommand(fleetDetachCmd)
+	fleetCmd.AddCommand(fleetValidateCmd)
+}
+
+func runFleet(w io.Writer, action shared.FleetAction) error {
+
+	if len(fleetFilters) == 0 {
+		return errors.New("at least one --filter must be given, so the whole region isn't selected by mistake")
+	}
+
+	var filters []*ec2.Filter
+
+	for _, value := range fleetFilters {
+		filter, err := shared.ParseFilter(value)
+
+		if err != nil {
+			return err
+		}
+
+		filters = append(filters, filter)
+	}
+
+	fleet, err := getFleet()
+
+	if err != nil {
+		return fmt.Errorf("unable to get fleet : %w", err)
+	}
+
+	results, err := fleet.Run(filters, action)
+
+	if err != nil {
+		return logAWSFailure(err)
+	}
+
+	return shared.WriteFleetReport(w, results)
+}
+
+var getFleet = func() (*shared.Fleet, error) {
+
+	opts, err := commonOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := loadManifest()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest != nil {
+		// Volumes listed for any instance would be allocated to every instance in the fleet
+		opts = append(opts, shared.WithManifest(manifest.InstancesOnly()))
+	}
+
+	return shared.GetFleet(region, fleetParallelism, opts...)
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+func TestFleetRequiresFilter(t *testing.T) {
+
+	saved := fleetFilters
+	defer func() {
+		fleetFilters = saved
+	}()
+
+	fleetFilters = nil
+
+	if err := fleetInfoCmd.RunE(fleetInfoCmd, nil); err == nil {
+		t.Error("Running against a fleet without filters should have failed")
+	}
+}
+
+func TestFleetInfoReport(t *testing.T) {
+
+	savedFilters, savedFleet := fleetFilters, getFleet
+	defer func() {
+		fleetFilters, getFleet = savedFilters, savedFleet
+	}()
+
+	fleetFilters = []string{"tag:role=db"}
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+	mockEC2Service.DescribeInstancesFunc = func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+		return &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{
+				InstanceId: aws.String("i-00000001"),
+				Placement:  &ec2.Placement{AvailabilityZone: aws.String("erewhona")},
+			}}}},
+		}, nil
+	}
+	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance("i-00000001",
+		testhelpers.NewDescribeTagsOutputBuilder().Build())
+
+	getFleet = func() (*shared.Fleet, error) {
+		return shared.NewFleet(mockEC2Service, "erewhon", 1), nil
+	}
+
+	buf := new(bytes.Buffer)
+	fleetInfoCmd.SetOutput(buf)
+	defer fleetInfoCmd.SetOutput(nil)
+
+	if err := fleetInfoCmd.RunE(fleetInfoCmd, nil); err != nil {
+		t.Fatalf("Running against the fleet shouldn't have failed, but I got %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "Instance ID (i-00000001) : OK") {
+		t.Errorf("Expected the report to include i-00000001, but got\n%s", buf.String())
+	}
+}
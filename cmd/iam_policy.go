@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/spf13/cobra"
+)
+
+var checkPolicy bool
+
+var iamPolicyCmd = &cobra.Command{
+	Use:   "iam-policy",
+	Short: "Write the least privileged IAM policy for the volumes",
+	Long: `Writes an IAM policy granting only the permissions needed to manage the volumes allocated to the instance,
+with the features enabled by the other flags. Permissions are granted on the instance and volumes themselves,
+and volumes found by selectors or claimed from pools by the tags selecting them.
+
+With --check no policy is written. Instead dry run requests are made needing each permission in the policy,
+reporting those which haven't been granted, and a non zero exit code is returned if any are missing`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// Only the policy is written to standard output, so it can be redirected to a file
+		if !checkPolicy {
+			logOutput = separateLogOutput
+		}
+
+		return apply(func(instance *shared.EC2Instance) error {
+			if checkPolicy {
+				return writePolicyFindings(instance, cmd.OutOrStdout())
+			}
+			return writePolicy(instance, cmd.OutOrStdout())
+		})
+	},
+}
+
+func init() {
+	iamPolicyCmd.Flags().BoolVar(&checkPolicy, "check", false, "check the permissions in the policy have been granted, instead of writing it")
+}
+
+func writePolicy(instance *shared.EC2Instance, w io.Writer) error {
+
+	policy, err := instance.Policy()
+
+	if err != nil {
+		return err
+	}
+
+	document, err := policy.JSON()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(document))
+
+	return err
+}
+
+func writePolicyFindings(instance *shared.EC2Instance, w io.Writer) error {
+
+	findings, err := instance.CheckPolicy()
+
+	if err != nil {
+		return err
+	}
+
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "All the permissions in the policy have been granted")
+	}
+
+	return reportFindings(findings, w)
+}
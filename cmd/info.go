@@ -10,10 +10,8 @@ var infoCmd = &cobra.Command{
 	Short: "Information about volumes and setup",
 	Long:  `Shows the volumes assigned, their status and detach setup`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return apply(showVolumesInfo)
+		return apply(func(instance *shared.EC2Instance) error {
+			return instance.WriteVolumesInfo(cmd.OutOrStdout())
+		})
 	},
 }
-
-func showVolumesInfo(instance *shared.EC2Instance) error {
-	return instance.ShowVolumesInfo()
-}
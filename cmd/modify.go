@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/spf13/cobra"
+)
+
+var modifyCmd = &cobra.Command{
+	Use:     "modify",
+	Aliases: []string{"resize"},
+	Short:   "Modify volumes",
+	Long: `Converges the size, type and performance of volumes to those designated via tags,
+growing the partitions and filesystems on resized volumes`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if instanceID != "" {
+			return errors.New("volumes can't be modified using --instance-id, as filesystems are grown on the instance")
+		}
+		return apply(modifyVolumes)
+	},
+}
+
+func modifyVolumes(instance *shared.EC2Instance) error {
+	return instance.ModifyVolumes()
+}
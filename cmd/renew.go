@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/spf13/cobra"
+)
+
+var renewInterval time.Duration
+
+var renewCmd = &cobra.Command{
+	Use:   "renew",
+	Short: "Renew volume leases",
+	Long: `Renews the leases on the attached volumes, when leases are used via --lease-duration.
+Leases are renewed once, or with --interval every interval until stopped, for example
+
+	ebs-volumes renew --lease-duration=5m --interval=1m`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return apply(renewLeases)
+	},
+}
+
+func init() {
+	renewCmd.Flags().DurationVar(&renewInterval, "interval", 0, "keep renewing leases this often, rather than renewing them once")
+}
+
+// renewSleep waits between renewals, and returns false to stop renewing
+var renewSleep = func(d time.Duration) bool {
+	time.Sleep(d)
+	return true
+}
+
+func renewLeases(instance *shared.EC2Instance) error {
+
+	if renewInterval <= 0 {
+		return instance.RenewLeases()
+	}
+
+	if renewInterval >= leaseDuration {
+		return errors.New("--interval must be shorter than --lease-duration, so leases are renewed before they expire")
+	}
+
+	for {
+		if err := instance.RenewLeases(); err != nil {
+			instance.Logger().Errorf("Unable to renew leases : %v", err)
+		}
+
+		if !renewSleep(renewInterval) {
+			return nil
+		}
+	}
+}
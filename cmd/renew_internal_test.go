@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+func TestRenewIntervalMustBeShorterThanLeaseDuration(t *testing.T) {
+
+	savedInterval, savedDuration := renewInterval, leaseDuration
+	defer func() {
+		renewInterval, leaseDuration = savedInterval, savedDuration
+	}()
+
+	renewInterval, leaseDuration = 5*time.Minute, 5*time.Minute
+
+	instance := shared.NewEC2Instance(testhelpers.NewMockMetadata("id-98765", "erewhon"), testhelpers.NewMockEC2Service(),
+		shared.WithLeaseDuration(leaseDuration))
+
+	if err := renewLeases(instance); err == nil {
+		t.Error("An interval as long as the lease duration should have been rejected")
+	}
+}
+
+func TestRenewKeepsRenewingUntilStopped(t *testing.T) {
+
+	savedInterval, savedDuration, savedSleep := renewInterval, leaseDuration, renewSleep
+	defer func() {
+		renewInterval, leaseDuration, renewSleep = savedInterval, savedDuration, savedSleep
+	}()
+
+	renewInterval, leaseDuration = time.Minute, 5*time.Minute
+
+	renewals := 0
+	renewSleep = func(d time.Duration) bool {
+		renewals++
+		return renewals < 3
+	}
+
+	instanceID := "id-98765"
+	mockEC2Service := testhelpers.NewMockEC2Service()
+	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
+		testhelpers.NewDescribeTagsOutputBuilder().Build())
+
+	instance := shared.NewEC2Instance(testhelpers.NewMockMetadata(instanceID, "erewhon"), mockEC2Service,
+		shared.WithLeaseDuration(leaseDuration))
+
+	if err := renewLeases(instance); err != nil || renewals != 3 {
+		t.Errorf("Expected leases to be renewed 3 times, but got %d renewals and %v", renewals, err)
+	}
+}
@@ -1,15 +1,50 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
 
 	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/sneakybeaky/ebs-volumes/shared/imds"
 	"github.com/sneakybeaky/ebs-volumes/shared/log"
 	"github.com/spf13/cobra"
 )
 
 var verbose bool
+var logLevel string
+var logFormat string
+var debugAWS bool
+var tagPrefix string
+var tagSource string
+var imdsV1Fallback bool
+var instanceID string
+var region string
+var retries int
+var modifyTimeout time.Duration
+var metadataTimeout time.Duration
+var manifestFile string
+var matchPolicy string
+var leaseDuration time.Duration
+var authorization string
+var requireEncryption bool
+var kmsKeyIDs []string
+var audit string
+var endpointURL string
+var imdsEndpoint string
+var fips bool
+var proxy string
+var caBundle string
+var roleARN string
+var externalID string
+var roleSessionName string
+
+// auditRunID identifies the audit records of this run
+var auditRunID = shared.NewRunID()
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
@@ -24,13 +59,31 @@ For example,
 
 	volume_/dev/sdg=vol-049df61146c4d7901
 
+To designate the size in GiB a volume should be grown to set a tag with the following syntax
+
+	size_<device_name>=<size>
+
+To designate the type and performance of a volume set a tag with the following syntax
+
+	perf_<device_name>=type=<volume_type>,iops=<iops>,throughput=<throughput>
+
 To signal that volumes should be detached set the following tag
 
-	detach_volumes=true`,
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		if verbose {
-			log.SetVerbose()
-		}
+	detach_volumes=true
+
+The same tags are also recognised in the ebs-volumes/ namespace, such as
+
+	ebs-volumes/volume:/dev/sdg=vol-049df61146c4d7901
+	ebs-volumes/size:/dev/sdg=200
+	ebs-volumes/perf:/dev/sdg=type=gp3
+	ebs-volumes/detach-volumes=true
+
+Use --tag-prefix to only recognise tags starting with a prefix of your own, for example
+--tag-prefix=ebs-volumes: gives ebs-volumes:volume:/dev/sdg
+
+Settings can also be given in the environment or a config file, see ebs-volumes config --help`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return configureCommand(cmd)
 	},
 }
 
@@ -42,6 +95,13 @@ func Execute() {
 	RootCmd.AddCommand(infoCmd)
 	RootCmd.AddCommand(attachCmd)
 	RootCmd.AddCommand(detachCmd)
+	RootCmd.AddCommand(modifyCmd)
+	RootCmd.AddCommand(validateCmd)
+	RootCmd.AddCommand(renewCmd)
+	RootCmd.AddCommand(iamPolicyCmd)
+	RootCmd.AddCommand(verifyAuditCmd)
+	RootCmd.AddCommand(fleetCmd)
+	RootCmd.AddCommand(configCmd)
 
 	RootCmd.SilenceUsage = true
 	RootCmd.SilenceErrors = true
@@ -58,7 +118,53 @@ func init() {
 	// Cobra supports Persistent Flags, which, if defined here,
 	// will be global for your application.
 
-	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output, the same as --log-level=debug")
+	RootCmd.PersistentFlags().StringVar(&logLevel, "log-level", log.LevelInfo.String(), "the least important entries to log : debug, info, warn or error")
+	RootCmd.PersistentFlags().BoolVar(&debugAWS, "debug-aws", false,
+		"log each AWS request and response at the debug level, with credentials redacted")
+	RootCmd.PersistentFlags().StringVar(&logFormat, "log-format", string(log.FormatText), "how to write log entries : text, or json for log pipelines")
+	RootCmd.PersistentFlags().StringVar(&instanceID, "instance-id", "",
+		"manage the volumes of this instance from outside it, rather than the instance this runs on")
+	RootCmd.PersistentFlags().StringVar(&region, "region", "",
+		"the region of the instance given by --instance-id, or of the fleet, when not set in the environment")
+	RootCmd.PersistentFlags().StringVar(&tagSource, "tag-source", string(shared.TagSourceAuto),
+		"where to read instance tags from : imds (instance metadata), api (DescribeTags) or auto (imds, falling back to api)")
+	RootCmd.PersistentFlags().BoolVar(&imdsV1Fallback, "imds-v1-fallback", false,
+		"read instance metadata without a session token (IMDSv1) when a token can't be acquired")
+	RootCmd.PersistentFlags().StringVar(&tagPrefix, "tag-prefix", "", "only use tags starting with this prefix, instead of the legacy and ebs-volumes/ tags")
+	RootCmd.PersistentFlags().StringVar(&configFile, "config", "",
+		"the config file to read, instead of "+defaultConfigFile+" when present")
+	RootCmd.PersistentFlags().StringVar(&manifestFile, "manifest", "",
+		"a manifest file allocating volumes to devices, merged with the volumes allocated by tags")
+	RootCmd.PersistentFlags().StringVar(&matchPolicy, "match-policy", string(shared.MatchPolicyError),
+		"how to choose between volumes matching a selector : error, prefer-available or prefer-newest")
+	RootCmd.PersistentFlags().DurationVar(&leaseDuration, "lease-duration", 0,
+		"lease volumes to the instance for this long when attaching them, so volumes allocated to more than one instance are reported")
+	RootCmd.PersistentFlags().StringVar(&authorization, "authorization", "",
+		"only attach volumes tagged to permit it : allowed-instance, or tag:<key> for a tag shared with the instance")
+	RootCmd.PersistentFlags().BoolVar(&requireEncryption, "require-encryption", false, "only attach encrypted volumes")
+	RootCmd.PersistentFlags().StringSliceVar(&kmsKeyIDs, "kms-key-ids", nil,
+		"only attach volumes encrypted with one of these KMS key IDs or ARNs, separated by commas")
+	RootCmd.PersistentFlags().StringVar(&audit, "audit", "",
+		"record each operation on a volume as JSON : stdout, syslog, or file:<path> to append to a file")
+	RootCmd.PersistentFlags().IntVar(&retries, "retries", -1, "how many times to retry failed AWS requests, or -1 for the AWS SDK default")
+	RootCmd.PersistentFlags().DurationVar(&modifyTimeout, "modify-timeout", 30*time.Minute, "how long to wait for each volume modification")
+	RootCmd.PersistentFlags().DurationVar(&metadataTimeout, "metadata-timeout", imds.DefaultTimeout,
+		"how long each request to the instance metadata service may take")
+	RootCmd.PersistentFlags().StringVar(&endpointURL, "endpoint-url", "",
+		"the URL of the EC2 API, such as a VPC interface endpoint, instead of the regional endpoint")
+	RootCmd.PersistentFlags().StringVar(&imdsEndpoint, "imds-endpoint", imds.DefaultEndpoint,
+		"the URL of the instance metadata service")
+	RootCmd.PersistentFlags().BoolVar(&fips, "fips", false, "use the FIPS endpoint of the EC2 API in the region")
+	RootCmd.PersistentFlags().StringVar(&proxy, "proxy", "",
+		"the URL of a proxy to send AWS requests through, instead of any given by HTTPS_PROXY")
+	RootCmd.PersistentFlags().StringVar(&caBundle, "ca-bundle", "",
+		"a file of PEM encoded CA certificates to trust for AWS requests, along with those of the system")
+	RootCmd.PersistentFlags().StringVar(&roleARN, "role-arn", "",
+		"assume this IAM role to manage volumes, rather than using the credentials found as usual")
+	RootCmd.PersistentFlags().StringVar(&externalID, "external-id", "", "the external ID to give when assuming the role given by --role-arn")
+	RootCmd.PersistentFlags().StringVar(&roleSessionName, "role-session-name", shared.DefaultRoleSessionName,
+		"the session name to give when assuming the role given by --role-arn")
 }
 
 func apply(action func(*shared.EC2Instance) error) error {
@@ -66,13 +172,222 @@ func apply(action func(*shared.EC2Instance) error) error {
 	instance, err := getInstance()
 
 	if err != nil {
-		return fmt.Errorf("unable to get EC2 instance : %v", err)
+		return logAWSFailure(fmt.Errorf("unable to get EC2 instance : %w", err))
 	}
 
-	return action(instance)
+	return logAWSFailure(action(instance))
 
 }
 
 var getInstance = func() (*shared.EC2Instance, error) {
-	return shared.GetInstance()
+
+	opts, err := instanceOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if instanceID != "" {
+		return shared.GetInstanceByID(instanceID, region, opts...)
+	}
+
+	if region != "" {
+		return nil, errors.New("--region can only be given along with --instance-id")
+	}
+
+	return shared.GetInstance(opts...)
+}
+
+// instanceOptions returns the options for the EC2 instance chosen via flags
+func instanceOptions() ([]shared.InstanceOption, error) {
+
+	opts, err := commonOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if instanceID == "" {
+		metadataOpts := []imds.Option{imds.WithTimeout(metadataTimeout), imds.WithEndpoint(imdsEndpoint)}
+
+		if imdsV1Fallback {
+			metadataOpts = append(metadataOpts, imds.WithFallbackToV1())
+		}
+
+		opts = append(opts, shared.WithMetadata(imds.New(metadataOpts...)))
+	}
+
+	manifest, err := loadManifest()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest != nil {
+		opts = append(opts, shared.WithManifest(manifest))
+	}
+
+	if len(configuredVolumes) > 0 {
+		opts = append(opts, shared.WithVolumes(configuredVolumes))
+	}
+
+	return opts, nil
+}
+
+// loadManifest returns the manifest given via flags, or nil when there isn't one
+func loadManifest() (*shared.Manifest, error) {
+
+	if manifestFile == "" {
+		return nil, nil
+	}
+
+	return shared.LoadManifest(manifestFile)
+}
+
+// logAWSFailure logs an error caused by a failed AWS request along with the request's code, status and ID,
+// so they can be given to AWS support, returning the error
+func logAWSFailure(err error) error {
+
+	if _, ok := shared.AWSRequestFailure(err); ok {
+		if logger, loggerErr := newLogger(); loggerErr == nil {
+			shared.LogError(logger, err)
+		}
+	}
+
+	return err
+}
+
+// logOutput is where log entries are written
+var logOutput io.Writer = os.Stdout
+
+// separateLogOutput is where log entries are written when standard output is kept for something else, such as
+// the IAM policy or audit records, so it can be read without them
+var separateLogOutput io.Writer = os.Stderr
+
+// newLogger returns a logger writing entries to the log output at the level and in the format chosen via flags
+func newLogger() (*log.Logger, error) {
+
+	level, err := log.ParseLevel(logLevel)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if verbose || debugAWS {
+		level = log.LevelDebug
+	}
+
+	format, err := log.ParseFormat(logFormat)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if audit == "stdout" {
+		return log.New(separateLogOutput, level, format), nil
+	}
+
+	return log.New(logOutput, level, format), nil
+}
+
+// commonOptions returns the options shared by every instance, including those in a fleet, chosen via flags
+func commonOptions() ([]shared.InstanceOption, error) {
+
+	logger, err := newLogger()
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []shared.InstanceOption{shared.WithModifyTimeout(modifyTimeout), shared.WithLeaseDuration(leaseDuration), shared.WithLogger(logger)}
+
+	if debugAWS {
+		opts = append(opts, shared.WithAWSDebug())
+	}
+
+	if retries >= 0 {
+		opts = append(opts, shared.WithAWSConfig(aws.NewConfig().WithMaxRetries(retries)))
+	}
+
+	if proxy != "" || caBundle != "" {
+		client, err := shared.NewHTTPClient(proxy, caBundle)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, shared.WithAWSConfig(aws.NewConfig().WithHTTPClient(client)))
+	}
+
+	if endpointURL != "" {
+		opts = append(opts, shared.WithEC2Endpoint(endpointURL))
+	}
+
+	if fips {
+		opts = append(opts, shared.WithFIPS())
+	}
+
+	if roleARN != "" {
+		role := shared.AssumeRole{RoleARN: roleARN, ExternalID: externalID, SessionName: roleSessionName}
+
+		if err := role.Validate(); err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, shared.WithAssumeRole(role))
+	} else if externalID != "" {
+		return nil, errors.New("--external-id can only be given along with --role-arn")
+	}
+
+	if authorization != "" {
+		rule, err := shared.ParseAuthorizationRule(authorization)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, shared.WithAuthorizationRule(rule))
+	}
+
+	if requireEncryption || len(kmsKeyIDs) > 0 {
+		opts = append(opts, shared.WithEncryptionPolicy(shared.EncryptionPolicy{KMSKeyIDs: kmsKeyIDs}))
+	}
+
+	if audit != "" {
+		sink, err := shared.ParseAuditSink(audit)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, shared.WithAuditor(shared.NewAuditor(sink, auditRunID)))
+	}
+
+	mode, err := shared.ParseTagSourceMode(tagSource)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, shared.WithTagSourceMode(mode))
+
+	policy, err := shared.ParseMatchPolicy(matchPolicy)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, shared.WithMatchPolicy(policy))
+
+	if tagPrefix != "" {
+		schema, err := shared.NewTagSchema(tagPrefix)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag prefix '%s' : %w", tagPrefix, err)
+		}
+
+		opts = append(opts, shared.WithTagSchemas(schema))
+	}
+
+	return opts, nil
 }
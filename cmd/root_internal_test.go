@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+)
+
+func TestInstanceOptionsWithTagPrefix(t *testing.T) {
+
+	saved := tagPrefix
+	defer func() {
+		tagPrefix = saved
+	}()
+
+	tagPrefix = ""
+
+	without, err := instanceOptions()
+	if err != nil {
+		t.Fatalf("Getting options without a tag prefix shouldn't have failed, but I got %v", err)
+	}
+
+	tagPrefix = "ebs-volumes:"
+
+	if opts, err := instanceOptions(); err != nil || len(opts) != len(without)+1 {
+		t.Errorf("Expected a tag schema option, but got %d, %v", len(opts), err)
+	}
+
+	tagPrefix = "aws:"
+
+	if _, err := instanceOptions(); err == nil {
+		t.Error("A reserved tag prefix should have been rejected")
+	}
+}
+
+func TestInstanceOptionsWithTagSource(t *testing.T) {
+
+	saved := tagSource
+	defer func() {
+		tagSource = saved
+	}()
+
+	for _, source := range []string{"auto", "imds", "api"} {
+		tagSource = source
+
+		if _, err := instanceOptions(); err != nil {
+			t.Errorf("Tag source '%s' should have been accepted, but got %v", source, err)
+		}
+	}
+
+	tagSource = "somewhere"
+
+	if _, err := instanceOptions(); err == nil {
+		t.Error("An unknown tag source should have been rejected")
+	}
+}
+
+func TestInstanceOptionsWithIMDSv1Fallback(t *testing.T) {
+
+	saved := imdsV1Fallback
+	defer func() {
+		imdsV1Fallback = saved
+	}()
+
+	imdsV1Fallback = true
+
+	if _, err := instanceOptions(); err != nil {
+		t.Errorf("Getting options with IMDSv1 fallback shouldn't have failed, but I got %v", err)
+	}
+}
+
+func TestInstanceOptionsWithConfiguredVolumes(t *testing.T) {
+
+	saved := configuredVolumes
+	defer func() {
+		configuredVolumes = saved
+	}()
+
+	configuredVolumes = nil
+
+	without, err := instanceOptions()
+	if err != nil {
+		t.Fatalf("Getting options without configured volumes shouldn't have failed, but I got %v", err)
+	}
+
+	configuredVolumes = map[string]string{"/dev/sdg": "vol-12345678"}
+
+	if opts, err := instanceOptions(); err != nil || len(opts) != len(without)+1 {
+		t.Errorf("Expected a volumes option, but got %d, %v", len(opts), err)
+	}
+}
+
+func TestGetInstanceWithInstanceID(t *testing.T) {
+
+	savedInstanceID, savedRegion := instanceID, region
+	defer func() {
+		instanceID, region = savedInstanceID, savedRegion
+	}()
+
+	instanceID, region = "", "erewhon"
+
+	if _, err := getInstance(); err == nil {
+		t.Error("--region without --instance-id should have been rejected")
+	}
+
+	instanceID = "i-1234567890abcdef0"
+
+	if _, err := getInstance(); err != nil {
+		t.Errorf("Getting the instance by ID shouldn't have failed, but I got %v", err)
+	}
+
+	if err := modifyCmd.RunE(modifyCmd, nil); err == nil {
+		t.Error("Modifying volumes using --instance-id should have been rejected")
+	}
+}
+
+func TestInstanceOptionsWithMatchPolicy(t *testing.T) {
+
+	saved := matchPolicy
+	defer func() {
+		matchPolicy = saved
+	}()
+
+	matchPolicy = "prefer-newest"
+
+	if _, err := instanceOptions(); err != nil {
+		t.Errorf("Match policy '%s' should have been accepted, but got %v", matchPolicy, err)
+	}
+
+	matchPolicy = "prefer-oldest"
+
+	if _, err := instanceOptions(); err == nil {
+		t.Error("An unknown match policy should have been rejected")
+	}
+}
+
+func TestInstanceOptionsWithEndpoints(t *testing.T) {
+
+	savedEndpoint, savedFIPS, savedProxy, savedBundle := endpointURL, fips, proxy, caBundle
+	defer func() {
+		endpointURL, fips, proxy, caBundle = savedEndpoint, savedFIPS, savedProxy, savedBundle
+	}()
+
+	endpointURL, fips, proxy, caBundle = "", false, "", ""
+
+	without, err := instanceOptions()
+	if err != nil {
+		t.Fatalf("Getting options without endpoints shouldn't have failed, but I got %v", err)
+	}
+
+	endpointURL, fips, proxy = "https://vpce-0123.ec2.eu-west-1.vpce.amazonaws.com", true, "http://proxy:3128"
+
+	if opts, err := instanceOptions(); err != nil || len(opts) != len(without)+3 {
+		t.Errorf("Expected endpoint, FIPS and HTTP client options, but got %d, %v", len(opts), err)
+	}
+
+	proxy = "not a url"
+
+	if _, err := instanceOptions(); err == nil {
+		t.Error("An invalid proxy URL should have been rejected")
+	}
+
+	proxy, caBundle = "", "/no/such/bundle.pem"
+
+	if _, err := instanceOptions(); err == nil {
+		t.Error("A missing CA bundle should have been rejected")
+	}
+}
+
+func TestInstanceOptionsWithRoleARN(t *testing.T) {
+
+	savedRole, savedExternalID, savedSessionName := roleARN, externalID, roleSessionName
+	defer func() {
+		roleARN, externalID, roleSessionName = savedRole, savedExternalID, savedSessionName
+	}()
+
+	roleARN, externalID = "", ""
+
+	without, err := instanceOptions()
+	if err != nil {
+		t.Fatalf("Getting options without a role shouldn't have failed, but I got %v", err)
+	}
+
+	roleARN, externalID, roleSessionName = "arn:aws:iam::123456789012:role/ebs-volumes", "secret-handshake", "ebs-volumes"
+
+	if opts, err := instanceOptions(); err != nil || len(opts) != len(without)+1 {
+		t.Errorf("Expected an assume role option, but got %d, %v", len(opts), err)
+	}
+
+	roleARN = "ebs-volumes"
+
+	if _, err := instanceOptions(); err == nil {
+		t.Error("An invalid role ARN should have been rejected")
+	}
+
+	roleARN = ""
+
+	if _, err := instanceOptions(); err == nil {
+		t.Error("--external-id without --role-arn should have been rejected")
+	}
+}
+
+func TestInstanceOptionsWithAuthorization(t *testing.T) {
+
+	saved := authorization
+	defer func() {
+		authorization = saved
+	}()
+
+	authorization = ""
+
+	without, err := instanceOptions()
+	if err != nil {
+		t.Fatalf("Getting options without authorization shouldn't have failed, but I got %v", err)
+	}
+
+	for _, rule := range []string{"allowed-instance", "tag:cluster"} {
+		authorization = rule
+
+		if opts, err := instanceOptions(); err != nil || len(opts) != len(without)+1 {
+			t.Errorf("Expected an authorization option for %s, but got %d, %v", rule, len(opts), err)
+		}
+	}
+
+	authorization = "tag:"
+
+	if _, err := instanceOptions(); err == nil {
+		t.Error("A rule without a tag key should have been rejected")
+	}
+}
+
+func TestInstanceOptionsWithEncryptionPolicy(t *testing.T) {
+
+	savedRequire, savedKeys := requireEncryption, kmsKeyIDs
+	defer func() {
+		requireEncryption, kmsKeyIDs = savedRequire, savedKeys
+	}()
+
+	requireEncryption, kmsKeyIDs = false, nil
+
+	without, err := instanceOptions()
+	if err != nil {
+		t.Fatalf("Getting options without an encryption policy shouldn't have failed, but I got %v", err)
+	}
+
+	requireEncryption = true
+
+	if opts, err := instanceOptions(); err != nil || len(opts) != len(without)+1 {
+		t.Errorf("Expected an encryption policy option, but got %d, %v", len(opts), err)
+	}
+
+	requireEncryption, kmsKeyIDs = false, []string{"1234abcd-12ab-34cd-56ef-1234567890ab"}
+
+	if opts, err := instanceOptions(); err != nil || len(opts) != len(without)+1 {
+		t.Errorf("Expected KMS keys to require encryption, but got %d, %v", len(opts), err)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+
+	savedLevel, savedFormat, savedVerbose, savedDebugAWS := logLevel, logFormat, verbose, debugAWS
+	defer func() {
+		logLevel, logFormat, verbose, debugAWS = savedLevel, savedFormat, savedVerbose, savedDebugAWS
+	}()
+
+	logLevel, logFormat, verbose = "warn", "json", false
+
+	logger, err := newLogger()
+
+	if err != nil || logger.Enabled(log.LevelInfo) || !logger.Enabled(log.LevelWarn) {
+		t.Errorf("Expected a logger at the warn level, but got %v", err)
+	}
+
+	verbose = true
+
+	if logger, err := newLogger(); err != nil || !logger.Enabled(log.LevelDebug) {
+		t.Errorf("Expected --verbose to log at the debug level, but got %v", err)
+	}
+
+	verbose, debugAWS = false, true
+
+	if logger, err := newLogger(); err != nil || !logger.Enabled(log.LevelDebug) {
+		t.Errorf("Expected --debug-aws to log at the debug level, but got %v", err)
+	}
+
+	debugAWS = false
+
+	savedAudit, savedLogOutput, savedSeparateLogOutput := audit, logOutput, separateLogOutput
+	defer func() {
+		audit, logOutput, separateLogOutput = savedAudit, savedLogOutput, savedSeparateLogOutput
+	}()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	audit, logOutput, separateLogOutput = "stdout", stdout, stderr
+
+	if logger, err := newLogger(); err != nil {
+		t.Errorf("Expected a logger, but got %v", err)
+	} else {
+		logger.Warnf("Apart from the audit records")
+	}
+
+	if stdout.Len() != 0 || !strings.Contains(stderr.String(), "Apart from the audit records") {
+		t.Errorf("Expected the log to be kept apart from audit records on stdout, but got %s on stdout and %s on stderr", stdout, stderr)
+	}
+
+	audit = ""
+
+	for _, invalid := range [][2]string{{"loud", "text"}, {"info", "xml"}} {
+		logLevel, logFormat = invalid[0], invalid[1]
+
+		if _, err := newLogger(); err == nil {
+			t.Errorf("Expected level %s and format %s to be rejected", logLevel, logFormat)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate volume setup",
+	Long: `Checks the volumes designated via tags could be attached, detached and modified, without changing anything.
+Findings are listed by severity, and a non zero exit code is returned if any are errors`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return apply(validateVolumes)
+	},
+}
+
+func validateVolumes(instance *shared.EC2Instance) error {
+	return writeFindings(instance, os.Stdout)
+}
+
+// writeFindings writes the validation findings for an instance, returning an error if any are errors
+func writeFindings(instance *shared.EC2Instance, w io.Writer) error {
+
+	findings, err := instance.Validate()
+	if err != nil {
+		return err
+	}
+
+	return reportFindings(findings, w)
+}
+
+// reportFindings writes findings, returning an error if any are errors
+func reportFindings(findings []shared.Finding, w io.Writer) error {
+
+	errors := 0
+
+	for _, finding := range findings {
+		fmt.Fprintln(w, finding)
+
+		if finding.Severity == shared.SeverityError {
+			errors++
+		}
+	}
+
+	if errors > 0 {
+		return fmt.Errorf("validation found %d error(s)", errors)
+	}
+
+	return nil
+}
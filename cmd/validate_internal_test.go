@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+func TestErrorReturnedWhenValidationFindsErrors(t *testing.T) {
+	instanceID := "id-98765"
+	metadata := testhelpers.NewMockMetadata(instanceID, "erewhon")
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+
+	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
+		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sdf", instanceID, "vol-12345678").Build())
+
+	mockEC2Service.DescribeInstancesFunc = func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+		return nil, errors.New("Whoops")
+	}
+
+	mockEC2Service.DescribeVolumesFunc = func(*ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+		return nil, errors.New("Whoops")
+	}
+
+	saved := getInstance
+	defer func() {
+		getInstance = saved
+	}()
+
+	getInstance = func() (*shared.EC2Instance, error) {
+		return shared.NewEC2Instance(metadata, mockEC2Service), nil
+	}
+
+	err := validateCmd.Execute()
+
+	if err == nil {
+		t.Error("No error returned")
+	}
+}
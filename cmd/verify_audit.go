@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sneakybeaky/ebs-volumes/shared"
+	"github.com/spf13/cobra"
+)
+
+var verifyAuditCmd = &cobra.Command{
+	Use:   "verify-audit <file>",
+	Short: "Verify the records in an audit file haven't been changed or removed",
+	Long: `Checks each record in an audit file written with --audit=file:<path> follows on from the record before it,
+returning a non zero exit code when a record has been changed or removed`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(args) != 1 {
+			return errors.New("the audit file to verify must be given")
+		}
+
+		return verifyAuditFile(args[0], cmd.OutOrStdout())
+	},
+}
+
+func verifyAuditFile(path string, w io.Writer) error {
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return fmt.Errorf("unable to open audit file (%s) : %v", path, err)
+	}
+
+	defer file.Close()
+
+	count, err := shared.VerifyAuditLog(file)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "All %d records in the audit file follow on from each other\n", count)
+	return err
+}
@@ -1,12 +1,14 @@
 package shared
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
 	"github.com/sneakybeaky/ebs-volumes/shared/log"
 )
 
@@ -15,13 +17,55 @@ type AllocatedVolume struct {
 	VolumeID   string
 	DeviceName string
 	InstanceID string
-	svc        ec2iface.EC2API
+
+	// AvailabilityZone is the availability zone of the instance, or empty if unknown
+	AvailabilityZone string
+
+	// Size is the size in GiB the volume should be grown to, or zero if unmanaged
+	Size int64
+
+	// Performance is the type and performance the volume should be modified to have
+	Performance Performance
+
+	// Source describes where the volume was allocated, such as the tag or manifest naming it
+	Source string
+
+	svc ec2ext.EC2API
+
+	// modifyTimeout is how long to wait for a modification to take effect, or zero for the default
+	modifyTimeout time.Duration
+
+	// leaseDuration is how long the volume is leased for when it's attached, or zero when leases aren't used
+	leaseDuration time.Duration
+
+	// authorization permits the volume to be attached to the instance, or is nil when any volume can be
+	authorization *volumeAuthorization
+
+	// encryption must be satisfied for the volume to be attached, or is nil when any volume can be
+	encryption *EncryptionPolicy
+
+	// claim is the claim on the volume when it was claimed from a pool, or nil otherwise
+	claim *poolClaim
+
+	// logger adds the volume, device and instance to each entry, or is nil to write nothing
+	logger *log.Logger
 }
 
 // NewAllocatedVolume returns a new instance of AllocatedVolume
-func NewAllocatedVolume(volumeID string, deviceName string, instanceID string, svc ec2iface.EC2API) *AllocatedVolume {
+func NewAllocatedVolume(volumeID string, deviceName string, instanceID string, svc ec2ext.EC2API) *AllocatedVolume {
+
+	volume := &AllocatedVolume{VolumeID: volumeID, DeviceName: deviceName, InstanceID: instanceID, svc: svc}
+	volume.useLogger(log.Default())
 
-	return &AllocatedVolume{VolumeID: volumeID, DeviceName: deviceName, InstanceID: instanceID, svc: svc}
+	return volume
+}
+
+// useLogger logs using a logger, adding the volume, device and instance to each entry
+func (volume *AllocatedVolume) useLogger(logger *log.Logger) {
+	volume.logger = logger.
+		With(log.FieldVolumeID, volume.VolumeID).
+		With(log.FieldDevice, volume.DeviceName).
+		With(log.FieldInstanceID, volume.InstanceID)
 }
 
 func (volume AllocatedVolume) String() string {
@@ -31,22 +75,56 @@ func (volume AllocatedVolume) String() string {
 // Attach attempts to attach the volume
 func (volume AllocatedVolume) Attach() error {
 
-	log.Info.Printf("Attaching Volume (%s) at (%s)\n", volume.VolumeID, volume.DeviceName)
+	volume.logger.Infof("Attaching Volume (%s) at (%s)", volume.VolumeID, volume.DeviceName)
 
 	attached, err := volume.Attached()
 	if err != nil {
-		return fmt.Errorf("error Attaching volume (%s) to instance (%s): %v",
+		return fmt.Errorf("error Attaching volume (%s) to instance (%s): %w",
 			volume.VolumeID, volume.InstanceID, err)
 	}
 
 	if attached {
-		log.Debug.Printf("Volume (%s) already attached - skipping\n", volume.VolumeID)
+		volume.logger.Debugf("Volume (%s) already attached - skipping", volume.VolumeID)
+
+		if volume.leaseDuration > 0 {
+			if _, err := volume.AcquireLease(); err != nil {
+				return fmt.Errorf("error renewing lease on attached volume (%s): %w", volume.VolumeID, err)
+			}
+		}
+
 		return nil
 	}
 
-	if err := volume.waitUntilAvailable(); err != nil {
-		return fmt.Errorf("error waiting for volume (%s) to become available: %v",
-			volume.VolumeID, err)
+	if err := volume.checkAttachable(); err != nil {
+		return fmt.Errorf("error attaching volume (%s) to instance (%s): %w",
+			volume.VolumeID, volume.InstanceID, err)
+	}
+
+	var lease Lease
+
+	if volume.leaseDuration > 0 {
+		if lease, err = volume.AcquireLease(); err != nil {
+			return fmt.Errorf("error attaching volume (%s) to instance (%s): %w",
+				volume.VolumeID, volume.InstanceID, err)
+		}
+	}
+
+	// A volume claimed from a pool was available when it was claimed, so it isn't waited on. If another
+	// instance attaches it first the claim has been lost, and another volume is claimed instead.
+	if volume.claim == nil {
+		if err := volume.waitUntilAvailable(); err != nil {
+			return fmt.Errorf("error waiting for volume (%s) to become available: %w",
+				volume.VolumeID, err)
+		}
+	}
+
+	// The lease may have expired while waiting and been taken by another instance, so its owner and fencing
+	// token are checked again immediately before attaching
+	if volume.leaseDuration > 0 {
+		if err := volume.checkLease(lease); err != nil {
+			return fmt.Errorf("error attaching volume (%s) to instance (%s): %w",
+				volume.VolumeID, volume.InstanceID, err)
+		}
 	}
 
 	opts := &ec2.AttachVolumeInput{
@@ -57,19 +135,19 @@ func (volume AllocatedVolume) Attach() error {
 
 	if _, err := volume.svc.AttachVolume(opts); err != nil {
 
-		return fmt.Errorf("error attaching volume (%s) to instance (%s): %s",
-			volume.VolumeID, volume.InstanceID, err)
+		return fmt.Errorf("error attaching volume (%s) to instance (%s): %w",
+			volume.VolumeID, volume.InstanceID, volume.claimLostError(err))
 
 	}
 
 	err = volume.waitUntilAttached()
 
 	if err != nil {
-		return fmt.Errorf("error waiting for volume (%s) to attach at (%s): %v",
+		return fmt.Errorf("error waiting for volume (%s) to attach at (%s): %w",
 			volume.VolumeID, volume.DeviceName, err)
 	}
 
-	log.Info.Printf("Attached Volume (%s) at (%s)\n", volume.VolumeID, volume.DeviceName)
+	volume.logger.Infof("Attached Volume (%s) at (%s)", volume.VolumeID, volume.DeviceName)
 
 	return nil
 
@@ -78,16 +156,16 @@ func (volume AllocatedVolume) Attach() error {
 // Detach attempts to detach the volume
 func (volume AllocatedVolume) Detach() error {
 
-	log.Info.Printf("Detaching Volume (%s) from (%s)\n", volume.VolumeID, volume.DeviceName)
+	volume.logger.Infof("Detaching Volume (%s) from (%s)", volume.VolumeID, volume.DeviceName)
 
 	attached, err := volume.Attached()
 	if err != nil {
-		return fmt.Errorf("error Detaching volume (%s) from instance (%s): %v",
+		return fmt.Errorf("error Detaching volume (%s) from instance (%s): %w",
 			volume.VolumeID, volume.InstanceID, err)
 	}
 
 	if !attached {
-		log.Debug.Printf("Volume (%s) not attached - skipping\n", volume.VolumeID)
+		volume.logger.Debugf("Volume (%s) not attached - skipping", volume.VolumeID)
 		return nil
 	}
 
@@ -99,7 +177,7 @@ func (volume AllocatedVolume) Detach() error {
 
 	if _, err := volume.svc.DetachVolume(opts); err != nil {
 
-		return fmt.Errorf("error detaching volume (%s) from instance (%s): %s",
+		return fmt.Errorf("error detaching volume (%s) from instance (%s): %w",
 			volume.VolumeID, volume.InstanceID, err)
 
 	}
@@ -107,11 +185,15 @@ func (volume AllocatedVolume) Detach() error {
 	err = volume.waitUntilAvailable()
 
 	if err != nil {
-		return fmt.Errorf("error waiting for volume (%s) to detach at (%s): %v",
+		return fmt.Errorf("error waiting for volume (%s) to detach at (%s): %w",
 			volume.VolumeID, volume.DeviceName, err)
 	}
 
-	log.Info.Printf("Detached Volume (%s) from (%s)\n", volume.VolumeID, volume.DeviceName)
+	volume.logger.Infof("Detached Volume (%s) from (%s)", volume.VolumeID, volume.DeviceName)
+
+	if volume.leaseDuration > 0 {
+		return volume.ReleaseLease()
+	}
 
 	return nil
 
@@ -127,7 +209,7 @@ var doAttached = func(volume *AllocatedVolume) (bool, error) {
 
 	if err != nil {
 
-		return false, fmt.Errorf("error getting volume status for volume (%s): %v",
+		return false, fmt.Errorf("error getting volume status for volume (%s): %w",
 			volume.VolumeID, err)
 
 	}
@@ -138,20 +220,110 @@ var doAttached = func(volume *AllocatedVolume) (bool, error) {
 // Info writes information about this volume
 func (volume AllocatedVolume) Info(w io.Writer) error {
 
+	volumeStatus, err := volume.describe()
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Volume ID (%s), Device Name (%s), Status is %s\n",
+		volume.VolumeID, volume.DeviceName, *volumeStatus.State)
+
+	if volume.Source != "" {
+		fmt.Fprintf(w, "\tAllocated by %s\n", volume.Source)
+	}
+
+	if volume.availabilityZoneMismatch(volumeStatus) {
+		fmt.Fprintf(w, "\tVolume is in availability zone (%s) but the instance is in (%s) - it can't be attached\n",
+			aws.StringValue(volumeStatus.AvailabilityZone), volume.AvailabilityZone)
+	}
+
+	var authorizationErr *AuthorizationError
+
+	if volume.authorization != nil && errors.As(volume.authorization.check(volumeStatus), &authorizationErr) {
+		fmt.Fprintf(w, "\tNot authorized for the instance - %s, so it can't be attached\n", authorizationErr.Reason)
+	}
+
+	if volume.encryption != nil {
+		var encryptionErr *EncryptionError
+
+		if errors.As(volume.encryption.check(volumeStatus), &encryptionErr) {
+			fmt.Fprintf(w, "\tDoesn't satisfy the encryption policy - %s, so it can't be attached\n", encryptionErr.Reason)
+		} else {
+			fmt.Fprintf(w, "\tSatisfies the encryption policy, %s\n", volume.encryption)
+		}
+	}
+
+	if lease := leaseFromTags(volumeStatus.Tags); lease.Active() {
+		fmt.Fprintf(w, "\tLeased to instance (%s) until %s, with fencing token %d\n",
+			lease.Owner, lease.Expires.Format(time.RFC3339), lease.Token)
+	}
+
+	return volume.modificationInfo(w)
+}
+
+// checkAttachable returns an error if the volume can't be attached to its instance, describing the volume
+// once for all the checks made
+func (volume AllocatedVolume) checkAttachable() error {
+
+	if volume.AvailabilityZone == "" && volume.authorization == nil && volume.encryption == nil {
+		return nil
+	}
+
+	status, err := volume.describe()
+	if err != nil {
+		return err
+	}
+
+	if err := volume.authorize(status); err != nil {
+		return err
+	}
+
+	if err := volume.checkEncryption(status); err != nil {
+		return err
+	}
+
+	return volume.checkAvailabilityZone(status)
+}
+
+// checkAvailabilityZone returns an error if the described volume is in a different availability zone to the instance
+func (volume AllocatedVolume) checkAvailabilityZone(status *ec2.Volume) error {
+
+	if volume.availabilityZoneMismatch(status) {
+		return fmt.Errorf("volume (%s) is in availability zone (%s) but instance (%s) is in (%s) : "+
+			"volumes can only be attached in the same availability zone, so snapshot the volume and "+
+			"create a new volume from the snapshot in (%s)",
+			volume.VolumeID, aws.StringValue(status.AvailabilityZone), volume.InstanceID,
+			volume.AvailabilityZone, volume.AvailabilityZone)
+	}
+
+	return nil
+}
+
+// availabilityZoneMismatch returns true if the described volume is known to be in a different
+// availability zone to the instance
+func (volume AllocatedVolume) availabilityZoneMismatch(status *ec2.Volume) bool {
+	return volume.AvailabilityZone != "" && status.AvailabilityZone != nil &&
+		*status.AvailabilityZone != volume.AvailabilityZone
+}
+
+// describe returns the current EC2 description of this volume
+func (volume AllocatedVolume) describe() (*ec2.Volume, error) {
+
 	status, err := volume.svc.DescribeVolumes(volume.describeVolumesInput())
 
 	if err != nil {
 
-		return fmt.Errorf("error getting volume status for volume (%s): %v",
+		return nil, fmt.Errorf("error getting volume status for volume (%s): %w",
 			volume.VolumeID, err)
 
 	}
 
-	volumeStatus := status.Volumes[0]
-	fmt.Fprintf(w, "Volume ID (%s), Device Name (%s), Status is %s\n",
-		volume.VolumeID, volume.DeviceName, *volumeStatus.State)
+	if len(status.Volumes) == 0 {
+		return nil, fmt.Errorf("volume (%s) not found", volume.VolumeID)
+	}
 
-	return nil
+	return status.Volumes[0], nil
 }
 
 // describeVolumesInput provides the structure to describe this volume when attached to the designated EC2 instance
@@ -180,7 +352,7 @@ func (volume AllocatedVolume) describeVolumesInput() *ec2.DescribeVolumesInput {
 
 func (volume AllocatedVolume) waitUntilAvailable() error {
 
-	log.Debug.Printf("Waiting for volume (%s) to become available\n", volume.VolumeID)
+	volume.logger.Debugf("Waiting for volume (%s) to become available", volume.VolumeID)
 	return volume.svc.WaitUntilVolumeAvailable(volume.describeVolumesInput())
 }
 
@@ -192,7 +364,7 @@ func (volume AllocatedVolume) waitUntilAttached() error {
 
 	input := volume.describeVolumesInputWhenAttached()
 
-	log.Debug.Printf("Waiting for volume (%s) to be attached at (%s)\n", volume.VolumeID, volume.DeviceName)
+	volume.logger.Debugf("Waiting for volume (%s) to be attached at (%s)", volume.VolumeID, volume.DeviceName)
 
 	return volume.svc.WaitUntilVolumeInUse(input)
 
@@ -2,6 +2,7 @@ package shared
 
 import (
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -10,7 +11,7 @@ import (
 
 func TestDetachVolumeWhenAttached(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	detachVolumeFuncCalled := false
 	waitUntilVolumeAvailableFuncCalled := false
@@ -52,7 +53,7 @@ func TestDetachVolumeWhenAttached(t *testing.T) {
 
 func TestDetachVolumeWhenDetached(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	detachVolumeFuncCalled := false
 	waitUntilVolumeAvailableFuncCalled := false
@@ -87,7 +88,7 @@ func TestDetachVolumeWhenDetached(t *testing.T) {
 
 func TestDetachVolumeErrorCallingWaitUntilVolumeAvailableAPI(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	mockEC2Service := &testhelpers.MockEC2Service{
 		DetachVolumeFunc: testhelpers.DetachVolumeForVolumeIDSuccess(expectedVolumeID),
@@ -115,7 +116,7 @@ func TestDetachVolumeErrorCallingWaitUntilVolumeAvailableAPI(t *testing.T) {
 
 func TestDetachVolumeErrorCallingDetachVolumeAPI(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	mockEC2Service := &testhelpers.MockEC2Service{
 		DetachVolumeFunc: func(input *ec2.DetachVolumeInput) (*ec2.VolumeAttachment, error) {
@@ -141,7 +142,7 @@ func TestDetachVolumeErrorCallingDetachVolumeAPI(t *testing.T) {
 
 func TestAttachVolumeWhenAttached(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	waitUntilVolumeAvailableFuncCalled := false
 	attachVolumeFuncCalled := false
@@ -180,7 +181,7 @@ func TestAttachVolumeWhenAttached(t *testing.T) {
 
 func TestAttachVolumeWhenDetached(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	waitUntilVolumeAvailableFuncCalled := false
 	attachVolumeFuncCalled := false
@@ -231,7 +232,7 @@ func TestAttachVolumeWhenDetached(t *testing.T) {
 
 func TestAttachVolumeErrorCallingWaitUntilVolumeAvailableAPI(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	mockEC2Service := &testhelpers.MockEC2Service{
 		WaitUntilVolumeAvailableFunc: func(input *ec2.DescribeVolumesInput) error {
@@ -257,7 +258,7 @@ func TestAttachVolumeErrorCallingWaitUntilVolumeAvailableAPI(t *testing.T) {
 
 func TestAttachVolumeErrorCallingAttachVolumeAPI(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	mockEC2Service := &testhelpers.MockEC2Service{
 		AttachVolumeFunc: func(input *ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error) {
@@ -284,7 +285,7 @@ func TestAttachVolumeErrorCallingAttachVolumeAPI(t *testing.T) {
 
 func TestAttachVolumeErrorCallingWaitUntilVolumeInUseAPI(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	mockEC2Service := &testhelpers.MockEC2Service{
 		AttachVolumeFunc:             testhelpers.AttachVolumeForVolumeIDSuccess(expectedVolumeID),
@@ -323,3 +324,44 @@ func setVolumeDetached() {
 		return false, nil
 	}
 }
+
+func TestAttachVolumeFailsInDifferentAvailabilityZone(t *testing.T) {
+
+	expectedVolumeID := "vol-87654321"
+
+	waitUntilVolumeAvailableFuncCalled := false
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeVolumesFunc: testhelpers.DescribeVolumeForID(expectedVolumeID, &ec2.DescribeVolumesOutput{
+			Volumes: []*ec2.Volume{testhelpers.NewVolumeBuilder().SetAvailabilityZone("erewhonb").Build()},
+		}),
+		WaitUntilVolumeAvailableFunc: func(input *ec2.DescribeVolumesInput) error {
+			waitUntilVolumeAvailableFuncCalled = true
+			return nil
+		},
+	}
+
+	saved := doAttached
+	defer func() {
+		doAttached = saved
+	}()
+
+	setVolumeDetached()
+
+	underTest := NewAllocatedVolume(expectedVolumeID, "/dev/sdg", "i-11223344", mockEC2Service)
+	underTest.AvailabilityZone = "erewhona"
+
+	err := underTest.Attach()
+
+	if err == nil {
+		t.Fatal("Attaching a volume in a different availability zone should have failed")
+	}
+
+	if !strings.Contains(err.Error(), "erewhonb") || !strings.Contains(err.Error(), "erewhona") {
+		t.Errorf("The error should name both availability zones, but was '%v'", err)
+	}
+
+	if waitUntilVolumeAvailableFuncCalled {
+		t.Error("The attach should have failed before waiting for the volume to become available")
+	}
+}
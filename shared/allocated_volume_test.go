@@ -9,12 +9,13 @@ import (
 	"io/ioutil"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
 	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
 )
 
 func TestInfo(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 	expectedState := "blooming"
 	expectedDeviceName := "/dev/sdg"
 
@@ -26,6 +27,9 @@ func TestInfo(t *testing.T) {
 			&ec2.DescribeVolumesOutput{
 				Volumes: []*ec2.Volume{volume},
 			}),
+		DescribeVolumesModificationsFunc: func(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+			return &ec2ext.DescribeVolumesModificationsOutput{}, nil
+		},
 	}
 
 	underTest := NewAllocatedVolume(expectedVolumeID, expectedDeviceName, "i-11223344", mockEC2Service)
@@ -51,9 +55,41 @@ func TestInfo(t *testing.T) {
 
 }
 
+func TestInfoFlagsAvailabilityZoneMismatch(t *testing.T) {
+
+	expectedVolumeID := "vol-87654321"
+	expectedState := "available"
+
+	volume := testhelpers.NewVolumeBuilder().SetState(&expectedState).SetAvailabilityZone("erewhonb").Build()
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeVolumesFunc: testhelpers.DescribeVolumeForID(
+			expectedVolumeID,
+			&ec2.DescribeVolumesOutput{
+				Volumes: []*ec2.Volume{volume},
+			}),
+		DescribeVolumesModificationsFunc: func(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+			return &ec2ext.DescribeVolumesModificationsOutput{}, nil
+		},
+	}
+
+	underTest := NewAllocatedVolume(expectedVolumeID, "/dev/sdg", "i-11223344", mockEC2Service)
+	underTest.AvailabilityZone = "erewhona"
+
+	buf := new(bytes.Buffer)
+
+	if err := underTest.Info(buf); err != nil {
+		t.Errorf("Getting info shouldn't have failed, but I got %v", err)
+	}
+
+	if infoString := buf.String(); !strings.Contains(infoString, "availability zone (erewhonb) but the instance is in (erewhona)") {
+		t.Errorf("Info message should have flagged the availability zone mismatch, but message was : '%s'", infoString)
+	}
+}
+
 func TestInfoErrorCallingDescribeVolumesAPI(t *testing.T) {
 
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	mockEC2Service := &testhelpers.MockEC2Service{
 		DescribeVolumesFunc: func(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
@@ -72,7 +108,7 @@ func TestInfoErrorCallingDescribeVolumesAPI(t *testing.T) {
 }
 
 func TestAttachedStatusWhenDetached(t *testing.T) {
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	mockEC2Service := &testhelpers.MockEC2Service{
 		DescribeVolumesFunc: testhelpers.DescribeVolumeForID(
@@ -91,7 +127,7 @@ func TestAttachedStatusWhenDetached(t *testing.T) {
 }
 
 func TestAttachedStatusWhenAttached(t *testing.T) {
-	expectedVolumeID := "vol-54321"
+	expectedVolumeID := "vol-87654321"
 
 	volume := testhelpers.NewVolumeBuilder().Build()
 
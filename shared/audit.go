@@ -0,0 +1,439 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+)
+
+// Results of audited operations
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// Audited actions
+const (
+	AuditActionAttach     = "attach"
+	AuditActionDetach     = "detach"
+	AuditActionModify     = "modify"
+	AuditActionRenewLease = "renew-lease"
+)
+
+// auditClock tells the time operations start and finish
+var auditClock = time.Now
+
+// AuditRecord is a structured record of an operation on a volume, kept for incident review
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	RunID      string    `json:"run_id"`
+	InstanceID string    `json:"instance_id"`
+	VolumeID   string    `json:"volume_id"`
+	Device     string    `json:"device"`
+	Action     string    `json:"action"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	// CallerARN and AccountID identify the credentials used, as told by STS GetCallerIdentity
+	CallerARN string `json:"caller_arn,omitempty"`
+	AccountID string `json:"account_id,omitempty"`
+	// Previous is the SHA-256 hash of the record before, so records changed or removed can be detected
+	Previous string `json:"previous"`
+}
+
+// AuditSink keeps audit records, each written as a line of JSON
+type AuditSink interface {
+	// WriteRecord writes a record, without a trailing newline
+	WriteRecord(record []byte) error
+	// LastRecord returns the last record already kept, so the records written follow on from it, or nil
+	LastRecord() ([]byte, error)
+}
+
+// sharedAuditSink is a sink other processes may write to at the same time, which is locked while the last
+// record is read and the next written so the records form a single chain
+type sharedAuditSink interface {
+	AuditSink
+	lock() error
+	unlock() error
+}
+
+// ParseAuditSink parses where to keep audit records : stdout, syslog or file:<path>
+func ParseAuditSink(value string) (AuditSink, error) {
+
+	switch {
+	case value == "stdout":
+		return NewAuditWriter(os.Stdout), nil
+	case value == "syslog":
+		return NewSyslogAudit(), nil
+	case strings.HasPrefix(value, "file:") && len(value) > len("file:"):
+		return NewAuditFile(value[len("file:"):]), nil
+	default:
+		return nil, fmt.Errorf("unknown audit sink '%s', expected stdout, syslog or file:<path>", value)
+	}
+}
+
+// auditWriter writes records to a writer, each on a line of its own
+type auditWriter struct {
+	w io.Writer
+}
+
+// NewAuditWriter returns a sink writing records to w
+func NewAuditWriter(w io.Writer) AuditSink {
+	return &auditWriter{w: w}
+}
+
+func (s *auditWriter) WriteRecord(record []byte) error {
+	_, err := s.w.Write(append(record, '\n'))
+	return err
+}
+
+func (s *auditWriter) LastRecord() ([]byte, error) {
+	return nil, nil
+}
+
+// auditFile appends records to a file, opened when first used. The file is locked while each record is written,
+// so runs sharing the file chain their records one after another.
+type auditFile struct {
+	path string
+	file *os.File
+}
+
+// NewAuditFile returns a sink appending records to a file, which is created if needed and only ever appended to
+func NewAuditFile(path string) AuditSink {
+	return &auditFile{path: path}
+}
+
+func (s *auditFile) open() error {
+
+	if s.file != nil {
+		return nil
+	}
+
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+
+	if err != nil {
+		return fmt.Errorf("unable to open audit file (%s) : %v", s.path, err)
+	}
+
+	s.file = file
+
+	return nil
+}
+
+func (s *auditFile) lock() error {
+
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	if err := syscall.Flock(int(s.file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("unable to lock audit file (%s) : %v", s.path, err)
+	}
+
+	return nil
+}
+
+func (s *auditFile) unlock() error {
+	return syscall.Flock(int(s.file.Fd()), syscall.LOCK_UN)
+}
+
+func (s *auditFile) WriteRecord(record []byte) error {
+
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	if _, err := s.file.Write(append(record, '\n')); err != nil {
+		return fmt.Errorf("unable to write to audit file (%s) : %v", s.path, err)
+	}
+
+	return nil
+}
+
+// auditTailSize is how much of the end of an audit file is read at first to find the last record
+const auditTailSize = 64 * 1024
+
+func (s *auditFile) LastRecord() ([]byte, error) {
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	info, err := s.file.Stat()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read audit file (%s) : %v", s.path, err)
+	}
+
+	// Read more of the end of the file until it holds the whole of the last record
+	for tail := int64(auditTailSize); ; tail *= 2 {
+
+		if tail > info.Size() {
+			tail = info.Size()
+		}
+
+		data := make([]byte, tail)
+
+		if _, err := s.file.ReadAt(data, info.Size()-tail); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("unable to read audit file (%s) : %v", s.path, err)
+		}
+
+		data = bytes.TrimRight(data, "\n")
+		start := bytes.LastIndexByte(data, '\n')
+
+		if start >= 0 || tail == info.Size() {
+			if len(data) == 0 {
+				return nil, nil
+			}
+			return data[start+1:], nil
+		}
+	}
+}
+
+// syslogAudit sends records to the local syslog daemon, connecting when the first record is written
+type syslogAudit struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogAudit returns a sink sending records to the local syslog daemon
+func NewSyslogAudit() AuditSink {
+	return &syslogAudit{}
+}
+
+func (s *syslogAudit) WriteRecord(record []byte) error {
+
+	if s.writer == nil {
+		writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "ebs-volumes")
+
+		if err != nil {
+			return fmt.Errorf("unable to connect to syslog : %v", err)
+		}
+
+		s.writer = writer
+	}
+
+	return s.writer.Info(string(record))
+}
+
+func (s *syslogAudit) LastRecord() ([]byte, error) {
+	return nil, nil
+}
+
+// NewRunID returns an ID for a run, so the records of operations made by the same run can be told apart
+func NewRunID() string {
+
+	id := make([]byte, 8)
+
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", auditClock().UnixNano())
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// Auditor records operations on volumes to a sink, chaining each record to the one before by its hash
+type Auditor struct {
+	sink  AuditSink
+	runID string
+
+	mu       sync.Mutex
+	started  bool
+	previous string
+
+	identity  func() (*sts.GetCallerIdentityOutput, error)
+	known     bool
+	callerARN string
+	accountID string
+
+	logger *log.Logger
+}
+
+// NewAuditor returns an auditor keeping records for a run in a sink
+func NewAuditor(sink AuditSink, runID string) *Auditor {
+	return &Auditor{sink: sink, runID: runID}
+}
+
+// WithAuditor records each operation on a volume
+func WithAuditor(auditor *Auditor) InstanceOption {
+	return func(e *EC2Instance) {
+		e.auditor = auditor
+	}
+}
+
+// useSTS identifies the caller with STS, unless a way to identify it has already been given, logging any
+// failure to identify it
+func (a *Auditor) useSTS(svc *sts.STS, logger *log.Logger) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.identity == nil {
+		a.logger = logger
+		a.identity = func() (*sts.GetCallerIdentityOutput, error) {
+			return svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+		}
+	}
+}
+
+// Record fills in the run, caller and previous record's hash, then keeps the record
+func (a *Auditor) Record(record AuditRecord) error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.known && a.identity != nil {
+		identity, err := a.identity()
+
+		if err != nil {
+			a.logger.Errorf("Unable to identify the caller for the audit records : %v", err)
+		} else {
+			a.callerARN, a.accountID = aws.StringValue(identity.Arn), aws.StringValue(identity.Account)
+		}
+
+		a.known = true
+	}
+
+	shared, isShared := a.sink.(sharedAuditSink)
+
+	if isShared {
+		if err := shared.lock(); err != nil {
+			return err
+		}
+		defer shared.unlock()
+	}
+
+	// Other runs may have written to a shared sink since the last record was written
+	if !a.started || isShared {
+		last, err := a.sink.LastRecord()
+
+		if err != nil {
+			return err
+		}
+
+		a.previous = ""
+
+		if last != nil {
+			a.previous = auditHash(last)
+		}
+
+		a.started = true
+	}
+
+	record.RunID, record.CallerARN, record.AccountID, record.Previous = a.runID, a.callerARN, a.accountID, a.previous
+
+	line, err := json.Marshal(record)
+
+	if err != nil {
+		return fmt.Errorf("unable to encode audit record : %v", err)
+	}
+
+	if err := a.sink.WriteRecord(line); err != nil {
+		return err
+	}
+
+	a.previous = auditHash(line)
+
+	return nil
+}
+
+func auditHash(record []byte) string {
+	sum := sha256.Sum256(record)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditLog returns an error if a record doesn't follow on from the record before it, as when records
+// have been changed or removed. The first record may follow on from records kept elsewhere.
+func VerifyAuditLog(r io.Reader) (int, error) {
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	count, previous := 0, ""
+
+	for scanner.Scan() {
+
+		line := scanner.Bytes()
+
+		if len(line) == 0 {
+			continue
+		}
+
+		count++
+
+		var record AuditRecord
+
+		if err := json.Unmarshal(line, &record); err != nil {
+			return count, fmt.Errorf("audit record %d isn't valid : %v", count, err)
+		}
+
+		if count > 1 && record.Previous != previous {
+			return count, fmt.Errorf("audit record %d doesn't follow on from the record before it", count)
+		}
+
+		previous = auditHash(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("unable to read audit records : %v", err)
+	}
+
+	return count, nil
+}
+
+// operation adds the operation to the entries logged for each volume, and records the outcome of the action
+// on each volume when an auditor is given
+func (e EC2Instance) operation(action string, apply func(volume *AllocatedVolume) error) func(volume *AllocatedVolume) error {
+
+	return func(volume *AllocatedVolume) error {
+
+		volume.logger = volume.logger.With(log.FieldOperation, action)
+
+		if e.auditor == nil {
+			return apply(volume)
+		}
+
+		start := auditClock()
+		err := apply(volume)
+
+		record := AuditRecord{
+			Time:       start.UTC(),
+			InstanceID: volume.InstanceID,
+			VolumeID:   volume.VolumeID,
+			Device:     volume.DeviceName,
+			Action:     action,
+			Result:     AuditResultSuccess,
+			DurationMS: int64(auditClock().Sub(start) / time.Millisecond),
+		}
+
+		if err != nil {
+			record.Result, record.Error = AuditResultFailure, strings.TrimSpace(err.Error())
+		}
+
+		if auditErr := e.auditor.Record(record); auditErr != nil {
+			auditErr = fmt.Errorf("unable to record %s of volume (%s) : %v", action, volume.VolumeID, auditErr)
+
+			if err == nil {
+				return auditErr
+			}
+
+			e.logger.Errorf("%v", auditErr)
+		}
+
+		return err
+	}
+}
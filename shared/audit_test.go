@@ -0,0 +1,215 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers/ec2fake"
+)
+
+func TestParseAuditSink(t *testing.T) {
+
+	for _, value := range []string{"stdout", "syslog", "file:/var/log/ebs-volumes/audit.log"} {
+		if _, err := ParseAuditSink(value); err != nil {
+			t.Errorf("Parsing '%s' shouldn't have failed, but I got %v", value, err)
+		}
+	}
+
+	for _, value := range []string{"", "file:", "stderr"} {
+		if _, err := ParseAuditSink(value); err == nil {
+			t.Errorf("Parsing '%s' should have failed", value)
+		}
+	}
+}
+
+func auditRecords(t *testing.T, data []byte) []AuditRecord {
+
+	var records []AuditRecord
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record AuditRecord
+
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Expected a JSON audit record, but got %s : %v", line, err)
+		}
+
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestAuditorChainsRecords(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	auditor := NewAuditor(NewAuditWriter(buf), "run-1")
+	auditor.identity = func() (*sts.GetCallerIdentityOutput, error) {
+		return &sts.GetCallerIdentityOutput{Arn: aws.String("arn:aws:iam::123456789012:user/ops"), Account: aws.String("123456789012")}, nil
+	}
+
+	for _, volumeID := range []string{"vol-11111111", "vol-22222222", "vol-33333333"} {
+		if err := auditor.Record(AuditRecord{VolumeID: volumeID, Action: AuditActionAttach, Result: AuditResultSuccess}); err != nil {
+			t.Fatalf("Recording shouldn't have failed, but I got %v", err)
+		}
+	}
+
+	records := auditRecords(t, buf.Bytes())
+
+	if len(records) != 3 || records[0].Previous != "" || records[1].Previous == "" {
+		t.Fatalf("Expected three chained records, but got %v", records)
+	}
+
+	if records[2].RunID != "run-1" || records[2].CallerARN != "arn:aws:iam::123456789012:user/ops" || records[2].AccountID != "123456789012" {
+		t.Errorf("Expected the run and caller to be recorded, but got %v", records[2])
+	}
+
+	if count, err := VerifyAuditLog(bytes.NewReader(buf.Bytes())); err != nil || count != 3 {
+		t.Errorf("Expected the records to be verified, but got %d, %v", count, err)
+	}
+
+	tampered := strings.Replace(buf.String(), "vol-22222222", "vol-99999999", 1)
+
+	if _, err := VerifyAuditLog(strings.NewReader(tampered)); err == nil {
+		t.Error("Expected a changed record to be detected")
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	removed := lines[0] + "\n" + lines[2] + "\n"
+
+	if _, err := VerifyAuditLog(strings.NewReader(removed)); err == nil {
+		t.Error("Expected a removed record to be detected")
+	}
+}
+
+func TestAuditFileContinuesChain(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	for _, runID := range []string{"run-1", "run-2"} {
+		if err := NewAuditor(NewAuditFile(path), runID).Record(AuditRecord{VolumeID: "vol-11111111"}); err != nil {
+			t.Fatalf("Recording shouldn't have failed, but I got %v", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		t.Fatalf("Unable to read the audit file : %v", err)
+	}
+
+	if count, err := VerifyAuditLog(bytes.NewReader(data)); err != nil || count != 2 {
+		t.Errorf("Expected the second run to follow on from the first, but got %d, %v", count, err)
+	}
+}
+
+func TestAuditFileSharedByConcurrentRuns(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// Each run has its own sink, as separate processes would
+	runs := []*Auditor{NewAuditor(NewAuditFile(path), "run-1"), NewAuditor(NewAuditFile(path), "run-2")}
+
+	// A record longer than the end of the file read at first to find the last record
+	if err := runs[0].Record(AuditRecord{VolumeID: "vol-11111111", Error: strings.Repeat("x", 2*auditTailSize)}); err != nil {
+		t.Fatalf("Recording shouldn't have failed, but I got %v", err)
+	}
+
+	var wg sync.WaitGroup
+
+	for _, run := range runs {
+		wg.Add(1)
+		go func(run *Auditor) {
+			defer wg.Done()
+
+			for i := 0; i < 10; i++ {
+				if err := run.Record(AuditRecord{VolumeID: "vol-11111111"}); err != nil {
+					t.Errorf("Recording shouldn't have failed, but I got %v", err)
+				}
+			}
+		}(run)
+	}
+
+	wg.Wait()
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		t.Fatalf("Unable to read the audit file : %v", err)
+	}
+
+	if count, err := VerifyAuditLog(bytes.NewReader(data)); err != nil || count != 21 {
+		t.Errorf("Expected the records of both runs to form a single chain, but got %d, %v", count, err)
+	}
+}
+
+func TestAuditedRecordsFailures(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	saved := auditClock
+	defer func() {
+		auditClock = saved
+	}()
+
+	auditClock = func() time.Time {
+		now = now.Add(1500 * time.Millisecond)
+		return now
+	}
+
+	buf := &bytes.Buffer{}
+	instance := NewEC2Instance(nil, nil, WithAuditor(NewAuditor(NewAuditWriter(buf), "run-1")))
+	volume := NewAllocatedVolume("vol-12345678", "/dev/sdh", "id-98765", nil)
+
+	failure := errors.New("unable to detach volume : Whoops\n")
+
+	if err := instance.operation(AuditActionDetach, func(*AllocatedVolume) error { return failure })(volume); err != failure {
+		t.Errorf("Expected the action's error, but got %v", err)
+	}
+
+	record := auditRecords(t, buf.Bytes())[0]
+
+	expected := AuditRecord{Time: time.Date(2021, 6, 1, 12, 0, 1, 500000000, time.UTC), RunID: "run-1", InstanceID: "id-98765",
+		VolumeID: "vol-12345678", Device: "/dev/sdh", Action: AuditActionDetach, Result: AuditResultFailure,
+		Error: "unable to detach volume : Whoops", DurationMS: 1500}
+
+	if record != expected {
+		t.Errorf("Expected %v, but got %v", expected, record)
+	}
+}
+
+func TestAttachVolumesAuditedWithCallerIdentity(t *testing.T) {
+
+	fake := ec2fake.New("erewhon")
+	t.Cleanup(fake.Close)
+
+	fake.AddInstance(ec2fake.Instance{ID: "i-0123456789abcdef0", AvailabilityZone: "erewhona",
+		Tags: map[string]string{"volume_/dev/sdf": "vol-11111111"}})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-11111111", AvailabilityZone: "erewhona"})
+
+	buf := &bytes.Buffer{}
+	instance, err := GetInstanceByID("i-0123456789abcdef0", "erewhon", WithAWSConfig(fake.AWSConfig()), WithWaitDelay(0),
+		WithAuditor(NewAuditor(NewAuditWriter(buf), "run-1")))
+
+	if err != nil {
+		t.Fatalf("Getting the instance shouldn't have failed, but I got %v", err)
+	}
+
+	if err := instance.AttachVolumes(); err != nil {
+		t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+	}
+
+	records := auditRecords(t, buf.Bytes())
+
+	if len(records) != 1 || records[0].Result != AuditResultSuccess || records[0].Action != AuditActionAttach ||
+		records[0].CallerARN != ec2fake.CallerARN || records[0].AccountID != ec2fake.AccountID {
+		t.Errorf("Expected the attach to be recorded along with the caller, but got %v", records)
+	}
+}
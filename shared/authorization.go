@@ -0,0 +1,170 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AllowedInstanceTag names the tag on a volume listing the instances allowed to attach it, separated by commas
+const AllowedInstanceTag = TagNamespace + "allowed-instance"
+
+// ErrNotAuthorized is wrapped by an AuthorizationError when a volume isn't permitted to be attached to an instance
+var ErrNotAuthorized = errors.New("volume is not authorized for the instance")
+
+// AuthorizationRule requires volumes to carry a tag permitting them to be attached to an instance, so being
+// able to change the tags on an instance isn't enough to attach any volume in the account to it
+type AuthorizationRule struct {
+	// SharedTag requires a volume to have the same value for the tag as the instance, such as a cluster tag.
+	// When empty the volume's AllowedInstanceTag must list the instance.
+	SharedTag string
+}
+
+// ParseAuthorizationRule parses a rule, which is either allowed-instance or tag:<key> for a shared tag
+func ParseAuthorizationRule(value string) (AuthorizationRule, error) {
+
+	switch {
+	case value == "allowed-instance":
+		return AuthorizationRule{}, nil
+	case strings.HasPrefix(value, "tag:") && len(value) > len("tag:"):
+		return AuthorizationRule{SharedTag: value[len("tag:"):]}, nil
+	default:
+		return AuthorizationRule{}, fmt.Errorf("unknown authorization rule '%s', expected allowed-instance or tag:<key>", value)
+	}
+}
+
+func (r AuthorizationRule) String() string {
+
+	if r.SharedTag != "" {
+		return "tag:" + r.SharedTag
+	}
+
+	return "allowed-instance"
+}
+
+// WithAuthorizationRule only attaches volumes the rule permits for the instance
+func WithAuthorizationRule(rule AuthorizationRule) InstanceOption {
+	return func(e *EC2Instance) {
+		e.authorization = &rule
+	}
+}
+
+// AuthorizationError describes why a volume isn't permitted to be attached to an instance
+type AuthorizationError struct {
+	VolumeID   string
+	InstanceID string
+	Reason     string
+}
+
+func (e *AuthorizationError) Error() string {
+	return fmt.Sprintf("volume (%s) is not authorized for instance (%s) as %s", e.VolumeID, e.InstanceID, e.Reason)
+}
+
+// Unwrap returns ErrNotAuthorized
+func (e *AuthorizationError) Unwrap() error {
+	return ErrNotAuthorized
+}
+
+// volumeAuthorization is a rule applied for an instance
+type volumeAuthorization struct {
+	rule       AuthorizationRule
+	instanceID string
+	// instanceValue is the instance's value for the shared tag, or empty when it doesn't have the tag
+	instanceValue string
+}
+
+// forInstance applies the rule for an instance with tags
+func (r *AuthorizationRule) forInstance(instanceID string, tags []*ec2.TagDescription) *volumeAuthorization {
+
+	if r == nil {
+		return nil
+	}
+
+	authorization := &volumeAuthorization{rule: *r, instanceID: instanceID}
+
+	for _, tag := range tags {
+		if r.SharedTag != "" && aws.StringValue(tag.Key) == r.SharedTag {
+			authorization.instanceValue = aws.StringValue(tag.Value)
+		}
+	}
+
+	return authorization
+}
+
+// check returns an AuthorizationError if the described volume isn't permitted for the instance
+func (a *volumeAuthorization) check(volume *ec2.Volume) error {
+
+	reason := a.refusal(volume)
+
+	if reason == "" {
+		return nil
+	}
+
+	return &AuthorizationError{VolumeID: aws.StringValue(volume.VolumeId), InstanceID: a.instanceID, Reason: reason}
+}
+
+// refusal returns why the volume isn't permitted for the instance, or an empty string if it is
+func (a *volumeAuthorization) refusal(volume *ec2.Volume) string {
+
+	key := AllowedInstanceTag
+
+	if a.rule.SharedTag != "" {
+		key = a.rule.SharedTag
+	}
+
+	value, ok := "", false
+
+	for _, tag := range volume.Tags {
+		if aws.StringValue(tag.Key) == key {
+			value, ok = aws.StringValue(tag.Value), true
+		}
+	}
+
+	if a.rule.SharedTag != "" {
+		switch {
+		case a.instanceValue == "":
+			return fmt.Sprintf("the instance has no '%s' tag", key)
+		case !ok:
+			return fmt.Sprintf("the volume has no '%s' tag", key)
+		case value != a.instanceValue:
+			return fmt.Sprintf("the volume's '%s' tag is '%s' but the instance's is '%s'", key, value, a.instanceValue)
+		}
+
+		return ""
+	}
+
+	if !ok {
+		return fmt.Sprintf("the volume has no '%s' tag", key)
+	}
+
+	for _, instanceID := range strings.Split(value, ",") {
+		if strings.TrimSpace(instanceID) == a.instanceID {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("the volume's '%s' tag doesn't list the instance", key)
+}
+
+// authorize returns an AuthorizationError if the described volume isn't permitted to be attached to its
+// instance, along with what allocated the volume when it's known
+func (volume AllocatedVolume) authorize(status *ec2.Volume) error {
+
+	if volume.authorization == nil {
+		return nil
+	}
+
+	if err := volume.authorization.check(status); err != nil {
+
+		if volume.Source != "" {
+			return fmt.Errorf("%w, and was allocated by %s", err, volume.Source)
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+)
+
+func TestParseAuthorizationRule(t *testing.T) {
+
+	var tests = []struct {
+		value    string
+		expected AuthorizationRule
+		valid    bool
+	}{
+		{"allowed-instance", AuthorizationRule{}, true},
+		{"tag:cluster", AuthorizationRule{SharedTag: "cluster"}, true},
+		{"tag:", AuthorizationRule{}, false},
+		{"cluster", AuthorizationRule{}, false},
+		{"", AuthorizationRule{}, false},
+	}
+
+	for _, test := range tests {
+		rule, err := ParseAuthorizationRule(test.value)
+
+		if (err == nil) != test.valid {
+			t.Errorf("Parsing '%s' : expected valid to be %t, but got %v", test.value, test.valid, err)
+			continue
+		}
+
+		if test.valid && (rule != test.expected || rule.String() != test.value) {
+			t.Errorf("Parsing '%s' : expected %v, but got %v", test.value, test.expected, rule)
+		}
+	}
+}
+
+func authorizationTags(tags map[string]string) []*ec2.TagDescription {
+
+	var descriptions []*ec2.TagDescription
+
+	for key, value := range tags {
+		descriptions = append(descriptions, &ec2.TagDescription{Key: aws.String(key), Value: aws.String(value)})
+	}
+
+	return descriptions
+}
+
+func TestAuthorizationCheck(t *testing.T) {
+
+	var tests = []struct {
+		rule         AuthorizationRule
+		instanceTags map[string]string
+		volumeTags   map[string]string
+		authorized   bool
+	}{
+		{AuthorizationRule{}, nil, map[string]string{AllowedInstanceTag: "id-98765"}, true},
+		{AuthorizationRule{}, nil, map[string]string{AllowedInstanceTag: "i-00000001, id-98765"}, true},
+		{AuthorizationRule{}, nil, map[string]string{AllowedInstanceTag: "i-00000001"}, false},
+		{AuthorizationRule{}, nil, map[string]string{AllowedInstanceTag: "id-987"}, false},
+		{AuthorizationRule{}, nil, nil, false},
+		{AuthorizationRule{SharedTag: "cluster"}, map[string]string{"cluster": "blue"}, map[string]string{"cluster": "blue"}, true},
+		{AuthorizationRule{SharedTag: "cluster"}, map[string]string{"cluster": "blue"}, map[string]string{"cluster": "green"}, false},
+		{AuthorizationRule{SharedTag: "cluster"}, map[string]string{"cluster": "blue"}, nil, false},
+		{AuthorizationRule{SharedTag: "cluster"}, nil, map[string]string{"cluster": "blue"}, false},
+		{AuthorizationRule{SharedTag: "cluster"}, nil, map[string]string{AllowedInstanceTag: "id-98765"}, false},
+	}
+
+	for _, test := range tests {
+		volume := &ec2.Volume{VolumeId: aws.String("vol-12345678")}
+
+		for key, value := range test.volumeTags {
+			volume.Tags = append(volume.Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+
+		err := test.rule.forInstance("id-98765", authorizationTags(test.instanceTags)).check(volume)
+
+		if (err == nil) != test.authorized {
+			t.Errorf("Rule %s with instance tags %v and volume tags %v : expected authorized to be %t, but got %v",
+				test.rule, test.instanceTags, test.volumeTags, test.authorized, err)
+		}
+
+		if err != nil && !errors.Is(err, ErrNotAuthorized) {
+			t.Errorf("Expected %v to be ErrNotAuthorized", err)
+		}
+	}
+}
+
+func TestNoRuleAuthorizesEveryVolume(t *testing.T) {
+
+	var rule *AuthorizationRule
+
+	if authorization := rule.forInstance("id-98765", nil); authorization != nil {
+		t.Errorf("Expected no authorization without a rule, but got %v", authorization)
+	}
+}
+
+func TestAttachRefusedWhenNotAuthorized(t *testing.T) {
+
+	svc := newLeaseService(map[string]string{AllowedInstanceTag: "i-00000001"})
+	svc.AttachVolumeFunc = func(*ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error) {
+		t.Error("Shouldn't have attached a volume which isn't authorized")
+		return &ec2.VolumeAttachment{}, nil
+	}
+
+	volume := NewAllocatedVolume("vol-12345678", "/dev/sdh", "id-98765", svc)
+	volume.authorization = (&AuthorizationRule{}).forInstance("id-98765", nil)
+
+	if err := volume.Attach(); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("Expected the attach to be refused, but got %v", err)
+	}
+
+	var authorizationError *AuthorizationError
+
+	if err := volume.Attach(); !errors.As(err, &authorizationError) || authorizationError.VolumeID != "vol-12345678" {
+		t.Errorf("Expected an AuthorizationError for the volume, but got %v", err)
+	}
+}
+
+func TestAttachChecksDescribeVolumeOnceAndRefusalIsLoggedOnce(t *testing.T) {
+
+	svc := newLeaseService(map[string]string{AllowedInstanceTag: "id-98765"})
+
+	describeVolume := svc.DescribeVolumesFunc
+	described := 0
+
+	svc.DescribeVolumesFunc = func(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+		if len(input.Filters) == 0 {
+			described++
+		}
+		return describeVolume(input)
+	}
+
+	buf := &bytes.Buffer{}
+
+	volume := NewAllocatedVolume("vol-12345678", "/dev/sdh", "id-98765", svc)
+	volume.AvailabilityZone = "erewhona"
+	volume.authorization = (&AuthorizationRule{}).forInstance("id-98765", nil)
+	volume.encryption = &EncryptionPolicy{}
+	volume.useLogger(log.New(buf, log.LevelWarn, log.FormatText))
+
+	if err := applyTo([]*AllocatedVolume{volume}, func(volume *AllocatedVolume) error {
+		return volume.Attach()
+	}); err == nil {
+		t.Fatal("Expected the attach to be refused")
+	}
+
+	if described != 1 {
+		t.Errorf("Expected the volume to be described once for the checks, but it was described %d times", described)
+	}
+
+	if lines := strings.Count(buf.String(), "\n"); lines != 1 || !strings.Contains(buf.String(), "isn't encrypted") {
+		t.Errorf("Expected the refusal to be logged once, but got %s", buf)
+	}
+}
@@ -0,0 +1,108 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+)
+
+// AWSRequestFailure returns the failed AWS request in an error's chain, giving its code, status and request ID
+func AWSRequestFailure(err error) (awserr.RequestFailure, bool) {
+
+	var failure awserr.RequestFailure
+
+	if errors.As(err, &failure) {
+		return failure, true
+	}
+
+	return nil, false
+}
+
+// LogError logs an error at the error level, along with the code, status and request ID of any failed AWS
+// request causing it
+func LogError(logger *log.Logger, err error) {
+
+	if failure, ok := AWSRequestFailure(err); ok {
+		logger = logger.
+			With(log.FieldAWSErrorCode, failure.Code()).
+			With(log.FieldAWSStatusCode, failure.StatusCode()).
+			With(log.FieldAWSRequestID, failure.RequestID())
+	}
+
+	logger.Errorf("%v", err)
+}
+
+// WithAWSDebug logs the AWS SDK's traces of each request and response at the debug level, with credentials redacted
+func WithAWSDebug() InstanceOption {
+	return func(e *EC2Instance) {
+		e.awsDebug = true
+	}
+}
+
+// awsDebugLevel logs requests and responses along with their bodies, retries and failures
+var awsDebugLevel = aws.LogDebugWithHTTPBody | aws.LogDebugWithRequestRetries | aws.LogDebugWithRequestErrors
+
+// redactions hide credentials in the traces logged by the AWS SDK
+var redactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)((?:Authorization|X-Amz-Security-Token|X-Aws-Ec2-Metadata-Token): )[^\r\n]*`), "${1}REDACTED"},
+	{regexp.MustCompile(`(?i)((?:X-Amz-Security-Token|X-Amz-Signature|X-Amz-Credential|SessionToken|SecretAccessKey)=)[^&\s]*`), "${1}REDACTED"},
+	{regexp.MustCompile(`(<(SecretAccessKey|SessionToken)>)[^<]*(</(SecretAccessKey|SessionToken)>)`), "${1}REDACTED${3}"},
+	{regexp.MustCompile(`("(?:SecretAccessKey|SessionToken|Token)"\s*:\s*")[^"]*(")`), "${1}REDACTED${2}"},
+}
+
+// redactCredentials hides the signatures, tokens and secret keys in a trace logged by the AWS SDK
+func redactCredentials(trace string) string {
+
+	for _, redaction := range redactions {
+		trace = redaction.pattern.ReplaceAllString(trace, redaction.replacement)
+	}
+
+	return trace
+}
+
+// awsDebugSession returns a copy of a session logging the AWS SDK's traces, with credentials redacted,
+// so every client made with it, including those assuming roles, is traced
+func awsDebugSession(sess *session.Session, logger *log.Logger) *session.Session {
+
+	traces := logger.With(log.FieldOperation, "aws-sdk")
+
+	return sess.Copy(aws.NewConfig().WithLogLevel(awsDebugLevel).WithLogger(aws.LoggerFunc(func(args ...interface{}) {
+		traces.Debugf("%s", redactCredentials(fmt.Sprint(args...)))
+	})))
+}
+
+// logRequests logs the request ID of each AWS request at the debug level, along with the code and status of
+// those which fail and which attempt it was
+func logRequests(handlers *request.Handlers, logger *log.Logger) {
+
+	handlers.Unmarshal.PushBack(func(r *request.Request) {
+		requestLogger(logger, r).Debugf("AWS request %s succeeded", r.Operation.Name)
+	})
+
+	handlers.UnmarshalError.PushBack(func(r *request.Request) {
+
+		entry := requestLogger(logger, r)
+
+		if failure, ok := AWSRequestFailure(r.Error); ok {
+			entry = entry.With(log.FieldAWSErrorCode, failure.Code()).With(log.FieldAWSStatusCode, failure.StatusCode())
+		}
+
+		entry.Debugf("AWS request %s failed : %v", r.Operation.Name, r.Error)
+	})
+}
+
+func requestLogger(logger *log.Logger, r *request.Request) *log.Logger {
+	return logger.
+		With(log.FieldAWSOperation, r.Operation.Name).
+		With(log.FieldAWSRequestID, r.RequestID).
+		With(log.FieldAttempt, r.RetryCount+1)
+}
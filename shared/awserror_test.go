@@ -0,0 +1,123 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers/ec2fake"
+)
+
+func TestRedactCredentials(t *testing.T) {
+
+	var tests = []struct {
+		trace    string
+		expected string
+	}{
+		{"Authorization: AWS4-HMAC-SHA256 Credential=AKIDEC2FAKE/20210601/erewhon/ec2/aws4_request, Signature=abc123\r\nHost: ec2",
+			"Authorization: REDACTED\r\nHost: ec2"},
+		{"X-Amz-Security-Token: FQoGZXIvYXdzE\r\n", "X-Amz-Security-Token: REDACTED\r\n"},
+		{"GET /?X-Amz-Credential=AKID%2F2021&X-Amz-Signature=abc123&Action=DescribeTags",
+			"GET /?X-Amz-Credential=REDACTED&X-Amz-Signature=REDACTED&Action=DescribeTags"},
+		{"<AccessKeyId>ASIAEC2FAKE1</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>",
+			"<AccessKeyId>ASIAEC2FAKE1</AccessKeyId><SecretAccessKey>REDACTED</SecretAccessKey><SessionToken>REDACTED</SessionToken>"},
+		{`{"SecretAccessKey" : "secret", "Token":"token"}`, `{"SecretAccessKey" : "REDACTED", "Token":"REDACTED"}`},
+		{"Action=AttachVolume&VolumeId=vol-11111111", "Action=AttachVolume&VolumeId=vol-11111111"},
+	}
+
+	for _, test := range tests {
+		if redacted := redactCredentials(test.trace); redacted != test.expected {
+			t.Errorf("Expected %s to be redacted as %s, but got %s", test.trace, test.expected, redacted)
+		}
+	}
+}
+
+func newAWSErrorFake(t *testing.T) *ec2fake.Server {
+
+	fake := ec2fake.New("erewhon")
+	t.Cleanup(fake.Close)
+
+	fake.AddInstance(ec2fake.Instance{ID: "i-0123456789abcdef0", AvailabilityZone: "erewhona",
+		Tags: map[string]string{"volume_/dev/sdf": "vol-11111111"}})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-11111111", AvailabilityZone: "erewhona"})
+
+	return fake
+}
+
+func TestFailedAttachKeepsAWSRequestFailure(t *testing.T) {
+
+	fake := newAWSErrorFake(t)
+	fake.Script(ec2fake.Scenario{Action: "AttachVolume", VolumeID: "vol-11111111", Steps: []ec2fake.Step{{Err: ec2fake.Unauthorized()}, {Err: ec2fake.Unauthorized()}}})
+
+	buf := &bytes.Buffer{}
+	instance, err := GetInstanceByID("i-0123456789abcdef0", "erewhon", WithAWSConfig(fake.AWSConfig()), WithWaitDelay(0),
+		WithLogger(log.New(buf, log.LevelInfo, log.FormatJSON)))
+
+	if err != nil {
+		t.Fatalf("Getting the instance shouldn't have failed, but I got %v", err)
+	}
+
+	volumes, err := instance.AllocatedVolumes()
+
+	if err != nil || len(volumes) != 1 {
+		t.Fatalf("Expected the allocated volume, but got %v, %v", volumes, err)
+	}
+
+	err = volumes[0].Attach()
+	failure, ok := AWSRequestFailure(err)
+
+	if !ok || failure.Code() != "UnauthorizedOperation" || failure.StatusCode() != 403 || !strings.HasPrefix(failure.RequestID(), "ec2fake-") {
+		t.Fatalf("Expected the failed AttachVolume request to be kept, but got %v", err)
+	}
+
+	if err := instance.AttachVolumes(); err == nil {
+		t.Fatal("Attaching should have failed")
+	}
+
+	for _, expected := range []string{`"level":"error"`, `"volume_id":"vol-11111111"`, `"aws_error_code":"UnauthorizedOperation"`, `"aws_status_code":403`, `"aws_request_id":"ec2fake-`} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected the failure to be logged with %s, but got %s", expected, buf)
+		}
+	}
+}
+
+func TestAWSRequestFailureNotFound(t *testing.T) {
+
+	if _, ok := AWSRequestFailure(errors.New("unable to attach volume")); ok {
+		t.Error("Expected no failed AWS request in an error not caused by one")
+	}
+}
+
+func TestAWSDebugRedactsCredentials(t *testing.T) {
+
+	fake := newAWSErrorFake(t)
+	fake.AddRole(ec2fake.Role{ARN: testRoleARN})
+
+	buf := &bytes.Buffer{}
+	instance, err := GetInstanceByID("i-0123456789abcdef0", "erewhon", WithAWSConfig(fake.AWSConfig()), WithWaitDelay(0),
+		WithAssumeRole(AssumeRole{RoleARN: testRoleARN}), WithAWSDebug(), WithLogger(log.New(buf, log.LevelDebug, log.FormatText)))
+
+	if err != nil {
+		t.Fatalf("Getting the instance shouldn't have failed, but I got %v", err)
+	}
+
+	if _, err := instance.AllocatedVolumes(); err != nil {
+		t.Fatalf("Finding the allocated volumes shouldn't have failed, but I got %v", err)
+	}
+
+	traces := buf.String()
+
+	for _, expected := range []string{"DEBUG: Request ec2/DescribeTags", "<AssumeRoleResponse", "Authorization: REDACTED", "AWS request DescribeTags succeeded"} {
+		if !strings.Contains(traces, expected) {
+			t.Errorf("Expected the traces to contain %s, but got %s", expected, traces)
+		}
+	}
+
+	for _, secret := range []string{"Signature=", "ec2fake-session-token", "<SecretAccessKey>secret"} {
+		if strings.Contains(traces, secret) {
+			t.Errorf("Expected %s to be redacted, but got %s", secret, traces)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package shared
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// DefaultRoleSessionName names the sessions of roles assumed, unless another name is given
+const DefaultRoleSessionName = "ebs-volumes"
+
+var (
+	roleARNPattern     = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]+$`)
+	sessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+	// roleExpiryWindow is how long before they expire the credentials of an assumed role are refreshed,
+	// so requests aren't signed with credentials about to expire
+	roleExpiryWindow = time.Minute
+)
+
+// AssumeRole is an IAM role assumed to manage volumes, using the credentials found as usual to assume it
+type AssumeRole struct {
+	RoleARN string
+	// ExternalID is given when assuming the role, when the role's trust policy requires one
+	ExternalID string
+	// SessionName is DefaultRoleSessionName unless given
+	SessionName string
+}
+
+// Validate returns an error if the role ARN or session name aren't valid
+func (r AssumeRole) Validate() error {
+
+	if !roleARNPattern.MatchString(r.RoleARN) {
+		return fmt.Errorf("'%s' is not a role ARN", r.RoleARN)
+	}
+
+	if r.SessionName != "" && !sessionNamePattern.MatchString(r.SessionName) {
+		return fmt.Errorf("'%s' is not a valid role session name", r.SessionName)
+	}
+
+	return nil
+}
+
+// WithAssumeRole assumes a role to call EC2, refreshing its credentials before they expire so long running
+// operations aren't interrupted. It only has an effect when the instance creates its own session.
+func WithAssumeRole(role AssumeRole) InstanceOption {
+	return func(e *EC2Instance) {
+		e.assumeRole = &role
+	}
+}
+
+// credentials returns credentials for the role, assumed with the credentials of the session
+func (r AssumeRole) credentials(sess *session.Session) *credentials.Credentials {
+
+	return stscreds.NewCredentials(sess, r.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = r.SessionName
+		p.ExpiryWindow = roleExpiryWindow
+
+		if p.RoleSessionName == "" {
+			p.RoleSessionName = DefaultRoleSessionName
+		}
+
+		if r.ExternalID != "" {
+			p.ExternalID = aws.String(r.ExternalID)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package shared
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers/ec2fake"
+)
+
+const testRoleARN = "arn:aws:iam::123456789012:role/ebs-volumes"
+
+func TestAssumeRoleValidate(t *testing.T) {
+
+	valid := []AssumeRole{
+		{RoleARN: testRoleARN},
+		{RoleARN: "arn:aws-us-gov:iam::123456789012:role/path/ebs-volumes", SessionName: "i-0123456789abcdef0"},
+	}
+
+	for _, role := range valid {
+		if err := role.Validate(); err != nil {
+			t.Errorf("Role %v should have been accepted, but got %v", role, err)
+		}
+	}
+
+	invalid := []AssumeRole{
+		{RoleARN: "ebs-volumes"},
+		{RoleARN: "arn:aws:iam::123456789012:user/someone"},
+		{RoleARN: testRoleARN, SessionName: "has spaces"},
+	}
+
+	for _, role := range invalid {
+		if err := role.Validate(); err == nil {
+			t.Errorf("Role %v should have been rejected", role)
+		}
+	}
+}
+
+func newAssumeRoleFake(t *testing.T) *ec2fake.Server {
+
+	fake := ec2fake.New("erewhon")
+	t.Cleanup(fake.Close)
+
+	fake.AddInstance(ec2fake.Instance{ID: "i-0123456789abcdef0", AvailabilityZone: "erewhona",
+		Tags: map[string]string{"volume_/dev/sdf": "vol-11111111"}})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-11111111", AvailabilityZone: "erewhona"})
+	fake.AddRole(ec2fake.Role{ARN: testRoleARN, ExternalID: "secret-handshake"})
+
+	return fake
+}
+
+func TestGetInstanceByIDWithAssumeRole(t *testing.T) {
+
+	fake := newAssumeRoleFake(t)
+
+	role := AssumeRole{RoleARN: testRoleARN, ExternalID: "secret-handshake"}
+	instance, err := GetInstanceByID("i-0123456789abcdef0", "erewhon", WithAWSConfig(fake.AWSConfig()), WithAssumeRole(role))
+
+	if err != nil {
+		t.Fatalf("Getting the instance shouldn't have failed, but I got %v", err)
+	}
+
+	if volumes, err := instance.AllocatedVolumes(); err != nil || len(volumes) != 1 {
+		t.Fatalf("Expected the volume allocated on the fake, but got %v, %v", volumes, err)
+	}
+
+	sessions := fake.AssumedRoles()
+
+	if len(sessions) != 1 || sessions[0].SessionName != DefaultRoleSessionName {
+		t.Fatalf("Expected the role to be assumed once with the default session name, but got %v", sessions)
+	}
+
+	if key := fake.AccessKey("DescribeTags"); key != sessions[0].AccessKeyID {
+		t.Errorf("Expected the tags to be described with the role's credentials %s, but they were signed by %s", sessions[0].AccessKeyID, key)
+	}
+}
+
+func TestAssumedRoleCredentialsAreRefreshed(t *testing.T) {
+
+	fake := newAssumeRoleFake(t)
+
+	// Credentials issued an hour ago have long expired
+	fake.SetClock(func() time.Time { return time.Now().Add(-time.Hour) })
+
+	role := AssumeRole{RoleARN: testRoleARN, ExternalID: "secret-handshake", SessionName: "i-0123456789abcdef0"}
+	instance, err := GetInstanceByID("i-0123456789abcdef0", "erewhon", WithAWSConfig(fake.AWSConfig()), WithAssumeRole(role))
+
+	if err != nil {
+		t.Fatalf("Getting the instance shouldn't have failed, but I got %v", err)
+	}
+
+	if _, err := instance.AllocatedVolumes(); err != nil {
+		t.Fatalf("Finding allocated volumes shouldn't have failed, but I got %v", err)
+	}
+
+	if sessions := fake.AssumedRoles(); len(sessions) < 2 || sessions[1].SessionName != "i-0123456789abcdef0" {
+		t.Errorf("Expected the role to be assumed again once its credentials expired, but got %v", sessions)
+	}
+}
+
+func TestAssumeRoleWithoutExternalIDIsDenied(t *testing.T) {
+
+	fake := newAssumeRoleFake(t)
+
+	instance, err := GetInstanceByID("i-0123456789abcdef0", "erewhon", WithAWSConfig(fake.AWSConfig()),
+		WithAssumeRole(AssumeRole{RoleARN: testRoleARN}))
+
+	if err != nil {
+		t.Fatalf("Getting the instance shouldn't have failed, but I got %v", err)
+	}
+
+	if _, err := instance.AllocatedVolumes(); err == nil {
+		t.Error("Assuming the role without its external ID should have been denied")
+	}
+
+	if calls := fake.Calls("DescribeTags"); calls != 0 {
+		t.Errorf("Expected no calls to EC2 without credentials, but DescribeTags was called %d times", calls)
+	}
+}
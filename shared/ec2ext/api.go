@@ -0,0 +1,208 @@
+package ec2ext
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Volume modification states
+const (
+	// VolumeModificationStateModifying is reported while the modification is being applied
+	VolumeModificationStateModifying = "modifying"
+
+	// VolumeModificationStateOptimizing is reported once the new configuration can be used
+	VolumeModificationStateOptimizing = "optimizing"
+
+	// VolumeModificationStateCompleted is reported once the modification has fully completed
+	VolumeModificationStateCompleted = "completed"
+
+	// VolumeModificationStateFailed is reported if the modification could not be made
+	VolumeModificationStateFailed = "failed"
+)
+
+// ModifyVolumeInput holds the parameters for ModifyVolume
+type ModifyVolumeInput struct {
+	_ struct{} `type:"structure"`
+
+	DryRun *bool `type:"boolean"`
+
+	// The target IOPS rate of the volume
+	Iops *int64 `type:"integer"`
+
+	// The target size of the volume, in GiB
+	Size *int64 `type:"integer"`
+
+	// The target throughput of the volume, in MiB/s
+	Throughput *int64 `type:"integer"`
+
+	// VolumeId is required
+	VolumeId *string `type:"string" required:"true"`
+
+	// The target EBS volume type of the volume
+	VolumeType *string `type:"string"`
+}
+
+// String returns the string representation
+func (s ModifyVolumeInput) String() string {
+	return awsutil.Prettify(s)
+}
+
+// GoString returns the string representation
+func (s ModifyVolumeInput) GoString() string {
+	return s.String()
+}
+
+// ModifyVolumeOutput holds the result of ModifyVolume
+type ModifyVolumeOutput struct {
+	_ struct{} `type:"structure"`
+
+	VolumeModification *VolumeModification `locationName:"volumeModification" type:"structure"`
+}
+
+// String returns the string representation
+func (s ModifyVolumeOutput) String() string {
+	return awsutil.Prettify(s)
+}
+
+// GoString returns the string representation
+func (s ModifyVolumeOutput) GoString() string {
+	return s.String()
+}
+
+// DescribeVolumesModificationsInput holds the parameters for DescribeVolumesModifications
+type DescribeVolumesModificationsInput struct {
+	_ struct{} `type:"structure"`
+
+	DryRun *bool `type:"boolean"`
+
+	Filters []*ec2.Filter `locationName:"Filter" locationNameList:"Filter" type:"list"`
+
+	MaxResults *int64 `type:"integer"`
+
+	NextToken *string `type:"string"`
+
+	VolumeIds []*string `locationName:"VolumeId" locationNameList:"VolumeId" type:"list"`
+}
+
+// String returns the string representation
+func (s DescribeVolumesModificationsInput) String() string {
+	return awsutil.Prettify(s)
+}
+
+// GoString returns the string representation
+func (s DescribeVolumesModificationsInput) GoString() string {
+	return s.String()
+}
+
+// DescribeVolumesModificationsOutput holds the result of DescribeVolumesModifications
+type DescribeVolumesModificationsOutput struct {
+	_ struct{} `type:"structure"`
+
+	NextToken *string `locationName:"nextToken" type:"string"`
+
+	VolumesModifications []*VolumeModification `locationName:"volumeModificationSet" locationNameList:"item" type:"list"`
+}
+
+// String returns the string representation
+func (s DescribeVolumesModificationsOutput) String() string {
+	return awsutil.Prettify(s)
+}
+
+// GoString returns the string representation
+func (s DescribeVolumesModificationsOutput) GoString() string {
+	return s.String()
+}
+
+// DescribeVolumesPerformanceOutput holds the result of DescribeVolumesPerformance
+type DescribeVolumesPerformanceOutput struct {
+	_ struct{} `type:"structure"`
+
+	NextToken *string `locationName:"nextToken" type:"string"`
+
+	Volumes []*VolumePerformance `locationName:"volumeSet" locationNameList:"item" type:"list"`
+}
+
+// String returns the string representation
+func (s DescribeVolumesPerformanceOutput) String() string {
+	return awsutil.Prettify(s)
+}
+
+// GoString returns the string representation
+func (s DescribeVolumesPerformanceOutput) GoString() string {
+	return s.String()
+}
+
+// VolumePerformance describes the size, type and performance of an EBS volume
+type VolumePerformance struct {
+	_ struct{} `type:"structure"`
+
+	// The IOPS rate of the volume
+	Iops *int64 `locationName:"iops" type:"integer"`
+
+	// The size of the volume, in GiB
+	Size *int64 `locationName:"size" type:"integer"`
+
+	// The throughput of the volume, in MiB/s
+	Throughput *int64 `locationName:"throughput" type:"integer"`
+
+	VolumeId *string `locationName:"volumeId" type:"string"`
+
+	VolumeType *string `locationName:"volumeType" type:"string"`
+}
+
+// String returns the string representation
+func (s VolumePerformance) String() string {
+	return awsutil.Prettify(s)
+}
+
+// GoString returns the string representation
+func (s VolumePerformance) GoString() string {
+	return s.String()
+}
+
+// VolumeModification describes a modification to an EBS volume
+type VolumeModification struct {
+	_ struct{} `type:"structure"`
+
+	EndTime *time.Time `locationName:"endTime" type:"timestamp" timestampFormat:"iso8601"`
+
+	// One of the VolumeModificationState values
+	ModificationState *string `locationName:"modificationState" type:"string"`
+
+	OriginalIops *int64 `locationName:"originalIops" type:"integer"`
+
+	OriginalSize *int64 `locationName:"originalSize" type:"integer"`
+
+	OriginalThroughput *int64 `locationName:"originalThroughput" type:"integer"`
+
+	OriginalVolumeType *string `locationName:"originalVolumeType" type:"string"`
+
+	// Modification progress, from 0 to 100 percent complete
+	Progress *int64 `locationName:"progress" type:"long"`
+
+	StartTime *time.Time `locationName:"startTime" type:"timestamp" timestampFormat:"iso8601"`
+
+	StatusMessage *string `locationName:"statusMessage" type:"string"`
+
+	TargetIops *int64 `locationName:"targetIops" type:"integer"`
+
+	TargetSize *int64 `locationName:"targetSize" type:"integer"`
+
+	TargetThroughput *int64 `locationName:"targetThroughput" type:"integer"`
+
+	TargetVolumeType *string `locationName:"targetVolumeType" type:"string"`
+
+	VolumeId *string `locationName:"volumeId" type:"string"`
+}
+
+// String returns the string representation
+func (s VolumeModification) String() string {
+	return awsutil.Prettify(s)
+}
+
+// GoString returns the string representation
+func (s VolumeModification) GoString() string {
+	return s.String()
+}
@@ -0,0 +1,91 @@
+// Package ec2ext adds EC2 operations missing from the vendored AWS SDK.
+//
+// The operations are sent through the SDK's own EC2 client, so they share
+// its session, credentials, signing and retry behaviour.
+package ec2ext
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// APIVersion is the EC2 API version the extended operations are sent with
+const APIVersion = "2016-11-15"
+
+const (
+	opModifyVolume                 = "ModifyVolume"
+	opDescribeVolumesModifications = "DescribeVolumesModifications"
+	opDescribeVolumes              = "DescribeVolumes"
+)
+
+// EC2API extends ec2iface.EC2API with the extended operations
+type EC2API interface {
+	ec2iface.EC2API
+
+	ModifyVolume(*ModifyVolumeInput) (*ModifyVolumeOutput, error)
+
+	DescribeVolumesModifications(*DescribeVolumesModificationsInput) (*DescribeVolumesModificationsOutput, error)
+
+	DescribeVolumesPerformance(*ec2.DescribeVolumesInput) (*DescribeVolumesPerformanceOutput, error)
+}
+
+// EC2 is an EC2 client supporting the extended operations
+type EC2 struct {
+	*ec2.EC2
+
+	waiterDelay time.Duration
+}
+
+// New creates a new instance of the extended EC2 client with a session.
+func New(p client.ConfigProvider, cfgs ...*aws.Config) *EC2 {
+	return &EC2{EC2: ec2.New(p, cfgs...), waiterDelay: DefaultWaiterDelay}
+}
+
+// newRequest creates a request for an extended operation, sent with APIVersion
+func (c *EC2) newRequest(name string, params, data interface{}) *request.Request {
+	op := &request.Operation{
+		Name:       name,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+
+	req := c.NewRequest(op, params, data)
+	req.ClientInfo.APIVersion = APIVersion
+
+	return req
+}
+
+// ModifyVolume changes the size, type or performance of an EBS volume
+func (c *EC2) ModifyVolume(input *ModifyVolumeInput) (*ModifyVolumeOutput, error) {
+	output := &ModifyVolumeOutput{}
+	err := c.newRequest(opModifyVolume, input, output).Send()
+	return output, err
+}
+
+// DescribeVolumesModifications reports the current modification state of EBS volumes
+func (c *EC2) DescribeVolumesModifications(input *DescribeVolumesModificationsInput) (*DescribeVolumesModificationsOutput, error) {
+	if input == nil {
+		input = &DescribeVolumesModificationsInput{}
+	}
+
+	output := &DescribeVolumesModificationsOutput{}
+	err := c.newRequest(opDescribeVolumesModifications, input, output).Send()
+	return output, err
+}
+
+// DescribeVolumesPerformance describes EBS volumes, including performance attributes such as
+// throughput that the vendored SDK's ec2.Volume doesn't know about
+func (c *EC2) DescribeVolumesPerformance(input *ec2.DescribeVolumesInput) (*DescribeVolumesPerformanceOutput, error) {
+	if input == nil {
+		input = &ec2.DescribeVolumesInput{}
+	}
+
+	output := &DescribeVolumesPerformanceOutput{}
+	err := c.newRequest(opDescribeVolumes, input, output).Send()
+	return output, err
+}
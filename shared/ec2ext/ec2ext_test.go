@@ -0,0 +1,214 @@
+package ec2ext
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const modifyVolumeResponse = `<ModifyVolumeResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+    <requestId>5jkdf074-37ed-4004-8671-a78ee82bf1cbEXAMPLE</requestId>
+    <volumeModification>
+        <modificationState>modifying</modificationState>
+        <originalSize>10</originalSize>
+        <progress>0</progress>
+        <startTime>2017-01-19T23:58:04.922Z</startTime>
+        <targetSize>200</targetSize>
+        <volumeId>vol-0123456789abcdef0</volumeId>
+    </volumeModification>
+</ModifyVolumeResponse>`
+
+const describeVolumesModificationsResponse = `<DescribeVolumesModificationsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+    <requestId>5jkdf074-37ed-4004-8671-a78ee82bf1cbEXAMPLE</requestId>
+    <volumeModificationSet>
+        <item>
+            <modificationState>optimizing</modificationState>
+            <progress>40</progress>
+            <targetSize>200</targetSize>
+            <volumeId>vol-0123456789abcdef0</volumeId>
+        </item>
+    </volumeModificationSet>
+</DescribeVolumesModificationsResponse>`
+
+func newTestClient(t *testing.T, responses map[string]string, seen func(r *http.Request)) (*EC2, func()) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Unable to parse request : %v", err)
+		}
+
+		seen(r)
+
+		response, ok := responses[r.Form.Get("Action")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, response)
+	}))
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("erewhon"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	})
+
+	if err != nil {
+		t.Fatalf("Unable to create session : %v", err)
+	}
+
+	return New(sess), server.Close
+}
+
+func TestModifyVolume(t *testing.T) {
+
+	var form map[string][]string
+
+	svc, closer := newTestClient(t, map[string]string{opModifyVolume: modifyVolumeResponse}, func(r *http.Request) {
+		form = r.Form
+	})
+	defer closer()
+
+	out, err := svc.ModifyVolume(&ModifyVolumeInput{
+		VolumeId: aws.String("vol-0123456789abcdef0"),
+		Size:     aws.Int64(200),
+	})
+
+	if err != nil {
+		t.Fatalf("ModifyVolume shouldn't have failed, but I got %v", err)
+	}
+
+	expected := map[string]string{
+		"Action":   opModifyVolume,
+		"Version":  APIVersion,
+		"VolumeId": "vol-0123456789abcdef0",
+		"Size":     "200",
+	}
+
+	for key, value := range expected {
+		if got := form[key]; len(got) != 1 || got[0] != value {
+			t.Errorf("Request parameter %s should have been %s, but was %v", key, value, got)
+		}
+	}
+
+	if *out.VolumeModification.ModificationState != VolumeModificationStateModifying {
+		t.Errorf("Expected state %s but got %s", VolumeModificationStateModifying, *out.VolumeModification.ModificationState)
+	}
+
+	if *out.VolumeModification.TargetSize != 200 {
+		t.Errorf("Expected target size 200 but got %d", *out.VolumeModification.TargetSize)
+	}
+}
+
+func TestDescribeVolumesModifications(t *testing.T) {
+
+	var form map[string][]string
+
+	svc, closer := newTestClient(t, map[string]string{opDescribeVolumesModifications: describeVolumesModificationsResponse}, func(r *http.Request) {
+		form = r.Form
+	})
+	defer closer()
+
+	out, err := svc.DescribeVolumesModifications(&DescribeVolumesModificationsInput{
+		VolumeIds: []*string{aws.String("vol-0123456789abcdef0")},
+	})
+
+	if err != nil {
+		t.Fatalf("DescribeVolumesModifications shouldn't have failed, but I got %v", err)
+	}
+
+	if got := form["VolumeId.1"]; len(got) != 1 || got[0] != "vol-0123456789abcdef0" {
+		t.Errorf("Request should have included the volume id, but got %v", got)
+	}
+
+	if len(out.VolumesModifications) != 1 {
+		t.Fatalf("Expected 1 modification but got %d", len(out.VolumesModifications))
+	}
+
+	modification := out.VolumesModifications[0]
+
+	if *modification.ModificationState != VolumeModificationStateOptimizing {
+		t.Errorf("Expected state %s but got %s", VolumeModificationStateOptimizing, *modification.ModificationState)
+	}
+
+	if *modification.Progress != 40 {
+		t.Errorf("Expected progress 40 but got %d", *modification.Progress)
+	}
+}
+
+const describeVolumesResponse = `<DescribeVolumesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+    <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+    <volumeSet>
+        <item>
+            <volumeId>vol-0123456789abcdef0</volumeId>
+            <size>80</size>
+            <status>in-use</status>
+            <volumeType>gp3</volumeType>
+            <iops>3000</iops>
+            <throughput>250</throughput>
+        </item>
+    </volumeSet>
+</DescribeVolumesResponse>`
+
+func TestDescribeVolumesPerformance(t *testing.T) {
+
+	var form map[string][]string
+
+	svc, closer := newTestClient(t, map[string]string{opDescribeVolumes: describeVolumesResponse}, func(r *http.Request) {
+		form = r.Form
+	})
+	defer closer()
+
+	out, err := svc.DescribeVolumesPerformance(&ec2.DescribeVolumesInput{
+		VolumeIds: []*string{aws.String("vol-0123456789abcdef0")},
+	})
+
+	if err != nil {
+		t.Fatalf("DescribeVolumesPerformance shouldn't have failed, but I got %v", err)
+	}
+
+	if got := form["Version"]; len(got) != 1 || got[0] != APIVersion {
+		t.Errorf("Request should have been sent with API version %s, but got %v", APIVersion, got)
+	}
+
+	if len(out.Volumes) != 1 {
+		t.Fatalf("Expected 1 volume but got %d", len(out.Volumes))
+	}
+
+	volume := out.Volumes[0]
+
+	if *volume.VolumeType != "gp3" || *volume.Iops != 3000 || *volume.Throughput != 250 || *volume.Size != 80 {
+		t.Errorf("Unexpected volume %s", volume)
+	}
+}
+
+func TestWaiterDelaySeconds(t *testing.T) {
+
+	var tests = []struct {
+		delay    time.Duration
+		expected int
+	}{
+		{0, 0},
+		{time.Millisecond, 1},
+		{500 * time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{DefaultWaiterDelay, 15},
+	}
+
+	for _, test := range tests {
+
+		svc := &EC2{waiterDelay: test.delay}
+
+		if got := svc.waiterDelaySeconds(); got != test.expected {
+			t.Errorf("Expected a delay of %v to wait %d seconds, but got %d", test.delay, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ec2ext
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/private/waiter"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// DefaultWaiterDelay is how long the volume waiters wait between attempts, as the SDK's own do
+const DefaultWaiterDelay = 15 * time.Second
+
+// waiterMaxAttempts is how many times the volume waiters check on volumes, as the SDK's own do
+const waiterMaxAttempts = 40
+
+// SetWaiterDelay sets how long WaitUntilVolumeAvailable and WaitUntilVolumeInUse wait between attempts,
+// instead of DefaultWaiterDelay. The delay is rounded up to whole seconds, so only a delay of zero checks
+// again straight away.
+func (c *EC2) SetWaiterDelay(delay time.Duration) {
+	c.waiterDelay = delay
+}
+
+// waiterDelaySeconds returns the waiter delay in whole seconds, as the SDK's waiter takes it, rounding up so
+// a delay under a second doesn't become no delay at all
+func (c *EC2) waiterDelaySeconds() int {
+	return int((c.waiterDelay + time.Second - 1) / time.Second)
+}
+
+// WaitUntilVolumeAvailable waits until the volumes described are available, as the SDK's waiter does
+// but waiting the waiter delay between attempts
+func (c *EC2) WaitUntilVolumeAvailable(input *ec2.DescribeVolumesInput) error {
+	return c.waitForVolumes(input, ec2.VolumeStateAvailable)
+}
+
+// WaitUntilVolumeInUse waits until the volumes described are in use, as the SDK's waiter does
+// but waiting the waiter delay between attempts
+func (c *EC2) WaitUntilVolumeInUse(input *ec2.DescribeVolumesInput) error {
+	return c.waitForVolumes(input, ec2.VolumeStateInUse)
+}
+
+func (c *EC2) waitForVolumes(input *ec2.DescribeVolumesInput, state string) error {
+
+	w := waiter.Waiter{
+		Client: c.EC2,
+		Input:  input,
+		Config: waiter.Config{
+			Operation:   opDescribeVolumes,
+			Delay:       c.waiterDelaySeconds(),
+			MaxAttempts: waiterMaxAttempts,
+			Acceptors: []waiter.WaitAcceptor{
+				{State: "success", Matcher: "pathAll", Argument: "Volumes[].State", Expected: state},
+				{State: "failure", Matcher: "pathAny", Argument: "Volumes[].State", Expected: ec2.VolumeStateDeleted},
+			},
+		},
+	}
+
+	return w.Wait()
+}
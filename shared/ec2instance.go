@@ -4,61 +4,278 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
 	"github.com/sneakybeaky/ebs-volumes/shared/iface"
+	"github.com/sneakybeaky/ebs-volumes/shared/imds"
 	"github.com/sneakybeaky/ebs-volumes/shared/log"
 )
 
 // VolumeTagPrefix prefixes the name of a tag describing an allocated volume
 const VolumeTagPrefix = "volume_"
 
+// VolumeSizeTagPrefix prefixes the name of a tag giving the size in GiB an allocated volume should be grown to
+const VolumeSizeTagPrefix = "size_"
+
+// PerformanceTagPrefix prefixes the name of a tag giving the type and performance of an allocated volume
+const PerformanceTagPrefix = "perf_"
+
+// PoolTagPrefix prefixes the name of a tag giving the pool a volume is claimed from for a device
+const PoolTagPrefix = "pool_"
+
 // DetachVolumesTag when set to a true value signals volumes can be detached
 const DetachVolumesTag = "detach_volumes"
 
-// GetInstance returns a representation for the current EC2 instance
-func GetInstance() (*EC2Instance, error) {
+// GetInstance returns a representation for the current EC2 instance, reading its metadata with
+// an IMDSv2 client unless other metadata is given
+func GetInstance(opts ...InstanceOption) (*EC2Instance, error) {
+
+	instance := NewEC2Instance(imds.New(), nil, opts...)
 
-	sess, err := session.NewSession()
+	sess, err := session.NewSession(instance.awsConfigs...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AWS session : %v", err)
+		return nil, fmt.Errorf("failed to create AWS session : %w", err)
 	}
 
-	metadata := NewEC2InstanceMetadata(sess)
-
-	region, err := metadata.Region()
+	region, err := instance.metadata.Region()
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get AWS region : %v", err)
+		return nil, fmt.Errorf("failed to get AWS region : %w", err)
 	}
 
 	sess.Config.Region = &region
 
-	return NewEC2Instance(metadata, ec2.New(sess)), nil
+	svc, err := newClient(sess, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	instance.svc = svc
+
+	return instance, nil
+
+}
+
+// GetInstanceByID returns a representation of any EC2 instance, so volumes can be managed from outside it.
+// Credentials are found as usual, and the region is taken from the environment when none is given.
+func GetInstanceByID(instanceID string, region string, opts ...InstanceOption) (*EC2Instance, error) {
+
+	if !instanceIDPattern.MatchString(instanceID) {
+		return nil, fmt.Errorf("'%s' is not an instance ID", instanceID)
+	}
+
+	sess, err := newRegionSession(region, awsConfigs(opts)...)
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := newClient(sess, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := NewStaticMetadata(instanceID, aws.StringValue(sess.Config.Region), svc)
+
+	return NewEC2Instance(metadata, svc, opts...), nil
+}
+
+// newRegionSession returns a session for a region, taken from the environment when none is given
+func newRegionSession(region string, configs ...*aws.Config) (*session.Session, error) {
 
+	config := aws.NewConfig()
+
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+
+	sess, err := session.NewSession(append(configs, config)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session : %w", err)
+	}
+
+	if aws.StringValue(sess.Config.Region) == "" {
+		return nil, errors.New("no AWS region given")
+	}
+
+	return sess, nil
 }
 
 // EC2Instance provides metadata about an EC2 instance.
 type EC2Instance struct {
-	svc      ec2iface.EC2API
+	svc      ec2ext.EC2API
 	metadata iface.Metadata
+	schemas  []TagSchema
+
+	tagSource     TagSource
+	tagSourceMode TagSourceMode
+
+	awsConfigs    []*aws.Config
+	modifyTimeout time.Duration
+	volumes       map[string]string
+	manifest      *Manifest
+	matchPolicy   MatchPolicy
+	leaseDuration time.Duration
+	waiterDelay   time.Duration
+	ec2Endpoint   string
+	fips          bool
+	assumeRole    *AssumeRole
+	authorization *AuthorizationRule
+	encryption    *EncryptionPolicy
+	auditor       *Auditor
+	logger        *log.Logger
+	awsDebug      bool
+}
+
+// InstanceOption configures an EC2Instance
+type InstanceOption func(*EC2Instance)
+
+// WithTagSchemas sets the schemas naming the tags used to designate volumes, instead of DefaultTagSchemas
+func WithTagSchemas(schemas ...TagSchema) InstanceOption {
+	return func(e *EC2Instance) {
+		e.schemas = schemas
+	}
+}
+
+// WithMetadata sets where metadata about the instance is read from
+func WithMetadata(metadata iface.Metadata) InstanceOption {
+	return func(e *EC2Instance) {
+		e.metadata = metadata
+	}
+}
+
+// WithTagSource sets where instance tags are read from
+func WithTagSource(source TagSource) InstanceOption {
+	return func(e *EC2Instance) {
+		e.tagSource = source
+	}
+}
+
+// WithTagSourceMode chooses where instance tags are read from, unless a source has been given.
+// The default is TagSourceAuto.
+func WithTagSourceMode(mode TagSourceMode) InstanceOption {
+	return func(e *EC2Instance) {
+		e.tagSourceMode = mode
+	}
+}
+
+// WithAWSConfig adds to the configuration used for AWS sessions, such as how many times requests are retried.
+// It only has an effect when the instance creates its own session.
+func WithAWSConfig(config *aws.Config) InstanceOption {
+	return func(e *EC2Instance) {
+		e.awsConfigs = append(e.awsConfigs, config)
+	}
+}
+
+// WithModifyTimeout sets how long to wait for each volume modification to take effect
+func WithModifyTimeout(timeout time.Duration) InstanceOption {
+	return func(e *EC2Instance) {
+		e.modifyTimeout = timeout
+	}
+}
+
+// WithVolumes allocates volumes keyed by device name, such as from a config file. They take
+// precedence over volumes allocated to the same device by tags.
+func WithVolumes(volumes map[string]string) InstanceOption {
+	return func(e *EC2Instance) {
+		e.volumes = volumes
+	}
+}
+
+// WithWaitDelay sets how long to wait between checks on volumes being attached or detached, instead
+// of ec2ext.DefaultWaiterDelay. It only has an effect when the instance creates its own session.
+func WithWaitDelay(delay time.Duration) InstanceOption {
+	return func(e *EC2Instance) {
+		e.waiterDelay = delay
+	}
+}
+
+// WithLogger logs using a logger, rather than writing entries at the info level and above as text to standard output
+func WithLogger(logger *log.Logger) InstanceOption {
+	return func(e *EC2Instance) {
+		e.logger = logger
+	}
+}
+
+// Logger returns the logger the instance logs with
+func (e EC2Instance) Logger() *log.Logger {
+	return e.logger
+}
+
+// awsConfigs returns the AWS configuration given by options
+func awsConfigs(opts []InstanceOption) []*aws.Config {
+	return NewEC2Instance(nil, nil, opts...).awsConfigs
+}
+
+// newClient returns an EC2 client for a session, configured by the options
+func newClient(sess *session.Session, opts []InstanceOption) (*ec2ext.EC2, error) {
+
+	instance := NewEC2Instance(nil, nil, opts...)
+
+	endpoint, err := instance.endpoint(aws.StringValue(sess.Config.Region))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if instance.awsDebug {
+		sess = awsDebugSession(sess, instance.logger)
+	}
+
+	var configs []*aws.Config
+
+	if endpoint != "" {
+		configs = append(configs, aws.NewConfig().WithEndpoint(endpoint))
+	}
+
+	var credentials []*aws.Config
+
+	if instance.assumeRole != nil {
+		credentials = append(credentials, aws.NewConfig().WithCredentials(instance.assumeRole.credentials(sess)))
+	}
+
+	if instance.auditor != nil {
+		identity := sts.New(sess, credentials...)
+		logRequests(&identity.Handlers, instance.logger)
+
+		instance.auditor.useSTS(identity, instance.logger)
+	}
+
+	svc := ec2ext.New(sess, append(configs, credentials...)...)
+	svc.SetWaiterDelay(instance.waiterDelay)
+	logRequests(&svc.Handlers, instance.logger)
+
+	return svc, nil
 }
 
 // NewEC2Instance returns a new EC2Instance
-func NewEC2Instance(metadata iface.Metadata, svc ec2iface.EC2API) *EC2Instance {
+func NewEC2Instance(metadata iface.Metadata, svc ec2ext.EC2API, opts ...InstanceOption) *EC2Instance {
 
-	return &EC2Instance{
+	instance := &EC2Instance{
 		svc:      svc,
 		metadata: metadata,
+		schemas:  DefaultTagSchemas,
+
+		tagSourceMode: TagSourceAuto,
+		matchPolicy:   MatchPolicyError,
+		waiterDelay:   ec2ext.DefaultWaiterDelay,
+		logger:        log.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(instance)
 	}
 
+	return instance
 }
 
 func (e EC2Instance) tags() ([]*ec2.TagDescription, error) {
@@ -69,66 +286,193 @@ func (e EC2Instance) tags() ([]*ec2.TagDescription, error) {
 		return nil, err
 	}
 
-	params := &ec2.DescribeTagsInput{
-		Filters: []*ec2.Filter{
-			{ // Required
-				Name: aws.String("resource-id"),
-				Values: []*string{
-					aws.String(instanceid), // Required
-				},
-			},
-		},
-		MaxResults: aws.Int64(1000),
-	}
-	resp, err := e.svc.DescribeTags(params)
+	source := e.tagSource
 
-	if err != nil {
-		return nil, err
+	if source == nil {
+		if source, err = newTagSource(e.logger, e.tagSourceMode, e.metadata, e.svc); err != nil {
+			return nil, err
+		}
 	}
 
-	return resp.Tags, nil
+	return source.Tags(instanceid)
 }
 
 // AllocatedVolumes returns the volumes allocated to this instance
 func (e EC2Instance) AllocatedVolumes() ([]*AllocatedVolume, error) {
-	var allocated []*AllocatedVolume
+
+	allocated, problems, err := e.parseAllocatedVolumes()
+
+	for _, problem := range problems {
+		e.logger.Errorf("Ignoring %v", problem)
+	}
+
+	return allocated, err
+}
+
+// parseAllocatedVolumes returns the volumes allocated to this instance, along with
+// problems found with tags that were ignored as a result
+func (e EC2Instance) parseAllocatedVolumes() ([]*AllocatedVolume, []error, error) {
 
 	tags, err := e.tags()
 
 	if err != nil {
-		return allocated, err
+		return nil, nil, err
+	}
+
+	return e.allocatedVolumes(tags)
+}
+
+// allocatedVolumes returns the volumes allocated to this instance by its tags, along with
+// problems found with tags that were ignored as a result
+func (e EC2Instance) allocatedVolumes(tags []*ec2.TagDescription) ([]*AllocatedVolume, []error, error) {
+	var allocated []*AllocatedVolume
+
+	root, err := e.metadata.RootDeviceName()
+
+	if err != nil {
+		e.logger.Infof("Not checking for root device collisions as the root device is unknown : %v", err)
+		root = ""
 	}
 
+	volumeTags, problems, err := e.volumeTags(tags, root)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	availabilityZone, err := e.metadata.AvailabilityZone()
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get availability zone : %w", err)
+	}
+
+	volumeTags, selectorProblems := resolveSelectors(volumeTags, e.svc, availabilityZone, e.matchPolicy)
+	problems = append(problems, selectorProblems...)
+
+	for _, tag := range volumeTags {
+		volume := NewAllocatedVolume(tag.VolumeID, tag.DeviceName, tag.InstanceID, e.svc)
+		volume.Source = tag.Source
+		allocated = append(allocated, volume)
+	}
+
+	sizes, sizeProblems := volumeSizes(tags, e.schemas)
+	performances, performanceProblems := volumePerformances(tags, e.schemas)
+
+	for _, volume := range allocated {
+		volume.AvailabilityZone = availabilityZone
+		volume.Size = sizes[deviceKey(volume.DeviceName)]
+		volume.Performance = performances[deviceKey(volume.DeviceName)]
+		volume.modifyTimeout = e.modifyTimeout
+		volume.leaseDuration = e.leaseDuration
+		volume.authorization = e.authorization.forInstance(volume.InstanceID, tags)
+		volume.encryption = e.encryption
+		volume.useLogger(e.logger)
+	}
+
+	problems = append(problems, sizeProblems...)
+
+	return allocated, append(problems, performanceProblems...), nil
+}
+
+// volumeTags returns the volumes allocated by tags, the manifest and the config file, with their selectors
+// yet to be resolved, along with problems found that were ignored as a result
+func (e EC2Instance) volumeTags(tags []*ec2.TagDescription, root string) ([]VolumeTag, []error, error) {
+
+	volumeTags, problems := parseVolumeTags(tags, e.schemas, root)
+
+	if e.manifest == nil && len(e.volumes) == 0 {
+		return volumeTags, problems, nil
+	}
+
+	instanceID, err := e.metadata.InstanceID()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sourceProblems []error
+
+	if e.manifest != nil {
+		volumeTags, sourceProblems = mergeManifestVolumes(e.logger, volumeTags, e.manifest, instanceID, root)
+		problems = append(problems, sourceProblems...)
+	}
+
+	volumeTags, sourceProblems = withConfiguredVolumes(e.logger, volumeTags, e.volumes, instanceID, root)
+
+	return volumeTags, append(problems, sourceProblems...), nil
+}
+
+// volumeSizes returns the designated volume sizes in GiB keyed by device
+func volumeSizes(tags []*ec2.TagDescription, schemas []TagSchema) (map[string]int64, []error) {
+
+	sizes := make(map[string]int64)
+	var problems []error
+
 	for _, tag := range tags {
-		if strings.HasPrefix(*tag.Key, VolumeTagPrefix) {
+		if device, ok := tagDevice(schemas, *tag.Key, sizePrefix); ok {
+
+			device, err := deviceSetting(*tag.Key, device, *tag.Value)
+			if err != nil {
+				problems = append(problems, err)
+				continue
+			}
 
-			key := *tag.Key
-			device := key[len(VolumeTagPrefix):]
-			allocated = append(allocated, NewAllocatedVolume(*tag.Value, device, *tag.ResourceId, e.svc))
+			size, err := strconv.ParseInt(*tag.Value, 10, 64)
+
+			if err != nil || size <= 0 {
+				problems = append(problems, &TagError{Key: *tag.Key, Value: *tag.Value, Err: fmt.Errorf("'%s' is not a size in GiB", *tag.Value)})
+				continue
+			}
+
+			sizes[device] = size
 		}
 	}
 
-	return allocated, nil
+	return sizes, problems
 }
 
-//shouldDetachVolumes returns true if volumes should be detached, false otherwise
-func (e EC2Instance) shouldDetachVolumes() (bool, error) {
-	tags, err := e.tags()
+// volumePerformances returns the designated volume types and performance keyed by device
+func volumePerformances(tags []*ec2.TagDescription, schemas []TagSchema) (map[string]Performance, []error) {
 
-	if err != nil {
-		return false, err
+	performances := make(map[string]Performance)
+	var problems []error
+
+	for _, tag := range tags {
+		if device, ok := tagDevice(schemas, *tag.Key, performancePrefix); ok {
+
+			device, err := deviceSetting(*tag.Key, device, *tag.Value)
+			if err != nil {
+				problems = append(problems, err)
+				continue
+			}
+
+			performance, err := ParsePerformance(*tag.Value)
+
+			if err != nil {
+				problems = append(problems, &TagError{Key: *tag.Key, Value: *tag.Value, Err: err})
+				continue
+			}
+
+			performances[device] = performance
+		}
 	}
 
+	return performances, problems
+}
+
+//shouldDetachVolumes returns true if the instance's tags say volumes should be detached, false otherwise
+func (e EC2Instance) shouldDetachVolumes(tags []*ec2.TagDescription) bool {
+
 	shouldDetach := false
 
 	for _, tag := range tags {
-		if *tag.Key == DetachVolumesTag {
+		if isDetachVolumesTag(e.schemas, *tag.Key) {
 
 			shouldDetach, _ = strconv.ParseBool(*tag.Value)
 
 			if !shouldDetach {
 
-				log.Debug.Printf("Tag '%s' value is '%s' - not detaching volumes\n", DetachVolumesTag, *tag.Value)
+				e.logger.Debugf("Tag '%s' value is '%s' - not detaching volumes", *tag.Key, *tag.Value)
 			}
 
 			break
@@ -136,41 +480,131 @@ func (e EC2Instance) shouldDetachVolumes() (bool, error) {
 		}
 	}
 
-	return shouldDetach, nil
+	return shouldDetach
 
 }
 
 // DetachVolumes attempts to detach the allocated volumes attached to this instance, if the necessary tag has been set
 func (e EC2Instance) DetachVolumes() error {
 
-	detachVolumes, err := e.shouldDetachVolumes()
+	tags, err := e.tags()
 
 	if err != nil {
 		return err
 	}
 
-	if !detachVolumes {
+	if !e.shouldDetachVolumes(tags) {
 		return nil
 	}
 
-	return e.applyToVolumes(detachVolume)
+	volumes, problems, err := e.allocatedVolumes(tags)
+
+	for _, problem := range problems {
+		e.logger.Errorf("Ignoring %v", problem)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to find allocated volumes : %w", err)
+	}
+
+	return applyTo(volumes, e.operation(AuditActionDetach, detachVolume))
 
 }
 
-// AttachVolumes attempts to attach the allocated volumes
+// AttachVolumes attempts to attach the allocated volumes, first claiming volumes from any pools given
 func (e EC2Instance) AttachVolumes() error {
-	return e.applyToVolumes(attachVolume)
+
+	tags, err := e.tags()
+
+	if err != nil {
+		return fmt.Errorf("unable to find allocated volumes : %w", err)
+	}
+
+	volumes, problems, err := e.allocatedVolumes(tags)
+
+	for _, problem := range problems {
+		e.logger.Errorf("Ignoring %v", problem)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to find allocated volumes : %w", err)
+	}
+
+	claimed, claimErr := e.claimPoolVolumes(tags, volumes)
+
+	claimedDevices := make(map[string]bool)
+
+	for _, volume := range claimed {
+		claimedDevices[deviceKey(volume.DeviceName)] = true
+	}
+
+	// Volumes just claimed take the place of those allocated by claims recorded against the instance that were lost
+	var attach []*AllocatedVolume
+
+	for _, volume := range volumes {
+		if !claimedDevices[deviceKey(volume.DeviceName)] {
+			attach = append(attach, volume)
+		}
+	}
+
+	if err := applyTo(append(attach, claimed...), e.operation(AuditActionAttach, attachVolume)); err != nil {
+		return err
+	}
+
+	return claimErr
 }
 
 // ShowVolumesInfo prints information about the allocated volumes
 func (e EC2Instance) ShowVolumesInfo() error {
-	return e.applyToVolumes(showVolumeInfo)
+	return e.WriteVolumesInfo(os.Stdout)
+}
+
+// WriteVolumesInfo writes information about the allocated volumes
+func (e EC2Instance) WriteVolumesInfo(w io.Writer) error {
+
+	locked := &lockedWriter{w: w}
+
+	return e.applyToVolumes(func(volume *AllocatedVolume) error {
+		return showVolumeInfo(volume, locked)
+	})
+}
+
+// lockedWriter serializes writes, so information about volumes gathered concurrently isn't interleaved
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// ModifyVolumes converges the allocated volumes to their designated sizes and performance,
+// growing the filesystems on resized volumes
+func (e EC2Instance) ModifyVolumes() error {
+	return e.applyToVolumes(e.operation(AuditActionModify, modifyVolume))
+}
+
+// ResizeVolumes grows the allocated volumes, and the filesystems on them, to their designated sizes.
+// Volumes are also converged to their designated performance, as by ModifyVolumes.
+//
+// Deprecated: use ModifyVolumes.
+func (e EC2Instance) ResizeVolumes() error {
+	return e.ModifyVolumes()
 }
 
 var attachVolume = func(volume *AllocatedVolume) error {
 
-	if err := volume.Attach(); err != nil {
-		return fmt.Errorf("unable to attach volume : %v\n", err)
+	attach := volume.Attach
+
+	if volume.claim != nil {
+		attach = volume.attachClaimed
+	}
+
+	if err := attach(); err != nil {
+		return fmt.Errorf("unable to attach volume : %w\n", err)
 	}
 
 	return nil
@@ -179,18 +613,28 @@ var attachVolume = func(volume *AllocatedVolume) error {
 var detachVolume = func(volume *AllocatedVolume) error {
 
 	if err := volume.Detach(); err != nil {
-		return fmt.Errorf("unable to detach volume : %v\n", err)
+		return fmt.Errorf("unable to detach volume : %w\n", err)
 	}
 	return nil
 }
 
-var showVolumeInfo = func(volume *AllocatedVolume) error {
+var modifyVolume = func(volume *AllocatedVolume) error {
+
+	if err := volume.Modify(); err != nil {
+		return fmt.Errorf("unable to modify volume : %w\n", err)
+	}
+	return nil
+}
+
+var showVolumeInfo = func(volume *AllocatedVolume, w io.Writer) error {
 	buf := new(bytes.Buffer)
 
 	if err := volume.Info(buf); err != nil {
-		return fmt.Errorf("unable to get info for volume : %v\n", err)
+		return fmt.Errorf("unable to get info for volume : %w\n", err)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("unable to write info for volume : %w\n", err)
 	}
-	os.Stdout.WriteString(buf.String())
 
 	return nil
 }
@@ -200,10 +644,16 @@ func (e EC2Instance) applyToVolumes(action func(volume *AllocatedVolume) error)
 	volumes, err := e.AllocatedVolumes()
 
 	if err != nil {
-		return fmt.Errorf("unable to find allocated volumes : %v", err)
+		return fmt.Errorf("unable to find allocated volumes : %w", err)
 	}
 
+	return applyTo(volumes, action)
+}
+
+func applyTo(volumes []*AllocatedVolume, action func(volume *AllocatedVolume) error) error {
+
 	var wg sync.WaitGroup
+	var mu sync.Mutex
 
 	failed := false
 
@@ -216,8 +666,11 @@ func (e EC2Instance) applyToVolumes(action func(volume *AllocatedVolume) error)
 			err := action(volume)
 
 			if err != nil {
-				log.Error.Println(err)
+				LogError(volume.logger, err)
+
+				mu.Lock()
 				failed = true
+				mu.Unlock()
 			}
 
 		}(action, volume)
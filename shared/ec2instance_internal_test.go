@@ -1,6 +1,8 @@
 package shared
 
 import (
+	"io"
+	"sync"
 	"testing"
 
 	"errors"
@@ -15,9 +17,9 @@ func TestAttachAllocatedVolumes(t *testing.T) {
 	mockEC2Service := testhelpers.NewMockEC2Service()
 
 	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance("id-98765",
-		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", "id-98765", "vol-1234567").WithVolume("/dev/sdb", "id-98765", "vol-54321").Build())
+		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", "id-98765", "vol-12345678").WithVolume("/dev/sdb", "id-98765", "vol-87654321").Build())
 
-	expectedVolumes := []string{"vol-1234567", "vol-54321"}
+	expectedVolumes := []string{"vol-12345678", "vol-87654321"}
 
 	var underTest = NewEC2Instance(metadata, mockEC2Service)
 
@@ -35,9 +37,9 @@ func TestDetachAllocatedVolumes(t *testing.T) {
 	mockEC2Service := testhelpers.NewMockEC2Service()
 
 	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
-		testhelpers.NewDescribeTagsOutputBuilder().DetachVolumes(instanceID).WithVolume("/dev/sda", instanceID, "vol-1234567").WithVolume("/dev/sdb", instanceID, "vol-54321").Build())
+		testhelpers.NewDescribeTagsOutputBuilder().DetachVolumes(instanceID).WithVolume("/dev/sda", instanceID, "vol-12345678").WithVolume("/dev/sdb", instanceID, "vol-87654321").Build())
 
-	expectedVolumes := []string{"vol-1234567", "vol-54321"}
+	expectedVolumes := []string{"vol-12345678", "vol-87654321"}
 
 	var underTest = NewEC2Instance(metadata, mockEC2Service)
 
@@ -54,7 +56,7 @@ func TestVolumesNotDetachedWhenTagUnset(t *testing.T) {
 	mockEC2Service := testhelpers.NewMockEC2Service()
 
 	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance("id-98765",
-		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", "id-98765", "vol-1234567").WithVolume("/dev/sdb", "id-98765", "vol-54321").Build())
+		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", "id-98765", "vol-12345678").WithVolume("/dev/sdb", "id-98765", "vol-87654321").Build())
 
 	var underTest = NewEC2Instance(metadata, mockEC2Service)
 
@@ -75,7 +77,7 @@ func TestVolumesNotDetachedWhenTagValueIsNotTrue(t *testing.T) {
 	mockEC2Service := testhelpers.NewMockEC2Service()
 
 	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
-		testhelpers.NewDescribeTagsOutputBuilder().DetachVolumesValue(instanceID, "false").WithVolume("/dev/sda", instanceID, "vol-1234567").WithVolume("/dev/sdb", instanceID, "vol-54321").Build())
+		testhelpers.NewDescribeTagsOutputBuilder().DetachVolumesValue(instanceID, "false").WithVolume("/dev/sda", instanceID, "vol-12345678").WithVolume("/dev/sdb", instanceID, "vol-87654321").Build())
 
 	var underTest = NewEC2Instance(metadata, mockEC2Service)
 
@@ -94,7 +96,7 @@ func TestErrorReturnedWhenDetachVolumeErrors(t *testing.T) {
 	mockEC2Service := testhelpers.NewMockEC2Service()
 
 	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
-		testhelpers.NewDescribeTagsOutputBuilder().DetachVolumes(instanceID).WithVolume("/dev/sda", instanceID, "vol-1234567").Build())
+		testhelpers.NewDescribeTagsOutputBuilder().DetachVolumes(instanceID).WithVolume("/dev/sda", instanceID, "vol-12345678").Build())
 
 	var underTest = NewEC2Instance(metadata, mockEC2Service)
 
@@ -121,7 +123,7 @@ func TestErrorReturnedWhenAttachVolumeErrors(t *testing.T) {
 	mockEC2Service := testhelpers.NewMockEC2Service()
 
 	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
-		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", instanceID, "vol-1234567").Build())
+		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", instanceID, "vol-12345678").Build())
 
 	var underTest = NewEC2Instance(metadata, mockEC2Service)
 
@@ -148,7 +150,7 @@ func TestErrorReturnedWhenInfoErrors(t *testing.T) {
 	mockEC2Service := testhelpers.NewMockEC2Service()
 
 	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
-		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", instanceID, "vol-1234567").Build())
+		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", instanceID, "vol-12345678").Build())
 
 	var underTest = NewEC2Instance(metadata, mockEC2Service)
 
@@ -157,7 +159,7 @@ func TestErrorReturnedWhenInfoErrors(t *testing.T) {
 		showVolumeInfo = saved
 	}()
 
-	showVolumeInfo = func(volume *AllocatedVolume) error {
+	showVolumeInfo = func(volume *AllocatedVolume, w io.Writer) error {
 		return errors.New("Couldn't attach")
 	}
 	error := underTest.ShowVolumesInfo()
@@ -168,6 +170,41 @@ func TestErrorReturnedWhenInfoErrors(t *testing.T) {
 
 }
 
+func TestResizeVolumesModifiesVolumes(t *testing.T) {
+	instanceID := "id-98765"
+	metadata := testhelpers.NewMockMetadata(instanceID, "erewhon")
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+
+	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
+		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", instanceID, "vol-12345678").Build())
+
+	var underTest = NewEC2Instance(metadata, mockEC2Service)
+
+	saved := modifyVolume
+	defer func() {
+		modifyVolume = saved
+	}()
+
+	var mu sync.Mutex
+	var modified []string
+
+	modifyVolume = func(volume *AllocatedVolume) error {
+		mu.Lock()
+		defer mu.Unlock()
+		modified = append(modified, volume.VolumeID)
+		return nil
+	}
+
+	if err := underTest.ResizeVolumes(); err != nil {
+		t.Errorf("Resizing volumes shouldn't have failed, but I got %v", err)
+	}
+
+	if len(modified) != 1 || modified[0] != "vol-12345678" {
+		t.Errorf("Expected vol-12345678 to have been modified, but got %v", modified)
+	}
+}
+
 func checkExpectedVolumesWereAttached(expectedVolumes []string, attached map[string]bool, t *testing.T) {
 	for _, expectedVolume := range expectedVolumes {
 		if _, attached := attached[expectedVolume]; !attached {
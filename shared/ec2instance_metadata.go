@@ -6,7 +6,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 )
 
-// A EC2InstanceMetadata provides metadata about an EC2 instance.
+// A EC2InstanceMetadata provides metadata about an EC2 instance. It only uses IMDSv1, so doesn't work on
+// instances requiring session tokens - see the imds package for a client that does.
 type EC2InstanceMetadata struct {
 	EC2Metadata *ec2metadata.EC2Metadata
 }
@@ -40,3 +41,18 @@ func (e EC2InstanceMetadata) Region() (string, error) {
 
 	return region, nil
 }
+
+// AvailabilityZone returns the availability zone for this EC2 instance
+func (e EC2InstanceMetadata) AvailabilityZone() (string, error) {
+	return e.EC2Metadata.GetMetadata("placement/availability-zone")
+}
+
+// RootDeviceName returns the name of the root device for this EC2 instance
+func (e EC2InstanceMetadata) RootDeviceName() (string, error) {
+	return e.EC2Metadata.GetMetadata("block-device-mapping/root")
+}
+
+// GetMetadata returns the instance metadata at a path
+func (e EC2InstanceMetadata) GetMetadata(p string) (string, error) {
+	return e.EC2Metadata.GetMetadata(p)
+}
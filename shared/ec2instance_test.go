@@ -1,9 +1,14 @@
 package shared
 
 import (
+	"bytes"
+	"encoding/json"
+	"strings"
 	"testing"
 
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
 	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers/ec2fake"
 )
 
 func TestFindAllocatedVolumes(t *testing.T) {
@@ -12,7 +17,7 @@ func TestFindAllocatedVolumes(t *testing.T) {
 
 	mockEC2Service := &testhelpers.MockEC2Service{
 		DescribeTagsFunc: testhelpers.DescribeVolumeTagsForInstance("id-98765",
-			testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", "id-98765", "vol-1234567").WithVolume("/dev/sdb", "id-98765", "vol-54321").Build()),
+			testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sda", "id-98765", "vol-12345678").WithVolume("/dev/sdb", "id-98765", "vol-87654321").Build()),
 	}
 
 	var underTest = NewEC2Instance(metadata, mockEC2Service)
@@ -24,8 +29,14 @@ func TestFindAllocatedVolumes(t *testing.T) {
 			t.Errorf("Should have got 2 allocated volumes, but got %d", len(volumes))
 		}
 
-		assertVolumesEqual(t, volumes[0], NewAllocatedVolume("vol-1234567", "/dev/sda", "id-98765", nil))
-		assertVolumesEqual(t, volumes[1], NewAllocatedVolume("vol-54321", "/dev/sdb", "id-98765", nil))
+		assertVolumesEqual(t, volumes[0], NewAllocatedVolume("vol-12345678", "/dev/sda", "id-98765", nil))
+		assertVolumesEqual(t, volumes[1], NewAllocatedVolume("vol-87654321", "/dev/sdb", "id-98765", nil))
+
+		for _, volume := range volumes {
+			if volume.AvailabilityZone != "erewhona" {
+				t.Errorf("Volume %s should have the instance availability zone erewhona, but got '%s'", volume.VolumeID, volume.AvailabilityZone)
+			}
+		}
 
 	}
 
@@ -37,3 +48,100 @@ func assertVolumesEqual(t *testing.T, left *AllocatedVolume, right *AllocatedVol
 		t.Errorf("Expected %s but got %s", left.String(), right.String())
 	}
 }
+
+func TestFindAllocatedVolumesWithPerformance(t *testing.T) {
+
+	metadata := testhelpers.NewMockMetadata("id-98765", "erewhon")
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeTagsFunc: testhelpers.DescribeVolumeTagsForInstance("id-98765",
+			testhelpers.NewDescribeTagsOutputBuilder().
+				WithVolume("/dev/sda", "id-98765", "vol-12345678").WithPerformance("/dev/sda", "id-98765", "type=gp3,throughput=250").
+				WithVolume("/dev/sdb", "id-98765", "vol-87654321").WithPerformance("/dev/sdb", "id-98765", "fast").Build()),
+	}
+
+	var underTest = NewEC2Instance(metadata, mockEC2Service)
+
+	volumes, err := underTest.AllocatedVolumes()
+
+	if err != nil {
+		t.Fatalf("Shouldn't have failed : got error %s", err.Error())
+	}
+
+	if expected := (Performance{VolumeType: "gp3", Throughput: 250}); volumes[0].Performance != expected {
+		t.Errorf("Volume %s should have designated performance %s, but got %s", volumes[0].VolumeID, expected, volumes[0].Performance)
+	}
+
+	if !volumes[1].Performance.IsZero() {
+		t.Errorf("Volume %s has an invalid performance tag and should be unmanaged, but got %s", volumes[1].VolumeID, volumes[1].Performance)
+	}
+}
+
+func TestFindAllocatedVolumesWithSizes(t *testing.T) {
+
+	metadata := testhelpers.NewMockMetadata("id-98765", "erewhon")
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeTagsFunc: testhelpers.DescribeVolumeTagsForInstance("id-98765",
+			testhelpers.NewDescribeTagsOutputBuilder().
+				WithVolume("/dev/sda", "id-98765", "vol-12345678").WithSize("/dev/sda", "id-98765", "100").
+				WithVolume("/dev/sdb", "id-98765", "vol-87654321").WithSize("/dev/sdb", "id-98765", "lots").Build()),
+	}
+
+	var underTest = NewEC2Instance(metadata, mockEC2Service)
+
+	volumes, err := underTest.AllocatedVolumes()
+
+	if err != nil {
+		t.Fatalf("Shouldn't have failed : got error %s", err.Error())
+	}
+
+	if volumes[0].Size != 100 {
+		t.Errorf("Volume %s should have a designated size of 100 GiB, but got %d", volumes[0].VolumeID, volumes[0].Size)
+	}
+
+	if volumes[1].Size != 0 {
+		t.Errorf("Volume %s has an invalid size tag and should be unmanaged, but got %d", volumes[1].VolumeID, volumes[1].Size)
+	}
+}
+
+func TestAttachVolumesLogsWithVolumeFields(t *testing.T) {
+
+	fake := ec2fake.New("erewhon")
+	t.Cleanup(fake.Close)
+
+	fake.AddInstance(ec2fake.Instance{ID: "i-0123456789abcdef0", AvailabilityZone: "erewhona",
+		Tags: map[string]string{"volume_/dev/sdf": "vol-11111111"}})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-11111111", AvailabilityZone: "erewhona"})
+
+	buf := &bytes.Buffer{}
+	instance, err := GetInstanceByID("i-0123456789abcdef0", "erewhon", WithAWSConfig(fake.AWSConfig()), WithWaitDelay(0),
+		WithLogger(log.New(buf, log.LevelInfo, log.FormatJSON)))
+
+	if err != nil {
+		t.Fatalf("Getting the instance shouldn't have failed, but I got %v", err)
+	}
+
+	if err := instance.AttachVolumes(); err != nil {
+		t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+	}
+
+	entries := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected entries for attaching and attached, but got %s", buf)
+	}
+
+	for _, entry := range entries {
+		var fields map[string]interface{}
+
+		if err := json.Unmarshal([]byte(entry), &fields); err != nil {
+			t.Fatalf("Expected a JSON entry, but got %s : %v", entry, err)
+		}
+
+		if fields["level"] != "info" || fields["volume_id"] != "vol-11111111" || fields["device"] != "/dev/sdf" ||
+			fields["instance_id"] != "i-0123456789abcdef0" || fields["operation"] != "attach" {
+			t.Errorf("Expected the entry to be logged with the volume's fields, but got %s", entry)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ErrEncryptionPolicy is wrapped by an EncryptionError when a volume doesn't satisfy the encryption policy
+var ErrEncryptionPolicy = errors.New("volume doesn't satisfy the encryption policy")
+
+// EncryptionPolicy requires volumes to be encrypted before they're attached, optionally with one of a list of KMS keys
+type EncryptionPolicy struct {
+	// KMSKeyIDs are the keys volumes may be encrypted with, as key IDs or key ARNs. Volumes encrypted with any
+	// key are allowed when empty.
+	KMSKeyIDs []string
+}
+
+// WithEncryptionPolicy only attaches volumes satisfying the policy
+func WithEncryptionPolicy(policy EncryptionPolicy) InstanceOption {
+	return func(e *EC2Instance) {
+		e.encryption = &policy
+	}
+}
+
+// EncryptionError describes why a volume doesn't satisfy the encryption policy
+type EncryptionError struct {
+	VolumeID string
+	Reason   string
+}
+
+func (e *EncryptionError) Error() string {
+	return fmt.Sprintf("volume (%s) doesn't satisfy the encryption policy as %s", e.VolumeID, e.Reason)
+}
+
+// Unwrap returns ErrEncryptionPolicy
+func (e *EncryptionError) Unwrap() error {
+	return ErrEncryptionPolicy
+}
+
+func (p EncryptionPolicy) String() string {
+
+	if len(p.KMSKeyIDs) == 0 {
+		return "encrypted with any key"
+	}
+
+	return "encrypted with one of " + strings.Join(p.KMSKeyIDs, ", ")
+}
+
+// check returns an EncryptionError if the described volume doesn't satisfy the policy
+func (p *EncryptionPolicy) check(volume *ec2.Volume) error {
+
+	if p == nil {
+		return nil
+	}
+
+	reason := p.refusal(volume)
+
+	if reason == "" {
+		return nil
+	}
+
+	return &EncryptionError{VolumeID: aws.StringValue(volume.VolumeId), Reason: reason}
+}
+
+// refusal returns why the volume doesn't satisfy the policy, or an empty string if it does
+func (p EncryptionPolicy) refusal(volume *ec2.Volume) string {
+
+	if !aws.BoolValue(volume.Encrypted) {
+		return "it isn't encrypted"
+	}
+
+	if len(p.KMSKeyIDs) == 0 {
+		return ""
+	}
+
+	keyARN := aws.StringValue(volume.KmsKeyId)
+
+	for _, allowed := range p.KMSKeyIDs {
+		if allowed == keyARN || allowed == kmsKeyID(keyARN) {
+			return ""
+		}
+	}
+
+	if keyARN == "" {
+		return "its KMS key isn't known"
+	}
+
+	return fmt.Sprintf("its KMS key (%s) isn't allowed", keyARN)
+}
+
+// kmsKeyID returns the key ID from a key ARN such as arn:aws:kms:eu-west-1:123456789012:key/<key ID>
+func kmsKeyID(keyARN string) string {
+
+	if i := strings.LastIndex(keyARN, ":key/"); i >= 0 {
+		return keyARN[i+len(":key/"):]
+	}
+
+	return keyARN
+}
+
+// checkEncryption returns an EncryptionError if the described volume doesn't satisfy the encryption policy
+func (volume AllocatedVolume) checkEncryption(status *ec2.Volume) error {
+
+	if volume.encryption == nil {
+		return nil
+	}
+
+	if err := volume.encryption.check(status); err != nil {
+		return err
+	}
+
+	volume.logger.Debugf("Volume (%s) satisfies the encryption policy, %s", volume.VolumeID, volume.encryption)
+
+	return nil
+}
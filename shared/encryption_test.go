@@ -0,0 +1,74 @@
+package shared
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const testKeyARN = "arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+func TestEncryptionPolicyCheck(t *testing.T) {
+
+	var tests = []struct {
+		policy    EncryptionPolicy
+		encrypted bool
+		keyARN    string
+		satisfied bool
+	}{
+		{EncryptionPolicy{}, true, testKeyARN, true},
+		{EncryptionPolicy{}, true, "", true},
+		{EncryptionPolicy{}, false, "", false},
+		{EncryptionPolicy{KMSKeyIDs: []string{testKeyARN}}, true, testKeyARN, true},
+		{EncryptionPolicy{KMSKeyIDs: []string{"1234abcd-12ab-34cd-56ef-1234567890ab"}}, true, testKeyARN, true},
+		{EncryptionPolicy{KMSKeyIDs: []string{"5678efgh-12ab-34cd-56ef-1234567890ab"}}, true, testKeyARN, false},
+		{EncryptionPolicy{KMSKeyIDs: []string{"1234abcd-12ab-34cd-56ef-1234567890ab"}}, true, "", false},
+		{EncryptionPolicy{KMSKeyIDs: []string{"1234abcd-12ab-34cd-56ef-1234567890ab"}}, false, "", false},
+	}
+
+	for _, test := range tests {
+		volume := &ec2.Volume{VolumeId: aws.String("vol-12345678"), Encrypted: aws.Bool(test.encrypted)}
+
+		if test.keyARN != "" {
+			volume.KmsKeyId = aws.String(test.keyARN)
+		}
+
+		err := test.policy.check(volume)
+
+		if (err == nil) != test.satisfied {
+			t.Errorf("Policy %s with encrypted %t and key %s : expected satisfied to be %t, but got %v",
+				test.policy, test.encrypted, test.keyARN, test.satisfied, err)
+		}
+
+		if err != nil && !errors.Is(err, ErrEncryptionPolicy) {
+			t.Errorf("Expected %v to be ErrEncryptionPolicy", err)
+		}
+	}
+}
+
+func TestNoEncryptionPolicyAllowsEveryVolume(t *testing.T) {
+
+	var policy *EncryptionPolicy
+
+	if err := policy.check(&ec2.Volume{VolumeId: aws.String("vol-12345678")}); err != nil {
+		t.Errorf("Expected any volume to be allowed without a policy, but got %v", err)
+	}
+}
+
+func TestAttachRefusedWhenNotEncrypted(t *testing.T) {
+
+	svc := newLeaseService(map[string]string{})
+	svc.AttachVolumeFunc = func(*ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error) {
+		t.Error("Shouldn't have attached a volume which isn't encrypted")
+		return &ec2.VolumeAttachment{}, nil
+	}
+
+	volume := NewAllocatedVolume("vol-12345678", "/dev/sdh", "id-98765", svc)
+	volume.encryption = &EncryptionPolicy{}
+
+	if err := volume.Attach(); !errors.Is(err, ErrEncryptionPolicy) {
+		t.Errorf("Expected the attach to be refused, but got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package shared
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// fipsRegionPrefixes are the prefixes of the regions with a FIPS endpoint for the EC2 API
+var fipsRegionPrefixes = []string{"us-east-", "us-west-", "us-gov-", "ca-central-", "ca-west-"}
+
+// WithEC2Endpoint sets the URL of the EC2 API, such as a VPC interface endpoint, instead of the
+// regional endpoint. It only has an effect when the instance creates its own session.
+func WithEC2Endpoint(endpoint string) InstanceOption {
+	return func(e *EC2Instance) {
+		e.ec2Endpoint = endpoint
+	}
+}
+
+// WithFIPS uses the FIPS endpoint of the EC2 API in the region, unless an endpoint is given with
+// WithEC2Endpoint. It only has an effect when the instance creates its own session.
+func WithFIPS() InstanceOption {
+	return func(e *EC2Instance) {
+		e.fips = true
+	}
+}
+
+// FIPSEndpoint returns the FIPS endpoint of the EC2 API in a region. The EC2 API endpoints in the
+// GovCloud regions are FIPS endpoints already.
+func FIPSEndpoint(region string) (string, error) {
+
+	for _, prefix := range fipsRegionPrefixes {
+
+		if !strings.HasPrefix(region, prefix) {
+			continue
+		}
+
+		if prefix == "us-gov-" {
+			return fmt.Sprintf("https://ec2.%s.amazonaws.com", region), nil
+		}
+
+		return fmt.Sprintf("https://ec2-fips.%s.amazonaws.com", region), nil
+	}
+
+	return "", fmt.Errorf("there is no FIPS endpoint for EC2 in region (%s)", region)
+}
+
+// endpoint returns the URL of the EC2 API chosen for a region, or an empty string for the regional endpoint
+func (e EC2Instance) endpoint(region string) (string, error) {
+
+	if e.ec2Endpoint != "" {
+		return e.ec2Endpoint, nil
+	}
+
+	if e.fips {
+		return FIPSEndpoint(region)
+	}
+
+	return "", nil
+}
+
+// NewHTTPClient returns a client for AWS requests, sent through a proxy when one is given and
+// otherwise through any proxy given in the environment. When a CA bundle is given the PEM encoded
+// certificates in it are trusted along with those of the system.
+func NewHTTPClient(proxy string, caBundle string) (*http.Client, error) {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL '%s'", proxy)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if caBundle != "" {
+		pool, err := loadCABundle(caBundle)
+
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// loadCABundle returns the system certificates along with those in a CA bundle
+func loadCABundle(path string) (*x509.CertPool, error) {
+
+	pem, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA bundle (%s) : %w", path, err)
+	}
+
+	pool, err := x509.SystemCertPool()
+
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM encoded certificates found in CA bundle (%s)", path)
+	}
+
+	return pool, nil
+}
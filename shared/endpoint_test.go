@@ -0,0 +1,126 @@
+package shared
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers/ec2fake"
+)
+
+func TestFIPSEndpoint(t *testing.T) {
+
+	for region, expected := range map[string]string{
+		"us-east-1":     "https://ec2-fips.us-east-1.amazonaws.com",
+		"ca-central-1":  "https://ec2-fips.ca-central-1.amazonaws.com",
+		"us-gov-west-1": "https://ec2.us-gov-west-1.amazonaws.com",
+	} {
+		if endpoint, err := FIPSEndpoint(region); err != nil || endpoint != expected {
+			t.Errorf("Expected FIPS endpoint %s for %s, but got %s, %v", expected, region, endpoint, err)
+		}
+	}
+
+	if _, err := FIPSEndpoint("eu-west-1"); err == nil {
+		t.Error("A region without a FIPS endpoint should have been rejected")
+	}
+}
+
+func TestGetInstanceByIDWithEndpoint(t *testing.T) {
+
+	fake := ec2fake.New("erewhona")
+	defer fake.Close()
+
+	fake.AddInstance(ec2fake.Instance{ID: "i-0123456789abcdef0", AvailabilityZone: "erewhona",
+		Tags: map[string]string{"volume_/dev/sdf": "vol-11111111"}})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-11111111", AvailabilityZone: "erewhona"})
+
+	config := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("AKIDEC2FAKE", "secret", "")).
+		WithMaxRetries(0)
+
+	instance, err := GetInstanceByID("i-0123456789abcdef0", "erewhona", WithAWSConfig(config), WithEC2Endpoint(fake.Endpoint()))
+
+	if err != nil {
+		t.Fatalf("Getting the instance shouldn't have failed, but I got %v", err)
+	}
+
+	if volumes, err := instance.AllocatedVolumes(); err != nil || len(volumes) != 1 {
+		t.Errorf("Expected the volume allocated on the fake, but got %v, %v", volumes, err)
+	}
+
+	if _, err := GetInstanceByID("i-0123456789abcdef0", "erewhona", WithAWSConfig(config), WithFIPS()); err == nil {
+		t.Error("FIPS in a region without a FIPS endpoint should have been rejected")
+	}
+}
+
+func TestNewHTTPClientWithCABundle(t *testing.T) {
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client, err := NewHTTPClient("", "")
+
+	if err != nil {
+		t.Fatalf("Creating a client shouldn't have failed, but I got %v", err)
+	}
+
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("The server's certificate shouldn't be trusted without a CA bundle")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	if err := ioutil.WriteFile(bundle, certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if client, err = NewHTTPClient("", bundle); err != nil {
+		t.Fatalf("Creating a client with a CA bundle shouldn't have failed, but I got %v", err)
+	}
+
+	if _, err := client.Get(server.URL); err != nil {
+		t.Errorf("The server's certificate should be trusted with the CA bundle, but I got %v", err)
+	}
+
+	if err := ioutil.WriteFile(bundle, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewHTTPClient("", bundle); err == nil {
+		t.Error("A CA bundle without certificates should have been rejected")
+	}
+}
+
+func TestNewHTTPClientWithProxy(t *testing.T) {
+
+	var proxied string
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := NewHTTPClient(proxy.URL, "")
+
+	if err != nil {
+		t.Fatalf("Creating a client with a proxy shouldn't have failed, but I got %v", err)
+	}
+
+	if _, err := client.Get("http://ec2.erewhon.amazonaws.com/"); err != nil {
+		t.Fatalf("The request should have been sent through the proxy, but I got %v", err)
+	}
+
+	if proxied != "http://ec2.erewhon.amazonaws.com/" {
+		t.Errorf("Expected the proxy to be asked for http://ec2.erewhon.amazonaws.com/, but it was asked for '%s'", proxied)
+	}
+
+	if _, err := NewHTTPClient("not a url", ""); err == nil {
+		t.Error("An invalid proxy URL should have been rejected")
+	}
+}
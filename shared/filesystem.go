@@ -0,0 +1,163 @@
+package shared
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+)
+
+// nvmeDeviceIDPrefix prefixes the name of the udev link for an EBS volume exposed as an NVMe device
+const nvmeDeviceIDPrefix = "/dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_"
+
+// blockDevice describes a block device as reported by lsblk
+type blockDevice struct {
+	Name       string
+	Type       string
+	FSType     string
+	MountPoint string
+}
+
+// runCommand runs a command, returning its combined output
+var runCommand = func(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	return string(out), err
+}
+
+// resolveDevice finds the block device a volume attached at deviceName is exposed as.
+// Xen based instances may rename /dev/sdX to /dev/xvdX, and Nitro based instances expose
+// volumes as NVMe devices whose serial number is the volume id.
+var resolveDevice = func(deviceName string, volumeID string) (string, error) {
+
+	candidates := []string{deviceName}
+
+	if strings.HasPrefix(deviceName, "/dev/sd") {
+		candidates = append(candidates, "/dev/xvd"+deviceName[len("/dev/sd"):])
+	}
+
+	candidates = append(candidates, nvmeDeviceIDPrefix+strings.Replace(volumeID, "-", "", 1))
+
+	for _, candidate := range candidates {
+		if device, err := filepath.EvalSymlinks(candidate); err == nil {
+			return device, nil
+		}
+	}
+
+	return "", fmt.Errorf("no block device found for volume (%s) attached at (%s)", volumeID, deviceName)
+}
+
+// growFilesystem grows the filesystem on a device to fill it, growing the partition holding
+// the filesystem first if there is one. Both steps are no-ops if there is nothing to grow.
+var growFilesystem = func(logger *log.Logger, device string) error {
+
+	out, err := runCommand("lsblk", "--noheadings", "--raw", "--paths", "--output", "NAME,TYPE,FSTYPE,MOUNTPOINT", device)
+	if err != nil {
+		return fmt.Errorf("unable to list block devices on (%s): %v: %s", device, err, out)
+	}
+
+	var filesystem *blockDevice
+
+	for _, block := range parseBlockDevices(out) {
+		if block.FSType != "" {
+			filesystem = block
+			break
+		}
+	}
+
+	if filesystem == nil {
+		logger.Debugf("No filesystem found on (%s) - skipping", device)
+		return nil
+	}
+
+	if filesystem.Type == "part" {
+		if err := growPartition(logger, device, filesystem.Name); err != nil {
+			return err
+		}
+	}
+
+	return growFS(logger, filesystem)
+}
+
+// parseBlockDevices parses the raw output of lsblk listing NAME,TYPE,FSTYPE,MOUNTPOINT
+func parseBlockDevices(out string) []*blockDevice {
+
+	var blocks []*blockDevice
+
+	for _, line := range strings.Split(out, "\n") {
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, " ")
+		for len(fields) < 4 {
+			fields = append(fields, "")
+		}
+
+		blocks = append(blocks, &blockDevice{
+			Name:       unescapeBlockDeviceField(fields[0]),
+			Type:       fields[1],
+			FSType:     fields[2],
+			MountPoint: unescapeBlockDeviceField(fields[3]),
+		})
+	}
+
+	return blocks
+}
+
+// unescapeBlockDeviceField undoes the escaping of spaces done by lsblk in raw mode
+func unescapeBlockDeviceField(field string) string {
+	return strings.Replace(field, `\x20`, " ", -1)
+}
+
+// growPartition grows the partition to fill the remainder of the disk
+func growPartition(logger *log.Logger, disk string, partition string) error {
+
+	number := partition[len(strings.TrimRight(partition, "0123456789")):]
+	if number == "" {
+		return fmt.Errorf("unable to find partition number of (%s)", partition)
+	}
+
+	logger.Debugf("Growing partition %s on (%s)", number, disk)
+
+	out, err := runCommand("growpart", disk, number)
+	if err != nil {
+		if strings.Contains(out, "NOCHANGE") {
+			logger.Debugf("Partition (%s) already fills (%s)", partition, disk)
+			return nil
+		}
+
+		return fmt.Errorf("unable to grow partition (%s): %v: %s", partition, err, out)
+	}
+
+	return nil
+}
+
+// growFS grows a filesystem to fill the block device it's on
+func growFS(logger *log.Logger, filesystem *blockDevice) error {
+
+	var out string
+	var err error
+
+	logger.Debugf("Growing %s filesystem on (%s)", filesystem.FSType, filesystem.Name)
+
+	switch filesystem.FSType {
+	case "ext2", "ext3", "ext4":
+		out, err = runCommand("resize2fs", filesystem.Name)
+	case "xfs":
+		if filesystem.MountPoint == "" {
+			return fmt.Errorf("xfs filesystem on (%s) must be mounted to be grown", filesystem.Name)
+		}
+		out, err = runCommand("xfs_growfs", filesystem.MountPoint)
+	default:
+		return fmt.Errorf("growing %s filesystems is not supported", filesystem.FSType)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to grow filesystem on (%s): %v: %s", filesystem.Name, err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package shared
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseBlockDevices(t *testing.T) {
+
+	out := "/dev/xvdg disk  \n/dev/xvdg1 part ext4 /mnt/my\\x20data\n"
+
+	blocks := parseBlockDevices(out)
+
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 block devices but got %d", len(blocks))
+	}
+
+	if blocks[0].Name != "/dev/xvdg" || blocks[0].Type != "disk" || blocks[0].FSType != "" {
+		t.Errorf("Unexpected disk %+v", blocks[0])
+	}
+
+	if blocks[1].Name != "/dev/xvdg1" || blocks[1].Type != "part" || blocks[1].FSType != "ext4" || blocks[1].MountPoint != "/mnt/my data" {
+		t.Errorf("Unexpected partition %+v", blocks[1])
+	}
+}
+
+var growFilesystemTests = []struct {
+	description string
+	lsblk       string
+	expected    []string
+}{
+	{"ext4 on disk", "/dev/xvdg disk ext4 \n", []string{"resize2fs /dev/xvdg"}},
+	{"xfs on disk", "/dev/xvdg disk xfs /data\n", []string{"xfs_growfs /data"}},
+	{"ext4 on partition", "/dev/nvme1n1 disk  \n/dev/nvme1n1p1 part ext4 /data\n", []string{"growpart /dev/nvme1n1 1", "resize2fs /dev/nvme1n1p1"}},
+	{"no filesystem", "/dev/xvdg disk  \n", nil},
+}
+
+func TestGrowFilesystem(t *testing.T) {
+
+	defer func() {
+		runCommand = savedRunCommand
+	}()
+
+	for _, tt := range growFilesystemTests {
+
+		commands := stubRunCommand(tt.lsblk, nil)
+
+		if err := growFilesystem(nil, strings.Fields(tt.lsblk)[0]); err != nil {
+			t.Errorf("%s : growing the filesystem shouldn't have failed, but I got %v", tt.description, err)
+		}
+
+		if strings.Join(*commands, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("%s : expected commands %v but got %v", tt.description, tt.expected, *commands)
+		}
+	}
+}
+
+func TestGrowFilesystemIgnoresUnchangedPartition(t *testing.T) {
+
+	defer func() {
+		runCommand = savedRunCommand
+	}()
+
+	stubRunCommand("/dev/xvdg disk  \n/dev/xvdg1 part ext4 /data\n", map[string]error{
+		"growpart": errors.New("exit status 1"),
+	})
+
+	if err := growFilesystem(nil, "/dev/xvdg"); err != nil {
+		t.Errorf("An unchanged partition shouldn't have failed, but I got %v", err)
+	}
+}
+
+func TestGrowFilesystemUnmountedXFS(t *testing.T) {
+
+	defer func() {
+		runCommand = savedRunCommand
+	}()
+
+	stubRunCommand("/dev/xvdg disk xfs \n", nil)
+
+	if err := growFilesystem(nil, "/dev/xvdg"); err == nil {
+		t.Error("Growing an unmounted xfs filesystem should have failed")
+	}
+}
+
+var savedRunCommand = runCommand
+
+// stubRunCommand replaces runCommand, answering lsblk with the supplied output and
+// recording every other command run. Commands named in failures fail with NOCHANGE.
+func stubRunCommand(lsblk string, failures map[string]error) *[]string {
+
+	var commands []string
+
+	runCommand = func(name string, args ...string) (string, error) {
+		if name == "lsblk" {
+			return lsblk, nil
+		}
+
+		if err, ok := failures[name]; ok {
+			return "NOCHANGE: partition 1 could only be grown by 0", err
+		}
+
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		return "", nil
+	}
+
+	return &commands
+}
@@ -0,0 +1,190 @@
+package shared
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
+)
+
+// DefaultParallelism is how many instances in a fleet are worked on at once by default
+const DefaultParallelism = 4
+
+// Instances in these states are never selected, as their volumes can't be managed
+var unmanagedInstanceStates = map[string]bool{
+	ec2.InstanceStateNameShuttingDown: true,
+	ec2.InstanceStateNameTerminated:   true,
+}
+
+// Fleet is a set of instances in a region, selected using DescribeInstances filters
+type Fleet struct {
+	svc         ec2ext.EC2API
+	region      string
+	parallelism int
+	opts        []InstanceOption
+}
+
+// FleetResult is the outcome of running an action against an instance in a fleet
+type FleetResult struct {
+	InstanceID string
+	Output     string
+	Err        error
+}
+
+// FleetAction is run against each instance in a fleet, writing what it finds
+type FleetAction func(instance *EC2Instance, w io.Writer) error
+
+// GetFleet returns a fleet in a region, taken from the environment when none is given. Each
+// instance is created with the options given.
+func GetFleet(region string, parallelism int, opts ...InstanceOption) (*Fleet, error) {
+
+	sess, err := newRegionSession(region, awsConfigs(opts)...)
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := newClient(sess, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFleet(svc, aws.StringValue(sess.Config.Region), parallelism, opts...), nil
+}
+
+// NewFleet returns a new Fleet, working on at most parallelism instances at once
+func NewFleet(svc ec2ext.EC2API, region string, parallelism int, opts ...InstanceOption) *Fleet {
+
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	return &Fleet{svc: svc, region: region, parallelism: parallelism, opts: opts}
+}
+
+// ParseFilter parses a DescribeInstances filter of the form name=value[,value...], such as tag:role=db
+func ParseFilter(filter string) (*ec2.Filter, error) {
+
+	parts := strings.SplitN(filter, "=", 2)
+
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || parts[1] == "" {
+		return nil, fmt.Errorf("filter '%s' should be of the form name=value[,value...]", filter)
+	}
+
+	return &ec2.Filter{
+		Name:   aws.String(strings.TrimSpace(parts[0])),
+		Values: aws.StringSlice(strings.Split(parts[1], ",")),
+	}, nil
+}
+
+// Instances returns the instances matching all the filters
+func (f *Fleet) Instances(filters []*ec2.Filter) ([]*EC2Instance, error) {
+
+	var instances []*EC2Instance
+
+	input := &ec2.DescribeInstancesInput{Filters: filters}
+
+	for {
+		resp, err := f.svc.DescribeInstances(input)
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe instances : %w", err)
+		}
+
+		for _, reservation := range resp.Reservations {
+			for _, instance := range reservation.Instances {
+
+				if instance.State != nil && unmanagedInstanceStates[aws.StringValue(instance.State.Name)] {
+					continue
+				}
+
+				metadata := newDescribedMetadata(instance, aws.StringValue(reservation.OwnerId), f.region, f.svc)
+				instances = append(instances, NewEC2Instance(metadata, f.svc, f.opts...))
+			}
+		}
+
+		if aws.StringValue(resp.NextToken) == "" {
+			return instances, nil
+		}
+
+		input.NextToken = resp.NextToken
+	}
+}
+
+// Run runs an action against every instance matching the filters, returning a result for
+// each in the order they were selected
+func (f *Fleet) Run(filters []*ec2.Filter, action FleetAction) ([]FleetResult, error) {
+
+	instances, err := f.Instances(filters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]FleetResult, len(instances))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, f.parallelism)
+
+	for i, instance := range instances {
+
+		wg.Add(1)
+		go func(i int, instance *EC2Instance) {
+
+			defer wg.Done()
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			instanceID, _ := instance.metadata.InstanceID()
+			buf := new(bytes.Buffer)
+
+			results[i] = FleetResult{InstanceID: instanceID, Err: action(instance, buf)}
+			results[i].Output = buf.String()
+
+		}(i, instance)
+	}
+
+	wg.Wait()
+
+	return results, nil
+}
+
+// WriteFleetReport writes the results for each instance followed by a summary, returning an
+// error if the action failed for any instance
+func WriteFleetReport(w io.Writer, results []FleetResult) error {
+
+	failed := 0
+
+	for _, result := range results {
+
+		status := "OK"
+
+		if result.Err != nil {
+			status = fmt.Sprintf("FAILED : %v", result.Err)
+			failed++
+		}
+
+		fmt.Fprintf(w, "Instance ID (%s) : %s\n", result.InstanceID, status)
+
+		if result.Output != "" {
+			fmt.Fprint(w, result.Output)
+
+			if !strings.HasSuffix(result.Output, "\n") {
+				fmt.Fprintln(w)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "%d instance(s), %d failed\n", len(results), failed)
+
+	if failed > 0 {
+		return fmt.Errorf("failed for %d instance(s)", failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+func fleetInstance(instanceID string, state string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId: aws.String(instanceID),
+		State:      &ec2.InstanceState{Name: aws.String(state)},
+		Placement:  &ec2.Placement{AvailabilityZone: aws.String("erewhona")},
+	}
+}
+
+// newFleetMock returns a mock describing instances across two pages
+func newFleetMock(t *testing.T) *testhelpers.MockEC2Service {
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+
+	mockEC2Service.DescribeInstancesFunc = func(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+
+		if len(input.Filters) != 1 || *input.Filters[0].Name != "tag:role" || *input.Filters[0].Values[0] != "db" {
+			t.Errorf("Unexpected filters %v", input.Filters)
+		}
+
+		if aws.StringValue(input.NextToken) == "" {
+			return &ec2.DescribeInstancesOutput{
+				NextToken: aws.String("more"),
+				Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{
+					fleetInstance("i-00000001", ec2.InstanceStateNameRunning),
+					fleetInstance("i-00000002", ec2.InstanceStateNameTerminated),
+				}}},
+			}, nil
+		}
+
+		return &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{
+				{Instances: []*ec2.Instance{fleetInstance("i-00000003", ec2.InstanceStateNameStopped)}},
+				{Instances: []*ec2.Instance{fleetInstance("i-00000004", ec2.InstanceStateNameRunning)}},
+			},
+		}, nil
+	}
+
+	return mockEC2Service
+}
+
+func TestParseFilter(t *testing.T) {
+
+	filter, err := ParseFilter("tag:role=db,web")
+
+	if err != nil {
+		t.Fatalf("Parsing the filter shouldn't have failed, but I got %v", err)
+	}
+
+	if *filter.Name != "tag:role" || len(filter.Values) != 2 || *filter.Values[1] != "web" {
+		t.Errorf("Expected filter tag:role with values db and web, but got %v", filter)
+	}
+
+	for _, value := range []string{"tag:role", "=db", "tag:role="} {
+		if _, err := ParseFilter(value); err == nil {
+			t.Errorf("Parsing filter '%s' should have failed", value)
+		}
+	}
+}
+
+func TestFleetInstances(t *testing.T) {
+
+	filter, _ := ParseFilter("tag:role=db")
+
+	instances, err := NewFleet(newFleetMock(t), "erewhon", 2).Instances([]*ec2.Filter{filter})
+
+	if err != nil {
+		t.Fatalf("Selecting instances shouldn't have failed, but I got %v", err)
+	}
+
+	var instanceIDs []string
+
+	for _, instance := range instances {
+		instanceID, _ := instance.metadata.InstanceID()
+		instanceIDs = append(instanceIDs, instanceID)
+
+		if availabilityZone, err := instance.metadata.AvailabilityZone(); err != nil || availabilityZone != "erewhona" {
+			t.Errorf("Expected availability zone erewhona for %s, but got '%s', %v", instanceID, availabilityZone, err)
+		}
+	}
+
+	if strings.Join(instanceIDs, ",") != "i-00000001,i-00000003,i-00000004" {
+		t.Errorf("Expected instances other than terminated ones across both pages, but got %v", instanceIDs)
+	}
+}
+
+func TestFleetRunBoundsParallelism(t *testing.T) {
+
+	filter, _ := ParseFilter("tag:role=db")
+
+	var mu sync.Mutex
+	running, most := 0, 0
+
+	results, err := NewFleet(newFleetMock(t), "erewhon", 2).Run([]*ec2.Filter{filter}, func(instance *EC2Instance, w io.Writer) error {
+
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		instanceID, _ := instance.metadata.InstanceID()
+		fmt.Fprintf(w, "checked %s", instanceID)
+
+		if instanceID == "i-00000003" {
+			return errors.New("whoops")
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Running against the fleet shouldn't have failed, but I got %v", err)
+	}
+
+	if most > 2 {
+		t.Errorf("Expected at most 2 instances at once, but there were %d", most)
+	}
+
+	if len(results) != 3 || results[1].InstanceID != "i-00000003" || results[1].Err == nil || results[1].Output != "checked i-00000003" {
+		t.Errorf("Expected a failed result for i-00000003 in selection order, but got %v", results)
+	}
+
+	buf := new(bytes.Buffer)
+
+	if err := WriteFleetReport(buf, results); err == nil {
+		t.Error("The report should have failed as an instance failed")
+	}
+
+	for _, expected := range []string{"Instance ID (i-00000001) : OK\nchecked i-00000001\n", "Instance ID (i-00000003) : FAILED : whoops", "3 instance(s), 1 failed"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected the report to contain '%s', but got\n%s", expected, buf.String())
+		}
+	}
+}
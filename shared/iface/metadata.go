@@ -4,4 +4,6 @@ package iface
 type Metadata interface {
 	InstanceID() (string, error)
 	Region() (string, error)
+	AvailabilityZone() (string, error)
+	RootDeviceName() (string, error)
 }
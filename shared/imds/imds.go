@@ -0,0 +1,312 @@
+// Package imds provides a client for the EC2 instance metadata service which uses session
+// tokens (IMDSv2), so it works on instances where tokens are required.
+package imds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sneakybeaky/ebs-volumes/shared/iface"
+)
+
+// DefaultEndpoint is where the instance metadata service is found
+const DefaultEndpoint = "http://169.254.169.254"
+
+// DefaultTokenTTL is how long session tokens are requested for
+const DefaultTokenTTL = 6 * time.Hour
+
+// DefaultTimeout is how long each request to the instance metadata service may take by default
+const DefaultTimeout = 5 * time.Second
+
+// Headers used by the instance metadata service
+const (
+	tokenHeader    = "X-aws-ec2-metadata-token"
+	tokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+)
+
+const (
+	tokenPath    = "/latest/api/token"
+	metadataPath = "/latest/meta-data"
+	identityPath = "/latest/dynamic/instance-identity/document"
+)
+
+// Tokens are refreshed this long before they expire, so they don't expire in flight
+const tokenRefreshMargin = time.Minute
+
+// ErrTokenUnavailable is returned when a session token couldn't be acquired, and falling back to IMDSv1
+// hasn't been allowed
+var ErrTokenUnavailable = errors.New("unable to get an instance metadata session token")
+
+// Error is returned when the instance metadata service responds with an unexpected status
+type Error struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("instance metadata request for (%s) failed with status %d", e.Path, e.StatusCode)
+}
+
+// Client reads the instance metadata using a session token, refreshing it as it expires
+type Client struct {
+	endpoint   string
+	httpClient *http.Client
+	tokenTTL   time.Duration
+	allowV1    bool
+	now        func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	v1      bool
+}
+
+var _ iface.Metadata = (*Client)(nil)
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithEndpoint sets where the instance metadata service is found, instead of DefaultEndpoint
+func WithEndpoint(endpoint string) Option {
+	return func(c *Client) {
+		c.endpoint = endpoint
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to make requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets how long each request to the instance metadata service may take, instead of DefaultTimeout.
+// Any HTTP client given by WithHTTPClient is copied rather than changed.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		httpClient := *c.httpClient
+		httpClient.Timeout = timeout
+		c.httpClient = &httpClient
+	}
+}
+
+// WithTokenTTL sets how long session tokens are requested for, between one second and six hours
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.tokenTTL = ttl
+	}
+}
+
+// WithFallbackToV1 allows requests to be made without a session token (IMDSv1) when one can't be acquired
+func WithFallbackToV1() Option {
+	return func(c *Client) {
+		c.allowV1 = true
+	}
+}
+
+// New returns a new Client
+func New(opts ...Option) *Client {
+
+	c := &Client{
+		endpoint: DefaultEndpoint,
+		httpClient: &http.Client{
+			// The metadata service is local, so fail fast when it can't be reached. Proxies are never
+			// used, as they'd answer with the proxy's metadata rather than this instance's.
+			Timeout:   DefaultTimeout,
+			Transport: &http.Transport{Proxy: nil, DialContext: (&net.Dialer{Timeout: time.Second}).DialContext},
+		},
+		tokenTTL: DefaultTokenTTL,
+		now:      time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// GetMetadata returns the instance metadata at a path, such as placement/availability-zone
+func (c *Client) GetMetadata(p string) (string, error) {
+	return c.get(path.Join(metadataPath, p))
+}
+
+// InstanceID returns the instance id for this EC2 instance
+func (c *Client) InstanceID() (string, error) {
+
+	doc, err := c.identityDocument()
+
+	if err != nil {
+		return "", err
+	}
+
+	return doc.InstanceID, nil
+}
+
+// Region returns the region id for this EC2 instance
+func (c *Client) Region() (string, error) {
+
+	doc, err := c.identityDocument()
+
+	if err != nil {
+		return "", err
+	}
+
+	return doc.Region, nil
+}
+
+// AccountID returns the ID of the account this EC2 instance belongs to
+func (c *Client) AccountID() (string, error) {
+
+	doc, err := c.identityDocument()
+
+	if err != nil {
+		return "", err
+	}
+
+	return doc.AccountID, nil
+}
+
+// AvailabilityZone returns the availability zone for this EC2 instance
+func (c *Client) AvailabilityZone() (string, error) {
+	return c.GetMetadata("placement/availability-zone")
+}
+
+// RootDeviceName returns the name of the root device for this EC2 instance
+func (c *Client) RootDeviceName() (string, error) {
+	return c.GetMetadata("block-device-mapping/root")
+}
+
+type identityDocument struct {
+	AccountID        string `json:"accountId"`
+	InstanceID       string `json:"instanceId"`
+	Region           string `json:"region"`
+	AvailabilityZone string `json:"availabilityZone"`
+}
+
+func (c *Client) identityDocument() (*identityDocument, error) {
+
+	body, err := c.get(identityPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &identityDocument{}
+
+	if err := json.Unmarshal([]byte(body), doc); err != nil {
+		return nil, fmt.Errorf("unable to parse the instance identity document : %v", err)
+	}
+
+	return doc, nil
+}
+
+// get makes a request with a session token, getting a new token and trying again if the
+// token is rejected
+func (c *Client) get(p string) (string, error) {
+
+	token, err := c.sessionToken(false)
+
+	if err != nil {
+		return "", err
+	}
+
+	body, status, err := c.do(http.MethodGet, p, token, nil)
+
+	if err == nil && status == http.StatusUnauthorized && token != "" {
+		if token, err = c.sessionToken(true); err != nil {
+			return "", err
+		}
+		body, status, err = c.do(http.MethodGet, p, token, nil)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if status != http.StatusOK {
+		return "", &Error{Path: p, StatusCode: status}
+	}
+
+	return body, nil
+}
+
+// sessionToken returns a current session token, or an empty token when falling back to IMDSv1
+func (c *Client) sessionToken(refresh bool) (string, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !refresh && (c.token != "" || c.v1) && c.now().Before(c.expires.Add(-tokenRefreshMargin)) {
+		return c.token, nil
+	}
+
+	requested := c.now()
+
+	ttl := strconv.Itoa(int(c.tokenTTL / time.Second))
+	token, status, err := c.do(http.MethodPut, tokenPath, "", map[string]string{tokenTTLHeader: ttl})
+
+	c.expires = requested.Add(c.tokenTTL)
+
+	if err == nil && status == http.StatusOK && token != "" {
+		c.token, c.v1 = token, false
+		return c.token, nil
+	}
+
+	// Without a token, fall back to IMDSv1 until the token would have expired rather than
+	// waiting on another token request for every call
+	c.token, c.v1 = "", c.allowV1
+
+	if c.allowV1 {
+		return "", nil
+	}
+
+	if err != nil {
+		// When the token response doesn't reach us, such as from a container further away than the
+		// instance's hop limit allows, the request times out
+		return "", fmt.Errorf("%w (the instance metadata hop limit may be too low) : %v", ErrTokenUnavailable, err)
+	}
+
+	return "", fmt.Errorf("%w : %v", ErrTokenUnavailable, &Error{Path: tokenPath, StatusCode: status})
+}
+
+func (c *Client) do(method string, p string, token string, headers map[string]string) (string, int, error) {
+
+	req, err := http.NewRequest(method, c.endpoint+p, nil)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	if token != "" {
+		req.Header.Set(tokenHeader, token)
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := c.httpClient.Do(req)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	return string(body), resp.StatusCode, nil
+}
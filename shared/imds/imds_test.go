@@ -0,0 +1,237 @@
+package imds
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// metadataServer stands in for the instance metadata service
+type metadataServer struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	tokensRequired bool
+	tokenDelay     time.Duration
+	tokens         map[string]bool
+	tokenRequests  int
+	ttls           []string
+}
+
+func newMetadataServer(tokensRequired bool) *metadataServer {
+
+	s := &metadataServer{tokensRequired: tokensRequired, tokens: make(map[string]bool)}
+
+	metadata := map[string]string{
+		metadataPath + "/placement/availability-zone": "erewhona",
+		metadataPath + "/block-device-mapping/root":   "/dev/xvda",
+		identityPath: `{"accountId" : "123456789012", "instanceId" : "id-98765", "region" : "erewhon", "availabilityZone" : "erewhona"}`,
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method == http.MethodPut && r.URL.Path == tokenPath {
+			time.Sleep(s.tokenDelay)
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if r.Header.Get(tokenTTLHeader) == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			s.tokenRequests++
+			s.ttls = append(s.ttls, r.Header.Get(tokenTTLHeader))
+
+			token := fmt.Sprintf("token-%d", s.tokenRequests)
+			s.tokens[token] = true
+			fmt.Fprint(w, token)
+			return
+		}
+
+		s.mu.Lock()
+		valid := s.tokens[r.Header.Get(tokenHeader)]
+		s.mu.Unlock()
+
+		if !valid && (s.tokensRequired || r.Header.Get(tokenHeader) != "") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		value, ok := metadata[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, value)
+	}))
+
+	return s
+}
+
+// revokeTokens makes every token issued so far invalid
+func (s *metadataServer) revokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+func (s *metadataServer) tokensIssued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenRequests
+}
+
+func TestMetadataWithToken(t *testing.T) {
+
+	server := newMetadataServer(true)
+	defer server.Close()
+
+	client := New(WithEndpoint(server.URL), WithTokenTTL(time.Minute*10))
+
+	if instanceID, err := client.InstanceID(); err != nil || instanceID != "id-98765" {
+		t.Errorf("Expected instance ID id-98765, but got '%s', %v", instanceID, err)
+	}
+
+	if region, err := client.Region(); err != nil || region != "erewhon" {
+		t.Errorf("Expected region erewhon, but got '%s', %v", region, err)
+	}
+
+	if account, err := client.AccountID(); err != nil || account != "123456789012" {
+		t.Errorf("Expected account 123456789012, but got '%s', %v", account, err)
+	}
+
+	if availabilityZone, err := client.AvailabilityZone(); err != nil || availabilityZone != "erewhona" {
+		t.Errorf("Expected availability zone erewhona, but got '%s', %v", availabilityZone, err)
+	}
+
+	if root, err := client.RootDeviceName(); err != nil || root != "/dev/xvda" {
+		t.Errorf("Expected root device /dev/xvda, but got '%s', %v", root, err)
+	}
+
+	if issued := server.tokensIssued(); issued != 1 {
+		t.Errorf("Expected the token to be reused, but %d were issued", issued)
+	}
+
+	if server.ttls[0] != "600" {
+		t.Errorf("Expected a token TTL of 600 seconds, but got %s", server.ttls[0])
+	}
+}
+
+func TestTokenRefreshedBeforeExpiry(t *testing.T) {
+
+	server := newMetadataServer(true)
+	defer server.Close()
+
+	now := time.Now()
+	client := New(WithEndpoint(server.URL), WithTokenTTL(time.Hour))
+	client.now = func() time.Time { return now }
+
+	if _, err := client.AvailabilityZone(); err != nil {
+		t.Fatalf("Getting the availability zone shouldn't have failed, but I got %v", err)
+	}
+
+	now = now.Add(time.Hour - time.Second)
+
+	if _, err := client.AvailabilityZone(); err != nil {
+		t.Fatalf("Getting the availability zone shouldn't have failed, but I got %v", err)
+	}
+
+	if issued := server.tokensIssued(); issued != 2 {
+		t.Errorf("Expected the token to be refreshed as it was about to expire, but %d were issued", issued)
+	}
+}
+
+func TestTokenRefreshedWhenRejected(t *testing.T) {
+
+	server := newMetadataServer(true)
+	defer server.Close()
+
+	client := New(WithEndpoint(server.URL))
+
+	if _, err := client.AvailabilityZone(); err != nil {
+		t.Fatalf("Getting the availability zone shouldn't have failed, but I got %v", err)
+	}
+
+	server.revokeTokens()
+
+	if _, err := client.AvailabilityZone(); err != nil {
+		t.Fatalf("Getting the availability zone shouldn't have failed after the token was revoked, but I got %v", err)
+	}
+
+	if issued := server.tokensIssued(); issued != 2 {
+		t.Errorf("Expected a new token after the first was rejected, but %d were issued", issued)
+	}
+}
+
+func TestFailsWhenTokenUnavailable(t *testing.T) {
+
+	server := newMetadataServer(false)
+	defer server.Close()
+
+	// A token response held up beyond the timeout behaves as one dropped by the hop limit
+	server.tokenDelay = 200 * time.Millisecond
+
+	client := New(WithEndpoint(server.URL), WithHTTPClient(&http.Client{Timeout: 50 * time.Millisecond}))
+
+	_, err := client.AvailabilityZone()
+
+	if !errors.Is(err, ErrTokenUnavailable) {
+		t.Fatalf("Expected %v, but got %v", ErrTokenUnavailable, err)
+	}
+
+	if !strings.Contains(err.Error(), "hop limit") {
+		t.Errorf("Expected the error to mention the hop limit, but got %v", err)
+	}
+}
+
+func TestFallsBackToV1WhenAllowed(t *testing.T) {
+
+	server := newMetadataServer(false)
+	defer server.Close()
+
+	server.tokenDelay = 200 * time.Millisecond
+
+	client := New(WithEndpoint(server.URL), WithHTTPClient(&http.Client{Timeout: 50 * time.Millisecond}), WithFallbackToV1())
+
+	for i := 0; i < 2; i++ {
+		if availabilityZone, err := client.AvailabilityZone(); err != nil || availabilityZone != "erewhona" {
+			t.Errorf("Expected availability zone erewhona, but got '%s', %v", availabilityZone, err)
+		}
+	}
+}
+
+func TestTimeoutDoesNotChangeGivenHTTPClient(t *testing.T) {
+
+	httpClient := &http.Client{Timeout: time.Minute}
+
+	client := New(WithHTTPClient(httpClient), WithTimeout(time.Second))
+
+	if httpClient.Timeout != time.Minute {
+		t.Errorf("Expected the given HTTP client to be left alone, but its timeout is now %v", httpClient.Timeout)
+	}
+
+	if client.httpClient.Timeout != time.Second {
+		t.Errorf("Expected requests to time out after a second, but got %v", client.httpClient.Timeout)
+	}
+}
+
+func TestErrorForMissingMetadata(t *testing.T) {
+
+	server := newMetadataServer(true)
+	defer server.Close()
+
+	_, err := New(WithEndpoint(server.URL)).GetMetadata("tags/instance")
+
+	var metadataError *Error
+
+	if !errors.As(err, &metadataError) || metadataError.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a not found error, but got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Tags set on a volume holding the lease on it
+const (
+	// LeaseOwnerTag gives the ID of the instance holding the lease
+	LeaseOwnerTag = TagNamespace + "lease-owner"
+	// LeaseExpiresTag gives when the lease expires, unless it's renewed
+	LeaseExpiresTag = TagNamespace + "lease-expires"
+	// LeaseTokenTag gives the fencing token of the lease, which increases every time the lease is taken
+	LeaseTokenTag = TagNamespace + "lease-token"
+)
+
+// ErrLeaseHeld is wrapped by a LeaseError when a volume is leased to another instance
+var ErrLeaseHeld = errors.New("volume is leased to another instance")
+
+// ErrLeaseExpired is returned when an instance's lease on a volume expires before the volume is attached
+var ErrLeaseExpired = errors.New("lease has expired")
+
+var (
+	// leaseSettleDelay is how long to wait after taking a lease before checking no other instance took it too
+	leaseSettleDelay = 2 * time.Second
+	leaseClock       = time.Now
+)
+
+// Lease is the ownership of a volume by an instance, recorded with tags on the volume
+type Lease struct {
+	Owner   string
+	Expires time.Time
+	Token   int64
+}
+
+// LeaseError describes the lease preventing an instance using a volume
+type LeaseError struct {
+	VolumeID string
+	Lease    Lease
+}
+
+func (e *LeaseError) Error() string {
+	return fmt.Sprintf("volume (%s) is leased to instance (%s) until %s, with fencing token %d",
+		e.VolumeID, e.Lease.Owner, e.Lease.Expires.Format(time.RFC3339), e.Lease.Token)
+}
+
+// Unwrap returns ErrLeaseHeld
+func (e *LeaseError) Unwrap() error {
+	return ErrLeaseHeld
+}
+
+// WithLeaseDuration leases volumes for a duration before they're attached, so volumes allocated to
+// more than one instance are reported straight away rather than waited on. Leases are only used
+// when the duration is more than zero.
+func WithLeaseDuration(duration time.Duration) InstanceOption {
+	return func(e *EC2Instance) {
+		e.leaseDuration = duration
+	}
+}
+
+// leaseFromTags returns the lease recorded by the tags on a volume
+func leaseFromTags(tags []*ec2.Tag) Lease {
+
+	var lease Lease
+
+	for _, tag := range tags {
+		switch aws.StringValue(tag.Key) {
+		case LeaseOwnerTag:
+			lease.Owner = aws.StringValue(tag.Value)
+		case LeaseExpiresTag:
+			lease.Expires, _ = time.Parse(time.RFC3339, aws.StringValue(tag.Value))
+		case LeaseTokenTag:
+			lease.Token, _ = strconv.ParseInt(aws.StringValue(tag.Value), 10, 64)
+		}
+	}
+
+	return lease
+}
+
+// Active returns true if the lease is held by an instance and hasn't expired
+func (l Lease) Active() bool {
+	return l.Owner != "" && l.Expires.After(leaseClock())
+}
+
+// lease returns the current lease on the volume
+func (volume AllocatedVolume) lease() (Lease, error) {
+
+	status, err := volume.describe()
+
+	if err != nil {
+		return Lease{}, err
+	}
+
+	return leaseFromTags(status.Tags), nil
+}
+
+// AcquireLease leases the volume to its instance, or renews the lease when the instance already holds it.
+// A LeaseError is returned when another instance holds the lease.
+func (volume AllocatedVolume) AcquireLease() (Lease, error) {
+
+	current, err := volume.lease()
+
+	if err != nil {
+		return Lease{}, err
+	}
+
+	if current.Active() && current.Owner != volume.InstanceID {
+		return Lease{}, &LeaseError{VolumeID: volume.VolumeID, Lease: current}
+	}
+
+	lease := Lease{Owner: volume.InstanceID, Expires: leaseClock().Add(volume.leaseDuration).Truncate(time.Second), Token: current.Token}
+
+	if !current.Active() {
+		lease.Token++
+	}
+
+	_, err = volume.svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: aws.StringSlice([]string{volume.VolumeID}),
+		Tags: []*ec2.Tag{
+			{Key: aws.String(LeaseOwnerTag), Value: aws.String(lease.Owner)},
+			{Key: aws.String(LeaseExpiresTag), Value: aws.String(lease.Expires.UTC().Format(time.RFC3339))},
+			{Key: aws.String(LeaseTokenTag), Value: aws.String(strconv.FormatInt(lease.Token, 10))},
+		},
+	})
+
+	if err != nil {
+		return Lease{}, fmt.Errorf("unable to lease volume (%s) : %w", volume.VolumeID, err)
+	}
+
+	if current.Active() {
+		volume.logger.Debugf("Renewed lease on volume (%s) until %s", volume.VolumeID, lease.Expires.Format(time.RFC3339))
+		return lease, nil
+	}
+
+	// Another instance may have taken the lease at the same time, in which case the last to write it wins
+	time.Sleep(leaseSettleDelay)
+
+	if err := volume.checkLease(lease); err != nil {
+		return Lease{}, err
+	}
+
+	volume.logger.Debugf("Leased volume (%s) until %s with fencing token %d", volume.VolumeID, lease.Expires.Format(time.RFC3339), lease.Token)
+
+	return lease, nil
+}
+
+// checkLease returns an error unless the volume is still leased to the owner of the lease with its fencing
+// token, and the lease hasn't expired
+func (volume AllocatedVolume) checkLease(lease Lease) error {
+
+	current, err := volume.lease()
+
+	if err != nil {
+		return err
+	}
+
+	if current.Owner != lease.Owner || current.Token != lease.Token {
+		return &LeaseError{VolumeID: volume.VolumeID, Lease: current}
+	}
+
+	if !current.Active() {
+		return fmt.Errorf("%w on volume (%s) at %s, so it could be leased to another instance",
+			ErrLeaseExpired, volume.VolumeID, current.Expires.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// ReleaseLease gives up the lease on the volume when it's held by its instance. The fencing token is
+// kept, so the next lease taken has a higher one.
+func (volume AllocatedVolume) ReleaseLease() error {
+
+	current, err := volume.lease()
+
+	if err != nil {
+		return err
+	}
+
+	if current.Owner != volume.InstanceID {
+		return nil
+	}
+
+	_, err = volume.svc.DeleteTags(&ec2.DeleteTagsInput{
+		Resources: aws.StringSlice([]string{volume.VolumeID}),
+		Tags:      []*ec2.Tag{{Key: aws.String(LeaseOwnerTag)}, {Key: aws.String(LeaseExpiresTag)}},
+	})
+
+	if err != nil {
+		return fmt.Errorf("unable to release lease on volume (%s) : %w", volume.VolumeID, err)
+	}
+
+	volume.logger.Debugf("Released lease on volume (%s)", volume.VolumeID)
+
+	return nil
+}
+
+// RenewLeases renews the leases on the allocated volumes attached to this instance
+func (e EC2Instance) RenewLeases() error {
+
+	if e.leaseDuration <= 0 {
+		return errors.New("leases aren't being used, as no lease duration has been given")
+	}
+
+	return e.applyToVolumes(e.operation(AuditActionRenewLease, renewLease))
+}
+
+var renewLease = func(volume *AllocatedVolume) error {
+
+	attached, err := volume.Attached()
+
+	if err != nil {
+		return fmt.Errorf("unable to renew lease on volume (%s) : %w", volume.VolumeID, err)
+	}
+
+	if !attached {
+		volume.logger.Debugf("Volume (%s) not attached - not renewing lease", volume.VolumeID)
+		return nil
+	}
+
+	if _, err := volume.AcquireLease(); err != nil {
+		return fmt.Errorf("unable to renew lease on volume (%s) : %w", volume.VolumeID, err)
+	}
+
+	return nil
+}
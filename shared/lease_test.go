@@ -0,0 +1,240 @@
+package shared
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+// leaseService stands in for EC2, keeping the tags set on a volume which is never attached. When a
+// competitor is given it takes the lease whenever the instance does.
+type leaseService struct {
+	*testhelpers.MockEC2Service
+	tags       map[string]string
+	competitor string
+}
+
+func newLeaseService(tags map[string]string) *leaseService {
+
+	s := &leaseService{MockEC2Service: testhelpers.NewMockEC2Service(), tags: tags}
+
+	s.DescribeVolumesFunc = func(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+
+		if len(input.Filters) > 0 {
+			return &ec2.DescribeVolumesOutput{}, nil
+		}
+
+		volume := &ec2.Volume{VolumeId: input.VolumeIds[0], State: aws.String(ec2.VolumeStateAvailable)}
+
+		for key, value := range s.tags {
+			volume.Tags = append(volume.Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+
+		return &ec2.DescribeVolumesOutput{Volumes: []*ec2.Volume{volume}}, nil
+	}
+
+	s.CreateTagsFunc = func(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+
+		for _, tag := range input.Tags {
+			s.tags[*tag.Key] = *tag.Value
+		}
+
+		if s.competitor != "" {
+			s.tags[LeaseOwnerTag] = s.competitor
+		}
+
+		return &ec2.CreateTagsOutput{}, nil
+	}
+
+	s.DeleteTagsFunc = func(input *ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
+
+		for _, tag := range input.Tags {
+			delete(s.tags, *tag.Key)
+		}
+
+		return &ec2.DeleteTagsOutput{}, nil
+	}
+
+	return s
+}
+
+func leaseTags(owner string, expires time.Time, token int64) map[string]string {
+	return map[string]string{
+		LeaseOwnerTag:   owner,
+		LeaseExpiresTag: expires.Format(time.RFC3339),
+		LeaseTokenTag:   strconv.FormatInt(token, 10),
+	}
+}
+
+func withLeaseTiming(now time.Time) func() {
+
+	savedDelay, savedClock := leaseSettleDelay, leaseClock
+
+	leaseSettleDelay = 0
+	leaseClock = func() time.Time { return now }
+
+	return func() {
+		leaseSettleDelay, leaseClock = savedDelay, savedClock
+	}
+}
+
+func leasedVolume(svc *leaseService) *AllocatedVolume {
+
+	volume := NewAllocatedVolume("vol-12345678", "/dev/sdh", "id-98765", svc)
+	volume.leaseDuration = 5 * time.Minute
+
+	return volume
+}
+
+func TestAcquireLease(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer withLeaseTiming(now)()
+
+	var tests = []struct {
+		tags     map[string]string
+		expected int64
+	}{
+		{map[string]string{}, 1},
+		{leaseTags("i-00000001", now.Add(-time.Minute), 4), 5},
+		{leaseTags("id-98765", now.Add(-time.Minute), 4), 5},
+		{leaseTags("id-98765", now.Add(time.Minute), 4), 4},
+	}
+
+	for i, tt := range tests {
+
+		svc := newLeaseService(tt.tags)
+
+		lease, err := leasedVolume(svc).AcquireLease()
+
+		if err != nil {
+			t.Fatalf("Test %d shouldn't have failed, but I got %v", i, err)
+		}
+
+		if lease.Owner != "id-98765" || lease.Token != tt.expected || !lease.Expires.Equal(now.Add(5*time.Minute)) {
+			t.Errorf("Test %d expected a lease until %s with fencing token %d, but got %v", i, now.Add(5*time.Minute), tt.expected, lease)
+		}
+
+		if svc.tags[LeaseOwnerTag] != "id-98765" || svc.tags[LeaseExpiresTag] != "2021-06-01T12:05:00Z" ||
+			svc.tags[LeaseTokenTag] != strconv.FormatInt(tt.expected, 10) {
+			t.Errorf("Test %d expected the lease to be recorded, but got %v", i, svc.tags)
+		}
+	}
+}
+
+func TestAcquireLeaseHeldByAnotherInstance(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer withLeaseTiming(now)()
+
+	svc := newLeaseService(leaseTags("i-00000001", now.Add(time.Minute), 4))
+
+	_, err := leasedVolume(svc).AcquireLease()
+
+	var leaseError *LeaseError
+
+	if !errors.As(err, &leaseError) || leaseError.Lease.Owner != "i-00000001" || !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("Expected the lease to be held by i-00000001, but got %v", err)
+	}
+}
+
+func TestAcquireLeaseLostToAnotherInstance(t *testing.T) {
+
+	defer withLeaseTiming(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))()
+
+	svc := newLeaseService(map[string]string{})
+	svc.competitor = "i-00000001"
+
+	if _, err := leasedVolume(svc).AcquireLease(); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("Expected the lease to be lost to i-00000001, but got %v", err)
+	}
+}
+
+func TestCheckLease(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer withLeaseTiming(now)()
+
+	lease := Lease{Owner: "id-98765", Expires: now.Add(time.Minute), Token: 4}
+
+	var tests = []struct {
+		tags     map[string]string
+		expected error
+	}{
+		{leaseTags("id-98765", now.Add(time.Minute), 4), nil},
+		{leaseTags("i-00000001", now.Add(time.Minute), 5), ErrLeaseHeld},
+		{leaseTags("id-98765", now.Add(time.Minute), 5), ErrLeaseHeld},
+		{leaseTags("id-98765", now.Add(-time.Second), 4), ErrLeaseExpired},
+		{map[string]string{}, ErrLeaseHeld},
+	}
+
+	for i, tt := range tests {
+
+		err := leasedVolume(newLeaseService(tt.tags)).checkLease(lease)
+
+		if tt.expected == nil && err != nil || tt.expected != nil && !errors.Is(err, tt.expected) {
+			t.Errorf("Test %d expected %v, but got %v", i, tt.expected, err)
+		}
+	}
+}
+
+func TestReleaseLeaseKeepsToken(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer withLeaseTiming(now)()
+
+	svc := newLeaseService(leaseTags("id-98765", now.Add(time.Minute), 4))
+
+	if err := leasedVolume(svc).ReleaseLease(); err != nil {
+		t.Fatalf("Releasing the lease shouldn't have failed, but I got %v", err)
+	}
+
+	if _, ok := svc.tags[LeaseOwnerTag]; ok || svc.tags[LeaseTokenTag] != "4" {
+		t.Errorf("Expected the owner to be removed and the fencing token kept, but got %v", svc.tags)
+	}
+}
+
+func TestAttachFailsStraightAwayWhenLeasedToAnotherInstance(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer withLeaseTiming(now)()
+
+	svc := newLeaseService(leaseTags("i-00000001", now.Add(time.Minute), 4))
+	svc.WaitUntilVolumeAvailableFunc = func(*ec2.DescribeVolumesInput) error {
+		t.Error("Shouldn't have waited for the volume to become available")
+		return nil
+	}
+
+	if err := leasedVolume(svc).Attach(); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("Expected the attach to fail as the volume is leased, but got %v", err)
+	}
+}
+
+func TestAttachChecksFencingTokenBeforeAttaching(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer withLeaseTiming(now)()
+
+	svc := newLeaseService(map[string]string{})
+
+	// The lease expires while waiting, and is taken again with a higher fencing token
+	svc.WaitUntilVolumeAvailableFunc = func(*ec2.DescribeVolumesInput) error {
+		svc.tags[LeaseTokenTag] = "2"
+		return nil
+	}
+	svc.AttachVolumeFunc = func(*ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error) {
+		t.Error("Shouldn't have attached the volume with a stale fencing token")
+		return &ec2.VolumeAttachment{}, nil
+	}
+
+	var leaseError *LeaseError
+
+	if err := leasedVolume(svc).Attach(); !errors.As(err, &leaseError) || leaseError.Lease.Token != 2 {
+		t.Errorf("Expected the attach to fail as the lease was taken again, but got %v", err)
+	}
+}
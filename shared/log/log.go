@@ -1,20 +1,250 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
-// Debug, Info and Error are used for logging at the appropriate level
+// Level is how important a log entry is
+type Level int
 
-var (
-	Debug = log.New(ioutil.Discard, "ebs-volumes: ", 0)
-	Info  = log.New(os.Stdout, "ebs-volumes: ", 0)
-	Error = log.New(os.Stdout, "ebs-volumes: ", 0)
+// Levels, from least to most important
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
 )
 
-// SetVerbose turns on logging categories for verbose logging
-func SetVerbose() {
-	Debug.SetOutput(os.Stdout)
+var levelNames = map[Level]string{LevelDebug: "debug", LevelInfo: "info", LevelWarn: "warn", LevelError: "error"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses a level : debug, info, warn or error
+func ParseLevel(value string) (Level, error) {
+
+	for level, name := range levelNames {
+		if strings.EqualFold(value, name) {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level '%s', expected debug, info, warn or error", value)
+}
+
+// Format is how log entries are written
+type Format string
+
+// Formats log entries can be written in
+const (
+	// FormatText writes an entry as its message followed by its fields, for people to read
+	FormatText Format = "text"
+	// FormatJSON writes an entry as a JSON object on a line of its own, for log pipelines to ingest
+	FormatJSON Format = "json"
+)
+
+// ParseFormat parses a format : text or json
+func ParseFormat(value string) (Format, error) {
+
+	switch Format(value) {
+	case FormatText, FormatJSON:
+		return Format(value), nil
+	default:
+		return FormatText, fmt.Errorf("unknown log format '%s', expected text or json", value)
+	}
+}
+
+// Common field names
+const (
+	FieldVolumeID   = "volume_id"
+	FieldDevice     = "device"
+	FieldInstanceID = "instance_id"
+	FieldOperation  = "operation"
+	FieldAttempt    = "attempt"
+
+	// FieldAWSErrorCode, FieldAWSStatusCode and FieldAWSRequestID describe a failed AWS request, so it can be
+	// found by AWS support
+	FieldAWSErrorCode  = "aws_error_code"
+	FieldAWSStatusCode = "aws_status_code"
+	FieldAWSRequestID  = "aws_request_id"
+	// FieldAWSOperation names the AWS API operation requested
+	FieldAWSOperation = "aws_operation"
+)
+
+// prefix starts each entry written as text
+const prefix = "ebs-volumes: "
+
+// clock tells the time of entries
+var clock = time.Now
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// output is shared by a logger and those derived from it, so entries written concurrently aren't interleaved
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Logger writes entries at or above a level, along with its fields
+type Logger struct {
+	out    *output
+	level  Level
+	format Format
+	fields []field
+}
+
+// New returns a logger writing entries at or above a level to w
+func New(w io.Writer, level Level, format Format) *Logger {
+	return &Logger{out: &output{w: w}, level: level, format: format}
+}
+
+// Default returns a logger writing entries at the info level and above as text to standard output
+func Default() *Logger {
+	return New(os.Stdout, LevelInfo, FormatText)
+}
+
+// Discard returns a logger which writes nothing
+func Discard() *Logger {
+	return New(ioutil.Discard, LevelError+1, FormatText)
+}
+
+// With returns a logger adding a field to each entry, replacing any field with the same key
+func (l *Logger) With(key string, value interface{}) *Logger {
+
+	if l == nil {
+		return nil
+	}
+
+	fields := make([]field, 0, len(l.fields)+1)
+
+	for _, f := range l.fields {
+		if f.key != key {
+			fields = append(fields, f)
+		}
+	}
+
+	return &Logger{out: l.out, level: l.level, format: l.format, fields: append(fields, field{key, value})}
+}
+
+// Enabled returns true if entries at the level are written. A nil logger writes nothing.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+// Debugf writes an entry at the debug level
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args...)
+}
+
+// Infof writes an entry at the info level
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args...)
+}
+
+// Warnf writes an entry at the warn level
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args...)
+}
+
+// Errorf writes an entry at the error level
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, format, args...)
+}
+
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+
+	if !l.Enabled(level) {
+		return
+	}
+
+	message := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+
+	var entry []byte
+
+	if l.format == FormatJSON {
+		entry = l.json(level, message)
+	} else {
+		entry = l.text(level, message)
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	l.out.w.Write(entry)
+}
+
+// text formats an entry as the prefix, the level unless it's info, the message and then the fields
+func (l *Logger) text(level Level, message string) []byte {
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(prefix)
+
+	if level != LevelInfo {
+		buf.WriteString(strings.ToUpper(level.String()) + " ")
+	}
+
+	buf.WriteString(message)
+
+	for _, f := range l.fields {
+		value := fmt.Sprint(f.value)
+
+		if strings.ContainsAny(value, " \"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+
+		fmt.Fprintf(buf, " %s=%s", f.key, value)
+	}
+
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
+
+// json formats an entry as an object holding the time, level, message and fields, in that order
+func (l *Logger) json(level Level, message string) []byte {
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+
+	write := func(key string, value interface{}) {
+
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+
+		encodedKey, _ := json.Marshal(key)
+		encodedValue, err := json.Marshal(value)
+
+		if err != nil {
+			encodedValue, _ = json.Marshal(fmt.Sprint(value))
+		}
+
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		buf.Write(encodedValue)
+	}
+
+	write("time", clock().UTC().Format(time.RFC3339Nano))
+	write("level", level.String())
+	write("msg", message)
+
+	for _, f := range l.fields {
+		write(f.key, f.value)
+	}
+
+	buf.WriteString("}\n")
+
+	return buf.Bytes()
 }
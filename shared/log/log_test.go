@@ -0,0 +1,71 @@
+package log
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+
+	for value, expected := range map[string]Level{"debug": LevelDebug, "INFO": LevelInfo, "warn": LevelWarn, "error": LevelError} {
+		if level, err := ParseLevel(value); err != nil || level != expected {
+			t.Errorf("Parsing '%s' : expected %s, but got %s, %v", value, expected, level, err)
+		}
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Parsing an unknown level should have failed")
+	}
+
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("Parsing an unknown format should have failed")
+	}
+}
+
+func TestTextEntries(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	logger := New(buf, LevelInfo, FormatText).With(FieldVolumeID, "vol-12345678").With(FieldOperation, "attach")
+
+	logger.Debugf("Not written")
+	logger.Infof("Attaching Volume (%s)\n", "vol-12345678")
+	logger.With(FieldOperation, "detach").Errorf("Unable to detach : %s", "Whoops")
+
+	expected := "ebs-volumes: Attaching Volume (vol-12345678) volume_id=vol-12345678 operation=attach\n" +
+		"ebs-volumes: ERROR Unable to detach : Whoops volume_id=vol-12345678 operation=detach\n"
+
+	if buf.String() != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, buf)
+	}
+}
+
+func TestJSONEntries(t *testing.T) {
+
+	saved := clock
+	defer func() {
+		clock = saved
+	}()
+
+	clock = func() time.Time { return time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC) }
+
+	buf := &bytes.Buffer{}
+	New(buf, LevelDebug, FormatJSON).With(FieldDevice, "/dev/sdf").With(FieldAttempt, 2).Debugf("Waiting for \"modification\"")
+
+	expected := `{"time":"2021-06-01T12:00:00Z","level":"debug","msg":"Waiting for \"modification\"","device":"/dev/sdf","attempt":2}` + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("Expected %s but got %s", expected, buf)
+	}
+}
+
+func TestNilLoggerWritesNothing(t *testing.T) {
+
+	var logger *Logger
+
+	logger.With(FieldVolumeID, "vol-12345678").Errorf("Not written")
+
+	if logger.Enabled(LevelError) {
+		t.Error("A nil logger shouldn't be enabled")
+	}
+}
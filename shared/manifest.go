@@ -0,0 +1,164 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrConflictingSources is returned when a manifest and tags allocate a device or volume differently
+var ErrConflictingSources = errors.New("allocated differently by the manifest and tags")
+
+// Manifest allocates volumes to devices without using tags, for instances whose tags can't be changed.
+// It's read from YAML such as
+//
+//	volumes:
+//	  /dev/sdg: vol-049df61146c4d7901
+//	instances:
+//	  i-1234567890abcdef0:
+//	    /dev/sdh: vol-0f0e0d0c0b0a09080
+//
+// Volumes listed for an instance are used instead of those listed for any instance.
+type Manifest struct {
+	// Path is where the manifest was read from, if anywhere
+	Path string
+
+	// Volumes are keyed by device name, and used by instances not listed in Instances
+	Volumes map[string]string `yaml:"volumes"`
+
+	// Instances lists the volumes keyed by device name for particular instances
+	Instances map[string]map[string]string `yaml:"instances"`
+}
+
+// LoadManifest reads a manifest from a file
+func LoadManifest(path string) (*Manifest, error) {
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest (%s) : %v", path, err)
+	}
+
+	manifest, err := ParseManifest(data)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse manifest (%s) : %v", path, err)
+	}
+
+	manifest.Path = path
+
+	return manifest, nil
+}
+
+// ParseManifest parses a manifest from YAML
+func ParseManifest(data []byte) (*Manifest, error) {
+
+	manifest := &Manifest{}
+
+	if err := yaml.UnmarshalStrict(data, manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// InstancesOnly returns the manifest without the volumes used by any instance, so they
+// aren't allocated to every instance in a fleet
+func (m *Manifest) InstancesOnly() *Manifest {
+	return &Manifest{Path: m.Path, Instances: m.Instances}
+}
+
+// VolumesFor returns the volumes keyed by device name for an instance
+func (m *Manifest) VolumesFor(instanceID string) map[string]string {
+
+	if volumes, ok := m.Instances[instanceID]; ok {
+		return volumes
+	}
+
+	return m.Volumes
+}
+
+func (m *Manifest) String() string {
+
+	if m.Path == "" {
+		return "manifest"
+	}
+
+	return fmt.Sprintf("manifest (%s)", m.Path)
+}
+
+// WithManifest merges the volumes allocated by a manifest with those allocated by tags
+func WithManifest(manifest *Manifest) InstanceOption {
+	return func(e *EC2Instance) {
+		e.manifest = manifest
+	}
+}
+
+// mergeManifestVolumes merges the volumes allocated by a manifest with those allocated by tags. A
+// device allocated the same volume by both is used once. Devices allocated different volumes, and
+// volumes allocated to different devices, aren't used at all.
+func mergeManifestVolumes(logger *log.Logger, tagged []VolumeTag, manifest *Manifest, instanceID string, root string) ([]VolumeTag, []error) {
+
+	listed, problems := parseVolumeMappings(manifest.VolumesFor(instanceID), manifest.String(), instanceID, root)
+
+	byDevice := make(map[string]VolumeTag)
+	byVolume := make(map[string]VolumeTag)
+
+	for _, volume := range listed {
+		byDevice[deviceKey(volume.DeviceName)] = volume
+		byVolume[volume.VolumeID] = volume
+	}
+
+	conflicted := make(map[string]bool)
+	agreed := make(map[string]bool)
+
+	conflict := func(tag VolumeTag, listed VolumeTag) {
+		problems = append(problems, fmt.Errorf("volume (%s) at (%s) from %s and volume (%s) at (%s) from %s : %w",
+			tag.VolumeID, tag.DeviceName, tag.Source, listed.VolumeID, listed.DeviceName, listed.Source, ErrConflictingSources))
+		conflicted[deviceKey(tag.DeviceName)] = true
+		conflicted[deviceKey(listed.DeviceName)] = true
+	}
+
+	for _, tag := range tagged {
+
+		if volume, ok := byDevice[deviceKey(tag.DeviceName)]; ok && volume.VolumeID != tag.VolumeID {
+			conflict(tag, volume)
+		} else if volume, ok := byVolume[tag.VolumeID]; ok && deviceKey(volume.DeviceName) != deviceKey(tag.DeviceName) {
+			conflict(tag, volume)
+		} else if ok {
+			agreed[deviceKey(tag.DeviceName)] = true
+		}
+	}
+
+	var volumes []VolumeTag
+
+	for _, tag := range tagged {
+
+		key := deviceKey(tag.DeviceName)
+
+		if conflicted[key] {
+			continue
+		}
+
+		if agreed[key] {
+			logger.Debugf("Volume (%s) at (%s) is allocated by both %s and %s", tag.VolumeID, tag.DeviceName, tag.Source, byDevice[key].Source)
+			tag.Source = fmt.Sprintf("%s and %s", tag.Source, byDevice[key].Source)
+		}
+
+		volumes = append(volumes, tag)
+	}
+
+	for _, volume := range listed {
+
+		key := deviceKey(volume.DeviceName)
+
+		if !conflicted[key] && !agreed[key] {
+			volumes = append(volumes, volume)
+		}
+	}
+
+	return volumes, problems
+}
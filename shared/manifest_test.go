@@ -0,0 +1,125 @@
+package shared
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+const testManifest = `
+volumes:
+  /dev/sdg: vol-11111111
+instances:
+  id-98765:
+    sdh: vol-22222222
+`
+
+func TestLoadManifest(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "manifest.yaml")
+
+	if err := ioutil.WriteFile(path, []byte(testManifest), 0600); err != nil {
+		t.Fatalf("Unable to write manifest : %v", err)
+	}
+
+	manifest, err := LoadManifest(path)
+
+	if err != nil {
+		t.Fatalf("Loading the manifest shouldn't have failed, but I got %v", err)
+	}
+
+	if volumes := manifest.VolumesFor("id-98765"); len(volumes) != 1 || volumes["sdh"] != "vol-22222222" {
+		t.Errorf("Expected the volumes listed for id-98765, but got %v", volumes)
+	}
+
+	if volumes := manifest.VolumesFor("id-12345"); len(volumes) != 1 || volumes["/dev/sdg"] != "vol-11111111" {
+		t.Errorf("Expected the volumes listed for any instance, but got %v", volumes)
+	}
+
+	if volumes := manifest.InstancesOnly().VolumesFor("id-12345"); len(volumes) != 0 {
+		t.Errorf("Expected no volumes for an instance not listed, but got %v", volumes)
+	}
+
+	if manifest.String() != "manifest ("+path+")" {
+		t.Errorf("Expected the manifest to be described by its path, but got %s", manifest)
+	}
+}
+
+func TestParseManifestRejectsUnknownFields(t *testing.T) {
+
+	if _, err := ParseManifest([]byte("volume:\n  /dev/sdg: vol-11111111\n")); err == nil {
+		t.Error("A manifest with an unknown field should have been rejected")
+	}
+}
+
+func TestMergeManifestVolumes(t *testing.T) {
+
+	tagged := []VolumeTag{
+		{DeviceName: "/dev/sdf", VolumeID: "vol-11111111", InstanceID: "id-98765", Source: "tag volume_sdf"},
+		{DeviceName: "/dev/sdg", VolumeID: "vol-22222222", InstanceID: "id-98765", Source: "tag volume_sdg"},
+		{DeviceName: "/dev/sdh", VolumeID: "vol-33333333", InstanceID: "id-98765", Source: "tag volume_sdh"},
+		{DeviceName: "/dev/sdi", VolumeID: "vol-44444444", InstanceID: "id-98765", Source: "tag volume_sdi"},
+	}
+
+	manifest := &Manifest{Volumes: map[string]string{
+		"xvdf": "vol-11111111", // agrees with the tag
+		"sdg":  "vol-55555555", // a different volume at the same device
+		"sdj":  "vol-33333333", // the same volume at a different device
+		"sdk":  "vol-66666666", // only in the manifest
+		"sdl":  "vol-1",        // invalid
+	}}
+
+	volumes, problems := mergeManifestVolumes(nil, tagged, manifest, "id-98765", "")
+
+	expected := []VolumeTag{
+		{DeviceName: "/dev/sdf", VolumeID: "vol-11111111", InstanceID: "id-98765", Source: "tag volume_sdf and manifest"},
+		{DeviceName: "/dev/sdi", VolumeID: "vol-44444444", InstanceID: "id-98765", Source: "tag volume_sdi"},
+		{DeviceName: "/dev/sdk", VolumeID: "vol-66666666", InstanceID: "id-98765", Source: "manifest"},
+	}
+
+	if len(volumes) != len(expected) {
+		t.Fatalf("Expected %v, but got %v", expected, volumes)
+	}
+
+	for i := range expected {
+		if volumes[i] != expected[i] {
+			t.Errorf("Expected %v, but got %v", expected[i], volumes[i])
+		}
+	}
+
+	if len(problems) != 3 || !containsError(problems, ErrConflictingSources) || !containsError(problems, ErrInvalidVolumeID) {
+		t.Errorf("Expected two conflicts and an invalid volume ID, but got %v", problems)
+	}
+}
+
+func TestAllocatedVolumesFromManifest(t *testing.T) {
+
+	instanceID := "id-98765"
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeTagsFunc: testhelpers.DescribeVolumeTagsForInstance(instanceID,
+			testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sdf", instanceID, "vol-11111111").Build()),
+	}
+
+	manifest, err := ParseManifest([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Parsing the manifest shouldn't have failed, but I got %v", err)
+	}
+
+	volumes, err := NewEC2Instance(testhelpers.NewMockMetadata(instanceID, "erewhon"), mockEC2Service,
+		WithManifest(manifest)).AllocatedVolumes()
+
+	if err != nil {
+		t.Fatalf("Shouldn't have failed : got error %s", err.Error())
+	}
+
+	if len(volumes) != 2 {
+		t.Fatalf("Should have got 2 allocated volumes, but got %v", volumes)
+	}
+
+	if volumes[1].VolumeID != "vol-22222222" || volumes[1].DeviceName != "/dev/sdh" || volumes[1].Source != "manifest" {
+		t.Errorf("Expected vol-22222222 at /dev/sdh from the manifest, but got %s from %s", volumes[1], volumes[1].Source)
+	}
+}
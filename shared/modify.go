@@ -0,0 +1,339 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+)
+
+// modificationPollDelay and modificationMaxAttempts control how long to wait for a volume modification
+var (
+	modificationPollDelay   = 15 * time.Second
+	modificationMaxAttempts = 120
+)
+
+// modificationCooldown is how long EC2 requires between modifications of the same volume
+const modificationCooldown = 6 * time.Hour
+
+// ShrinkError is returned when a volume is larger than its designated size, as EBS volumes can't be shrunk
+type ShrinkError struct {
+	VolumeID       string
+	Size           int64
+	DesignatedSize int64
+}
+
+func (e *ShrinkError) Error() string {
+	return fmt.Sprintf("volume (%s) is %d GiB and can't be shrunk to %d GiB", e.VolumeID, e.Size, e.DesignatedSize)
+}
+
+// Modify converges the size, type and performance of the volume to those designated. When the volume
+// has a designated size the partition and filesystem on it are grown once the volume has been.
+// Every step checks the current state before acting, so an interrupted modification is resumed by running it again.
+func (volume AllocatedVolume) Modify() error {
+
+	if volume.Size == 0 && volume.Performance.IsZero() {
+		volume.logger.Debugf("No size or performance designated for volume (%s) - skipping", volume.VolumeID)
+		return nil
+	}
+
+	volume.logger.Infof("Modifying Volume (%s) at (%s)", volume.VolumeID, volume.DeviceName)
+
+	if volume.Size != 0 {
+		attached, err := volume.Attached()
+		if err != nil {
+			return fmt.Errorf("error modifying volume (%s): %w", volume.VolumeID, err)
+		}
+
+		if !attached {
+			return fmt.Errorf("volume (%s) must be attached to instance (%s) to be resized",
+				volume.VolumeID, volume.InstanceID)
+		}
+	}
+
+	if err := volume.reconcile(); err != nil {
+		return err
+	}
+
+	if volume.Size != 0 {
+		device, err := resolveDevice(volume.DeviceName, volume.VolumeID)
+		if err != nil {
+			return err
+		}
+
+		if err := growFilesystem(volume.logger, device); err != nil {
+			return fmt.Errorf("error growing filesystem for volume (%s) on (%s): %w",
+				volume.VolumeID, device, err)
+		}
+	}
+
+	volume.logger.Infof("Modified Volume (%s) at (%s)", volume.VolumeID, volume.DeviceName)
+
+	return nil
+}
+
+// Resize grows the volume to its designated size, then grows the partition and filesystem on it.
+// The volume is also converged to its designated performance, as by Modify.
+//
+// Deprecated: use Modify.
+func (volume AllocatedVolume) Resize() error {
+	return volume.Modify()
+}
+
+// reconcile modifies the EBS volume if it differs from the designated size and performance
+func (volume AllocatedVolume) reconcile() error {
+
+	modification, err := volume.latestModification()
+	if err != nil {
+		return err
+	}
+
+	if modification != nil && aws.StringValue(modification.ModificationState) == ec2ext.VolumeModificationStateModifying {
+		volume.logger.Debugf("Volume (%s) is already being modified", volume.VolumeID)
+
+		if err := volume.waitUntilModified(); err != nil {
+			return err
+		}
+	}
+
+	current, err := volume.describePerformance()
+	if err != nil {
+		return err
+	}
+
+	if err := volume.checkSize(current); err != nil {
+		return err
+	}
+
+	opts := volume.modifyVolumeInput(current)
+
+	if opts == nil {
+		volume.logger.Debugf("Volume (%s) already has the designated size and performance", volume.VolumeID)
+		return nil
+	}
+
+	if modification != nil && modification.StartTime != nil {
+		if next := modification.StartTime.Add(modificationCooldown); time.Now().Before(next) {
+			volume.logger.Infof("Volume (%s) was last modified at %s and can't be modified again until %s - skipping",
+				volume.VolumeID, modification.StartTime.Format(time.RFC3339), next.Format(time.RFC3339))
+			return nil
+		}
+	}
+
+	volume.logger.Debugf("Modifying volume (%s) : %s", volume.VolumeID, describeModifyVolumeInput(opts))
+
+	if _, err := volume.svc.ModifyVolume(opts); err != nil {
+		return fmt.Errorf("error modifying volume (%s): %w", volume.VolumeID, err)
+	}
+
+	return volume.waitUntilModified()
+}
+
+// describePerformance returns the current size, type and performance of the volume
+func (volume AllocatedVolume) describePerformance() (*ec2ext.VolumePerformance, error) {
+
+	resp, err := volume.svc.DescribeVolumesPerformance(volume.describeVolumesInput())
+	if err != nil {
+		return nil, fmt.Errorf("error getting performance for volume (%s): %w", volume.VolumeID, err)
+	}
+
+	if len(resp.Volumes) == 0 {
+		return nil, fmt.Errorf("volume (%s) not found", volume.VolumeID)
+	}
+
+	return resp.Volumes[0], nil
+}
+
+// checkSize returns a ShrinkError if the volume is larger than its designated size
+func (volume AllocatedVolume) checkSize(current *ec2ext.VolumePerformance) error {
+
+	if size := aws.Int64Value(current.Size); volume.Size != 0 && size > volume.Size {
+		return &ShrinkError{VolumeID: volume.VolumeID, Size: size, DesignatedSize: volume.Size}
+	}
+
+	return nil
+}
+
+// modifyVolumeInput returns the modification needed to converge the volume to the designated
+// size and performance, or nil if none is needed. Volumes are only ever grown.
+func (volume AllocatedVolume) modifyVolumeInput(current *ec2ext.VolumePerformance) *ec2ext.ModifyVolumeInput {
+
+	opts := &ec2ext.ModifyVolumeInput{VolumeId: aws.String(volume.VolumeID)}
+	changed := false
+
+	if volume.Size != 0 && aws.Int64Value(current.Size) < volume.Size {
+		opts.Size = aws.Int64(volume.Size)
+		changed = true
+	}
+
+	if volume.Performance.VolumeType != "" && aws.StringValue(current.VolumeType) != volume.Performance.VolumeType {
+		opts.VolumeType = aws.String(volume.Performance.VolumeType)
+		changed = true
+	}
+
+	if volume.Performance.Iops != 0 && aws.Int64Value(current.Iops) != volume.Performance.Iops {
+		opts.Iops = aws.Int64(volume.Performance.Iops)
+		changed = true
+	}
+
+	if volume.Performance.Throughput != 0 && aws.Int64Value(current.Throughput) != volume.Performance.Throughput {
+		opts.Throughput = aws.Int64(volume.Performance.Throughput)
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return opts
+}
+
+// latestModification returns the most recent modification of the volume, or nil if it has never been modified
+func (volume AllocatedVolume) latestModification() (*ec2ext.VolumeModification, error) {
+
+	resp, err := volume.svc.DescribeVolumesModifications(&ec2ext.DescribeVolumesModificationsInput{
+		VolumeIds: []*string{aws.String(volume.VolumeID)},
+	})
+
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidVolumeModification.NotFound" {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("error getting modifications for volume (%s): %w", volume.VolumeID, err)
+	}
+
+	if len(resp.VolumesModifications) == 0 {
+		return nil, nil
+	}
+
+	return resp.VolumesModifications[0], nil
+}
+
+// waitUntilModified waits until the modification of the volume has progressed far enough
+// for the new configuration to be used
+func (volume AllocatedVolume) waitUntilModified() error {
+
+	volume.logger.Debugf("Waiting for modification of volume (%s) to complete", volume.VolumeID)
+
+	for attempt := 0; attempt < volume.modificationAttempts(); attempt++ {
+
+		modification, err := volume.latestModification()
+		if err != nil {
+			return err
+		}
+
+		if modification == nil {
+			return fmt.Errorf("no modification found for volume (%s)", volume.VolumeID)
+		}
+
+		volume.logger.With(log.FieldAttempt, attempt+1).Debugf("Modification of volume (%s) is %s",
+			volume.VolumeID, aws.StringValue(modification.ModificationState))
+
+		switch aws.StringValue(modification.ModificationState) {
+		case ec2ext.VolumeModificationStateOptimizing, ec2ext.VolumeModificationStateCompleted:
+			return nil
+		case ec2ext.VolumeModificationStateFailed:
+			return fmt.Errorf("modification of volume (%s) failed: %s",
+				volume.VolumeID, aws.StringValue(modification.StatusMessage))
+		}
+
+		time.Sleep(modificationPollDelay)
+	}
+
+	return fmt.Errorf("timed out waiting for modification of volume (%s)", volume.VolumeID)
+}
+
+// modificationAttempts returns how many times to check on a modification before giving up
+func (volume AllocatedVolume) modificationAttempts() int {
+
+	if volume.modifyTimeout <= 0 || modificationPollDelay <= 0 {
+		return modificationMaxAttempts
+	}
+
+	if attempts := int(volume.modifyTimeout / modificationPollDelay); attempts > 1 {
+		return attempts
+	}
+
+	return 1
+}
+
+// modificationInfo writes the modification in progress and any outstanding modification of the volume, when
+// it has a designated size or performance
+func (volume AllocatedVolume) modificationInfo(w io.Writer) error {
+
+	// Volumes without a designated size or performance aren't modified, so the permission to describe
+	// modifications isn't granted for them
+	if volume.Size == 0 && volume.Performance.IsZero() {
+		return nil
+	}
+
+	modification, err := volume.latestModification()
+	if err != nil {
+		return err
+	}
+
+	if modification != nil {
+		state := aws.StringValue(modification.ModificationState)
+
+		if state == ec2ext.VolumeModificationStateModifying || state == ec2ext.VolumeModificationStateOptimizing {
+			fmt.Fprintf(w, "\tModification is %s (%d%% complete) : %s\n", state,
+				aws.Int64Value(modification.Progress), describeModificationTargets(modification))
+		}
+	}
+
+	current, err := volume.describePerformance()
+	if err != nil {
+		return err
+	}
+
+	var shrinkErr *ShrinkError
+
+	if errors.As(volume.checkSize(current), &shrinkErr) {
+		fmt.Fprintf(w, "\tVolume is %d GiB, larger than its designated size of %d GiB - it can't be shrunk\n",
+			shrinkErr.Size, shrinkErr.DesignatedSize)
+	}
+
+	if opts := volume.modifyVolumeInput(current); opts != nil {
+		fmt.Fprintf(w, "\tPending modification : %s\n", describeModifyVolumeInput(opts))
+	}
+
+	return nil
+}
+
+func describeModifyVolumeInput(opts *ec2ext.ModifyVolumeInput) string {
+	return describeTargets(opts.Size, opts.VolumeType, opts.Iops, opts.Throughput)
+}
+
+func describeModificationTargets(modification *ec2ext.VolumeModification) string {
+	return describeTargets(modification.TargetSize, modification.TargetVolumeType, modification.TargetIops, modification.TargetThroughput)
+}
+
+func describeTargets(size *int64, volumeType *string, iops *int64, throughput *int64) string {
+
+	var targets []string
+
+	if size != nil {
+		targets = append(targets, fmt.Sprintf("size %d GiB", *size))
+	}
+
+	if volumeType != nil {
+		targets = append(targets, fmt.Sprintf("type %s", *volumeType))
+	}
+
+	if iops != nil {
+		targets = append(targets, fmt.Sprintf("iops %d", *iops))
+	}
+
+	if throughput != nil {
+		targets = append(targets, fmt.Sprintf("throughput %d MiB/s", *throughput))
+	}
+
+	return strings.Join(targets, ", ")
+}
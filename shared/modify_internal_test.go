@@ -0,0 +1,409 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+func TestModifyGrowsVolumeAndFilesystem(t *testing.T) {
+
+	expectedVolumeID := "vol-87654321"
+
+	modifyVolumeFuncCalled := false
+	grownDevice := ""
+
+	// The volume has never been modified until ModifyVolume is called
+	states := []string{}
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeVolumesPerformanceFunc: describePerformance(expectedVolumeID, 10, "gp2", 100, 0),
+		ModifyVolumeFunc: func(input *ec2ext.ModifyVolumeInput) (*ec2ext.ModifyVolumeOutput, error) {
+			modifyVolumeFuncCalled = true
+
+			if *input.VolumeId != expectedVolumeID || aws.Int64Value(input.Size) != 20 || input.VolumeType != nil {
+				t.Errorf("Unexpected modification %s", input)
+			}
+
+			states = []string{ec2ext.VolumeModificationStateModifying, ec2ext.VolumeModificationStateOptimizing}
+			return &ec2ext.ModifyVolumeOutput{}, nil
+		},
+		DescribeVolumesModificationsFunc: func(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+			if len(states) == 0 {
+				return nil, awserr.New("InvalidVolumeModification.NotFound", "not modified", nil)
+			}
+
+			state := states[0]
+			if len(states) > 1 {
+				states = states[1:]
+			}
+
+			return describeModification(expectedVolumeID, state, time.Now()), nil
+		},
+	}
+
+	defer stubModify(&grownDevice)()
+	setVolumeAttached()
+
+	underTest := NewAllocatedVolume(expectedVolumeID, "/dev/sdg", "i-11223344", mockEC2Service)
+	underTest.Size = 20
+
+	if err := underTest.Modify(); err != nil {
+		t.Errorf("Modifying the volume shouldn't have failed, but I got %v", err)
+	}
+
+	if !modifyVolumeFuncCalled {
+		t.Error("The AWS API ModifyVolume function wasn't called")
+	}
+
+	if grownDevice != "/dev/xvdg" {
+		t.Errorf("The filesystem on /dev/xvdg should have been grown, but got '%s'", grownDevice)
+	}
+}
+
+func TestModifyConvergesPerformance(t *testing.T) {
+
+	expectedVolumeID := "vol-87654321"
+
+	var modification *ec2ext.ModifyVolumeInput
+	grownDevice := ""
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeVolumesPerformanceFunc: describePerformance(expectedVolumeID, 10, "gp2", 100, 0),
+		ModifyVolumeFunc: func(input *ec2ext.ModifyVolumeInput) (*ec2ext.ModifyVolumeOutput, error) {
+			modification = input
+			return &ec2ext.ModifyVolumeOutput{}, nil
+		},
+		DescribeVolumesModificationsFunc: func(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+			if modification == nil {
+				return &ec2ext.DescribeVolumesModificationsOutput{}, nil
+			}
+			return describeModification(expectedVolumeID, ec2ext.VolumeModificationStateOptimizing, time.Now()), nil
+		},
+	}
+
+	defer stubModify(&grownDevice)()
+	setVolumeDetached()
+
+	underTest := NewAllocatedVolume(expectedVolumeID, "/dev/sdg", "i-11223344", mockEC2Service)
+	underTest.Performance = Performance{VolumeType: "gp3", Iops: 100, Throughput: 250}
+
+	if err := underTest.Modify(); err != nil {
+		t.Errorf("Modifying the volume shouldn't have failed, but I got %v", err)
+	}
+
+	if modification == nil {
+		t.Fatal("The AWS API ModifyVolume function wasn't called")
+	}
+
+	if aws.StringValue(modification.VolumeType) != "gp3" || aws.Int64Value(modification.Throughput) != 250 ||
+		modification.Iops != nil || modification.Size != nil {
+		t.Errorf("Only the differing type and throughput should have been modified, but got %s", modification)
+	}
+
+	if grownDevice != "" {
+		t.Error("No filesystem should have been grown when no size was designated")
+	}
+}
+
+func TestModifyRespectsCooldown(t *testing.T) {
+
+	expectedVolumeID := "vol-87654321"
+
+	grownDevice := ""
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeVolumesPerformanceFunc: describePerformance(expectedVolumeID, 10, "gp2", 100, 0),
+		ModifyVolumeFunc: func(input *ec2ext.ModifyVolumeInput) (*ec2ext.ModifyVolumeOutput, error) {
+			t.Error("The volume shouldn't have been modified during the cooldown")
+			return &ec2ext.ModifyVolumeOutput{}, nil
+		},
+		DescribeVolumesModificationsFunc: func(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+			return describeModification(expectedVolumeID, ec2ext.VolumeModificationStateCompleted, time.Now().Add(-time.Hour)), nil
+		},
+	}
+
+	defer stubModify(&grownDevice)()
+
+	underTest := NewAllocatedVolume(expectedVolumeID, "/dev/sdg", "i-11223344", mockEC2Service)
+	underTest.Performance = Performance{VolumeType: "gp3"}
+
+	if err := underTest.Modify(); err != nil {
+		t.Errorf("Modifying the volume during the cooldown should have been skipped, but I got %v", err)
+	}
+}
+
+func TestModifyResumesWhenAlreadyModifying(t *testing.T) {
+
+	expectedVolumeID := "vol-87654321"
+
+	grownDevice := ""
+	states := []string{ec2ext.VolumeModificationStateModifying, ec2ext.VolumeModificationStateModifying, ec2ext.VolumeModificationStateCompleted}
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeVolumesPerformanceFunc: describePerformance(expectedVolumeID, 20, "gp2", 100, 0),
+		ModifyVolumeFunc: func(input *ec2ext.ModifyVolumeInput) (*ec2ext.ModifyVolumeOutput, error) {
+			t.Error("The volume shouldn't have been modified again")
+			return &ec2ext.ModifyVolumeOutput{}, nil
+		},
+		DescribeVolumesModificationsFunc: func(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+			state := states[0]
+			if len(states) > 1 {
+				states = states[1:]
+			}
+
+			return describeModification(expectedVolumeID, state, time.Now()), nil
+		},
+	}
+
+	defer stubModify(&grownDevice)()
+	setVolumeAttached()
+
+	underTest := NewAllocatedVolume(expectedVolumeID, "/dev/sdg", "i-11223344", mockEC2Service)
+	underTest.Size = 20
+
+	if err := underTest.Modify(); err != nil {
+		t.Errorf("Modifying the volume shouldn't have failed, but I got %v", err)
+	}
+
+	if grownDevice == "" {
+		t.Error("The filesystem should have been grown")
+	}
+}
+
+func TestModifyFailsWhenModificationFails(t *testing.T) {
+
+	expectedVolumeID := "vol-87654321"
+
+	grownDevice := ""
+	modified := false
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeVolumesPerformanceFunc: describePerformance(expectedVolumeID, 10, "gp2", 100, 0),
+		ModifyVolumeFunc: func(input *ec2ext.ModifyVolumeInput) (*ec2ext.ModifyVolumeOutput, error) {
+			modified = true
+			return &ec2ext.ModifyVolumeOutput{}, nil
+		},
+		DescribeVolumesModificationsFunc: func(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+			if !modified {
+				return &ec2ext.DescribeVolumesModificationsOutput{}, nil
+			}
+			return describeModification(expectedVolumeID, ec2ext.VolumeModificationStateFailed, time.Now()), nil
+		},
+	}
+
+	defer stubModify(&grownDevice)()
+	setVolumeAttached()
+
+	underTest := NewAllocatedVolume(expectedVolumeID, "/dev/sdg", "i-11223344", mockEC2Service)
+	underTest.Size = 20
+
+	if err := underTest.Modify(); err == nil {
+		t.Error("Modifying the volume should have failed")
+	}
+
+	if grownDevice != "" {
+		t.Error("The filesystem shouldn't have been grown")
+	}
+}
+
+func TestModifyRefusesToShrink(t *testing.T) {
+
+	expectedVolumeID := "vol-87654321"
+
+	grownDevice := ""
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeVolumesPerformanceFunc: describePerformance(expectedVolumeID, 30, "gp2", 100, 0),
+		DescribeVolumesModificationsFunc: func(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+			return &ec2ext.DescribeVolumesModificationsOutput{}, nil
+		},
+	}
+
+	defer stubModify(&grownDevice)()
+	setVolumeAttached()
+
+	underTest := NewAllocatedVolume(expectedVolumeID, "/dev/sdg", "i-11223344", mockEC2Service)
+	underTest.Size = 20
+
+	var shrinkErr *ShrinkError
+
+	if err := underTest.Modify(); !errors.As(err, &shrinkErr) || shrinkErr.Size != 30 || shrinkErr.DesignatedSize != 20 {
+		t.Errorf("Shrinking the volume should have failed, but got %v", err)
+	}
+}
+
+func TestModifyFailsWhenResizingDetachedVolume(t *testing.T) {
+
+	grownDevice := ""
+
+	defer stubModify(&grownDevice)()
+	setVolumeDetached()
+
+	underTest := NewAllocatedVolume("vol-87654321", "/dev/sdg", "i-11223344", &testhelpers.MockEC2Service{})
+	underTest.Size = 20
+
+	if err := underTest.Modify(); err == nil {
+		t.Error("Resizing a detached volume should have failed")
+	}
+}
+
+func TestModifySkippedWhenNothingDesignated(t *testing.T) {
+
+	underTest := NewAllocatedVolume("vol-87654321", "/dev/sdg", "i-11223344", &testhelpers.MockEC2Service{
+		DescribeVolumesFunc: func(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+			return nil, errors.New("No EC2 API functions should have been called")
+		},
+	})
+
+	if err := underTest.Modify(); err != nil {
+		t.Errorf("Modifying should have been skipped, but I got %v", err)
+	}
+}
+
+func TestModificationInfo(t *testing.T) {
+
+	expectedVolumeID := "vol-87654321"
+
+	modification := describeModification(expectedVolumeID, ec2ext.VolumeModificationStateOptimizing, time.Now())
+	modification.VolumesModifications[0].TargetSize = aws.Int64(20)
+	modification.VolumesModifications[0].Progress = aws.Int64(42)
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeVolumesPerformanceFunc: describePerformance(expectedVolumeID, 20, "gp2", 100, 0),
+		DescribeVolumesModificationsFunc: func(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+			return modification, nil
+		},
+	}
+
+	underTest := NewAllocatedVolume(expectedVolumeID, "/dev/sdg", "i-11223344", mockEC2Service)
+	underTest.Size = 20
+	underTest.Performance = Performance{VolumeType: "gp3", Throughput: 250}
+
+	buf := new(bytes.Buffer)
+
+	if err := underTest.modificationInfo(buf); err != nil {
+		t.Fatalf("Getting modification info shouldn't have failed, but I got %v", err)
+	}
+
+	info := buf.String()
+
+	for _, expected := range []string{"optimizing (42% complete) : size 20 GiB", "Pending modification : type gp3, throughput 250 MiB/s"} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("Info should have contained '%s', but was : '%s'", expected, info)
+		}
+	}
+}
+
+func TestModificationInfoWhenLargerThanDesignated(t *testing.T) {
+
+	expectedVolumeID := "vol-87654321"
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeVolumesPerformanceFunc: describePerformance(expectedVolumeID, 30, "gp2", 100, 0),
+		DescribeVolumesModificationsFunc: func(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+			return &ec2ext.DescribeVolumesModificationsOutput{}, nil
+		},
+	}
+
+	underTest := NewAllocatedVolume(expectedVolumeID, "/dev/sdg", "i-11223344", mockEC2Service)
+	underTest.Size = 20
+	underTest.Performance = Performance{VolumeType: "gp3"}
+
+	buf := new(bytes.Buffer)
+
+	if err := underTest.modificationInfo(buf); err != nil {
+		t.Fatalf("Getting modification info shouldn't have failed for a volume larger than designated, but I got %v", err)
+	}
+
+	info := buf.String()
+
+	for _, expected := range []string{"Volume is 30 GiB, larger than its designated size of 20 GiB", "Pending modification : type gp3\n"} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("Info should have contained '%s', but was : '%s'", expected, info)
+		}
+	}
+}
+
+func describePerformance(volumeID string, size int64, volumeType string, iops int64, throughput int64) func(input *ec2.DescribeVolumesInput) (*ec2ext.DescribeVolumesPerformanceOutput, error) {
+	return testhelpers.DescribeVolumePerformanceForID(volumeID, &ec2ext.DescribeVolumesPerformanceOutput{
+		Volumes: []*ec2ext.VolumePerformance{
+			{
+				VolumeId:   aws.String(volumeID),
+				Size:       aws.Int64(size),
+				VolumeType: aws.String(volumeType),
+				Iops:       aws.Int64(iops),
+				Throughput: aws.Int64(throughput),
+			},
+		},
+	})
+}
+
+func describeModification(volumeID string, state string, started time.Time) *ec2ext.DescribeVolumesModificationsOutput {
+	return &ec2ext.DescribeVolumesModificationsOutput{
+		VolumesModifications: []*ec2ext.VolumeModification{
+			{
+				VolumeId:          aws.String(volumeID),
+				ModificationState: aws.String(state),
+				StartTime:         aws.Time(started),
+			},
+		},
+	}
+}
+
+// stubModify replaces the package functions used when modifying, returning a function to restore them
+func stubModify(grownDevice *string) func() {
+
+	savedAttached := doAttached
+	savedResolveDevice := resolveDevice
+	savedGrowFilesystem := growFilesystem
+	savedPollDelay := modificationPollDelay
+
+	modificationPollDelay = 0
+
+	resolveDevice = func(deviceName string, volumeID string) (string, error) {
+		return "/dev/xvd" + deviceName[len("/dev/sd"):], nil
+	}
+
+	growFilesystem = func(logger *log.Logger, device string) error {
+		*grownDevice = device
+		return nil
+	}
+
+	return func() {
+		doAttached = savedAttached
+		resolveDevice = savedResolveDevice
+		growFilesystem = savedGrowFilesystem
+		modificationPollDelay = savedPollDelay
+	}
+}
+
+func TestModificationAttemptsFollowTimeout(t *testing.T) {
+
+	volume := AllocatedVolume{}
+
+	if attempts := volume.modificationAttempts(); attempts != modificationMaxAttempts {
+		t.Errorf("Expected %d attempts without a timeout, but got %d", modificationMaxAttempts, attempts)
+	}
+
+	volume.modifyTimeout = 10 * modificationPollDelay
+
+	if attempts := volume.modificationAttempts(); attempts != 10 {
+		t.Errorf("Expected 10 attempts, but got %d", attempts)
+	}
+
+	volume.modifyTimeout = time.Second
+
+	if attempts := volume.modificationAttempts(); attempts != 1 {
+		t.Errorf("Expected at least one attempt, but got %d", attempts)
+	}
+}
@@ -0,0 +1,83 @@
+package shared
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Performance describes the type and performance designated for a volume. Zero values are left unmanaged.
+type Performance struct {
+	VolumeType string
+	Iops       int64
+	Throughput int64
+}
+
+// ParsePerformance parses a performance designation such as
+//
+//	type=gp3,iops=3000,throughput=125
+//
+// where throughput is in MiB/s. Every setting is optional.
+func ParsePerformance(value string) (Performance, error) {
+
+	var performance Performance
+
+	for _, setting := range strings.Split(value, ",") {
+
+		if strings.TrimSpace(setting) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return Performance{}, fmt.Errorf("setting '%s' should be of the form name=value", setting)
+		}
+
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		designated := strings.TrimSpace(parts[1])
+
+		switch name {
+		case "type":
+			performance.VolumeType = designated
+		case "iops", "throughput":
+			number, err := strconv.ParseInt(designated, 10, 64)
+			if err != nil || number <= 0 {
+				return Performance{}, fmt.Errorf("%s '%s' should be a positive number", name, designated)
+			}
+
+			if name == "iops" {
+				performance.Iops = number
+			} else {
+				performance.Throughput = number
+			}
+		default:
+			return Performance{}, fmt.Errorf("unknown setting '%s'", name)
+		}
+	}
+
+	return performance, nil
+}
+
+// IsZero returns true if nothing has been designated
+func (p Performance) IsZero() bool {
+	return p == Performance{}
+}
+
+func (p Performance) String() string {
+
+	var settings []string
+
+	if p.VolumeType != "" {
+		settings = append(settings, "type="+p.VolumeType)
+	}
+
+	if p.Iops != 0 {
+		settings = append(settings, fmt.Sprintf("iops=%d", p.Iops))
+	}
+
+	if p.Throughput != 0 {
+		settings = append(settings, fmt.Sprintf("throughput=%d", p.Throughput))
+	}
+
+	return strings.Join(settings, ",")
+}
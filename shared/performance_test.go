@@ -0,0 +1,47 @@
+package shared
+
+import "testing"
+
+var parsePerformanceTests = []struct {
+	value    string
+	expected Performance
+}{
+	{"type=gp3,iops=3000,throughput=125", Performance{VolumeType: "gp3", Iops: 3000, Throughput: 125}},
+	{" IOPS = 16000 ", Performance{Iops: 16000}},
+	{"type=io2,", Performance{VolumeType: "io2"}},
+	{"", Performance{}},
+}
+
+func TestParsePerformance(t *testing.T) {
+
+	for _, tt := range parsePerformanceTests {
+
+		performance, err := ParsePerformance(tt.value)
+
+		if err != nil {
+			t.Errorf("Parsing '%s' shouldn't have failed, but I got %v", tt.value, err)
+		}
+
+		if performance != tt.expected {
+			t.Errorf("Parsing '%s' should have given %+v, but got %+v", tt.value, tt.expected, performance)
+		}
+	}
+}
+
+func TestParsePerformanceErrors(t *testing.T) {
+
+	for _, value := range []string{"gp3", "iops=lots", "throughput=-1", "speed=fast"} {
+		if _, err := ParsePerformance(value); err == nil {
+			t.Errorf("Parsing '%s' should have failed", value)
+		}
+	}
+}
+
+func TestPerformanceString(t *testing.T) {
+
+	performance := Performance{VolumeType: "gp3", Throughput: 125}
+
+	if performance.String() != "type=gp3,throughput=125" {
+		t.Errorf("Unexpected string representation '%s'", performance.String())
+	}
+}
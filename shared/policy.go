@@ -0,0 +1,546 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+)
+
+// PolicyVersion is the version of the IAM policy language policies are written in
+const PolicyVersion = "2012-10-17"
+
+// Policy is an IAM policy document
+type Policy struct {
+	Version   string            `json:"Version"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+// PolicyStatement is a statement in an IAM policy, with conditions keyed by operator and then condition key
+type PolicyStatement struct {
+	Sid       string                         `json:"Sid,omitempty"`
+	Effect    string                         `json:"Effect"`
+	Action    []string                       `json:"Action"`
+	Resource  []string                       `json:"Resource"`
+	Condition map[string]map[string][]string `json:"Condition,omitempty"`
+}
+
+// JSON returns the policy as indented JSON
+func (p *Policy) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// AccountMetadata gives the account an instance belongs to, as the instance identity document does
+type AccountMetadata interface {
+	AccountID() (string, error)
+}
+
+// plannedVolume is a volume given by ID
+type plannedVolume struct {
+	id     string
+	device string
+	modify bool
+}
+
+// selectedVolumes are volumes found by a selector, rather than given by ID
+type selectedVolumes struct {
+	device   string
+	selector *VolumeSelector
+	modify   bool
+	// pool is true when the volumes are claimed from a pool
+	pool bool
+}
+
+// policyPlan is what the instance needs permission to do with its configuration
+type policyPlan struct {
+	partition        string
+	region           string
+	account          string
+	instanceID       string
+	availabilityZone string
+
+	describe []string
+	volumes  []plannedVolume
+	selected []selectedVolumes
+	lease    bool
+	// poolTags are the instance tags recording claims on pool volumes
+	poolTags []string
+
+	logger *log.Logger
+}
+
+// partition returns the partition a region is in, as used in ARNs
+func partition(region string) string {
+
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	default:
+		return "aws"
+	}
+}
+
+// accountID returns the account the instance belongs to, or a wildcard when it can't be found
+func (e EC2Instance) accountID() string {
+
+	metadata, ok := e.metadata.(AccountMetadata)
+
+	if !ok {
+		e.logger.Infof("Not restricting the policy to an account as the account is unknown")
+		return "*"
+	}
+
+	account, err := metadata.AccountID()
+
+	if err != nil || account == "" {
+		e.logger.Infof("Not restricting the policy to an account as the account is unknown : %v", err)
+		return "*"
+	}
+
+	return account
+}
+
+// policyPlan works out what the instance needs permission to do, along with problems found with tags
+// that were ignored as a result
+func (e EC2Instance) policyPlan() (*policyPlan, []error, error) {
+
+	instanceID, err := e.metadata.InstanceID()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	region, err := e.metadata.Region()
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get region : %w", err)
+	}
+
+	availabilityZone, err := e.metadata.AvailabilityZone()
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get availability zone : %w", err)
+	}
+
+	tags, err := e.tags()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root, _ := e.metadata.RootDeviceName()
+
+	volumeTags, problems, err := e.volumeTags(tags, root)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan := &policyPlan{
+		partition:        partition(region),
+		region:           region,
+		account:          e.accountID(),
+		instanceID:       instanceID,
+		availabilityZone: availabilityZone,
+		lease:            e.leaseDuration > 0,
+		logger:           e.logger,
+	}
+
+	sizes, sizeProblems := volumeSizes(tags, e.schemas)
+	performances, performanceProblems := volumePerformances(tags, e.schemas)
+	problems = append(append(problems, sizeProblems...), performanceProblems...)
+
+	modified := func(device string) bool {
+		return sizes[deviceKey(device)] != 0 || !performances[deviceKey(device)].IsZero()
+	}
+
+	designated := make(map[string]bool)
+
+	for _, tag := range volumeTags {
+
+		designated[deviceKey(tag.DeviceName)] = true
+
+		if !IsVolumeSelector(tag.VolumeID) {
+			plan.volumes = append(plan.volumes, plannedVolume{id: tag.VolumeID, device: tag.DeviceName, modify: modified(tag.DeviceName)})
+			continue
+		}
+
+		selector, err := ParseVolumeSelector(tag.VolumeID)
+
+		if err != nil {
+			problems = append(problems, fmt.Errorf("volume selector '%s' for (%s) from %s : %w", tag.VolumeID, tag.DeviceName, tag.Source, err))
+			continue
+		}
+
+		plan.selected = append(plan.selected, selectedVolumes{device: tag.DeviceName, selector: selector, modify: modified(tag.DeviceName)})
+	}
+
+	pools, poolProblems := parsePoolTags(tags, e.schemas, root)
+	problems = append(problems, poolProblems...)
+
+	for _, pool := range pools {
+
+		if designated[deviceKey(pool.device)] {
+			continue
+		}
+
+		plan.selected = append(plan.selected, selectedVolumes{device: pool.device, selector: pool.selector, modify: modified(pool.device), pool: true})
+		plan.poolTags = append(plan.poolTags, pool.schema.VolumeTag(pool.device))
+	}
+
+	plan.describe = []string{"ec2:DescribeInstances", "ec2:DescribeVolumes"}
+
+	if e.tagSourceMode != TagSourceMetadata {
+		plan.describe = append(plan.describe, "ec2:DescribeTags")
+	}
+
+	if plan.modified() {
+		plan.describe = append(plan.describe, "ec2:DescribeVolumesModifications")
+	}
+
+	sort.Strings(plan.describe)
+
+	return plan, problems, nil
+}
+
+// modified returns true if any volume is modified
+func (p *policyPlan) modified() bool {
+
+	for _, volume := range p.volumes {
+		if volume.modify {
+			return true
+		}
+	}
+
+	for _, selected := range p.selected {
+		if selected.modify {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *policyPlan) arn(resource string) string {
+	return fmt.Sprintf("arn:%s:ec2:%s:%s:%s", p.partition, p.region, p.account, resource)
+}
+
+// volumeARNs returns the ARNs of the volumes given by ID, only including those modified if modified is true
+func (p *policyPlan) volumeARNs(modified bool) []string {
+
+	var arns []string
+
+	for _, volume := range p.volumes {
+		if volume.modify || !modified {
+			arns = append(arns, p.arn("volume/"+volume.id))
+		}
+	}
+
+	return arns
+}
+
+// leaseTagKeys are the tags set and removed on volumes when leasing them
+var leaseTagKeys = []string{LeaseExpiresTag, LeaseOwnerTag, LeaseTokenTag}
+
+// claimTagKeys are the tags set on volumes when claiming them from pools
+var claimTagKeys = []string{ClaimOwnerTag, ClaimedAtTag, ClaimTokenTag}
+
+// Policy returns the least privileged IAM policy allowing the instance to manage the volumes allocated to it,
+// granting permissions on the volumes and the instance themselves where EC2 allows it. Volumes found by
+// selectors or claimed from pools are granted permissions by the tags selecting them.
+func (e EC2Instance) Policy() (*Policy, error) {
+
+	plan, problems, err := e.policyPlan()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, problem := range problems {
+		e.logger.Errorf("Ignoring %v", problem)
+	}
+
+	return plan.policy(), nil
+}
+
+func (p *policyPlan) policy() *Policy {
+
+	attach := []string{"ec2:AttachVolume", "ec2:DetachVolume"}
+	tagging := []string{"ec2:CreateTags", "ec2:DeleteTags"}
+	volumes, modified := p.volumeARNs(false), p.volumeARNs(true)
+
+	policy := &Policy{Version: PolicyVersion}
+
+	// EC2 doesn't allow Describe permissions to be restricted to resources
+	policy.add(PolicyStatement{Sid: "DescribeVolumes", Action: p.describe, Resource: []string{"*"},
+		Condition: condition("StringEquals", "aws:RequestedRegion", p.region)})
+
+	policy.add(PolicyStatement{Sid: "AttachToInstance", Action: attach, Resource: []string{p.arn("instance/" + p.instanceID)}})
+
+	if len(volumes) > 0 {
+		policy.add(PolicyStatement{Sid: "AttachVolumes", Action: attach, Resource: volumes})
+	}
+
+	if len(modified) > 0 {
+		policy.add(PolicyStatement{Sid: "ModifyVolumes", Action: []string{"ec2:ModifyVolume"}, Resource: modified})
+	}
+
+	if p.lease && len(volumes) > 0 {
+		policy.add(PolicyStatement{Sid: "LeaseVolumes", Action: tagging, Resource: volumes,
+			Condition: condition("ForAllValues:StringEquals", "aws:TagKeys", leaseTagKeys...)})
+	}
+
+	for i, selected := range p.selected {
+
+		actions := []string{"ec2:AttachVolume", "ec2:DetachVolume"}
+
+		if selected.modify {
+			actions = append(actions, "ec2:ModifyVolume")
+		}
+
+		policy.add(PolicyStatement{Sid: fmt.Sprintf("AttachSelectedVolumes%d", i+1), Action: actions,
+			Resource: []string{p.arn("volume/*")}, Condition: p.selectedCondition(selected)})
+
+		var keys []string
+
+		if selected.pool {
+			keys = append(keys, claimTagKeys...)
+		}
+
+		if p.lease {
+			keys = append(keys, leaseTagKeys...)
+		}
+
+		if len(keys) > 0 {
+			tagCondition := p.selectedCondition(selected)
+			tagCondition["ForAllValues:StringEquals"] = map[string][]string{"aws:TagKeys": keys}
+
+			policy.add(PolicyStatement{Sid: fmt.Sprintf("TagSelectedVolumes%d", i+1), Action: tagging,
+				Resource: []string{p.arn("volume/*")}, Condition: tagCondition})
+		}
+	}
+
+	if len(p.poolTags) > 0 {
+		policy.add(PolicyStatement{Sid: "RecordPoolClaims", Action: tagging,
+			Resource: []string{p.arn("instance/" + p.instanceID)}, Condition: condition("ForAllValues:StringEquals", "aws:TagKeys", p.poolTags...)})
+	}
+
+	return policy
+}
+
+// add adds a statement allowing its actions
+func (policy *Policy) add(statement PolicyStatement) {
+	statement.Effect = "Allow"
+	policy.Statement = append(policy.Statement, statement)
+}
+
+// selectedCondition restricts permissions to volumes in the instance's availability zone with the tags
+// a selector looks for. Selectors filtering on anything else can't be restricted further.
+func (p *policyPlan) selectedCondition(selected selectedVolumes) map[string]map[string][]string {
+
+	c := condition("StringEquals", "ec2:AvailabilityZone", p.availabilityZone)
+	restricted := false
+
+	for _, filter := range selected.selector.Filters {
+
+		name := aws.StringValue(filter.Name)
+
+		if !strings.HasPrefix(name, "tag:") {
+			continue
+		}
+
+		operator := "StringEquals"
+		values := aws.StringValueSlice(filter.Values)
+
+		for _, value := range values {
+			if strings.ContainsAny(value, "*?") {
+				operator = "StringLike"
+			}
+		}
+
+		if c[operator] == nil {
+			c[operator] = make(map[string][]string)
+		}
+
+		c[operator]["ec2:ResourceTag/"+name[len("tag:"):]] = values
+		restricted = true
+	}
+
+	if !restricted {
+		p.logger.Infof("Volumes for (%s) are only selected by tags they don't have, so can't be restricted beyond the availability zone (%s)",
+			selected.device, p.availabilityZone)
+	}
+
+	return c
+}
+
+func condition(operator string, key string, values ...string) map[string]map[string][]string {
+	return map[string]map[string][]string{operator: {key: values}}
+}
+
+// CheckPolicy makes dry run requests needing each permission in the policy, returning findings for those
+// not granted. An error is only returned if the checks couldn't be made.
+func (e EC2Instance) CheckPolicy() ([]Finding, error) {
+
+	plan, problems, err := e.policyPlan()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+
+	for _, problem := range problems {
+		findings = append(findings, Finding{Severity: SeverityWarning, Message: fmt.Sprintf("ignored %v", problem)})
+	}
+
+	for _, action := range plan.describe {
+		findings = append(findings, permissionFindings(Finding{}, action, e.describeDryRun(action, plan.instanceID))...)
+	}
+
+	for _, volume := range plan.volumes {
+		findings = append(findings, e.checkVolume(plan, volume.id, volume.device, volume.modify, nil)...)
+	}
+
+	for _, selected := range plan.selected {
+
+		volumeID, err := e.selectedVolume(plan, selected)
+
+		if err != nil {
+			findings = append(findings, Finding{Severity: SeverityWarning, DeviceName: selected.device,
+				Message: fmt.Sprintf("permissions on volumes found by selector not checked : %v", err)})
+			continue
+		}
+
+		var claim []string
+
+		if selected.pool {
+			claim = append(claim, claimTagKeys...)
+		}
+
+		findings = append(findings, e.checkVolume(plan, volumeID, selected.device, selected.modify, claim)...)
+	}
+
+	if len(plan.poolTags) > 0 {
+		_, err := e.svc.CreateTags(&ec2.CreateTagsInput{
+			DryRun:    aws.Bool(true),
+			Resources: aws.StringSlice([]string{plan.instanceID}),
+			Tags:      dryRunTags(plan.poolTags),
+		})
+		findings = append(findings, permissionFindings(Finding{}, "ec2:CreateTags on the instance", err)...)
+
+		_, err = e.svc.DeleteTags(&ec2.DeleteTagsInput{
+			DryRun:    aws.Bool(true),
+			Resources: aws.StringSlice([]string{plan.instanceID}),
+			Tags:      dryRunTags(plan.poolTags),
+		})
+		findings = append(findings, permissionFindings(Finding{}, "ec2:DeleteTags on the instance", err)...)
+	}
+
+	return findings, nil
+}
+
+// selectedVolume returns a volume found by a selector, to check permissions on
+func (e EC2Instance) selectedVolume(plan *policyPlan, selected selectedVolumes) (string, error) {
+
+	volumes, err := selected.selector.matchingVolumes(e.svc, plan.availabilityZone)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(volumes) == 0 {
+		return "", ErrNoVolumeMatched
+	}
+
+	return aws.StringValue(volumes[0].VolumeId), nil
+}
+
+// checkVolume makes dry run requests needing the permissions on a volume
+func (e EC2Instance) checkVolume(plan *policyPlan, volumeID string, device string, modify bool, claim []string) []Finding {
+
+	volume := NewAllocatedVolume(volumeID, device, plan.instanceID, e.svc)
+
+	_, err := e.svc.AttachVolume(&ec2.AttachVolumeInput{
+		DryRun:     aws.Bool(true),
+		Device:     aws.String("/dev/sdz"),
+		InstanceId: aws.String(plan.instanceID),
+		VolumeId:   aws.String(volumeID),
+	})
+	findings := volume.permissionFindings("ec2:AttachVolume", err)
+
+	_, err = e.svc.DetachVolume(&ec2.DetachVolumeInput{
+		DryRun:     aws.Bool(true),
+		InstanceId: aws.String(plan.instanceID),
+		VolumeId:   aws.String(volumeID),
+	})
+	findings = append(findings, volume.permissionFindings("ec2:DetachVolume", err)...)
+
+	if modify {
+		_, err = e.svc.ModifyVolume(&ec2ext.ModifyVolumeInput{DryRun: aws.Bool(true), VolumeId: aws.String(volumeID)})
+		findings = append(findings, volume.permissionFindings("ec2:ModifyVolume", err)...)
+	}
+
+	keys := claim
+
+	if plan.lease {
+		keys = append(keys, leaseTagKeys...)
+	}
+
+	if len(keys) > 0 {
+		_, err = e.svc.CreateTags(&ec2.CreateTagsInput{
+			DryRun:    aws.Bool(true),
+			Resources: aws.StringSlice([]string{volumeID}),
+			Tags:      dryRunTags(keys),
+		})
+		findings = append(findings, volume.permissionFindings("ec2:CreateTags", err)...)
+
+		_, err = e.svc.DeleteTags(&ec2.DeleteTagsInput{
+			DryRun:    aws.Bool(true),
+			Resources: aws.StringSlice([]string{volumeID}),
+			Tags:      dryRunTags(keys),
+		})
+		findings = append(findings, volume.permissionFindings("ec2:DeleteTags", err)...)
+	}
+
+	return findings
+}
+
+// describeDryRun makes a dry run request for a Describe action
+func (e EC2Instance) describeDryRun(action string, instanceID string) error {
+
+	var err error
+
+	switch action {
+	case "ec2:DescribeInstances":
+		_, err = e.svc.DescribeInstances(&ec2.DescribeInstancesInput{DryRun: aws.Bool(true), InstanceIds: aws.StringSlice([]string{instanceID})})
+	case "ec2:DescribeTags":
+		_, err = e.svc.DescribeTags(&ec2.DescribeTagsInput{DryRun: aws.Bool(true), Filters: []*ec2.Filter{
+			{Name: aws.String("resource-id"), Values: aws.StringSlice([]string{instanceID})},
+		}})
+	case "ec2:DescribeVolumes":
+		_, err = e.svc.DescribeVolumes(&ec2.DescribeVolumesInput{DryRun: aws.Bool(true)})
+	case "ec2:DescribeVolumesModifications":
+		_, err = e.svc.DescribeVolumesModifications(&ec2ext.DescribeVolumesModificationsInput{DryRun: aws.Bool(true)})
+	}
+
+	return err
+}
+
+func dryRunTags(keys []string) []*ec2.Tag {
+
+	var tags []*ec2.Tag
+
+	for _, key := range keys {
+		tags = append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String("")})
+	}
+
+	return tags
+}
@@ -0,0 +1,221 @@
+package shared
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers/ec2fake"
+)
+
+func newPolicyFake(t *testing.T) (*ec2fake.Server, *EC2Instance) {
+
+	fake := ec2fake.New("us-east-1")
+	t.Cleanup(fake.Close)
+
+	fake.AddInstance(ec2fake.Instance{ID: "i-0123456789abcdef0", AvailabilityZone: "us-east-1a", Tags: map[string]string{
+		"volume_/dev/sdf": "vol-11111111",
+		"size_/dev/sdf":   "20",
+		"volume_/dev/sdg": "tag:Name=data-*",
+		"pool_/dev/sdh":   "tag:pool=kafka",
+	}})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-11111111", AvailabilityZone: "us-east-1a"})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-22222222", AvailabilityZone: "us-east-1a", Tags: map[string]string{"Name": "data-0"}})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-33333333", AvailabilityZone: "us-east-1a", Tags: map[string]string{"pool": "kafka"}})
+
+	instance := NewEC2Instance(fake.Metadata(), fake.Client(), WithTagSourceMode(TagSourceAPI), WithLeaseDuration(5*time.Minute))
+
+	return fake, instance
+}
+
+func statements(policy *Policy) map[string]PolicyStatement {
+
+	bySid := make(map[string]PolicyStatement)
+
+	for _, statement := range policy.Statement {
+		bySid[statement.Sid] = statement
+	}
+
+	return bySid
+}
+
+func TestPolicy(t *testing.T) {
+
+	_, instance := newPolicyFake(t)
+
+	policy, err := instance.Policy()
+
+	if err != nil {
+		t.Fatalf("Writing the policy shouldn't have failed, but I got %v", err)
+	}
+
+	const arn = "arn:aws:ec2:us-east-1:123456789012:"
+
+	expected := map[string]PolicyStatement{
+		"DescribeVolumes": {
+			Action:    []string{"ec2:DescribeInstances", "ec2:DescribeTags", "ec2:DescribeVolumes", "ec2:DescribeVolumesModifications"},
+			Resource:  []string{"*"},
+			Condition: map[string]map[string][]string{"StringEquals": {"aws:RequestedRegion": {"us-east-1"}}},
+		},
+		"AttachToInstance": {
+			Action:   []string{"ec2:AttachVolume", "ec2:DetachVolume"},
+			Resource: []string{arn + "instance/i-0123456789abcdef0"},
+		},
+		"AttachVolumes": {
+			Action:   []string{"ec2:AttachVolume", "ec2:DetachVolume"},
+			Resource: []string{arn + "volume/vol-11111111"},
+		},
+		"ModifyVolumes": {
+			Action:   []string{"ec2:ModifyVolume"},
+			Resource: []string{arn + "volume/vol-11111111"},
+		},
+		"LeaseVolumes": {
+			Action:    []string{"ec2:CreateTags", "ec2:DeleteTags"},
+			Resource:  []string{arn + "volume/vol-11111111"},
+			Condition: map[string]map[string][]string{"ForAllValues:StringEquals": {"aws:TagKeys": leaseTagKeys}},
+		},
+		"AttachSelectedVolumes1": {
+			Action:   []string{"ec2:AttachVolume", "ec2:DetachVolume"},
+			Resource: []string{arn + "volume/*"},
+			Condition: map[string]map[string][]string{
+				"StringEquals": {"ec2:AvailabilityZone": {"us-east-1a"}},
+				"StringLike":   {"ec2:ResourceTag/Name": {"data-*"}},
+			},
+		},
+		"TagSelectedVolumes1": {
+			Action:   []string{"ec2:CreateTags", "ec2:DeleteTags"},
+			Resource: []string{arn + "volume/*"},
+			Condition: map[string]map[string][]string{
+				"StringEquals":              {"ec2:AvailabilityZone": {"us-east-1a"}},
+				"StringLike":                {"ec2:ResourceTag/Name": {"data-*"}},
+				"ForAllValues:StringEquals": {"aws:TagKeys": leaseTagKeys},
+			},
+		},
+		"AttachSelectedVolumes2": {
+			Action:   []string{"ec2:AttachVolume", "ec2:DetachVolume"},
+			Resource: []string{arn + "volume/*"},
+			Condition: map[string]map[string][]string{
+				"StringEquals": {"ec2:AvailabilityZone": {"us-east-1a"}, "ec2:ResourceTag/pool": {"kafka"}},
+			},
+		},
+		"TagSelectedVolumes2": {
+			Action:   []string{"ec2:CreateTags", "ec2:DeleteTags"},
+			Resource: []string{arn + "volume/*"},
+			Condition: map[string]map[string][]string{
+				"StringEquals":              {"ec2:AvailabilityZone": {"us-east-1a"}, "ec2:ResourceTag/pool": {"kafka"}},
+				"ForAllValues:StringEquals": {"aws:TagKeys": append(append([]string{}, claimTagKeys...), leaseTagKeys...)},
+			},
+		},
+		"RecordPoolClaims": {
+			Action:    []string{"ec2:CreateTags", "ec2:DeleteTags"},
+			Resource:  []string{arn + "instance/i-0123456789abcdef0"},
+			Condition: map[string]map[string][]string{"ForAllValues:StringEquals": {"aws:TagKeys": {"volume_/dev/sdh"}}},
+		},
+	}
+
+	got := statements(policy)
+
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d statements, but got %d", len(expected), len(got))
+	}
+
+	for sid, statement := range expected {
+
+		statement.Sid, statement.Effect = sid, "Allow"
+
+		if !reflect.DeepEqual(got[sid], statement) {
+			t.Errorf("Expected statement %s to be\n%+v\nbut got\n%+v", sid, statement, got[sid])
+		}
+	}
+}
+
+func TestPolicyJSON(t *testing.T) {
+
+	_, instance := newPolicyFake(t)
+
+	policy, err := instance.Policy()
+
+	if err != nil {
+		t.Fatalf("Writing the policy shouldn't have failed, but I got %v", err)
+	}
+
+	document, err := policy.JSON()
+
+	if err != nil {
+		t.Fatalf("Encoding the policy shouldn't have failed, but I got %v", err)
+	}
+
+	var decoded struct {
+		Version   string
+		Statement []struct {
+			Effect   string
+			Resource []string
+		}
+	}
+
+	if err := json.Unmarshal(document, &decoded); err != nil || decoded.Version != PolicyVersion {
+		t.Fatalf("Expected a %s policy, but got %s, %v", PolicyVersion, document, err)
+	}
+
+	// Only the Describe permissions, which EC2 can't restrict, are granted on every resource
+	for i, statement := range decoded.Statement {
+		if statement.Effect != "Allow" || i > 0 && strings.Join(statement.Resource, ",") == "*" {
+			t.Errorf("Unexpected statement %d in %s", i, document)
+		}
+	}
+}
+
+func TestPolicyWithoutVolumes(t *testing.T) {
+
+	fake := ec2fake.New("cn-north-1")
+	t.Cleanup(fake.Close)
+
+	fake.AddInstance(ec2fake.Instance{ID: "i-0123456789abcdef0", AvailabilityZone: "cn-north-1a", Tags: map[string]string{"Name": "db"}, TagsInMetadata: true})
+
+	policy, err := NewEC2Instance(fake.Metadata(), fake.Client(), WithTagSourceMode(TagSourceMetadata)).Policy()
+
+	if err != nil {
+		t.Fatalf("Writing the policy shouldn't have failed, but I got %v", err)
+	}
+
+	got := statements(policy)
+
+	if len(got) != 2 || !reflect.DeepEqual(got["DescribeVolumes"].Action, []string{"ec2:DescribeInstances", "ec2:DescribeVolumes"}) {
+		t.Errorf("Expected only the describe and instance statements, without DescribeTags, but got %+v", policy)
+	}
+
+	if resource := got["AttachToInstance"].Resource[0]; resource != "arn:aws-cn:ec2:cn-north-1:123456789012:instance/i-0123456789abcdef0" {
+		t.Errorf("Unexpected instance ARN %s", resource)
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+
+	fake, instance := newPolicyFake(t)
+
+	findings, err := instance.CheckPolicy()
+
+	if err != nil || len(findings) != 0 {
+		t.Fatalf("Expected every permission to be granted, but got %v, %v", findings, err)
+	}
+
+	fake.Script(
+		ec2fake.Scenario{Action: "AttachVolume", VolumeID: "vol-11111111", Steps: []ec2fake.Step{{Err: ec2fake.Unauthorized()}}},
+		ec2fake.Scenario{Action: "CreateTags", VolumeID: "vol-33333333", Steps: []ec2fake.Step{{Err: ec2fake.Unauthorized()}}},
+	)
+
+	if findings, err = instance.CheckPolicy(); err != nil {
+		t.Fatalf("Checking the policy shouldn't have failed, but I got %v", err)
+	}
+
+	expected := []Finding{
+		{Severity: SeverityError, VolumeID: "vol-11111111", DeviceName: "/dev/sdf", Message: "permission ec2:AttachVolume has not been granted"},
+		{Severity: SeverityError, VolumeID: "vol-33333333", DeviceName: "/dev/sdh", Message: "permission ec2:CreateTags has not been granted"},
+	}
+
+	if !reflect.DeepEqual(findings, expected) {
+		t.Errorf("Expected findings %v, but got %v", expected, findings)
+	}
+}
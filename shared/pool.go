@@ -0,0 +1,524 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+)
+
+// Tags set on a pool volume claimed by an instance
+const (
+	// ClaimOwnerTag gives the ID of the instance claiming the volume
+	ClaimOwnerTag = TagNamespace + "claim-owner"
+	// ClaimedAtTag gives when the volume was claimed
+	ClaimedAtTag = TagNamespace + "claimed-at"
+	// ClaimTokenTag gives a token unique to the attempt to claim the volume, telling it apart from later claims
+	ClaimTokenTag = TagNamespace + "claim-token"
+)
+
+// ErrPoolExhausted is returned when no volume in a pool can be claimed
+var ErrPoolExhausted = errors.New("no unclaimed volume is available in the pool")
+
+// ErrClaimLost is returned when a volume claimed from a pool is attached to another instance first
+var ErrClaimLost = errors.New("claim on volume was lost to another instance")
+
+var (
+	// claimSettleDelay is how long to wait after claiming a volume before checking no other instance claimed it too
+	claimSettleDelay = 2 * time.Second
+	// claimExpiry is how long a claim on a volume that isn't attached is honoured, so volumes claimed by
+	// instances that have since gone are returned to the pool
+	claimExpiry = 10 * time.Minute
+	claimClock  = time.Now
+)
+
+// volumeClaim is a claim on a pool volume, as recorded by the tags on it
+type volumeClaim struct {
+	volumeID string
+	owner    string
+	token    string
+	claimed  string
+}
+
+// poolClaim is the claim on the volume allocated to a device from a pool, kept so another volume can be
+// claimed in its place if the claim is lost
+type poolClaim struct {
+	volumeClaim
+	pool poolTag
+	// tag is the instance tag recording the volume once it's attached
+	tag string
+}
+
+// poolTag is a parsed tag giving the pool a volume is claimed from for a device
+type poolTag struct {
+	key      string
+	device   string
+	selector *VolumeSelector
+	schema   TagSchema
+}
+
+// parsePoolTags returns the pools volumes are claimed from, along with problems found with tags that were ignored
+func parsePoolTags(tags []*ec2.TagDescription, schemas []TagSchema, root string) ([]poolTag, []error) {
+
+	var pools []poolTag
+	var problems []error
+
+	designated := make(map[string]bool)
+
+	for _, tag := range tags {
+		key, value := aws.StringValue(tag.Key), aws.StringValue(tag.Value)
+
+		for _, schema := range schemas {
+
+			if schema.PoolPrefix == "" || !strings.HasPrefix(key, schema.PoolPrefix) {
+				continue
+			}
+
+			device, err := NormalizeDeviceName(key[len(schema.PoolPrefix):])
+
+			if err == nil && collidesWithRoot(device, root) {
+				err = ErrRootDeviceCollision
+			}
+
+			var selector *VolumeSelector
+
+			if err == nil {
+				selector, err = ParseVolumeSelector(value)
+			}
+
+			if err == nil && designated[deviceKey(device)] {
+				err = ErrDuplicateDevice
+			}
+
+			if err != nil {
+				problems = append(problems, &TagError{Key: key, Value: value, Err: err})
+				break
+			}
+
+			designated[deviceKey(device)] = true
+			pools = append(pools, poolTag{key: key, device: device, selector: selector, schema: schema})
+
+			break
+		}
+	}
+
+	return pools, problems
+}
+
+// ClaimPoolVolumes claims a volume from the pool given for each device not already allocated a volume.
+// Once a claimed volume is attached the claim is recorded with a tag on the instance allocating the volume
+// to the device, so later runs use the same volume. When a recorded claim has been lost to another instance
+// the tag is removed, and another volume is claimed in its place.
+func (e EC2Instance) ClaimPoolVolumes() ([]*AllocatedVolume, error) {
+
+	tags, err := e.tags()
+
+	if err != nil {
+		return nil, err
+	}
+
+	allocated, _, err := e.allocatedVolumes(tags)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e.claimPoolVolumes(tags, allocated)
+}
+
+// claimPoolVolumes claims volumes from the pools given by the instance's tags, for devices not among those
+// allocated volumes
+func (e EC2Instance) claimPoolVolumes(tags []*ec2.TagDescription, allocated []*AllocatedVolume) ([]*AllocatedVolume, error) {
+
+	root, _ := e.metadata.RootDeviceName()
+
+	pools, problems := parsePoolTags(tags, e.schemas, root)
+
+	for _, problem := range problems {
+		e.logger.Errorf("Ignoring %v", problem)
+	}
+
+	if len(pools) == 0 {
+		return nil, nil
+	}
+
+	designated := make(map[string]string)
+
+	for _, volume := range allocated {
+		designated[deviceKey(volume.DeviceName)] = volume.VolumeID
+	}
+
+	recorded := make(map[string]string)
+
+	for _, tag := range tags {
+		recorded[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	instanceID, err := e.metadata.InstanceID()
+
+	if err != nil {
+		return nil, err
+	}
+
+	availabilityZone, err := e.metadata.AvailabilityZone()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to get availability zone : %w", err)
+	}
+
+	var claimed []*AllocatedVolume
+	failed := 0
+
+	for _, pool := range pools {
+
+		key := pool.schema.VolumeTag(pool.device)
+
+		if volumeID, ok := designated[deviceKey(pool.device)]; ok {
+
+			if recorded[key] != volumeID {
+				e.logger.Debugf("Device (%s) is already allocated a volume - not claiming from pool", pool.device)
+				continue
+			}
+
+			lost, err := claimLost(e.svc, volumeID, instanceID)
+
+			if err != nil {
+				e.logger.Errorf("Unable to check the claim for (%s) recorded by tag '%s' : %v", pool.device, key, err)
+				failed++
+				continue
+			}
+
+			if !lost {
+				e.logger.Debugf("Volume (%s) is still claimed for (%s) - not claiming from pool", volumeID, pool.device)
+				continue
+			}
+
+			e.logger.Infof("Claim on volume (%s) for (%s) was lost to another instance - removing tag '%s'", volumeID, pool.device, key)
+
+			if err := removeClaim(e.svc, instanceID, key, volumeID); err != nil {
+				e.logger.Errorf("%v", err)
+				failed++
+				continue
+			}
+		}
+
+		claim, err := claimPoolVolume(e.logger, e.svc, pool.selector, instanceID, availabilityZone, nil)
+
+		if err != nil {
+			e.logger.Errorf("Unable to claim a volume for (%s) from pool given by tag '%s' : %v", pool.device, pool.key, err)
+			failed++
+			continue
+		}
+
+		e.logger.Infof("Claimed volume (%s) for (%s) from pool given by tag '%s'", claim.volumeID, pool.device, pool.key)
+
+		volume := NewAllocatedVolume(claim.volumeID, pool.device, instanceID, e.svc)
+		volume.AvailabilityZone = availabilityZone
+		volume.Source = "pool tag " + pool.key
+		volume.modifyTimeout = e.modifyTimeout
+		volume.leaseDuration = e.leaseDuration
+		volume.authorization = e.authorization.forInstance(instanceID, tags)
+		volume.encryption = e.encryption
+		volume.claim = &poolClaim{volumeClaim: claim, pool: pool, tag: key}
+		volume.useLogger(e.logger)
+
+		claimed = append(claimed, volume)
+	}
+
+	if failed > 0 {
+		return claimed, fmt.Errorf("unable to claim volumes from pools for %d device(s)", failed)
+	}
+
+	return claimed, nil
+}
+
+// attachClaimed attaches a volume claimed from a pool, recording the claim on the instance once it's attached.
+// When another instance attaches the volume first the claim has been lost, so it's released and another volume
+// claimed from the pool in its place.
+func (volume *AllocatedVolume) attachClaimed() error {
+
+	lost := make(map[string]bool)
+
+	for {
+		err := volume.Attach()
+
+		if err == nil {
+			return volume.recordClaim()
+		}
+
+		if !errors.Is(err, ErrClaimLost) {
+			return err
+		}
+
+		volume.logger.Infof("Volume (%s) was attached by another instance - claiming another from pool given by tag '%s'",
+			volume.VolumeID, volume.claim.pool.key)
+
+		if err := volume.releaseClaim(); err != nil {
+			return err
+		}
+
+		lost[volume.VolumeID] = true
+
+		claim, err := claimPoolVolume(volume.logger, volume.svc, volume.claim.pool.selector, volume.InstanceID, volume.AvailabilityZone, lost)
+
+		if err != nil {
+			return fmt.Errorf("unable to claim a volume for (%s) from pool given by tag '%s' : %w", volume.DeviceName, volume.claim.pool.key, err)
+		}
+
+		volume.VolumeID = claim.volumeID
+		volume.claim.volumeClaim = claim
+		volume.logger = volume.logger.With(log.FieldVolumeID, claim.volumeID)
+
+		volume.logger.Infof("Claimed volume (%s) for (%s) from pool given by tag '%s'", claim.volumeID, volume.DeviceName, volume.claim.pool.key)
+	}
+}
+
+// recordClaim tags the instance with the volume claimed from a pool, so later runs use it again. It's only
+// recorded once the volume is attached, as until then the claim can still be lost.
+func (volume AllocatedVolume) recordClaim() error {
+
+	_, err := volume.svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: aws.StringSlice([]string{volume.InstanceID}),
+		Tags:      []*ec2.Tag{{Key: aws.String(volume.claim.tag), Value: aws.String(volume.VolumeID)}},
+	})
+
+	if err != nil {
+		return fmt.Errorf("unable to record claim of volume (%s) with tag '%s' on instance (%s) : %w",
+			volume.VolumeID, volume.claim.tag, volume.InstanceID, err)
+	}
+
+	volume.logger.Debugf("Recorded claim of volume (%s) with tag '%s'", volume.VolumeID, volume.claim.tag)
+
+	return nil
+}
+
+// releaseClaim removes the instance's claim from the volume. Tags are only removed while they still have the
+// values the instance wrote, so a claim made since by another instance is left alone.
+func (volume AllocatedVolume) releaseClaim() error {
+
+	_, err := volume.svc.DeleteTags(&ec2.DeleteTagsInput{
+		Resources: aws.StringSlice([]string{volume.VolumeID}),
+		Tags: []*ec2.Tag{
+			{Key: aws.String(ClaimOwnerTag), Value: aws.String(volume.claim.owner)},
+			{Key: aws.String(ClaimedAtTag), Value: aws.String(volume.claim.claimed)},
+			{Key: aws.String(ClaimTokenTag), Value: aws.String(volume.claim.token)},
+		},
+	})
+
+	if err != nil {
+		return fmt.Errorf("unable to release claim on volume (%s) : %w", volume.VolumeID, err)
+	}
+
+	return nil
+}
+
+// claimLostError returns an error wrapping ErrClaimLost when attaching a volume claimed from a pool failed as
+// it was attached by another instance first, or otherwise the error given
+func (volume AllocatedVolume) claimLostError(err error) error {
+
+	var aerr awserr.Error
+
+	if volume.claim == nil || !errors.As(err, &aerr) || (aerr.Code() != "VolumeInUse" && aerr.Code() != "IncorrectState") {
+		return err
+	}
+
+	return fmt.Errorf("%w, as volume (%s) was attached by another instance : %v", ErrClaimLost, volume.VolumeID, err)
+}
+
+// removeClaim removes the tag recording the claim of a volume from the instance, unless it's since been
+// changed to another volume
+func removeClaim(svc ec2iface.EC2API, instanceID string, key string, volumeID string) error {
+
+	_, err := svc.DeleteTags(&ec2.DeleteTagsInput{
+		Resources: aws.StringSlice([]string{instanceID}),
+		Tags:      []*ec2.Tag{{Key: aws.String(key), Value: aws.String(volumeID)}},
+	})
+
+	if err != nil {
+		return fmt.Errorf("unable to remove claim of volume (%s) with tag '%s' from instance (%s) : %w", volumeID, key, instanceID, err)
+	}
+
+	return nil
+}
+
+// claimLost returns true when a volume recorded as claimed by the instance isn't attached to it, and has
+// since been attached to or claimed by another instance
+func claimLost(svc ec2iface.EC2API, volumeID string, instanceID string) (bool, error) {
+
+	volume, err := describePoolVolume(svc, volumeID)
+
+	if err != nil || volume == nil || attachedTo(volume, instanceID) {
+		return false, err
+	}
+
+	holder := claimHolder(volume)
+
+	return len(volume.Attachments) > 0 || (holder != "" && holder != instanceID), nil
+}
+
+// claimPoolVolume claims a volume matching the selector, other than those given as lost. A volume already
+// claimed by the instance is used again. Otherwise an available volume without a claim is tagged with a claim
+// unique to the attempt, and once other instances have had time to do the same the claim is read back. The
+// last instance to write the claim wins, and instances losing the volume try the next one.
+func claimPoolVolume(logger *log.Logger, svc ec2iface.EC2API, selector *VolumeSelector, instanceID string, availabilityZone string, lost map[string]bool) (volumeClaim, error) {
+
+	volumes, err := selector.matchingVolumes(svc, availabilityZone)
+
+	if err != nil {
+		return volumeClaim{}, err
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return aws.StringValue(volumes[i].VolumeId) < aws.StringValue(volumes[j].VolumeId)
+	})
+
+	for _, volume := range volumes {
+
+		if lost[aws.StringValue(volume.VolumeId)] {
+			continue
+		}
+
+		if attachedTo(volume, instanceID) || (available(volume) && claimHolder(volume) == instanceID) {
+			logger.Debugf("Volume (%s) was already claimed by instance (%s)", aws.StringValue(volume.VolumeId), instanceID)
+			return claimOf(volume), nil
+		}
+	}
+
+	for _, volume := range volumes {
+
+		if lost[aws.StringValue(volume.VolumeId)] || !available(volume) || claimHolder(volume) != "" {
+			continue
+		}
+
+		claim, won, err := claimVolume(logger, svc, aws.StringValue(volume.VolumeId), instanceID)
+
+		if err != nil {
+			return volumeClaim{}, err
+		}
+
+		if won {
+			return claim, nil
+		}
+	}
+
+	return volumeClaim{}, ErrPoolExhausted
+}
+
+// claimVolume tags a volume with a claim, returning the claim and true if it won. The volume is described
+// again first, as it may have been claimed since the pool was listed.
+func claimVolume(logger *log.Logger, svc ec2iface.EC2API, volumeID string, instanceID string) (volumeClaim, bool, error) {
+
+	current, err := describePoolVolume(svc, volumeID)
+
+	if err != nil {
+		return volumeClaim{}, false, err
+	}
+
+	if current == nil || !available(current) || claimHolder(current) != "" {
+		logger.Debugf("Volume (%s) was claimed by another instance", volumeID)
+		return volumeClaim{}, false, nil
+	}
+
+	claim := volumeClaim{volumeID: volumeID, owner: instanceID, token: NewRunID(), claimed: claimClock().UTC().Format(time.RFC3339)}
+
+	_, err = svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: aws.StringSlice([]string{volumeID}),
+		Tags: []*ec2.Tag{
+			{Key: aws.String(ClaimOwnerTag), Value: aws.String(claim.owner)},
+			{Key: aws.String(ClaimedAtTag), Value: aws.String(claim.claimed)},
+			{Key: aws.String(ClaimTokenTag), Value: aws.String(claim.token)},
+		},
+	})
+
+	if err != nil {
+		return volumeClaim{}, false, fmt.Errorf("unable to claim volume (%s) : %w", volumeID, err)
+	}
+
+	// Another instance may have claimed the volume at the same time, in which case the last to write the claim wins
+	time.Sleep(claimSettleDelay)
+
+	current, err = describePoolVolume(svc, volumeID)
+
+	if err != nil {
+		return volumeClaim{}, false, err
+	}
+
+	if current != nil && claimOf(current) == claim {
+		return claim, true, nil
+	}
+
+	logger.Debugf("Volume (%s) was claimed by another instance", volumeID)
+
+	return volumeClaim{}, false, nil
+}
+
+// describePoolVolume describes a volume in a pool, returning nil if there's no such volume
+func describePoolVolume(svc ec2iface.EC2API, volumeID string) (*ec2.Volume, error) {
+
+	resp, err := svc.DescribeVolumes(&ec2.DescribeVolumesInput{VolumeIds: aws.StringSlice([]string{volumeID})})
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to check claim on volume (%s) : %w", volumeID, err)
+	}
+
+	if len(resp.Volumes) != 1 {
+		return nil, nil
+	}
+
+	return resp.Volumes[0], nil
+}
+
+// claimOf returns the claim recorded by the tags on a volume
+func claimOf(volume *ec2.Volume) volumeClaim {
+
+	claim := volumeClaim{volumeID: aws.StringValue(volume.VolumeId)}
+
+	for _, tag := range volume.Tags {
+		switch aws.StringValue(tag.Key) {
+		case ClaimOwnerTag:
+			claim.owner = aws.StringValue(tag.Value)
+		case ClaimedAtTag:
+			claim.claimed = aws.StringValue(tag.Value)
+		case ClaimTokenTag:
+			claim.token = aws.StringValue(tag.Value)
+		}
+	}
+
+	return claim
+}
+
+// claimHolder returns the instance holding the claim on a volume, or an empty string if there's no claim
+// or it has lapsed. Claims on volumes in use don't lapse.
+func claimHolder(volume *ec2.Volume) string {
+
+	claim := claimOf(volume)
+	claimed, _ := time.Parse(time.RFC3339, claim.claimed)
+
+	if aws.StringValue(volume.State) != ec2.VolumeStateInUse && claimClock().Sub(claimed) > claimExpiry {
+		return ""
+	}
+
+	return claim.owner
+}
+
+func available(volume *ec2.Volume) bool {
+	return aws.StringValue(volume.State) == ec2.VolumeStateAvailable
+}
+
+func attachedTo(volume *ec2.Volume, instanceID string) bool {
+
+	for _, attachment := range volume.Attachments {
+		if aws.StringValue(attachment.InstanceId) == instanceID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,392 @@
+package shared
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers/ec2fake"
+)
+
+// poolService stands in for EC2, keeping the tags set on pool volumes. When a competitor is given for a
+// volume it claims the volume straight after the instance does. The pool is listed as given by listed,
+// when it's set, as though the list was stale.
+type poolService struct {
+	*testhelpers.MockEC2Service
+	volumes   []*ec2.Volume
+	listed    []*ec2.Volume
+	competing map[string]string
+	claimed   []string
+	recorded  []*ec2.CreateTagsInput
+}
+
+func newPoolService(volumes ...*ec2.Volume) *poolService {
+
+	s := &poolService{MockEC2Service: testhelpers.NewMockEC2Service(), volumes: volumes, competing: make(map[string]string)}
+
+	s.DescribeVolumesFunc = func(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+
+		if len(input.VolumeIds) == 0 && s.listed != nil {
+			return &ec2.DescribeVolumesOutput{Volumes: s.listed}, nil
+		}
+
+		if len(input.VolumeIds) == 0 {
+			return &ec2.DescribeVolumesOutput{Volumes: s.volumes}, nil
+		}
+
+		return &ec2.DescribeVolumesOutput{Volumes: []*ec2.Volume{s.volume(*input.VolumeIds[0])}}, nil
+	}
+
+	s.CreateTagsFunc = func(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+
+		volume := s.volume(*input.Resources[0])
+
+		if volume == nil {
+			s.recorded = append(s.recorded, input)
+			return &ec2.CreateTagsOutput{}, nil
+		}
+
+		s.claimed = append(s.claimed, *volume.VolumeId)
+		setTags(volume, input.Tags...)
+
+		if competitor, ok := s.competing[*volume.VolumeId]; ok {
+			setTags(volume, claimTags(competitor, claimClock())...)
+		}
+
+		return &ec2.CreateTagsOutput{}, nil
+	}
+
+	return s
+}
+
+func (s *poolService) volume(volumeID string) *ec2.Volume {
+
+	for _, volume := range s.volumes {
+		if *volume.VolumeId == volumeID {
+			return volume
+		}
+	}
+
+	return nil
+}
+
+// setTags sets tags on a volume, replacing those with the same keys
+func setTags(volume *ec2.Volume, tags ...*ec2.Tag) {
+
+	for _, tag := range tags {
+
+		replaced := false
+
+		for _, existing := range volume.Tags {
+			if *existing.Key == *tag.Key {
+				existing.Value, replaced = tag.Value, true
+			}
+		}
+
+		if !replaced {
+			volume.Tags = append(volume.Tags, &ec2.Tag{Key: tag.Key, Value: tag.Value})
+		}
+	}
+}
+
+func poolVolume(volumeID string, state string, tags ...*ec2.Tag) *ec2.Volume {
+	return &ec2.Volume{VolumeId: aws.String(volumeID), State: aws.String(state), Tags: tags}
+}
+
+func claimTags(instanceID string, claimed time.Time) []*ec2.Tag {
+	return []*ec2.Tag{
+		{Key: aws.String(ClaimOwnerTag), Value: aws.String(instanceID)},
+		{Key: aws.String(ClaimedAtTag), Value: aws.String(claimed.UTC().Format(time.RFC3339))},
+		{Key: aws.String(ClaimTokenTag), Value: aws.String("token-of-" + instanceID)},
+	}
+}
+
+func withClaimTiming(now time.Time) func() {
+
+	savedDelay, savedClock := claimSettleDelay, claimClock
+
+	claimSettleDelay = 0
+	claimClock = func() time.Time { return now }
+
+	return func() {
+		claimSettleDelay, claimClock = savedDelay, savedClock
+	}
+}
+
+func TestParsePoolTags(t *testing.T) {
+
+	tags := testhelpers.NewDescribeTagsOutputBuilder().
+		WithTag("id-98765", "pool_sdh", "tag:pool=kafka-data").
+		WithTag("id-98765", "ebs-volumes/pool:/dev/xvdh", "tag:pool=other").
+		WithTag("id-98765", "pool_sdi", "kafka-data").
+		WithTag("id-98765", "pool_sda", "tag:pool=kafka-data").Build().Tags
+
+	pools, problems := parsePoolTags(tags, DefaultTagSchemas, "/dev/sda1")
+
+	if len(pools) != 1 || pools[0].device != "/dev/sdh" || pools[0].schema != LegacyTagSchema {
+		t.Errorf("Expected a pool for /dev/sdh, but got %v", pools)
+	}
+
+	for _, expected := range []error{ErrDuplicateDevice, ErrInvalidSelector, ErrRootDeviceCollision} {
+		if !containsError(problems, expected) {
+			t.Errorf("Expected a problem of %v, but got %v", expected, problems)
+		}
+	}
+}
+
+func TestClaimHolder(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer withClaimTiming(now)()
+
+	stale := now.Add(-time.Hour)
+
+	var tests = []struct {
+		volume   *ec2.Volume
+		expected string
+	}{
+		{poolVolume("vol-11111111", ec2.VolumeStateAvailable), ""},
+		{poolVolume("vol-11111111", ec2.VolumeStateAvailable, claimTags("i-00000001", now.Add(-time.Minute))...), "i-00000001"},
+		{poolVolume("vol-11111111", ec2.VolumeStateAvailable, claimTags("i-00000001", stale)...), ""},
+		{poolVolume("vol-11111111", ec2.VolumeStateInUse, claimTags("i-00000001", stale)...), "i-00000001"},
+		{poolVolume("vol-11111111", ec2.VolumeStateAvailable, claimTags("i-00000001", now)[:1]...), ""},
+	}
+
+	for i, tt := range tests {
+		if holder := claimHolder(tt.volume); holder != tt.expected {
+			t.Errorf("Test %d should have been held by '%s', but got '%s'", i, tt.expected, holder)
+		}
+	}
+}
+
+func TestClaimPoolVolumeAfterLosingRace(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer withClaimTiming(now)()
+
+	svc := newPoolService(
+		poolVolume("vol-11111111", ec2.VolumeStateInUse),
+		poolVolume("vol-22222222", ec2.VolumeStateAvailable),
+		poolVolume("vol-33333333", ec2.VolumeStateAvailable),
+	)
+	svc.competing["vol-22222222"] = "i-competing"
+
+	selector, _ := ParseVolumeSelector("tag:pool=kafka-data")
+
+	claim, err := claimPoolVolume(nil, svc, selector, "id-98765", "erewhona", nil)
+
+	if err != nil || claim.volumeID != "vol-33333333" || claim.owner != "id-98765" || claim.token == "" {
+		t.Fatalf("Expected to claim vol-33333333, but got %v, %v", claim, err)
+	}
+
+	if holder := claimHolder(svc.volume("vol-22222222")); holder != "i-competing" {
+		t.Errorf("Expected vol-22222222 to be left claimed by the instance writing the claim last, but got '%s'", holder)
+	}
+
+	// Claiming again finds the volume already claimed
+	again, err := claimPoolVolume(nil, svc, selector, "id-98765", "erewhona", nil)
+
+	if err != nil || again != claim {
+		t.Errorf("Expected the claim on vol-33333333 to be found, but got %v, %v", again, err)
+	}
+
+	if _, err := claimPoolVolume(nil, svc, selector, "id-12345", "erewhona", nil); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Expected the pool to be exhausted for another instance, but got %v", err)
+	}
+}
+
+func TestClaimLostToLaterClaimBySameInstance(t *testing.T) {
+
+	defer withClaimTiming(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))()
+
+	svc := newPoolService(poolVolume("vol-22222222", ec2.VolumeStateAvailable))
+
+	// Another run on the same instance claims the volume straight after, with its own token
+	svc.competing["vol-22222222"] = "id-98765"
+
+	selector, _ := ParseVolumeSelector("tag:pool=kafka-data")
+
+	if claim, err := claimPoolVolume(nil, svc, selector, "id-98765", "erewhona", nil); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Expected the claim to be lost to the later one, but got %v, %v", claim, err)
+	}
+}
+
+func TestClaimPoolVolumeChecksVolumeBeforeClaiming(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer withClaimTiming(now)()
+
+	svc := newPoolService(
+		poolVolume("vol-22222222", ec2.VolumeStateAvailable, claimTags("i-competing", now)...),
+		poolVolume("vol-33333333", ec2.VolumeStateAvailable),
+	)
+
+	// The pool was listed before vol-22222222 was claimed
+	svc.listed = []*ec2.Volume{
+		poolVolume("vol-22222222", ec2.VolumeStateAvailable),
+		poolVolume("vol-33333333", ec2.VolumeStateAvailable),
+	}
+
+	selector, _ := ParseVolumeSelector("tag:pool=kafka-data")
+
+	claim, err := claimPoolVolume(nil, svc, selector, "id-98765", "erewhona", nil)
+
+	if err != nil || claim.volumeID != "vol-33333333" {
+		t.Fatalf("Expected to claim vol-33333333, but got %v, %v", claim, err)
+	}
+
+	if len(svc.claimed) != 1 || svc.claimed[0] != "vol-33333333" {
+		t.Errorf("Expected only vol-33333333 to be tagged with a claim, but got %v", svc.claimed)
+	}
+}
+
+func TestClaimPoolVolumes(t *testing.T) {
+
+	defer withClaimTiming(time.Now())()
+
+	instanceID := "id-98765"
+
+	svc := newPoolService(poolVolume("vol-22222222", ec2.VolumeStateAvailable))
+	svc.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
+		testhelpers.NewDescribeTagsOutputBuilder().
+			WithTag(instanceID, "ebs-volumes/pool:sdh", "tag:pool=kafka-data").
+			WithTag(instanceID, "pool_sdg", "tag:pool=kafka-data").
+			WithVolume("sdg", instanceID, "vol-11111111").Build())
+
+	claimed, err := NewEC2Instance(testhelpers.NewMockMetadata(instanceID, "erewhon"), svc).ClaimPoolVolumes()
+
+	if err != nil {
+		t.Fatalf("Claiming shouldn't have failed, but I got %v", err)
+	}
+
+	if len(claimed) != 1 || claimed[0].VolumeID != "vol-22222222" || claimed[0].DeviceName != "/dev/sdh" {
+		t.Fatalf("Expected vol-22222222 to be claimed for /dev/sdh only, but got %v", claimed)
+	}
+
+	if len(svc.recorded) != 0 {
+		t.Errorf("The claim shouldn't be recorded on the instance before the volume is attached, but got %v", svc.recorded)
+	}
+
+	if claimed[0].claim == nil || claimed[0].claim.tag != "ebs-volumes/volume:/dev/sdh" {
+		t.Fatalf("Expected the claim to be kept with the volume, but got %v", claimed[0].claim)
+	}
+
+	if err := claimed[0].recordClaim(); err != nil {
+		t.Fatalf("Recording the claim shouldn't have failed, but I got %v", err)
+	}
+
+	if len(svc.recorded) != 1 || *svc.recorded[0].Resources[0] != instanceID ||
+		*svc.recorded[0].Tags[0].Key != "ebs-volumes/volume:/dev/sdh" || *svc.recorded[0].Tags[0].Value != "vol-22222222" {
+		t.Errorf("Expected the claim to be recorded on the instance, but got %v", svc.recorded)
+	}
+}
+
+// newPoolFake returns a fake EC2 with an instance claiming a volume for /dev/sdh from a pool of two
+func newPoolFake(t *testing.T, tags map[string]string) (*ec2fake.Server, *EC2Instance) {
+
+	fake := ec2fake.New("erewhon")
+	t.Cleanup(fake.Close)
+
+	tags["pool_/dev/sdh"] = "tag:pool=kafka-data"
+
+	fake.AddInstance(ec2fake.Instance{ID: "i-0123456789abcdef0", AvailabilityZone: "erewhona", Tags: tags})
+	fake.AddInstance(ec2fake.Instance{ID: "i-0fedcba9876543210", AvailabilityZone: "erewhona"})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-11111111", AvailabilityZone: "erewhona", Tags: map[string]string{"pool": "kafka-data"}})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-22222222", AvailabilityZone: "erewhona", Tags: map[string]string{"pool": "kafka-data"}})
+
+	return fake, NewEC2Instance(fake.Metadata(), fake.Client(), WithTagSourceMode(TagSourceAPI))
+}
+
+func TestClaimRecordedOnceAttached(t *testing.T) {
+
+	defer withClaimTiming(time.Now())()
+
+	fake, instance := newPoolFake(t, map[string]string{})
+	fake.Fail("AttachVolume", ec2fake.Unauthorized())
+
+	if err := instance.AttachVolumes(); err == nil {
+		t.Fatal("Attaching should have failed")
+	}
+
+	if recorded, ok := fake.Tags("i-0123456789abcdef0")["volume_/dev/sdh"]; ok {
+		t.Fatalf("The claim shouldn't be recorded when the volume isn't attached, but got %s", recorded)
+	}
+
+	if err := instance.AttachVolumes(); err != nil {
+		t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+	}
+
+	if volume := fake.Volume("vol-11111111"); *volume.State != ec2.VolumeStateInUse {
+		t.Errorf("Expected the volume claimed before to be attached, but got %v", volume)
+	}
+
+	if recorded := fake.Tags("i-0123456789abcdef0")["volume_/dev/sdh"]; recorded != "vol-11111111" {
+		t.Errorf("Expected the claim to be recorded once attached, but got '%s'", recorded)
+	}
+}
+
+func TestLostClaimIsRemoved(t *testing.T) {
+
+	defer withClaimTiming(time.Now())()
+
+	fake, instance := newPoolFake(t, map[string]string{"volume_/dev/sdh": "vol-11111111"})
+	fake.AttachVolume("vol-11111111", "i-0fedcba9876543210", "/dev/sdh")
+
+	if err := instance.AttachVolumes(); err != nil {
+		t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+	}
+
+	if volume := fake.Volume("vol-22222222"); *volume.State != ec2.VolumeStateInUse {
+		t.Errorf("Expected another volume to be claimed and attached, but got %v", volume)
+	}
+
+	if recorded := fake.Tags("i-0123456789abcdef0")["volume_/dev/sdh"]; recorded != "vol-22222222" {
+		t.Errorf("Expected the lost claim to be replaced, but got '%s'", recorded)
+	}
+}
+
+func TestAttachReadsInstanceTagsOnceWhenClaiming(t *testing.T) {
+
+	defer withClaimTiming(time.Now())()
+
+	fake, instance := newPoolFake(t, map[string]string{})
+
+	if err := instance.AttachVolumes(); err != nil {
+		t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+	}
+
+	if calls := fake.Calls("DescribeTags"); calls != 1 {
+		t.Errorf("Expected the instance tags to be read once, but DescribeTags was called %d times", calls)
+	}
+}
+
+func TestClaimLostWhenAttachingClaimsAnother(t *testing.T) {
+
+	defer withClaimTiming(time.Now())()
+
+	fake, instance := newPoolFake(t, map[string]string{})
+
+	// Another instance attaches vol-11111111 first
+	fake.Script(ec2fake.Scenario{Action: "AttachVolume", VolumeID: "vol-11111111", Steps: []ec2fake.Step{
+		{Err: &ec2fake.Error{StatusCode: 400, Code: "VolumeInUse", Message: "vol-11111111 is already attached to an instance"}},
+	}})
+
+	if err := instance.AttachVolumes(); err != nil {
+		t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+	}
+
+	if volume := fake.Volume("vol-22222222"); *volume.State != ec2.VolumeStateInUse {
+		t.Errorf("Expected another volume to be claimed and attached, but got %v", volume)
+	}
+
+	if tags := fake.Tags("vol-11111111"); tags[ClaimOwnerTag] != "" || tags[ClaimTokenTag] != "" {
+		t.Errorf("Expected the lost claim to be released, but got %v", tags)
+	}
+
+	if recorded := fake.Tags("i-0123456789abcdef0")["volume_/dev/sdh"]; recorded != "vol-22222222" {
+		t.Errorf("Expected the claim of the attached volume to be recorded, but got '%s'", recorded)
+	}
+}
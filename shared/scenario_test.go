@@ -0,0 +1,139 @@
+package shared
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers/ec2fake"
+)
+
+// newScenarioFake returns a fake EC2 with an instance allocated a volume, and the instance using it
+func newScenarioFake(t *testing.T, configs ...*aws.Config) (*ec2fake.Server, *EC2Instance) {
+
+	fake := ec2fake.New("erewhon")
+	t.Cleanup(fake.Close)
+
+	fake.AddInstance(ec2fake.Instance{
+		ID:               "i-0123456789abcdef0",
+		AvailabilityZone: "erewhona",
+		Tags:             map[string]string{"volume_/dev/sdf": "vol-11111111", "detach_volumes": "true"},
+	})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-11111111", AvailabilityZone: "erewhona"})
+
+	return fake, NewEC2Instance(fake.Metadata(), fake.Client(configs...), WithTagSourceMode(TagSourceAPI))
+}
+
+// attachOnlyVolume attaches the instance's only volume, returning why it couldn't be
+func attachOnlyVolume(t *testing.T, instance *EC2Instance) error {
+
+	volumes, err := instance.AllocatedVolumes()
+
+	if err != nil {
+		t.Fatalf("Shouldn't have failed to get volumes, but I got %v", err)
+	}
+
+	return volumes[0].Attach()
+}
+
+func TestAttachWaitsOutStaleReads(t *testing.T) {
+
+	fake, instance := newScenarioFake(t)
+
+	fake.Script(ec2fake.StaleReads("vol-11111111", 3))
+
+	if err := instance.AttachVolumes(); err != nil {
+		t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+	}
+
+	if volume := fake.Volume("vol-11111111"); *volume.State != ec2.VolumeStateInUse {
+		t.Errorf("Expected the volume to be attached, but got %v", volume)
+	}
+
+	// Three checks before attaching, then the wait for the attachment sees three stale reads before a fresh one
+	if calls := fake.Calls("DescribeVolumes"); calls != 7 {
+		t.Errorf("Expected the stale reads to be waited out, but DescribeVolumes was called %d times", calls)
+	}
+}
+
+func TestAttachRetriesThrottledRequests(t *testing.T) {
+
+	fake, instance := newScenarioFake(t)
+	fake.Script(ec2fake.Throttling("DescribeVolumes", 1))
+
+	if err := attachOnlyVolume(t, instance); err == nil || !strings.Contains(err.Error(), "RequestLimitExceeded") {
+		t.Errorf("Expected attaching to be throttled without retries, but got %v", err)
+	}
+
+	fake, instance = newScenarioFake(t, aws.NewConfig().WithMaxRetries(1))
+	fake.Script(ec2fake.Throttling("DescribeVolumes", 1))
+
+	if err := instance.AttachVolumes(); err != nil {
+		t.Errorf("Attaching should have been retried, but I got %v", err)
+	}
+}
+
+func TestAttachReportsIncorrectState(t *testing.T) {
+
+	fake, instance := newScenarioFake(t)
+
+	fake.Script(ec2fake.Scenario{
+		Action:   "AttachVolume",
+		VolumeID: "vol-11111111",
+		Steps:    []ec2fake.Step{{Err: ec2fake.IncorrectState("vol-11111111", "creating")}},
+	})
+
+	if err := attachOnlyVolume(t, instance); err == nil || !strings.Contains(err.Error(), "IncorrectState") {
+		t.Errorf("Expected the incorrect state to be reported, but got %v", err)
+	}
+}
+
+func TestDetachWaitsForVolumeStuckDetaching(t *testing.T) {
+
+	var tests = []struct {
+		describes int
+		fails     bool
+	}{
+		{5, false},
+		{100, true},
+	}
+
+	for i, tt := range tests {
+
+		fake, instance := newScenarioFake(t)
+
+		fake.AttachVolume("vol-11111111", "i-0123456789abcdef0", "/dev/sdf")
+		fake.Script(ec2fake.Stuck("vol-11111111", tt.describes))
+
+		volumes, err := instance.AllocatedVolumes()
+
+		if err != nil {
+			t.Fatalf("Test %d shouldn't have failed to get volumes, but I got %v", i, err)
+		}
+
+		err = volumes[0].Detach()
+
+		if tt.fails != (err != nil && strings.Contains(err.Error(), "exceeded 40 wait attempts")) {
+			t.Errorf("Test %d expected waiting to give up to be %t, but got %v", i, tt.fails, err)
+		}
+	}
+}
+
+func TestDetachReadsInstanceTagsOnce(t *testing.T) {
+
+	fake, instance := newScenarioFake(t)
+	fake.AttachVolume("vol-11111111", "i-0123456789abcdef0", "/dev/sdf")
+
+	if err := instance.DetachVolumes(); err != nil {
+		t.Fatalf("Detaching shouldn't have failed, but I got %v", err)
+	}
+
+	if volume := fake.Volume("vol-11111111"); *volume.State != ec2.VolumeStateAvailable {
+		t.Errorf("Expected the volume to be detached, but got %v", volume)
+	}
+
+	if calls := fake.Calls("DescribeTags"); calls != 1 {
+		t.Errorf("Expected the instance tags to be read once, but DescribeTags was called %d times", calls)
+	}
+}
@@ -0,0 +1,120 @@
+package shared
+
+import (
+	"errors"
+	"strings"
+)
+
+// TagNamespace is the namespace of the tags used alongside the legacy ones by default
+const TagNamespace = "ebs-volumes/"
+
+// TagSchema names the tags used to designate volumes, so tools sharing an instance can
+// each use their own
+type TagSchema struct {
+	// VolumePrefix prefixes the name of a tag allocating a volume to a device
+	VolumePrefix string
+	// SizePrefix prefixes the name of a tag giving the size in GiB of the volume at a device
+	SizePrefix string
+	// PerformancePrefix prefixes the name of a tag giving the type and performance of the volume at a device
+	PerformancePrefix string
+	// DetachVolumesTag names the tag signalling volumes can be detached
+	DetachVolumesTag string
+	// PoolPrefix prefixes the name of a tag giving the pool a volume is claimed from for a device
+	PoolPrefix string
+}
+
+// LegacyTagSchema is the original schema, such as volume_/dev/sdh
+var LegacyTagSchema = TagSchema{
+	VolumePrefix:      VolumeTagPrefix,
+	SizePrefix:        VolumeSizeTagPrefix,
+	PerformancePrefix: PerformanceTagPrefix,
+	DetachVolumesTag:  DetachVolumesTag,
+	PoolPrefix:        PoolTagPrefix,
+}
+
+// NamespacedTagSchema is the schema in the default namespace, such as ebs-volumes/volume:/dev/sdh
+var NamespacedTagSchema = prefixedTagSchema(TagNamespace)
+
+// DefaultTagSchemas are the schemas used unless others are given
+var DefaultTagSchemas = []TagSchema{LegacyTagSchema, NamespacedTagSchema}
+
+// NewTagSchema returns a schema where every tag name starts with a prefix, so a prefix
+// of ebs-volumes: gives tags such as ebs-volumes:volume:/dev/sdh
+func NewTagSchema(prefix string) (TagSchema, error) {
+
+	if strings.TrimSpace(prefix) == "" {
+		return TagSchema{}, errors.New("a tag prefix must be given")
+	}
+
+	if strings.HasPrefix(strings.ToLower(prefix), "aws:") {
+		return TagSchema{}, errors.New("tag names starting with aws: are reserved by AWS")
+	}
+
+	return prefixedTagSchema(prefix), nil
+}
+
+func prefixedTagSchema(prefix string) TagSchema {
+	return TagSchema{
+		VolumePrefix:      prefix + "volume:",
+		SizePrefix:        prefix + "size:",
+		PerformancePrefix: prefix + "perf:",
+		DetachVolumesTag:  prefix + "detach-volumes",
+		PoolPrefix:        prefix + "pool:",
+	}
+}
+
+// VolumeTag returns the name of the tag allocating a volume to a device
+func (s TagSchema) VolumeTag(device string) string {
+	return s.VolumePrefix + device
+}
+
+// SizeTag returns the name of the tag giving the size of the volume at a device
+func (s TagSchema) SizeTag(device string) string {
+	return s.SizePrefix + device
+}
+
+// PerformanceTag returns the name of the tag giving the type and performance of the volume at a device
+func (s TagSchema) PerformanceTag(device string) string {
+	return s.PerformancePrefix + device
+}
+
+// PoolTag returns the name of the tag giving the pool a volume is claimed from for a device
+func (s TagSchema) PoolTag(device string) string {
+	return s.PoolPrefix + device
+}
+
+// tagDevice returns the device a tag names when it starts with the prefix chosen from any of the schemas
+func tagDevice(schemas []TagSchema, key string, prefix func(TagSchema) string) (string, bool) {
+
+	for _, schema := range schemas {
+		if p := prefix(schema); p != "" && strings.HasPrefix(key, p) {
+			return key[len(p):], true
+		}
+	}
+
+	return "", false
+}
+
+func volumePrefix(s TagSchema) string {
+	return s.VolumePrefix
+}
+
+func sizePrefix(s TagSchema) string {
+	return s.SizePrefix
+}
+
+func performancePrefix(s TagSchema) string {
+	return s.PerformancePrefix
+}
+
+// isDetachVolumesTag returns true if a tag signals volumes can be detached in any of the schemas
+func isDetachVolumesTag(schemas []TagSchema, key string) bool {
+
+	for _, schema := range schemas {
+		if schema.DetachVolumesTag != "" && key == schema.DetachVolumesTag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,103 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+func TestNewTagSchema(t *testing.T) {
+
+	schema, err := NewTagSchema("ebs-volumes:")
+
+	if err != nil {
+		t.Fatalf("Creating the schema shouldn't have failed, but I got %v", err)
+	}
+
+	if tag := schema.VolumeTag("/dev/sdh"); tag != "ebs-volumes:volume:/dev/sdh" {
+		t.Errorf("Expected volume tag ebs-volumes:volume:/dev/sdh, but got %s", tag)
+	}
+
+	for _, prefix := range []string{"", " ", "aws:", "AWS:ebs"} {
+		if _, err := NewTagSchema(prefix); err == nil {
+			t.Errorf("Creating a schema with prefix '%s' should have failed", prefix)
+		}
+	}
+}
+
+func TestFindAllocatedVolumesInDefaultSchemas(t *testing.T) {
+
+	instanceID := "id-98765"
+	metadata := testhelpers.NewMockMetadata(instanceID, "erewhon")
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeTagsFunc: testhelpers.DescribeVolumeTagsForInstance(instanceID,
+			testhelpers.NewDescribeTagsOutputBuilder().
+				WithTag(instanceID, "volume_/dev/sdf", "vol-12345678").
+				WithTag(instanceID, "ebs-volumes/volume:/dev/sdf", "vol-12345678").
+				WithTag(instanceID, "ebs-volumes/volume:/dev/sdg", "vol-87654321").
+				WithTag(instanceID, "ebs-volumes/size:/dev/sdg", "100").
+				WithTag(instanceID, "other:volume:/dev/sdh", "vol-11111111").Build()),
+	}
+
+	volumes, problems, err := NewEC2Instance(metadata, mockEC2Service).parseAllocatedVolumes()
+
+	if err != nil {
+		t.Fatalf("Shouldn't have failed : got error %s", err.Error())
+	}
+
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems, but got %v", problems)
+	}
+
+	if len(volumes) != 2 {
+		t.Fatalf("Should have got 2 allocated volumes, but got %v", volumes)
+	}
+
+	assertVolumesEqual(t, volumes[0], NewAllocatedVolume("vol-12345678", "/dev/sdf", instanceID, nil))
+	assertVolumesEqual(t, volumes[1], NewAllocatedVolume("vol-87654321", "/dev/sdg", instanceID, nil))
+
+	if volumes[1].Size != 100 {
+		t.Errorf("Volume %s should have designated size 100, but got %d", volumes[1].VolumeID, volumes[1].Size)
+	}
+}
+
+func TestFindAllocatedVolumesWithTagSchema(t *testing.T) {
+
+	instanceID := "id-98765"
+	metadata := testhelpers.NewMockMetadata(instanceID, "erewhon")
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeTagsFunc: testhelpers.DescribeVolumeTagsForInstance(instanceID,
+			testhelpers.NewDescribeTagsOutputBuilder().
+				WithTag(instanceID, "volume_/dev/sdf", "vol-12345678").
+				WithTag(instanceID, "ebs-volumes/volume:/dev/sdg", "vol-87654321").
+				WithTag(instanceID, "other:volume:/dev/sdh", "vol-11111111").
+				WithTag(instanceID, "other:detach-volumes", "true").Build()),
+	}
+
+	schema, _ := NewTagSchema("other:")
+	underTest := NewEC2Instance(metadata, mockEC2Service, WithTagSchemas(schema))
+
+	volumes, err := underTest.AllocatedVolumes()
+
+	if err != nil {
+		t.Fatalf("Shouldn't have failed : got error %s", err.Error())
+	}
+
+	if len(volumes) != 1 {
+		t.Fatalf("Should have got 1 allocated volume, but got %v", volumes)
+	}
+
+	assertVolumesEqual(t, volumes[0], NewAllocatedVolume("vol-11111111", "/dev/sdh", instanceID, nil))
+
+	tags, err := underTest.tags()
+
+	if err != nil {
+		t.Fatalf("Shouldn't have failed to get tags, but I got %v", err)
+	}
+
+	if !underTest.shouldDetachVolumes(tags) {
+		t.Error("Volumes should be detached when other:detach-volumes is true")
+	}
+}
@@ -0,0 +1,292 @@
+package shared
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Reasons a volume selector can't be resolved to a volume
+var (
+	ErrInvalidSelector   = errors.New("not a volume selector")
+	ErrNoVolumeMatched   = errors.New("no volume matches the selector")
+	ErrAmbiguousSelector = errors.New("more than one volume matches the selector")
+)
+
+// MatchPolicy chooses the volume used when more than one matches a selector
+type MatchPolicy string
+
+// Ways of choosing between volumes matching a selector. Whatever the policy, a single matching volume
+// already attached to the instance is always chosen, so volumes aren't swapped on later runs.
+const (
+	// MatchPolicyError fails when more than one volume matches
+	MatchPolicyError MatchPolicy = "error"
+	// MatchPolicyPreferAvailable chooses the only available volume, failing when more than one is available
+	MatchPolicyPreferAvailable MatchPolicy = "prefer-available"
+	// MatchPolicyPreferNewest chooses the most recently created volume
+	MatchPolicyPreferNewest MatchPolicy = "prefer-newest"
+)
+
+// MatchPolicies lists the valid policies
+var MatchPolicies = []MatchPolicy{MatchPolicyError, MatchPolicyPreferAvailable, MatchPolicyPreferNewest}
+
+// ParseMatchPolicy returns the policy with a name
+func ParseMatchPolicy(name string) (MatchPolicy, error) {
+
+	for _, policy := range MatchPolicies {
+		if string(policy) == name {
+			return policy, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown match policy '%s', expected one of %v", name, MatchPolicies)
+}
+
+// WithMatchPolicy chooses the volume used when more than one matches a selector. The default is MatchPolicyError.
+func WithMatchPolicy(policy MatchPolicy) InstanceOption {
+	return func(e *EC2Instance) {
+		e.matchPolicy = policy
+	}
+}
+
+// VolumeSelector finds a volume using DescribeVolumes filters, rather than naming it by ID. It's given
+// instead of a volume ID either as a single tag filter, such as tag:Name=db-data-0, or as a JSON list of
+// filters, such as [{"Name":"tag:role","Values":["db"]},{"Name":"size","Values":["100"]}]
+type VolumeSelector struct {
+	Filters []*ec2.Filter
+}
+
+// IsVolumeSelector returns true if a value looks like a volume selector rather than a volume ID
+func IsVolumeSelector(value string) bool {
+	return strings.HasPrefix(value, "tag:") || strings.HasPrefix(value, "[")
+}
+
+// ParseVolumeSelector parses a volume selector
+func ParseVolumeSelector(value string) (*VolumeSelector, error) {
+
+	if strings.HasPrefix(value, "tag:") {
+		filter, err := ParseFilter(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w : %v", ErrInvalidSelector, err)
+		}
+
+		if aws.StringValue(filter.Name) == "tag:" {
+			return nil, fmt.Errorf("%w : no tag name given", ErrInvalidSelector)
+		}
+
+		return &VolumeSelector{Filters: []*ec2.Filter{filter}}, nil
+	}
+
+	var filters []*ec2.Filter
+
+	if err := json.Unmarshal([]byte(value), &filters); err != nil {
+		return nil, fmt.Errorf("%w : %v", ErrInvalidSelector, err)
+	}
+
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("%w : no filters given", ErrInvalidSelector)
+	}
+
+	for _, filter := range filters {
+		if filter == nil || aws.StringValue(filter.Name) == "" || len(filter.Values) == 0 {
+			return nil, fmt.Errorf("%w : every filter needs a Name and Values", ErrInvalidSelector)
+		}
+	}
+
+	return &VolumeSelector{Filters: filters}, nil
+}
+
+// validateVolumeValue returns an error unless a value is a volume ID or a volume selector
+func validateVolumeValue(value string) error {
+
+	if IsVolumeSelector(value) {
+		_, err := ParseVolumeSelector(value)
+		return err
+	}
+
+	return ValidateVolumeID(value)
+}
+
+// Resolve returns the ID of the volume matching the selector in an availability zone, using the policy
+// to choose between volumes when more than one matches
+func (s *VolumeSelector) Resolve(svc ec2iface.EC2API, instanceID string, availabilityZone string, policy MatchPolicy) (string, error) {
+
+	volumes, err := s.matchingVolumes(svc, availabilityZone)
+
+	if err != nil {
+		return "", err
+	}
+
+	volume, err := chooseVolume(volumes, instanceID, policy)
+
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(volume.VolumeId), nil
+}
+
+// matchingVolumes returns the volumes matching the selector in an availability zone, or in any when none is given
+func (s *VolumeSelector) matchingVolumes(svc ec2iface.EC2API, availabilityZone string) ([]*ec2.Volume, error) {
+
+	filters := s.Filters
+
+	if availabilityZone != "" {
+		filters = append(filters[:len(filters):len(filters)], &ec2.Filter{
+			Name:   aws.String("availability-zone"),
+			Values: aws.StringSlice([]string{availabilityZone}),
+		})
+	}
+
+	var volumes []*ec2.Volume
+
+	input := &ec2.DescribeVolumesInput{Filters: filters}
+
+	for {
+		resp, err := svc.DescribeVolumes(input)
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe volumes : %w", err)
+		}
+
+		volumes = append(volumes, resp.Volumes...)
+
+		if aws.StringValue(resp.NextToken) == "" {
+			return volumes, nil
+		}
+
+		input.NextToken = resp.NextToken
+	}
+}
+
+// chooseVolume chooses between the volumes matching a selector
+func chooseVolume(volumes []*ec2.Volume, instanceID string, policy MatchPolicy) (*ec2.Volume, error) {
+
+	if len(volumes) == 0 {
+		return nil, ErrNoVolumeMatched
+	}
+
+	if len(volumes) == 1 {
+		return volumes[0], nil
+	}
+
+	var attached []*ec2.Volume
+
+	for _, volume := range volumes {
+		if attachedTo(volume, instanceID) {
+			attached = append(attached, volume)
+		}
+	}
+
+	if len(attached) == 1 {
+		return attached[0], nil
+	}
+
+	switch policy {
+	case MatchPolicyPreferAvailable:
+
+		var available []*ec2.Volume
+
+		for _, volume := range volumes {
+			if aws.StringValue(volume.State) == ec2.VolumeStateAvailable {
+				available = append(available, volume)
+			}
+		}
+
+		if len(available) == 1 {
+			return available[0], nil
+		}
+
+		return nil, fmt.Errorf("%w, and %d are available (%s)", ErrAmbiguousSelector, len(available), volumeIDs(volumes))
+
+	case MatchPolicyPreferNewest:
+
+		newest := volumes[0]
+
+		for _, volume := range volumes[1:] {
+			if aws.TimeValue(volume.CreateTime).After(aws.TimeValue(newest.CreateTime)) {
+				newest = volume
+			}
+		}
+
+		return newest, nil
+	}
+
+	return nil, fmt.Errorf("%w (%s)", ErrAmbiguousSelector, volumeIDs(volumes))
+}
+
+func volumeIDs(volumes []*ec2.Volume) string {
+
+	var ids []string
+
+	for _, volume := range volumes {
+		ids = append(ids, aws.StringValue(volume.VolumeId))
+	}
+
+	sort.Strings(ids)
+
+	return strings.Join(ids, ", ")
+}
+
+// resolveSelectors replaces the volume selectors in volumes with the IDs of the volumes they match.
+// Volumes whose selector can't be resolved, or which match a volume allocated at another device, aren't used.
+func resolveSelectors(volumes []VolumeTag, svc ec2iface.EC2API, availabilityZone string, policy MatchPolicy) ([]VolumeTag, []error) {
+
+	var resolved []VolumeTag
+	var problems []error
+
+	allocated := make(map[string]string)
+
+	for _, volume := range volumes {
+		if !IsVolumeSelector(volume.VolumeID) {
+			allocated[volume.VolumeID] = volume.DeviceName
+		}
+	}
+
+	for _, volume := range volumes {
+
+		if !IsVolumeSelector(volume.VolumeID) {
+			resolved = append(resolved, volume)
+			continue
+		}
+
+		selectorError := func(err error) error {
+			return fmt.Errorf("volume selector '%s' for (%s) from %s : %w", volume.VolumeID, volume.DeviceName, volume.Source, err)
+		}
+
+		selector, err := ParseVolumeSelector(volume.VolumeID)
+
+		if err != nil {
+			problems = append(problems, selectorError(err))
+			continue
+		}
+
+		volumeID, err := selector.Resolve(svc, volume.InstanceID, availabilityZone, policy)
+
+		if err != nil {
+			problems = append(problems, selectorError(err))
+			continue
+		}
+
+		if device, ok := allocated[volumeID]; ok {
+			problems = append(problems, selectorError(fmt.Errorf("volume (%s) is already allocated at (%s)", volumeID, device)))
+			continue
+		}
+
+		allocated[volumeID] = volume.DeviceName
+
+		volume.Source = fmt.Sprintf("%s (selector %s)", volume.Source, volume.VolumeID)
+		volume.VolumeID = volumeID
+
+		resolved = append(resolved, volume)
+	}
+
+	return resolved, problems
+}
@@ -0,0 +1,142 @@
+package shared
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+func TestParseVolumeSelector(t *testing.T) {
+
+	selector, err := ParseVolumeSelector("tag:Name=db-data-0")
+
+	if err != nil || len(selector.Filters) != 1 || *selector.Filters[0].Name != "tag:Name" || *selector.Filters[0].Values[0] != "db-data-0" {
+		t.Errorf("Expected a tag:Name filter for db-data-0, but got %v, %v", selector, err)
+	}
+
+	selector, err = ParseVolumeSelector(`[{"Name":"tag:role","Values":["db"]},{"Name":"size","Values":["100","200"]}]`)
+
+	if err != nil || len(selector.Filters) != 2 || len(selector.Filters[1].Values) != 2 {
+		t.Errorf("Expected two filters, but got %v, %v", selector, err)
+	}
+
+	for _, value := range []string{"tag:Name", "tag:=db", "[]", "[{", `[{"Name":"size"}]`, "[null]"} {
+		if _, err := ParseVolumeSelector(value); !errors.Is(err, ErrInvalidSelector) {
+			t.Errorf("Selector '%s' should have been rejected, but got %v", value, err)
+		}
+	}
+}
+
+func TestParseMatchPolicy(t *testing.T) {
+
+	for _, policy := range MatchPolicies {
+		if parsed, err := ParseMatchPolicy(string(policy)); err != nil || parsed != policy {
+			t.Errorf("Policy '%s' should have been parsed, but got %v, %v", policy, parsed, err)
+		}
+	}
+
+	if _, err := ParseMatchPolicy("prefer-oldest"); err == nil {
+		t.Error("An unknown policy should have been rejected")
+	}
+}
+
+func selectorVolume(volumeID string, state string, created time.Time, attachedTo string) *ec2.Volume {
+
+	volume := &ec2.Volume{VolumeId: aws.String(volumeID), State: aws.String(state), CreateTime: aws.Time(created)}
+
+	if attachedTo != "" {
+		volume.Attachments = []*ec2.VolumeAttachment{{InstanceId: aws.String(attachedTo)}}
+	}
+
+	return volume
+}
+
+func TestChooseVolume(t *testing.T) {
+
+	older, newer := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	inUse := selectorVolume("vol-11111111", ec2.VolumeStateInUse, newer, "id-12345")
+	available := selectorVolume("vol-22222222", ec2.VolumeStateAvailable, older, "")
+	attached := selectorVolume("vol-33333333", ec2.VolumeStateInUse, older, "id-98765")
+
+	var tests = []struct {
+		volumes  []*ec2.Volume
+		policy   MatchPolicy
+		expected string
+		err      error
+	}{
+		{nil, MatchPolicyPreferNewest, "", ErrNoVolumeMatched},
+		{[]*ec2.Volume{inUse}, MatchPolicyError, "vol-11111111", nil},
+		{[]*ec2.Volume{inUse, available}, MatchPolicyError, "", ErrAmbiguousSelector},
+		{[]*ec2.Volume{inUse, available}, MatchPolicyPreferAvailable, "vol-22222222", nil},
+		{[]*ec2.Volume{inUse, inUse}, MatchPolicyPreferAvailable, "", ErrAmbiguousSelector},
+		{[]*ec2.Volume{available, inUse}, MatchPolicyPreferNewest, "vol-11111111", nil},
+		{[]*ec2.Volume{inUse, available, attached}, MatchPolicyError, "vol-33333333", nil},
+		{[]*ec2.Volume{inUse, available, attached}, MatchPolicyPreferNewest, "vol-33333333", nil},
+	}
+
+	for i, tt := range tests {
+
+		volume, err := chooseVolume(tt.volumes, "id-98765", tt.policy)
+
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Test %d should have failed with %v, but got %v", i, tt.err, err)
+		}
+
+		if err == nil && aws.StringValue(volume.VolumeId) != tt.expected {
+			t.Errorf("Test %d should have chosen %s, but got %s", i, tt.expected, aws.StringValue(volume.VolumeId))
+		}
+	}
+}
+
+func TestAllocatedVolumesResolvesSelectors(t *testing.T) {
+
+	instanceID := "id-98765"
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
+		testhelpers.NewDescribeTagsOutputBuilder().
+			WithVolume("/dev/sdg", instanceID, "tag:Name=db-data-0").
+			WithVolume("/dev/sdh", instanceID, "tag:Name=missing").Build())
+
+	var filters []*ec2.Filter
+
+	mockEC2Service.DescribeVolumesFunc = func(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+
+		if *input.Filters[0].Values[0] == "missing" {
+			return &ec2.DescribeVolumesOutput{}, nil
+		}
+
+		filters = input.Filters
+
+		return &ec2.DescribeVolumesOutput{Volumes: []*ec2.Volume{
+			selectorVolume("vol-11111111", ec2.VolumeStateAvailable, time.Now(), ""),
+		}}, nil
+	}
+
+	volumes, problems, err := NewEC2Instance(testhelpers.NewMockMetadata(instanceID, "erewhon"), mockEC2Service).parseAllocatedVolumes()
+
+	if err != nil {
+		t.Fatalf("Shouldn't have failed : got error %s", err.Error())
+	}
+
+	if len(volumes) != 1 || volumes[0].VolumeID != "vol-11111111" || volumes[0].DeviceName != "/dev/sdg" {
+		t.Fatalf("Expected vol-11111111 at /dev/sdg, but got %v", volumes)
+	}
+
+	if volumes[0].Source != "tag volume_/dev/sdg (selector tag:Name=db-data-0)" {
+		t.Errorf("Expected the selector in the source, but got %s", volumes[0].Source)
+	}
+
+	if len(filters) != 2 || *filters[1].Name != "availability-zone" || *filters[1].Values[0] != "erewhona" {
+		t.Errorf("Expected volumes to be found in the availability zone of the instance, but got %v", filters)
+	}
+
+	if len(problems) != 1 || !errors.Is(problems[0], ErrNoVolumeMatched) {
+		t.Errorf("Expected no volume to match the missing selector, but got %v", problems)
+	}
+}
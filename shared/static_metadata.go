@@ -0,0 +1,121 @@
+package shared
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Instance IDs, in both the older short and current long formats
+var instanceIDPattern = regexp.MustCompile(`^i-([0-9a-f]{8}|[0-9a-f]{17})$`)
+
+// StaticMetadata describes an instance given by ID and region, so volumes can be managed from outside
+// the instance. The availability zone and root device are looked up using DescribeInstances.
+type StaticMetadata struct {
+	instanceID string
+	region     string
+	svc        ec2iface.EC2API
+
+	once      sync.Once
+	instance  *ec2.Instance
+	accountID string
+	err       error
+}
+
+// NewStaticMetadata returns a new StaticMetadata
+func NewStaticMetadata(instanceID string, region string, svc ec2iface.EC2API) *StaticMetadata {
+	return &StaticMetadata{instanceID: instanceID, region: region, svc: svc}
+}
+
+// newDescribedMetadata returns metadata for an instance in an account which has already been described
+func newDescribedMetadata(instance *ec2.Instance, accountID string, region string, svc ec2iface.EC2API) *StaticMetadata {
+
+	m := NewStaticMetadata(aws.StringValue(instance.InstanceId), region, svc)
+	m.once.Do(func() {
+		m.instance, m.accountID = instance, accountID
+	})
+
+	return m
+}
+
+// InstanceID returns the instance id given
+func (m *StaticMetadata) InstanceID() (string, error) {
+	return m.instanceID, nil
+}
+
+// Region returns the region given
+func (m *StaticMetadata) Region() (string, error) {
+	return m.region, nil
+}
+
+// AvailabilityZone returns the availability zone the instance is in
+func (m *StaticMetadata) AvailabilityZone() (string, error) {
+
+	instance, err := m.describe()
+
+	if err != nil {
+		return "", err
+	}
+
+	if instance.Placement == nil || aws.StringValue(instance.Placement.AvailabilityZone) == "" {
+		return "", fmt.Errorf("no availability zone found for instance (%s)", m.instanceID)
+	}
+
+	return aws.StringValue(instance.Placement.AvailabilityZone), nil
+}
+
+// AccountID returns the ID of the account the instance belongs to
+func (m *StaticMetadata) AccountID() (string, error) {
+
+	if _, err := m.describe(); err != nil {
+		return "", err
+	}
+
+	if m.accountID == "" {
+		return "", fmt.Errorf("no account found for instance (%s)", m.instanceID)
+	}
+
+	return m.accountID, nil
+}
+
+// RootDeviceName returns the name of the root device of the instance
+func (m *StaticMetadata) RootDeviceName() (string, error) {
+
+	instance, err := m.describe()
+
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(instance.RootDeviceName), nil
+}
+
+// describe looks up the instance once, as what's needed doesn't change while it's running
+func (m *StaticMetadata) describe() (*ec2.Instance, error) {
+
+	m.once.Do(func() {
+		resp, err := m.svc.DescribeInstances(&ec2.DescribeInstancesInput{
+			InstanceIds: []*string{aws.String(m.instanceID)},
+		})
+
+		if err != nil {
+			m.err = fmt.Errorf("unable to describe instance (%s) : %w", m.instanceID, err)
+			return
+		}
+
+		for _, reservation := range resp.Reservations {
+			for _, instance := range reservation.Instances {
+				m.instance, m.accountID = instance, aws.StringValue(reservation.OwnerId)
+				return
+			}
+		}
+
+		m.err = fmt.Errorf("instance (%s) not found", m.instanceID)
+	})
+
+	return m.instance, m.err
+}
@@ -0,0 +1,84 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+func TestStaticMetadata(t *testing.T) {
+
+	calls := 0
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+	mockEC2Service.DescribeInstancesFunc = func(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+		calls++
+		return &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{
+				InstanceId:     input.InstanceIds[0],
+				Placement:      &ec2.Placement{AvailabilityZone: aws.String("erewhonb")},
+				RootDeviceName: aws.String("/dev/xvda"),
+			}}}},
+		}, nil
+	}
+
+	underTest := NewStaticMetadata("i-12345678", "erewhon", mockEC2Service)
+
+	if instanceID, _ := underTest.InstanceID(); instanceID != "i-12345678" {
+		t.Errorf("Expected instance ID i-12345678, but got %s", instanceID)
+	}
+
+	if region, _ := underTest.Region(); region != "erewhon" {
+		t.Errorf("Expected region erewhon, but got %s", region)
+	}
+
+	if availabilityZone, err := underTest.AvailabilityZone(); err != nil || availabilityZone != "erewhonb" {
+		t.Errorf("Expected availability zone erewhonb, but got '%s', %v", availabilityZone, err)
+	}
+
+	if root, err := underTest.RootDeviceName(); err != nil || root != "/dev/xvda" {
+		t.Errorf("Expected root device /dev/xvda, but got '%s', %v", root, err)
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected the instance to be described once, but it was described %d times", calls)
+	}
+}
+
+func TestStaticMetadataInstanceNotFound(t *testing.T) {
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+	mockEC2Service.DescribeInstancesFunc = func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+		return &ec2.DescribeInstancesOutput{}, nil
+	}
+
+	if _, err := NewStaticMetadata("i-12345678", "erewhon", mockEC2Service).AvailabilityZone(); err == nil {
+		t.Error("Getting the availability zone of a missing instance should have failed")
+	}
+}
+
+func TestGetInstanceByID(t *testing.T) {
+
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	if _, err := GetInstanceByID("id-98765", "erewhon"); err == nil {
+		t.Error("An invalid instance ID should have been rejected")
+	}
+
+	if _, err := GetInstanceByID("i-12345678", ""); err == nil {
+		t.Error("An instance without a region should have been rejected")
+	}
+
+	instance, err := GetInstanceByID("i-1234567890abcdef0", "erewhon")
+
+	if err != nil {
+		t.Fatalf("Getting the instance shouldn't have failed, but I got %v", err)
+	}
+
+	if region, _ := instance.metadata.Region(); region != "erewhon" {
+		t.Errorf("Expected region erewhon, but got %s", region)
+	}
+}
@@ -0,0 +1,280 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+)
+
+const devicePrefix = "/dev/"
+
+// Reasons a tag can't be parsed, wrapped by TagError
+var (
+	ErrEmptyDeviceName     = errors.New("no device name given")
+	ErrInvalidDeviceName   = errors.New("not a device name volumes can be attached at")
+	ErrInvalidVolumeID     = errors.New("not a volume ID")
+	ErrRootDeviceCollision = errors.New("device collides with the root device")
+	ErrDuplicateDevice     = errors.New("device is allocated different volumes by more than one tag")
+)
+
+// Device names volumes can be attached at, such as /dev/sdh, /dev/xvdba or /dev/sdh1
+var deviceNamePattern = regexp.MustCompile(`^/dev/(sd|xvd|hd)[a-z]{1,2}[0-9]{0,2}$`)
+
+// Volume IDs, in both the older short and current long formats
+var volumeIDPattern = regexp.MustCompile(`^vol-([0-9a-f]{8}|[0-9a-f]{17})$`)
+
+// TagError describes a tag that couldn't be parsed
+type TagError struct {
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("tag '%s' : %v", e.Key, e.Err)
+}
+
+// Unwrap returns the reason the tag couldn't be parsed
+func (e *TagError) Unwrap() error {
+	return e.Err
+}
+
+// VolumeTag is a parsed tag allocating a volume to a device
+type VolumeTag struct {
+	DeviceName string
+	VolumeID   string
+	InstanceID string
+
+	// Source describes where the volume was allocated, such as the tag naming it
+	Source string
+}
+
+// NormalizeDeviceName returns the full name of a device given with or without the /dev/ prefix,
+// so both sdh and /dev/sdh give /dev/sdh
+func NormalizeDeviceName(name string) (string, error) {
+
+	if name == "" {
+		return "", ErrEmptyDeviceName
+	}
+
+	if !strings.Contains(name, "/") {
+		name = devicePrefix + name
+	}
+
+	if !deviceNamePattern.MatchString(name) {
+		return "", ErrInvalidDeviceName
+	}
+
+	return name, nil
+}
+
+// ValidateVolumeID checks a volume ID is well formed
+func ValidateVolumeID(volumeID string) error {
+
+	if !volumeIDPattern.MatchString(volumeID) {
+		return ErrInvalidVolumeID
+	}
+
+	return nil
+}
+
+// deviceKey identifies a normalized device regardless of naming scheme. EC2 exposes a volume
+// attached at /dev/sdh as /dev/xvdh on many instances, so both names refer to the same device.
+func deviceKey(device string) string {
+
+	name := strings.TrimPrefix(device, devicePrefix)
+
+	if strings.HasPrefix(name, "xvd") {
+		name = "sd" + name[len("xvd"):]
+	}
+
+	return devicePrefix + name
+}
+
+// collidesWithRoot returns true if a device is, or is the disk holding, the root device
+func collidesWithRoot(device string, root string) bool {
+
+	if root == "" {
+		return false
+	}
+
+	if !strings.Contains(root, "/") {
+		root = devicePrefix + root
+	}
+
+	disk := func(name string) string {
+		return strings.TrimRight(deviceKey(name), "0123456789")
+	}
+
+	return disk(device) == disk(root)
+}
+
+// ParseVolumeTag parses a tag allocating a volume using the legacy schema, such as
+// volume_/dev/sdh = vol-1234567890abcdef0. Devices colliding with the root device are
+// rejected, unless the root device is empty.
+func ParseVolumeTag(key string, value string, root string) (string, string, error) {
+	return LegacyTagSchema.ParseVolumeTag(key, value, root)
+}
+
+// ParseVolumeTag parses a tag allocating a volume using the schema. Devices colliding
+// with the root device are rejected, unless the root device is empty.
+func (s TagSchema) ParseVolumeTag(key string, value string, root string) (string, string, error) {
+	return parseVolumeTag(key, strings.TrimPrefix(key, s.VolumePrefix), value, root)
+}
+
+func parseVolumeTag(key string, device string, value string, root string) (string, string, error) {
+
+	tagError := func(err error) error {
+		return &TagError{Key: key, Value: value, Err: err}
+	}
+
+	device, err := NormalizeDeviceName(device)
+	if err != nil {
+		return "", "", tagError(err)
+	}
+
+	if err := validateVolumeValue(value); err != nil && IsVolumeSelector(value) {
+		return "", "", tagError(err)
+	} else if err != nil {
+		return "", "", tagError(fmt.Errorf("'%s' is %w", value, err))
+	}
+
+	if collidesWithRoot(device, root) {
+		return "", "", tagError(ErrRootDeviceCollision)
+	}
+
+	return device, value, nil
+}
+
+// parseVolumeTags returns the volumes allocated via tags in any of the schemas, in the order the
+// tags were given, along with errors for the tags that were rejected. A device allocated the same
+// volume in more than one schema is only returned once.
+func parseVolumeTags(tags []*ec2.TagDescription, schemas []TagSchema, root string) ([]VolumeTag, []error) {
+
+	type parsedTag struct {
+		key    string
+		volume VolumeTag
+	}
+
+	var parsed []parsedTag
+	var problems []error
+
+	designated := make(map[string]map[string]bool)
+
+	for _, tag := range tags {
+		key, value := aws.StringValue(tag.Key), aws.StringValue(tag.Value)
+
+		device, ok := tagDevice(schemas, key, volumePrefix)
+		if !ok {
+			continue
+		}
+
+		device, volumeID, err := parseVolumeTag(key, device, value, root)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+
+		if designated[deviceKey(device)] == nil {
+			designated[deviceKey(device)] = make(map[string]bool)
+		}
+		designated[deviceKey(device)][volumeID] = true
+
+		parsed = append(parsed, parsedTag{key: key,
+			volume: VolumeTag{DeviceName: device, VolumeID: volumeID, InstanceID: aws.StringValue(tag.ResourceId), Source: "tag " + key}})
+	}
+
+	var volumes []VolumeTag
+	returned := make(map[string]bool)
+
+	for _, tag := range parsed {
+		key := deviceKey(tag.volume.DeviceName)
+
+		if len(designated[key]) > 1 {
+			problems = append(problems, &TagError{Key: tag.key, Value: tag.volume.VolumeID, Err: ErrDuplicateDevice})
+			continue
+		}
+
+		if !returned[key] {
+			volumes = append(volumes, tag.volume)
+			returned[key] = true
+		}
+	}
+
+	return volumes, problems
+}
+
+// withConfiguredVolumes returns the volumes allocated by tags with those configured keyed by device
+// name, which replace any allocated by tags to the same device
+func withConfiguredVolumes(logger *log.Logger, tagged []VolumeTag, configured map[string]string, instanceID string, root string) ([]VolumeTag, []error) {
+
+	volumes, problems := parseVolumeMappings(configured, "config file", instanceID, root)
+
+	overridden := make(map[string]string)
+	for _, volume := range volumes {
+		overridden[deviceKey(volume.DeviceName)] = volume.VolumeID
+	}
+
+	for _, tag := range tagged {
+		if volumeID, ok := overridden[deviceKey(tag.DeviceName)]; ok {
+			logger.Debugf("Volume (%s) configured for (%s) replaces volume (%s) allocated by %s",
+				volumeID, tag.DeviceName, tag.VolumeID, tag.Source)
+			continue
+		}
+		volumes = append(volumes, tag)
+	}
+
+	return volumes, problems
+}
+
+// parseVolumeMappings parses volume IDs keyed by device name from a source other than tags,
+// returning them in device order
+func parseVolumeMappings(mappings map[string]string, source string, instanceID string, root string) ([]VolumeTag, []error) {
+
+	var devices []string
+	for device := range mappings {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	var problems []error
+	var volumes []VolumeTag
+
+	for _, name := range devices {
+		volumeID := mappings[name]
+
+		device, err := NormalizeDeviceName(name)
+		if err == nil {
+			err = validateVolumeValue(volumeID)
+		}
+		if err == nil && collidesWithRoot(device, root) {
+			err = ErrRootDeviceCollision
+		}
+
+		if err != nil {
+			problems = append(problems, fmt.Errorf("volume '%s' for device '%s' in the %s : %w", volumeID, name, source, err))
+			continue
+		}
+
+		volumes = append(volumes, VolumeTag{DeviceName: device, VolumeID: volumeID, InstanceID: instanceID, Source: source})
+	}
+
+	return volumes, problems
+}
+
+// deviceSetting returns the device key of a tag designating a setting for the volume at a device
+func deviceSetting(key string, device string, value string) (string, error) {
+
+	device, err := NormalizeDeviceName(device)
+	if err != nil {
+		return "", &TagError{Key: key, Value: value, Err: err}
+	}
+
+	return deviceKey(device), nil
+}
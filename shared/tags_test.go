@@ -0,0 +1,247 @@
+package shared
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+var normalizeDeviceNameTests = []struct {
+	name     string
+	expected string
+	err      error
+}{
+	{"/dev/sdh", "/dev/sdh", nil},
+	{"sdh", "/dev/sdh", nil},
+	{"xvdh", "/dev/xvdh", nil},
+	{"/dev/xvdba", "/dev/xvdba", nil},
+	{"sdh1", "/dev/sdh1", nil},
+	{"", "", ErrEmptyDeviceName},
+	{"/dev/", "", ErrInvalidDeviceName},
+	{"/mnt/sdh", "", ErrInvalidDeviceName},
+	{"dev/sdh", "", ErrInvalidDeviceName},
+	{"nvme1n1", "", ErrInvalidDeviceName},
+	{"SDH", "", ErrInvalidDeviceName},
+	{" sdh", "", ErrInvalidDeviceName},
+}
+
+func TestNormalizeDeviceName(t *testing.T) {
+
+	for _, tt := range normalizeDeviceNameTests {
+
+		device, err := NormalizeDeviceName(tt.name)
+
+		if err != tt.err {
+			t.Errorf("Normalizing '%s' should have given error %v, but got %v", tt.name, tt.err, err)
+		}
+
+		if device != tt.expected {
+			t.Errorf("Normalizing '%s' should have given '%s', but got '%s'", tt.name, tt.expected, device)
+		}
+	}
+}
+
+func TestValidateVolumeID(t *testing.T) {
+
+	for _, volumeID := range []string{"vol-12345678", "vol-1234567890abcdef0"} {
+		if err := ValidateVolumeID(volumeID); err != nil {
+			t.Errorf("Volume ID '%s' should be valid, but got %v", volumeID, err)
+		}
+	}
+
+	for _, volumeID := range []string{"", "vol-", "vol-1234567", "vol-123456789", "vol-ABCDEF12", "i-12345678", " vol-12345678"} {
+		if err := ValidateVolumeID(volumeID); err != ErrInvalidVolumeID {
+			t.Errorf("Volume ID '%s' should be invalid, but got %v", volumeID, err)
+		}
+	}
+}
+
+var parseVolumeTagErrorTests = []struct {
+	key   string
+	value string
+	root  string
+	err   error
+}{
+	{"volume_", "vol-12345678", "", ErrEmptyDeviceName},
+	{"volume_/dev/nvme1n1", "vol-12345678", "", ErrInvalidDeviceName},
+	{"volume_/dev/sdh", "sdh", "", ErrInvalidVolumeID},
+	{"volume_/dev/sda", "vol-12345678", "/dev/sda1", ErrRootDeviceCollision},
+	{"volume_sda1", "vol-12345678", "/dev/sda1", ErrRootDeviceCollision},
+	{"volume_/dev/sda", "vol-12345678", "/dev/xvda", ErrRootDeviceCollision},
+	{"volume_xvda", "vol-12345678", "sda1", ErrRootDeviceCollision},
+}
+
+func TestParseVolumeTagErrors(t *testing.T) {
+
+	for _, tt := range parseVolumeTagErrorTests {
+
+		_, _, err := ParseVolumeTag(tt.key, tt.value, tt.root)
+
+		var tagError *TagError
+
+		if !errors.As(err, &tagError) || tagError.Key != tt.key || tagError.Value != tt.value {
+			t.Errorf("Parsing '%s' = '%s' should have given a TagError for the tag, but got %v", tt.key, tt.value, err)
+		}
+
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parsing '%s' = '%s' should have failed with %v, but got %v", tt.key, tt.value, tt.err, err)
+		}
+	}
+}
+
+func TestParseVolumeTag(t *testing.T) {
+
+	device, volumeID, err := ParseVolumeTag("volume_sdh", "vol-1234567890abcdef0", "/dev/xvda")
+
+	if err != nil {
+		t.Fatalf("Parsing shouldn't have failed, but I got %v", err)
+	}
+
+	if device != "/dev/sdh" || volumeID != "vol-1234567890abcdef0" {
+		t.Errorf("Expected volume vol-1234567890abcdef0 at /dev/sdh, but got %s at %s", volumeID, device)
+	}
+}
+
+func TestFindAllocatedVolumesRejectsInvalidTags(t *testing.T) {
+
+	instanceID := "id-98765"
+	metadata := testhelpers.NewMockMetadata(instanceID, "erewhon").SetRootDeviceName("/dev/xvda")
+
+	mockEC2Service := &testhelpers.MockEC2Service{
+		DescribeTagsFunc: testhelpers.DescribeVolumeTagsForInstance(instanceID,
+			testhelpers.NewDescribeTagsOutputBuilder().
+				WithVolume("", instanceID, "vol-11111111").
+				WithVolume("/dev/sda", instanceID, "vol-22222222").
+				WithVolume("sdg", instanceID, "vol-33333333").WithSize("xvdg", instanceID, "100").
+				WithVolume("/dev/sdh", instanceID, "vol-44444444").
+				WithVolume("/dev/xvdh", instanceID, "vol-55555555").
+				WithVolume("/dev/sdi", instanceID, "sdi").Build()),
+	}
+
+	volumes, problems, err := NewEC2Instance(metadata, mockEC2Service).parseAllocatedVolumes()
+
+	if err != nil {
+		t.Fatalf("Shouldn't have failed : got error %s", err.Error())
+	}
+
+	if len(volumes) != 1 {
+		t.Fatalf("Should have got 1 allocated volume, but got %v", volumes)
+	}
+
+	if volumes[0].DeviceName != "/dev/sdg" || volumes[0].VolumeID != "vol-33333333" || volumes[0].Size != 100 {
+		t.Errorf("Expected vol-33333333 at /dev/sdg with a size of 100 GiB, but got %s with a size of %d", volumes[0], volumes[0].Size)
+	}
+
+	for _, expected := range []error{ErrEmptyDeviceName, ErrRootDeviceCollision, ErrDuplicateDevice, ErrInvalidVolumeID} {
+		if !containsError(problems, expected) {
+			t.Errorf("Expected a problem of %v, but got %v", expected, problems)
+		}
+	}
+}
+
+func containsError(problems []error, expected error) bool {
+	for _, problem := range problems {
+		if errors.Is(problem, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzParseVolumeTag(f *testing.F) {
+
+	f.Add("volume_/dev/sdh", "vol-1234567890abcdef0", "/dev/xvda")
+	f.Add("volume_sdh", "vol-12345678", "sda1")
+	f.Add("volume_xvdba", "vol-12345678", "")
+	f.Add("volume_", "", "")
+	f.Add("volume_/dev/../sdh", "vol-1234567", "/dev/sda1")
+	f.Add("volume_sdh", "tag:Name=db-data-0", "")
+	f.Add("volume_sdh", `[{"Name":"tag:role","Values":["db"]}]`, "")
+
+	f.Fuzz(func(t *testing.T, key string, value string, root string) {
+
+		device, volumeID, err := ParseVolumeTag(key, value, root)
+
+		if err != nil {
+			var tagError *TagError
+			if !errors.As(err, &tagError) || tagError.Key != key || tagError.Value != value {
+				t.Errorf("Expected a TagError for '%s' = '%s', but got %v", key, value, err)
+			}
+			return
+		}
+
+		if normalized, err := NormalizeDeviceName(device); err != nil || normalized != device {
+			t.Errorf("Device '%s' parsed from '%s' isn't normalized", device, key)
+		}
+
+		if !strings.HasPrefix(device, devicePrefix) || validateVolumeValue(volumeID) != nil || volumeID != value {
+			t.Errorf("Parsing '%s' = '%s' gave invalid volume %s at %s", key, value, volumeID, device)
+		}
+
+		if collidesWithRoot(device, root) {
+			t.Errorf("Device '%s' collides with root device '%s'", device, root)
+		}
+	})
+}
+
+func FuzzParseVolumeTags(f *testing.F) {
+
+	f.Add("volume_sdh", "vol-12345678", "volume_/dev/xvdh", "vol-87654321")
+	f.Add("volume_/dev/sdf", "vol-12345678", "size_sdf", "100")
+	f.Add("perf_sdf", "type=gp3", "volume_", "")
+
+	f.Fuzz(func(t *testing.T, firstKey string, firstValue string, secondKey string, secondValue string) {
+
+		tags := []*ec2.TagDescription{
+			{Key: aws.String(firstKey), Value: aws.String(firstValue), ResourceId: aws.String("id-98765")},
+			{Key: aws.String(secondKey), Value: aws.String(secondValue), ResourceId: aws.String("id-98765")},
+		}
+
+		volumes, problems := parseVolumeTags(tags, DefaultTagSchemas, "")
+		volumeSizes(tags, DefaultTagSchemas)
+		volumePerformances(tags, DefaultTagSchemas)
+
+		designated := make(map[string]bool)
+
+		for _, volume := range volumes {
+			if designated[deviceKey(volume.DeviceName)] {
+				t.Errorf("Device '%s' was allocated more than once", volume.DeviceName)
+			}
+			designated[deviceKey(volume.DeviceName)] = true
+		}
+
+		for _, problem := range problems {
+			var tagError *TagError
+			if !errors.As(problem, &tagError) {
+				t.Errorf("Expected a TagError, but got %v", problem)
+			}
+		}
+	})
+}
+
+func TestConfiguredVolumesReplaceTags(t *testing.T) {
+
+	tagged := []VolumeTag{
+		{DeviceName: "/dev/xvdg", VolumeID: "vol-11111111", InstanceID: "id-98765"},
+		{DeviceName: "/dev/sdh", VolumeID: "vol-22222222", InstanceID: "id-98765"},
+	}
+
+	configured := map[string]string{"sdg": "vol-33333333", "/dev/sda": "vol-44444444", "/dev/sdi": "sdi"}
+
+	volumes, problems := withConfiguredVolumes(nil, tagged, configured, "id-98765", "/dev/sda1")
+
+	if len(volumes) != 2 || volumes[0].DeviceName != "/dev/sdg" || volumes[0].VolumeID != "vol-33333333" ||
+		volumes[1].VolumeID != "vol-22222222" {
+		t.Errorf("Expected vol-33333333 at /dev/sdg to replace the tagged volume, but got %v", volumes)
+	}
+
+	for _, expected := range []error{ErrRootDeviceCollision, ErrInvalidVolumeID} {
+		if !containsError(problems, expected) {
+			t.Errorf("Expected a problem of %v, but got %v", expected, problems)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package shared
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/sneakybeaky/ebs-volumes/shared/log"
+)
+
+// instanceTagsPath is where instance tags are found in the instance metadata, when access to them has been enabled
+const instanceTagsPath = "tags/instance"
+
+// TagSourceMode chooses where instance tags are read from
+type TagSourceMode string
+
+// Places instance tags can be read from
+const (
+	// TagSourceAuto reads tags from the instance metadata, falling back to DescribeTags when that fails
+	TagSourceAuto TagSourceMode = "auto"
+	// TagSourceMetadata only reads tags from the instance metadata
+	TagSourceMetadata TagSourceMode = "imds"
+	// TagSourceAPI only reads tags using DescribeTags
+	TagSourceAPI TagSourceMode = "api"
+)
+
+// TagSourceModes lists the valid modes
+var TagSourceModes = []TagSourceMode{TagSourceAuto, TagSourceMetadata, TagSourceAPI}
+
+// ParseTagSourceMode returns the mode with a name
+func ParseTagSourceMode(name string) (TagSourceMode, error) {
+
+	for _, mode := range TagSourceModes {
+		if string(mode) == name {
+			return mode, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown tag source '%s', expected one of %v", name, TagSourceModes)
+}
+
+// TagSource reads the tags set against an instance
+type TagSource interface {
+	Tags(instanceID string) ([]*ec2.TagDescription, error)
+}
+
+// MetadataClient reads paths from the instance metadata, as ec2metadata.EC2Metadata does
+type MetadataClient interface {
+	GetMetadata(path string) (string, error)
+}
+
+// DescribeTagsSource reads instance tags using the EC2 DescribeTags API, which needs the
+// ec2:DescribeTags permission
+type DescribeTagsSource struct {
+	svc ec2iface.EC2API
+}
+
+// NewDescribeTagsSource returns a new DescribeTagsSource
+func NewDescribeTagsSource(svc ec2iface.EC2API) *DescribeTagsSource {
+	return &DescribeTagsSource{svc: svc}
+}
+
+// Tags returns the tags set against an instance
+func (s DescribeTagsSource) Tags(instanceID string) ([]*ec2.TagDescription, error) {
+
+	params := &ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			{ // Required
+				Name: aws.String("resource-id"),
+				Values: []*string{
+					aws.String(instanceID), // Required
+				},
+			},
+		},
+		MaxResults: aws.Int64(1000),
+	}
+	resp, err := s.svc.DescribeTags(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Tags, nil
+}
+
+// MetadataTagSource reads instance tags from the instance metadata, which only works when
+// access to tags in the instance metadata has been enabled for the instance
+type MetadataTagSource struct {
+	client MetadataClient
+}
+
+// NewMetadataTagSource returns a new MetadataTagSource
+func NewMetadataTagSource(client MetadataClient) *MetadataTagSource {
+	return &MetadataTagSource{client: client}
+}
+
+// Tags returns the tags set against an instance
+func (s MetadataTagSource) Tags(instanceID string) ([]*ec2.TagDescription, error) {
+
+	keys, err := s.client.GetMetadata(instanceTagsPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tags in the instance metadata : %w", err)
+	}
+
+	var tags []*ec2.TagDescription
+
+	for _, key := range strings.Split(keys, "\n") {
+
+		if key == "" {
+			continue
+		}
+
+		value, err := s.client.GetMetadata(path.Join(instanceTagsPath, key))
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to get tag '%s' from the instance metadata : %w", key, err)
+		}
+
+		tags = append(tags, &ec2.TagDescription{
+			Key:          aws.String(key),
+			ResourceId:   aws.String(instanceID),
+			ResourceType: aws.String(ec2.ResourceTypeInstance),
+			Value:        aws.String(value),
+		})
+	}
+
+	return tags, nil
+}
+
+// AutoTagSource reads instance tags from a preferred source, falling back to another when that fails
+type AutoTagSource struct {
+	preferred TagSource
+	fallback  TagSource
+	logger    *log.Logger
+}
+
+// NewAutoTagSource returns a new AutoTagSource
+func NewAutoTagSource(preferred TagSource, fallback TagSource) *AutoTagSource {
+	return &AutoTagSource{preferred: preferred, fallback: fallback}
+}
+
+// Tags returns the tags set against an instance
+func (s AutoTagSource) Tags(instanceID string) ([]*ec2.TagDescription, error) {
+
+	tags, err := s.preferred.Tags(instanceID)
+
+	if err == nil {
+		return tags, nil
+	}
+
+	s.logger.Debugf("Falling back to reading tags using DescribeTags : %v", err)
+
+	return s.fallback.Tags(instanceID)
+}
+
+// newTagSource returns the source of tags for a mode. Tags can only be read from the instance
+// metadata when the metadata given to the instance is also a MetadataClient.
+func newTagSource(logger *log.Logger, mode TagSourceMode, metadata interface{}, svc ec2iface.EC2API) (TagSource, error) {
+
+	api := NewDescribeTagsSource(svc)
+	client, ok := metadata.(MetadataClient)
+
+	switch {
+	case mode == TagSourceAPI:
+		return api, nil
+	case mode == TagSourceMetadata && ok:
+		return NewMetadataTagSource(client), nil
+	case mode == TagSourceMetadata:
+		return nil, fmt.Errorf("tags can't be read from the instance metadata using %T", metadata)
+	case mode == TagSourceAuto && ok:
+		source := NewAutoTagSource(NewMetadataTagSource(client), api)
+		source.logger = logger
+
+		return source, nil
+	case mode == TagSourceAuto:
+		return api, nil
+	default:
+		return nil, fmt.Errorf("unknown tag source '%s'", mode)
+	}
+}
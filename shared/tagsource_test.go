@@ -0,0 +1,171 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+// newMetadataServer stands in for the instance metadata service, serving the instance tags when given
+func newMetadataServer(t *testing.T, tags map[string]string) (*EC2InstanceMetadata, func()) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if tags == nil || !strings.HasPrefix(r.URL.Path, "/latest/meta-data/tags/instance") {
+			http.NotFound(w, r)
+			return
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/latest/meta-data/tags/instance"), "/")
+
+		if key == "" {
+			var keys []string
+			for k := range tags {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			fmt.Fprint(w, strings.Join(keys, "\n"))
+			return
+		}
+
+		value, ok := tags[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, value)
+	}))
+
+	sess, err := session.NewSession()
+	if err != nil {
+		t.Fatalf("Unable to create session : %v", err)
+	}
+
+	return NewEC2InstanceMetadata(sess, &aws.Config{Endpoint: aws.String(server.URL + "/latest")}), server.Close
+}
+
+func TestMetadataTagSource(t *testing.T) {
+
+	metadata, closer := newMetadataServer(t, map[string]string{
+		"volume_sdh":           "vol-12345678",
+		"ebs-volumes:size:sdh": "100",
+		"detach_volumes":       "true",
+	})
+	defer closer()
+
+	tags, err := NewMetadataTagSource(metadata).Tags("id-98765")
+
+	if err != nil {
+		t.Fatalf("Reading tags shouldn't have failed, but I got %v", err)
+	}
+
+	if len(tags) != 3 {
+		t.Fatalf("Expected 3 tags, but got %v", tags)
+	}
+
+	if *tags[2].Key != "volume_sdh" || *tags[2].Value != "vol-12345678" || *tags[2].ResourceId != "id-98765" {
+		t.Errorf("Expected tag volume_sdh = vol-12345678 for id-98765, but got %v", tags[2])
+	}
+}
+
+func TestMetadataTagSourceFailsWhenTagsNotEnabled(t *testing.T) {
+
+	metadata, closer := newMetadataServer(t, nil)
+	defer closer()
+
+	if _, err := NewMetadataTagSource(metadata).Tags("id-98765"); err == nil {
+		t.Error("Reading tags should have failed when tags aren't in the instance metadata")
+	}
+}
+
+func TestAutoTagSourcePrefersMetadata(t *testing.T) {
+
+	metadata, closer := newMetadataServer(t, map[string]string{"volume_sdh": "vol-12345678"})
+	defer closer()
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+	mockEC2Service.DescribeTagsFunc = func(*ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+		t.Error("DescribeTags shouldn't have been called")
+		return nil, errors.New("whoops")
+	}
+
+	source, err := newTagSource(nil, TagSourceAuto, metadata, mockEC2Service)
+	if err != nil {
+		t.Fatalf("Creating the tag source shouldn't have failed, but I got %v", err)
+	}
+
+	if tags, err := source.Tags("id-98765"); err != nil || len(tags) != 1 {
+		t.Errorf("Expected 1 tag from the instance metadata, but got %v, %v", tags, err)
+	}
+}
+
+func TestAutoTagSourceFallsBackToDescribeTags(t *testing.T) {
+
+	metadata, closer := newMetadataServer(t, nil)
+	defer closer()
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance("id-98765",
+		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sdh", "id-98765", "vol-12345678").Build())
+
+	source, err := newTagSource(nil, TagSourceAuto, metadata, mockEC2Service)
+	if err != nil {
+		t.Fatalf("Creating the tag source shouldn't have failed, but I got %v", err)
+	}
+
+	if tags, err := source.Tags("id-98765"); err != nil || len(tags) != 1 {
+		t.Errorf("Expected 1 tag from DescribeTags, but got %v, %v", tags, err)
+	}
+}
+
+func TestNewTagSource(t *testing.T) {
+
+	metadata := testhelpers.NewMockMetadata("id-98765", "erewhon")
+	mockEC2Service := testhelpers.NewMockEC2Service()
+
+	if source, err := newTagSource(nil, TagSourceAuto, metadata, mockEC2Service); err != nil {
+		t.Errorf("Auto should use DescribeTags when the metadata can't be read, but got %v", err)
+	} else if _, ok := source.(*DescribeTagsSource); !ok {
+		t.Errorf("Auto should use DescribeTags when the metadata can't be read, but got %T", source)
+	}
+
+	if _, err := newTagSource(nil, TagSourceMetadata, metadata, mockEC2Service); err == nil {
+		t.Error("Reading tags from metadata that can't be read should have failed")
+	}
+
+	if _, err := ParseTagSourceMode("somewhere"); err == nil {
+		t.Error("Parsing an unknown tag source should have failed")
+	}
+}
+
+func TestAllocatedVolumesFromMetadataTags(t *testing.T) {
+
+	metadata, closer := newMetadataServer(t, map[string]string{"volume_sdh": "vol-12345678"})
+	defer closer()
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+
+	underTest := NewEC2Instance(testhelpers.NewMockMetadata("id-98765", "erewhon"), mockEC2Service,
+		WithTagSource(NewMetadataTagSource(metadata)))
+
+	volumes, err := underTest.AllocatedVolumes()
+
+	if err != nil {
+		t.Fatalf("Shouldn't have failed : got error %s", err.Error())
+	}
+
+	if len(volumes) != 1 {
+		t.Fatalf("Should have got 1 allocated volume, but got %v", volumes)
+	}
+
+	assertVolumesEqual(t, volumes[0], NewAllocatedVolume("vol-12345678", "/dev/sdh", "id-98765", nil))
+}
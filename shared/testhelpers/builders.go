@@ -29,6 +29,42 @@ func (builder DescribeTagsOutputBuilder) WithVolume(DeviceName string, InstanceI
 	return builder
 }
 
+// WithSize adds a tag designating the size of the volume at a device
+func (builder DescribeTagsOutputBuilder) WithSize(DeviceName string, InstanceID string, Size string) DescribeTagsOutputBuilder {
+	builder.tagDescriptions = append(builder.tagDescriptions, &ec2.TagDescription{
+		Key:          aws.String(fmt.Sprintf("size_%s", DeviceName)),
+		ResourceId:   aws.String(InstanceID),
+		ResourceType: aws.String("instance"),
+		Value:        aws.String(Size),
+	})
+
+	return builder
+}
+
+// WithPerformance adds a tag designating the type and performance of the volume at a device
+func (builder DescribeTagsOutputBuilder) WithPerformance(DeviceName string, InstanceID string, Performance string) DescribeTagsOutputBuilder {
+	builder.tagDescriptions = append(builder.tagDescriptions, &ec2.TagDescription{
+		Key:          aws.String(fmt.Sprintf("perf_%s", DeviceName)),
+		ResourceId:   aws.String(InstanceID),
+		ResourceType: aws.String("instance"),
+		Value:        aws.String(Performance),
+	})
+
+	return builder
+}
+
+// WithTag adds an arbitrary tag
+func (builder DescribeTagsOutputBuilder) WithTag(InstanceID string, Key string, Value string) DescribeTagsOutputBuilder {
+	builder.tagDescriptions = append(builder.tagDescriptions, &ec2.TagDescription{
+		Key:          aws.String(Key),
+		ResourceId:   aws.String(InstanceID),
+		ResourceType: aws.String("instance"),
+		Value:        aws.String(Value),
+	})
+
+	return builder
+}
+
 // DetachVolumes sets the tag to indicate volumes should be detached
 func (builder DescribeTagsOutputBuilder) DetachVolumes(instanceID string) DescribeTagsOutputBuilder {
 	return builder.DetachVolumesValue(instanceID, "true")
@@ -55,7 +91,9 @@ func (builder DescribeTagsOutputBuilder) Build() *ec2.DescribeTagsOutput {
 
 // VolumeBuilder helps construct an ec2.Volume structure for humans
 type VolumeBuilder struct {
-	state *string
+	state            *string
+	size             *int64
+	availabilityZone *string
 }
 
 // NewVolumeBuilder returns a new VolumeBuilder
@@ -69,7 +107,19 @@ func (builder VolumeBuilder) SetState(state *string) VolumeBuilder {
 	return builder
 }
 
+// SetSize sets the size value
+func (builder VolumeBuilder) SetSize(size int64) VolumeBuilder {
+	builder.size = aws.Int64(size)
+	return builder
+}
+
+// SetAvailabilityZone sets the availability zone value
+func (builder VolumeBuilder) SetAvailabilityZone(availabilityZone string) VolumeBuilder {
+	builder.availabilityZone = aws.String(availabilityZone)
+	return builder
+}
+
 // Build returns a populated Volume structure
 func (builder VolumeBuilder) Build() *ec2.Volume {
-	return &ec2.Volume{State: builder.state}
+	return &ec2.Volume{State: builder.state, Size: builder.size, AvailabilityZone: builder.availabilityZone}
 }
@@ -0,0 +1,874 @@
+package ec2fake
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// namespace is the XML namespace of EC2 Query API responses
+const namespace = "http://ec2.amazonaws.com/doc/2016-11-15/"
+
+// actions are the EC2 Query API operations the fake answers
+var actions = map[string]func(*Server, url.Values) (interface{}, *Error){
+	"DescribeTags":                 (*Server).describeTags,
+	"DescribeInstances":            (*Server).describeInstances,
+	"DescribeVolumes":              (*Server).describeVolumes,
+	"AttachVolume":                 (*Server).attachVolume,
+	"DetachVolume":                 (*Server).detachVolume,
+	"CreateTags":                   (*Server).createTags,
+	"DeleteTags":                   (*Server).deleteTags,
+	"ModifyVolume":                 (*Server).modifyVolume,
+	"DescribeVolumesModifications": (*Server).describeVolumesModifications,
+}
+
+var errDryRun = &Error{StatusCode: 412, Code: "DryRunOperation", Message: "Request would have succeeded, but DryRun flag is set."}
+
+func (s *Server) serveEC2(w http.ResponseWriter, r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		s.writeError(w, &Error{StatusCode: 400, Code: "MalformedQueryString", Message: err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	action := r.Form.Get("Action")
+	s.calls[action]++
+	s.accessKeys[action] = accessKey(r)
+
+	if _, ok := stsActions[action]; ok {
+		s.current = s.nextStep(action, r.Form)
+		defer func() {
+			s.current = nil
+		}()
+
+		s.serveSTS(w, action, r.Form)
+		return
+	}
+
+	handler, ok := actions[action]
+
+	if !ok {
+		s.writeError(w, &Error{StatusCode: 400, Code: "InvalidAction", Message: fmt.Sprintf("The action %s is not valid for this web service.", action)})
+		return
+	}
+
+	if s.permitted != nil && !s.permitted["ec2:"+action] {
+		s.writeError(w, Unauthorized())
+		return
+	}
+
+	s.current = s.nextStep(action, r.Form)
+	defer func() {
+		s.transition(s.current, r.Form)
+		s.current = nil
+	}()
+
+	if s.current != nil && s.current.step.Err != nil {
+		s.writeError(w, s.current.step.Err)
+		return
+	}
+
+	if strings.HasPrefix(action, "Describe") && dryRun(r.Form) {
+		s.writeError(w, errDryRun)
+		return
+	}
+
+	for _, v := range s.volumes {
+		if !s.current.holds(v) {
+			s.settle(v)
+		}
+	}
+
+	body, err := handler(s, r.Form)
+
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+
+	start := xml.StartElement{Name: xml.Name{Space: namespace, Local: action + "Response"}}
+
+	if err := xml.NewEncoder(w).EncodeElement(body, start); err != nil {
+		panic(fmt.Sprintf("unable to encode %s response : %v", action, err))
+	}
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Response"`
+	Code      string   `xml:"Errors>Error>Code"`
+	Message   string   `xml:"Errors>Error>Message"`
+	RequestID string   `xml:"RequestID"`
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err *Error) {
+
+	s.requests++
+
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+	w.WriteHeader(err.StatusCode)
+
+	xml.NewEncoder(w).Encode(&errorResponse{Code: err.Code, Message: err.Message, RequestID: fmt.Sprintf("ec2fake-%d", s.requests)})
+}
+
+// Responses, named as the EC2 Query API names them
+
+type tagItem struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+type tagDescriptionItem struct {
+	ResourceID   string `xml:"resourceId"`
+	ResourceType string `xml:"resourceType"`
+	Key          string `xml:"key"`
+	Value        string `xml:"value"`
+}
+
+type attachmentItem struct {
+	VolumeID   string    `xml:"volumeId"`
+	InstanceID string    `xml:"instanceId"`
+	Device     string    `xml:"device"`
+	Status     string    `xml:"status"`
+	AttachTime time.Time `xml:"attachTime"`
+}
+
+type volumeItem struct {
+	VolumeID         string           `xml:"volumeId"`
+	Size             int64            `xml:"size"`
+	AvailabilityZone string           `xml:"availabilityZone"`
+	Status           string           `xml:"status"`
+	CreateTime       time.Time        `xml:"createTime"`
+	Attachments      []attachmentItem `xml:"attachmentSet>item"`
+	Tags             []tagItem        `xml:"tagSet>item"`
+	VolumeType       string           `xml:"volumeType"`
+	Iops             int64            `xml:"iops,omitempty"`
+	Throughput       int64            `xml:"throughput,omitempty"`
+	Encrypted        bool             `xml:"encrypted"`
+	KmsKeyID         string           `xml:"kmsKeyId,omitempty"`
+}
+
+type blockDeviceItem struct {
+	DeviceName string `xml:"deviceName"`
+	VolumeID   string `xml:"ebs>volumeId"`
+	Status     string `xml:"ebs>status"`
+}
+
+type instanceItem struct {
+	InstanceID          string            `xml:"instanceId"`
+	StateCode           int               `xml:"instanceState>code"`
+	StateName           string            `xml:"instanceState>name"`
+	AvailabilityZone    string            `xml:"placement>availabilityZone"`
+	RootDeviceName      string            `xml:"rootDeviceName"`
+	BlockDeviceMappings []blockDeviceItem `xml:"blockDeviceMapping>item"`
+	Tags                []tagItem         `xml:"tagSet>item"`
+}
+
+type reservationItem struct {
+	ReservationID string         `xml:"reservationId"`
+	OwnerID       string         `xml:"ownerId"`
+	Instances     []instanceItem `xml:"instancesSet>item"`
+}
+
+type modificationItem struct {
+	VolumeID           string     `xml:"volumeId"`
+	ModificationState  string     `xml:"modificationState"`
+	Progress           int64      `xml:"progress"`
+	StartTime          time.Time  `xml:"startTime"`
+	EndTime            *time.Time `xml:"endTime,omitempty"`
+	OriginalSize       int64      `xml:"originalSize"`
+	OriginalVolumeType string     `xml:"originalVolumeType"`
+	OriginalIops       int64      `xml:"originalIops,omitempty"`
+	OriginalThroughput int64      `xml:"originalThroughput,omitempty"`
+	TargetSize         int64      `xml:"targetSize"`
+	TargetVolumeType   string     `xml:"targetVolumeType"`
+	TargetIops         int64      `xml:"targetIops,omitempty"`
+	TargetThroughput   int64      `xml:"targetThroughput,omitempty"`
+}
+
+type returnResponse struct {
+	Return bool `xml:"return"`
+}
+
+// Parameters, flattened as the EC2 Query API flattens them
+
+// list returns the members of a list parameter, such as VolumeId.1, VolumeId.2
+func list(form url.Values, name string) []string {
+
+	var members []string
+
+	for i := 1; ; i++ {
+		member, ok := form[name+"."+strconv.Itoa(i)]
+
+		if !ok {
+			return members
+		}
+
+		members = append(members, member[0])
+	}
+}
+
+type filter struct {
+	name   string
+	values []string
+}
+
+// filters returns the filters given, such as Filter.1.Name, Filter.1.Value.1
+func filters(form url.Values) []filter {
+
+	var parsed []filter
+
+	for i := 1; ; i++ {
+		prefix := "Filter." + strconv.Itoa(i)
+		name := form.Get(prefix + ".Name")
+
+		if name == "" {
+			return parsed
+		}
+
+		parsed = append(parsed, filter{name: name, values: list(form, prefix+".Value")})
+	}
+}
+
+// matches returns true if the filter has any of the values, where values may end with a * wildcard
+func (f filter) matches(values ...string) bool {
+
+	for _, want := range f.values {
+		for _, value := range values {
+			if want == value || strings.HasSuffix(want, "*") && strings.HasPrefix(value, strings.TrimSuffix(want, "*")) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchesTags applies a tag filter, returning false for filters that aren't about tags
+func (f filter) matchesTags(tags map[string]string) (matched bool, ok bool) {
+
+	switch {
+	case f.name == "tag-key":
+		return f.matches(sortedKeys(tags)...), true
+	case strings.HasPrefix(f.name, "tag:"):
+		value, tagged := tags[f.name[len("tag:"):]]
+		return tagged && f.matches(value), true
+	}
+
+	return false, false
+}
+
+func invalidFilter(f filter) *Error {
+	return &Error{StatusCode: 400, Code: "InvalidParameterValue", Message: fmt.Sprintf("The filter '%s' is invalid", f.name)}
+}
+
+type tagParameter struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// tagParameters returns the tags given, such as Tag.1.Key, Tag.1.Value
+func tagParameters(form url.Values) []tagParameter {
+
+	var tags []tagParameter
+
+	for i := 1; ; i++ {
+		prefix := "Tag." + strconv.Itoa(i)
+		key, ok := form[prefix+".Key"]
+
+		if !ok {
+			return tags
+		}
+
+		value, hasValue := form[prefix+".Value"]
+		tag := tagParameter{key: key[0], hasValue: hasValue}
+
+		if hasValue {
+			tag.value = value[0]
+		}
+
+		tags = append(tags, tag)
+	}
+}
+
+func int64Parameter(form url.Values, name string) (int64, *Error) {
+
+	value := form.Get(name)
+
+	if value == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil {
+		return 0, &Error{StatusCode: 400, Code: "InvalidParameterValue", Message: fmt.Sprintf("Invalid value '%s' for %s", value, name)}
+	}
+
+	return parsed, nil
+}
+
+func dryRun(form url.Values) bool {
+	return form.Get("DryRun") == "true"
+}
+
+// Operations
+
+func (s *Server) volume(volumeID string) (*volume, *Error) {
+
+	if v, ok := s.volumes[volumeID]; ok {
+		return v, nil
+	}
+
+	return nil, &Error{StatusCode: 400, Code: "InvalidVolume.NotFound", Message: fmt.Sprintf("The volume '%s' does not exist.", volumeID)}
+}
+
+func (s *Server) instance(instanceID string) (*Instance, *Error) {
+
+	if instance, ok := s.instances[instanceID]; ok {
+		return instance, nil
+	}
+
+	return nil, &Error{StatusCode: 400, Code: "InvalidInstanceID.NotFound", Message: fmt.Sprintf("The instance ID '%s' does not exist", instanceID)}
+}
+
+func (s *Server) describeTags(form url.Values) (interface{}, *Error) {
+
+	var resources []string
+
+	for id := range s.instances {
+		resources = append(resources, id)
+	}
+
+	for id := range s.volumes {
+		resources = append(resources, id)
+	}
+
+	sort.Strings(resources)
+
+	var items []tagDescriptionItem
+
+	for _, id := range resources {
+
+		tags, _ := s.tagsOf(id)
+		resourceType := "instance"
+
+		if _, ok := s.volumes[id]; ok {
+			resourceType = "volume"
+		}
+
+	tags:
+		for _, key := range sortedKeys(tags) {
+
+			for _, f := range filters(form) {
+
+				var matched bool
+
+				switch f.name {
+				case "resource-id":
+					matched = f.matches(id)
+				case "resource-type":
+					matched = f.matches(resourceType)
+				case "key":
+					matched = f.matches(key)
+				case "value":
+					matched = f.matches(tags[key])
+				default:
+					return nil, invalidFilter(f)
+				}
+
+				if !matched {
+					continue tags
+				}
+			}
+
+			items = append(items, tagDescriptionItem{ResourceID: id, ResourceType: resourceType, Key: key, Value: tags[key]})
+		}
+	}
+
+	return &struct {
+		Tags []tagDescriptionItem `xml:"tagSet>item"`
+	}{items}, nil
+}
+
+func (s *Server) describeInstances(form url.Values) (interface{}, *Error) {
+
+	ids := list(form, "InstanceId")
+
+	if len(ids) == 0 {
+		for id := range s.instances {
+			ids = append(ids, id)
+		}
+
+		sort.Strings(ids)
+	}
+
+	var reservations []reservationItem
+
+instances:
+	for _, id := range ids {
+
+		instance, err := s.instance(id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range filters(form) {
+
+			var matched bool
+
+			switch f.name {
+			case "instance-id":
+				matched = f.matches(instance.ID)
+			case "availability-zone":
+				matched = f.matches(instance.AvailabilityZone)
+			case "instance-state-name":
+				matched = f.matches("running")
+			default:
+				var ok bool
+
+				if matched, ok = f.matchesTags(instance.Tags); !ok {
+					return nil, invalidFilter(f)
+				}
+			}
+
+			if !matched {
+				continue instances
+			}
+		}
+
+		item := instanceItem{
+			InstanceID:       instance.ID,
+			StateCode:        16,
+			StateName:        "running",
+			AvailabilityZone: instance.AvailabilityZone,
+			RootDeviceName:   instance.RootDeviceName,
+		}
+
+		for _, v := range s.sortedVolumes() {
+			if a := v.attachment; a != nil && a.instanceID == instance.ID {
+				item.BlockDeviceMappings = append(item.BlockDeviceMappings, blockDeviceItem{DeviceName: a.device, VolumeID: v.ID, Status: a.status})
+			}
+		}
+
+		for _, key := range sortedKeys(instance.Tags) {
+			item.Tags = append(item.Tags, tagItem{Key: key, Value: instance.Tags[key]})
+		}
+
+		reservations = append(reservations, reservationItem{ReservationID: "r-" + strings.TrimPrefix(instance.ID, "i-"), OwnerID: AccountID, Instances: []instanceItem{item}})
+	}
+
+	return &struct {
+		Reservations []reservationItem `xml:"reservationSet>item"`
+	}{reservations}, nil
+}
+
+func (s *Server) sortedVolumes() []*volume {
+
+	var volumes []*volume
+
+	for _, v := range s.volumes {
+		volumes = append(volumes, v)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].ID < volumes[j].ID
+	})
+
+	return volumes
+}
+
+func (s *Server) describeVolumes(form url.Values) (interface{}, *Error) {
+
+	var volumes []*volume
+
+	if ids := list(form, "VolumeId"); len(ids) > 0 {
+		for _, id := range ids {
+
+			v, err := s.volume(id)
+
+			if err != nil {
+				return nil, err
+			}
+
+			volumes = append(volumes, v)
+		}
+	} else {
+		volumes = s.sortedVolumes()
+	}
+
+	var items []volumeItem
+
+volumes:
+	for _, v := range volumes {
+
+		if s.current.stale(v) {
+			v = v.previous
+		}
+
+		for _, f := range filters(form) {
+
+			matched, err := v.matches(f)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !matched {
+				continue volumes
+			}
+		}
+
+		items = append(items, v.describe())
+	}
+
+	return &struct {
+		Volumes []volumeItem `xml:"volumeSet>item"`
+	}{items}, nil
+}
+
+// matches applies a DescribeVolumes filter to the volume
+func (v *volume) matches(f filter) (bool, *Error) {
+
+	a := v.attachment
+
+	if a == nil {
+		a = &attachment{}
+	}
+
+	switch f.name {
+	case "volume-id":
+		return f.matches(v.ID), nil
+	case "availability-zone":
+		return f.matches(v.AvailabilityZone), nil
+	case "status":
+		return f.matches(v.state()), nil
+	case "size":
+		return f.matches(strconv.FormatInt(v.Size, 10)), nil
+	case "volume-type":
+		return f.matches(v.VolumeType), nil
+	case "encrypted":
+		return f.matches(strconv.FormatBool(v.Encrypted)), nil
+	case "attachment.instance-id":
+		return v.attachment != nil && f.matches(a.instanceID), nil
+	case "attachment.device":
+		return v.attachment != nil && f.matches(a.device), nil
+	case "attachment.status":
+		return v.attachment != nil && f.matches(a.status), nil
+	}
+
+	if matched, ok := f.matchesTags(v.Tags); ok {
+		return matched, nil
+	}
+
+	return false, invalidFilter(f)
+}
+
+func (v *volume) describe() volumeItem {
+
+	item := volumeItem{
+		VolumeID:         v.ID,
+		Size:             v.Size,
+		AvailabilityZone: v.AvailabilityZone,
+		Status:           v.state(),
+		CreateTime:       v.created.UTC(),
+		VolumeType:       v.VolumeType,
+		Iops:             v.Iops,
+		Throughput:       v.Throughput,
+		Encrypted:        v.Encrypted,
+		KmsKeyID:         v.KmsKeyID,
+	}
+
+	if a := v.attachment; a != nil {
+		item.Attachments = []attachmentItem{{VolumeID: v.ID, InstanceID: a.instanceID, Device: a.device, Status: a.status, AttachTime: a.changed.UTC()}}
+	}
+
+	for _, key := range sortedKeys(v.Tags) {
+		item.Tags = append(item.Tags, tagItem{Key: key, Value: v.Tags[key]})
+	}
+
+	return item
+}
+
+func (s *Server) attachVolume(form url.Values) (interface{}, *Error) {
+
+	v, err := s.volume(form.Get("VolumeId"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := s.instance(form.Get("InstanceId"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Dry runs only check permissions, and not whether the volume could be attached
+	if dryRun(form) {
+		return nil, errDryRun
+	}
+
+	device := form.Get("Device")
+
+	if v.AvailabilityZone != instance.AvailabilityZone {
+		return nil, &Error{StatusCode: 400, Code: "InvalidVolume.ZoneMismatch",
+			Message: fmt.Sprintf("The volume '%s' is not in the same availability zone as instance '%s'", v.ID, instance.ID)}
+	}
+
+	if v.attachment != nil {
+		return nil, &Error{StatusCode: 400, Code: "VolumeInUse", Message: fmt.Sprintf("%s is already attached to an instance", v.ID)}
+	}
+
+	inUse := device == instance.RootDeviceName
+
+	for _, other := range s.volumes {
+		if a := other.attachment; a != nil && a.instanceID == instance.ID && a.device == device {
+			inUse = true
+		}
+	}
+
+	if inUse {
+		return nil, &Error{StatusCode: 400, Code: "InvalidParameterValue",
+			Message: fmt.Sprintf("Invalid value '%s' for unixDevice. Attachment point %s is already in use", device, device)}
+	}
+
+	v.remember()
+	v.attachment = &attachment{instanceID: instance.ID, device: device, status: attaching, changed: s.now()}
+
+	return v.describe().Attachments[0], nil
+}
+
+func (s *Server) detachVolume(form url.Values) (interface{}, *Error) {
+
+	v, err := s.volume(form.Get("VolumeId"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Dry runs only check permissions, and not whether the volume could be detached
+	if dryRun(form) {
+		return nil, errDryRun
+	}
+
+	if v.attachment == nil || v.attachment.status == detaching {
+		return nil, &Error{StatusCode: 400, Code: "IncorrectState", Message: fmt.Sprintf("Volume '%s' is in the 'available' state.", v.ID)}
+	}
+
+	if instanceID := form.Get("InstanceId"); instanceID != "" && instanceID != v.attachment.instanceID {
+		return nil, &Error{StatusCode: 400, Code: "InvalidAttachment.NotFound",
+			Message: fmt.Sprintf("Volume '%s' can not be detached from '%s' because it is not attached", v.ID, instanceID)}
+	}
+
+	v.remember()
+	v.attachment.status, v.attachment.changed = detaching, s.now()
+
+	return v.describe().Attachments[0], nil
+}
+
+// remember keeps a volume as it is before its tags are changed
+func (s *Server) remember(resourceID string) {
+	if v, ok := s.volumes[resourceID]; ok {
+		v.remember()
+	}
+}
+
+func (s *Server) createTags(form url.Values) (interface{}, *Error) {
+
+	resources := list(form, "ResourceId")
+
+	for _, id := range resources {
+		if _, err := s.tagsOf(id); err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun(form) {
+		return nil, errDryRun
+	}
+
+	for _, id := range resources {
+
+		s.remember(id)
+		tags, _ := s.tagsOf(id)
+
+		for _, tag := range tagParameters(form) {
+			tags[tag.key] = tag.value
+		}
+	}
+
+	return &returnResponse{Return: true}, nil
+}
+
+func (s *Server) deleteTags(form url.Values) (interface{}, *Error) {
+
+	resources := list(form, "ResourceId")
+
+	for _, id := range resources {
+		if _, err := s.tagsOf(id); err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun(form) {
+		return nil, errDryRun
+	}
+
+	for _, id := range resources {
+
+		s.remember(id)
+		tags, _ := s.tagsOf(id)
+		given := tagParameters(form)
+
+		if len(given) == 0 {
+			for key := range tags {
+				delete(tags, key)
+			}
+		}
+
+		for _, tag := range given {
+			if !tag.hasValue || tags[tag.key] == tag.value {
+				delete(tags, tag.key)
+			}
+		}
+	}
+
+	return &returnResponse{Return: true}, nil
+}
+
+func (s *Server) modifyVolume(form url.Values) (interface{}, *Error) {
+
+	v, err := s.volume(form.Get("VolumeId"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if v.modification != nil && v.modification.state == modifying {
+		return nil, &Error{StatusCode: 400, Code: "IncorrectModificationState",
+			Message: fmt.Sprintf("Cannot modify volume %s as it is already being modified", v.ID)}
+	}
+
+	m := &modification{
+		state:              modifying,
+		started:            s.now(),
+		originalSize:       v.Size,
+		originalVolumeType: v.VolumeType,
+		originalIops:       v.Iops,
+		originalThroughput: v.Throughput,
+		targetSize:         v.Size,
+		targetVolumeType:   v.VolumeType,
+		targetIops:         v.Iops,
+		targetThroughput:   v.Throughput,
+	}
+
+	if size, err := int64Parameter(form, "Size"); err != nil {
+		return nil, err
+	} else if size != 0 {
+		if size < v.Size {
+			return nil, &Error{StatusCode: 400, Code: "InvalidParameterValue",
+				Message: fmt.Sprintf("New size cannot be smaller than existing size of %d GiB", v.Size)}
+		}
+		m.targetSize = size
+	}
+
+	if volumeType := form.Get("VolumeType"); volumeType != "" {
+		m.targetVolumeType = volumeType
+	}
+
+	if iops, err := int64Parameter(form, "Iops"); err != nil {
+		return nil, err
+	} else if iops != 0 {
+		m.targetIops = iops
+	}
+
+	if throughput, err := int64Parameter(form, "Throughput"); err != nil {
+		return nil, err
+	} else if throughput != 0 {
+		m.targetThroughput = throughput
+	}
+
+	if dryRun(form) {
+		return nil, errDryRun
+	}
+
+	v.remember()
+	v.modification = m
+
+	return &struct {
+		Modification modificationItem `xml:"volumeModification"`
+	}{v.describeModification()}, nil
+}
+
+func (v *volume) describeModification() modificationItem {
+
+	m := v.modification
+
+	item := modificationItem{
+		VolumeID:           v.ID,
+		ModificationState:  m.state,
+		StartTime:          m.started.UTC(),
+		OriginalSize:       m.originalSize,
+		OriginalVolumeType: m.originalVolumeType,
+		OriginalIops:       m.originalIops,
+		OriginalThroughput: m.originalThroughput,
+		TargetSize:         m.targetSize,
+		TargetVolumeType:   m.targetVolumeType,
+		TargetIops:         m.targetIops,
+		TargetThroughput:   m.targetThroughput,
+	}
+
+	if m.state == completed {
+		ended := m.ended.UTC()
+		item.Progress, item.EndTime = 100, &ended
+	}
+
+	return item
+}
+
+func (s *Server) describeVolumesModifications(form url.Values) (interface{}, *Error) {
+
+	var items []modificationItem
+
+	ids := list(form, "VolumeId")
+
+	if len(ids) == 0 {
+		for _, v := range s.sortedVolumes() {
+			if v.modification != nil {
+				ids = append(ids, v.ID)
+			}
+		}
+	}
+
+	for _, id := range ids {
+
+		v, err := s.volume(id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if v.modification == nil {
+			return nil, &Error{StatusCode: 400, Code: "InvalidVolumeModification.NotFound",
+				Message: fmt.Sprintf("Modification for volume '%s' does not exist.", id)}
+		}
+
+		items = append(items, v.describeModification())
+	}
+
+	return &struct {
+		Modifications []modificationItem `xml:"volumeModificationSet>item"`
+	}{items}, nil
+}
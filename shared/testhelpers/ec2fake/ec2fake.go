@@ -0,0 +1,427 @@
+// Package ec2fake is an in-process stand-in for the EC2 API and the instance metadata service, so the
+// AWS SDK's own EC2 client and the imds client can be exercised end to end in tests without AWS.
+//
+// It models instances, volumes, their attachments and modifications and the tags on both, answering
+// the EC2 Query API operations ebs-volumes uses. Roles can be assumed through STS at the same endpoint. Volumes move between states after configurable delays,
+// and scenarios can be scripted for any operation, such as throttling, errors, volumes stuck in a state
+// or reads that don't yet show the latest change.
+package ec2fake
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
+	"github.com/sneakybeaky/ebs-volumes/shared/imds"
+)
+
+// AccountID is the account instances and volumes belong to
+const AccountID = "123456789012"
+
+// Attachment and modification states
+const (
+	attaching = "attaching"
+	attached  = "attached"
+	detaching = "detaching"
+
+	modifying = "modifying"
+	completed = "completed"
+)
+
+// Instance describes an instance to add to the fake
+type Instance struct {
+	ID               string
+	AvailabilityZone string
+	// RootDeviceName is /dev/xvda unless given
+	RootDeviceName string
+	Tags           map[string]string
+	// TagsInMetadata allows the instance tags to be read from the instance metadata
+	TagsInMetadata bool
+}
+
+// Volume describes a volume to add to the fake
+type Volume struct {
+	ID               string
+	AvailabilityZone string
+	// Size is in GiB, and is 8 unless given
+	Size int64
+	// VolumeType is gp2 unless given
+	VolumeType string
+	Iops       int64
+	Throughput int64
+	Encrypted  bool
+	KmsKeyID   string
+	Tags       map[string]string
+}
+
+// Delays are how long volumes take to move between states. Volumes move as soon as they're next
+// described when there's no delay.
+type Delays struct {
+	// Attach is how long a volume is attaching before it's attached
+	Attach time.Duration
+	// Detach is how long a volume is detaching before it's available
+	Detach time.Duration
+	// Modify is how long a modification is modifying before it's completed
+	Modify time.Duration
+}
+
+// Error is an error response from the EC2 API
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+type attachment struct {
+	instanceID string
+	device     string
+	status     string
+	changed    time.Time
+}
+
+type modification struct {
+	state                                          string
+	started, ended                                 time.Time
+	originalSize, originalIops, originalThroughput int64
+	targetSize, targetIops, targetThroughput       int64
+	originalVolumeType, targetVolumeType           string
+}
+
+type volume struct {
+	Volume
+	created      time.Time
+	attachment   *attachment
+	modification *modification
+	// previous is the volume as it was before the last call changing it
+	previous *volume
+}
+
+// remember keeps the volume as it is, before it's changed
+func (v *volume) remember() {
+
+	previous := *v
+	previous.Tags = copyTags(v.Tags)
+	previous.previous = nil
+
+	if v.attachment != nil {
+		a := *v.attachment
+		previous.attachment = &a
+	}
+
+	if v.modification != nil {
+		m := *v.modification
+		previous.modification = &m
+	}
+
+	v.previous = &previous
+}
+
+// Server serves the EC2 Query API and the instance metadata service. The instance metadata describes
+// the first instance added, unless another is chosen with ServeMetadataFor.
+type Server struct {
+	ec2  *httptest.Server
+	imds *httptest.Server
+
+	mu               sync.Mutex
+	region           string
+	now              func() time.Time
+	delays           Delays
+	instances        map[string]*Instance
+	volumes          map[string]*volume
+	metadataInstance string
+	scenarios        []*scenario
+	current          *call
+	calls            map[string]int
+	accessKeys       map[string]string
+	requests         int
+	roles            map[string]*Role
+	sessions         []AssumedRole
+	// permitted are the EC2 actions allowed, or nil if all are
+	permitted map[string]bool
+}
+
+// New starts a fake for a region, which should be closed once finished with
+func New(region string) *Server {
+
+	s := &Server{
+		region:     region,
+		now:        time.Now,
+		instances:  make(map[string]*Instance),
+		volumes:    make(map[string]*volume),
+		calls:      make(map[string]int),
+		accessKeys: make(map[string]string),
+		roles:      make(map[string]*Role),
+	}
+
+	s.ec2 = httptest.NewServer(http.HandlerFunc(s.serveEC2))
+	s.imds = httptest.NewServer(http.HandlerFunc(s.serveMetadata))
+
+	return s
+}
+
+// Permit allows only the EC2 actions given, named as in IAM policies such as ec2:AttachVolume. Other
+// calls, and dry runs of them, fail as though the permission hadn't been granted.
+func (s *Server) Permit(actions ...string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permitted = make(map[string]bool)
+
+	for _, action := range actions {
+		s.permitted[action] = true
+	}
+}
+
+// Close stops the fake
+func (s *Server) Close() {
+	s.ec2.Close()
+	s.imds.Close()
+}
+
+// Endpoint returns the URL of the EC2 API
+func (s *Server) Endpoint() string {
+	return s.ec2.URL
+}
+
+// MetadataEndpoint returns the URL of the instance metadata service
+func (s *Server) MetadataEndpoint() string {
+	return s.imds.URL
+}
+
+// AWSConfig returns the config for an EC2 client using the fake, with static credentials and without retries
+func (s *Server) AWSConfig() *aws.Config {
+	return aws.NewConfig().
+		WithEndpoint(s.ec2.URL).
+		WithRegion(s.region).
+		WithCredentials(credentials.NewStaticCredentials("AKIDEC2FAKE", "secret", "")).
+		WithMaxRetries(0)
+}
+
+// Client returns an EC2 client using the fake, which doesn't wait between checks on volumes being attached
+// or detached. Configs are applied after the fake's own.
+func (s *Server) Client(configs ...*aws.Config) *ec2ext.EC2 {
+
+	svc := ec2ext.New(session.New(append([]*aws.Config{s.AWSConfig()}, configs...)...))
+	svc.SetWaiterDelay(0)
+
+	return svc
+}
+
+// Metadata returns a client for the fake instance metadata service
+func (s *Server) Metadata() *imds.Client {
+	return imds.New(imds.WithEndpoint(s.imds.URL))
+}
+
+// SetDelays sets how long volumes take to move between states
+func (s *Server) SetDelays(delays Delays) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays = delays
+}
+
+// SetClock sets the clock used to move volumes between states, instead of time.Now
+func (s *Server) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// AddInstance adds a running instance
+func (s *Server) AddInstance(instance Instance) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if instance.RootDeviceName == "" {
+		instance.RootDeviceName = "/dev/xvda"
+	}
+
+	instance.Tags = copyTags(instance.Tags)
+	s.instances[instance.ID] = &instance
+
+	if s.metadataInstance == "" {
+		s.metadataInstance = instance.ID
+	}
+}
+
+// ServeMetadataFor chooses the instance described by the instance metadata
+func (s *Server) ServeMetadataFor(instanceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metadataInstance = instanceID
+}
+
+// AddVolume adds an available volume
+func (s *Server) AddVolume(v Volume) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v.Size == 0 {
+		v.Size = 8
+	}
+
+	if v.VolumeType == "" {
+		v.VolumeType = ec2.VolumeTypeGp2
+	}
+
+	v.Tags = copyTags(v.Tags)
+	s.volumes[v.ID] = &volume{Volume: v, created: s.now()}
+}
+
+// AttachVolume attaches a volume to an instance straight away, as though it had been attached earlier
+func (s *Server) AttachVolume(volumeID string, instanceID string, device string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.volumes[volumeID].attachment = &attachment{instanceID: instanceID, device: device, status: attached, changed: s.now()}
+}
+
+// Calls returns how many times an action has been called
+func (s *Server) Calls(action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[action]
+}
+
+// Volume returns a volume as it would currently be described, or nil if there's no such volume
+func (s *Server) Volume(volumeID string) *ec2.Volume {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.volumes[volumeID]
+
+	if !ok {
+		return nil
+	}
+
+	s.settle(v)
+
+	described := &ec2.Volume{
+		VolumeId:         aws.String(v.ID),
+		AvailabilityZone: aws.String(v.AvailabilityZone),
+		Size:             aws.Int64(v.Size),
+		VolumeType:       aws.String(v.VolumeType),
+		State:            aws.String(v.state()),
+		Encrypted:        aws.Bool(v.Encrypted),
+		CreateTime:       aws.Time(v.created),
+	}
+
+	if v.KmsKeyID != "" {
+		described.KmsKeyId = aws.String(v.KmsKeyID)
+	}
+
+	if a := v.attachment; a != nil {
+		described.Attachments = []*ec2.VolumeAttachment{{
+			VolumeId:   aws.String(v.ID),
+			InstanceId: aws.String(a.instanceID),
+			Device:     aws.String(a.device),
+			State:      aws.String(a.status),
+			AttachTime: aws.Time(a.changed),
+		}}
+	}
+
+	for _, key := range sortedKeys(v.Tags) {
+		described.Tags = append(described.Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(v.Tags[key])})
+	}
+
+	return described
+}
+
+// Tags returns the tags on an instance or volume
+func (s *Server) Tags(resourceID string) map[string]string {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags, err := s.tagsOf(resourceID)
+
+	if err != nil {
+		return nil
+	}
+
+	return copyTags(tags)
+}
+
+// settle moves a volume on to the states it has reached since its last change
+func (s *Server) settle(v *volume) {
+
+	now := s.now()
+
+	if a := v.attachment; a != nil {
+		switch {
+		case a.status == attaching && !now.Before(a.changed.Add(s.delays.Attach)):
+			a.status, a.changed = attached, now
+		case a.status == detaching && !now.Before(a.changed.Add(s.delays.Detach)):
+			v.attachment = nil
+		}
+	}
+
+	if m := v.modification; m != nil && m.state == modifying && !now.Before(m.started.Add(s.delays.Modify)) {
+		m.state, m.ended = completed, now
+		v.Size, v.VolumeType, v.Iops, v.Throughput = m.targetSize, m.targetVolumeType, m.targetIops, m.targetThroughput
+	}
+}
+
+// state returns the state of the volume, which is in use while it's attached or moving between states
+func (v *volume) state() string {
+
+	if v.attachment != nil {
+		return ec2.VolumeStateInUse
+	}
+
+	return ec2.VolumeStateAvailable
+}
+
+// tagsOf returns the tags on an instance or volume
+func (s *Server) tagsOf(resourceID string) (map[string]string, *Error) {
+
+	if v, ok := s.volumes[resourceID]; ok {
+		return v.Tags, nil
+	}
+
+	if instance, ok := s.instances[resourceID]; ok {
+		return instance.Tags, nil
+	}
+
+	return nil, &Error{StatusCode: 400, Code: "InvalidID", Message: fmt.Sprintf("The ID '%s' is not valid", resourceID)}
+}
+
+func copyTags(tags map[string]string) map[string]string {
+
+	copied := make(map[string]string, len(tags))
+
+	for key, value := range tags {
+		copied[key] = value
+	}
+
+	return copied
+}
+
+func sortedKeys(m map[string]string) []string {
+
+	var keys []string
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,381 @@
+package ec2fake_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
+	"github.com/sneakybeaky/ebs-volumes/shared/imds"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers/ec2fake"
+)
+
+func newFake(t *testing.T) (*ec2fake.Server, *ec2ext.EC2) {
+
+	fake := ec2fake.New("erewhon")
+	t.Cleanup(fake.Close)
+
+	fake.AddInstance(ec2fake.Instance{ID: "i-0123456789abcdef0", AvailabilityZone: "erewhona", Tags: map[string]string{"role": "db"}})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-11111111", AvailabilityZone: "erewhona", Tags: map[string]string{"pool": "db"}})
+	fake.AddVolume(ec2fake.Volume{ID: "vol-22222222", AvailabilityZone: "erewhonb"})
+
+	sess, err := session.NewSession(fake.AWSConfig())
+
+	if err != nil {
+		t.Fatalf("Unable to create session : %v", err)
+	}
+
+	return fake, ec2ext.New(sess)
+}
+
+func TestAttachmentMovesBetweenStatesAfterDelays(t *testing.T) {
+
+	fake, svc := newFake(t)
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	fake.SetClock(func() time.Time { return now })
+	fake.SetDelays(ec2fake.Delays{Attach: time.Minute, Detach: time.Minute})
+
+	_, err := svc.AttachVolume(&ec2.AttachVolumeInput{
+		VolumeId: aws.String("vol-11111111"), InstanceId: aws.String("i-0123456789abcdef0"), Device: aws.String("/dev/sdf"),
+	})
+
+	if err != nil {
+		t.Fatalf("Attaching shouldn't have failed, but I got %v", err)
+	}
+
+	attachedInput := &ec2.DescribeVolumesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("attachment.instance-id"), Values: aws.StringSlice([]string{"i-0123456789abcdef0"})},
+		{Name: aws.String("attachment.status"), Values: aws.StringSlice([]string{"attached"})},
+	}}
+
+	if resp, err := svc.DescribeVolumes(attachedInput); err != nil || len(resp.Volumes) != 0 {
+		t.Errorf("Expected the volume to still be attaching, but got %v, %v", resp, err)
+	}
+
+	now = now.Add(time.Minute)
+
+	resp, err := svc.DescribeVolumes(attachedInput)
+
+	if err != nil || len(resp.Volumes) != 1 || *resp.Volumes[0].State != ec2.VolumeStateInUse || *resp.Volumes[0].Attachments[0].Device != "/dev/sdf" {
+		t.Fatalf("Expected the volume to be attached, but got %v, %v", resp, err)
+	}
+
+	if _, err := svc.DetachVolume(&ec2.DetachVolumeInput{VolumeId: aws.String("vol-11111111")}); err != nil {
+		t.Fatalf("Detaching shouldn't have failed, but I got %v", err)
+	}
+
+	if state := *fake.Volume("vol-11111111").Attachments[0].State; state != "detaching" {
+		t.Errorf("Expected the volume to be detaching, but it's %s", state)
+	}
+
+	now = now.Add(time.Minute)
+
+	if volume := fake.Volume("vol-11111111"); *volume.State != ec2.VolumeStateAvailable || len(volume.Attachments) != 0 {
+		t.Errorf("Expected the volume to be available, but got %v", volume)
+	}
+}
+
+func TestErrorsAreReturnedAsEC2Errors(t *testing.T) {
+
+	fake, svc := newFake(t)
+
+	var tests = []struct {
+		call     func() error
+		expected string
+	}{
+		{func() error {
+			_, err := svc.DescribeVolumes(&ec2.DescribeVolumesInput{VolumeIds: aws.StringSlice([]string{"vol-33333333"})})
+			return err
+		}, "InvalidVolume.NotFound"},
+		{func() error {
+			_, err := svc.AttachVolume(&ec2.AttachVolumeInput{
+				VolumeId: aws.String("vol-22222222"), InstanceId: aws.String("i-0123456789abcdef0"), Device: aws.String("/dev/sdf"),
+			})
+			return err
+		}, "InvalidVolume.ZoneMismatch"},
+		{func() error {
+			_, err := svc.AttachVolume(&ec2.AttachVolumeInput{
+				VolumeId: aws.String("vol-11111111"), InstanceId: aws.String("i-0123456789abcdef0"), Device: aws.String("/dev/xvda"),
+			})
+			return err
+		}, "InvalidParameterValue"},
+		{func() error {
+			_, err := svc.AttachVolume(&ec2.AttachVolumeInput{DryRun: aws.Bool(true),
+				VolumeId: aws.String("vol-11111111"), InstanceId: aws.String("i-0123456789abcdef0"), Device: aws.String("/dev/sdf"),
+			})
+			return err
+		}, "DryRunOperation"},
+		{func() error {
+			_, err := svc.DetachVolume(&ec2.DetachVolumeInput{VolumeId: aws.String("vol-11111111")})
+			return err
+		}, "IncorrectState"},
+		{func() error {
+			fake.Fail("DescribeTags", &ec2fake.Error{StatusCode: 503, Code: "RequestLimitExceeded", Message: "Request limit exceeded."})
+			_, err := svc.DescribeTags(&ec2.DescribeTagsInput{})
+			return err
+		}, "RequestLimitExceeded"},
+	}
+
+	for i, tt := range tests {
+		if aerr, ok := tt.call().(awserr.Error); !ok || aerr.Code() != tt.expected {
+			t.Errorf("Test %d expected a %s error, but got %v", i, tt.expected, aerr)
+		}
+	}
+
+	if _, err := svc.DescribeTags(&ec2.DescribeTagsInput{}); err != nil {
+		t.Errorf("Only the next call should have failed, but I got %v", err)
+	}
+}
+
+func TestTagsAndFilters(t *testing.T) {
+
+	fake, svc := newFake(t)
+
+	_, err := svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: aws.StringSlice([]string{"i-0123456789abcdef0"}),
+		Tags:      []*ec2.Tag{{Key: aws.String("volume_/dev/sdf"), Value: aws.String("vol-11111111")}},
+	})
+
+	if err != nil {
+		t.Fatalf("Creating tags shouldn't have failed, but I got %v", err)
+	}
+
+	tags, err := svc.DescribeTags(&ec2.DescribeTagsInput{Filters: []*ec2.Filter{
+		{Name: aws.String("resource-id"), Values: aws.StringSlice([]string{"i-0123456789abcdef0"})},
+	}})
+
+	if err != nil || len(tags.Tags) != 2 || *tags.Tags[1].Key != "volume_/dev/sdf" || *tags.Tags[1].ResourceType != "instance" {
+		t.Errorf("Expected the instance tags, but got %v, %v", tags, err)
+	}
+
+	volumes, err := svc.DescribeVolumes(&ec2.DescribeVolumesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("tag:pool"), Values: aws.StringSlice([]string{"db"})},
+	}})
+
+	if err != nil || len(volumes.Volumes) != 1 || *volumes.Volumes[0].VolumeId != "vol-11111111" {
+		t.Errorf("Expected the volume tagged for the pool, but got %v, %v", volumes, err)
+	}
+
+	_, err = svc.DeleteTags(&ec2.DeleteTagsInput{
+		Resources: aws.StringSlice([]string{"vol-11111111"}),
+		Tags:      []*ec2.Tag{{Key: aws.String("pool")}},
+	})
+
+	if err != nil || len(fake.Tags("vol-11111111")) != 0 {
+		t.Errorf("Expected the volume tag to be deleted, but got %v, %v", fake.Tags("vol-11111111"), err)
+	}
+
+	instances, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("tag:role"), Values: aws.StringSlice([]string{"db"})},
+	}})
+
+	if err != nil || len(instances.Reservations) != 1 || *instances.Reservations[0].Instances[0].Placement.AvailabilityZone != "erewhona" {
+		t.Errorf("Expected the instance with the role, but got %v, %v", instances, err)
+	}
+}
+
+func TestModification(t *testing.T) {
+
+	fake, svc := newFake(t)
+
+	_, err := svc.ModifyVolume(&ec2ext.ModifyVolumeInput{VolumeId: aws.String("vol-11111111"), Size: aws.Int64(20), VolumeType: aws.String("gp3")})
+
+	if err != nil {
+		t.Fatalf("Modifying shouldn't have failed, but I got %v", err)
+	}
+
+	resp, err := svc.DescribeVolumesModifications(&ec2ext.DescribeVolumesModificationsInput{VolumeIds: aws.StringSlice([]string{"vol-11111111"})})
+
+	if err != nil || *resp.VolumesModifications[0].ModificationState != "completed" || *resp.VolumesModifications[0].TargetSize != 20 {
+		t.Fatalf("Expected the modification to have completed, but got %v, %v", resp, err)
+	}
+
+	if volume := fake.Volume("vol-11111111"); *volume.Size != 20 || *volume.VolumeType != "gp3" {
+		t.Errorf("Expected the volume to have been modified, but got %v", volume)
+	}
+}
+
+func TestInstanceMetadata(t *testing.T) {
+
+	fake, _ := newFake(t)
+
+	client := imds.New(imds.WithEndpoint(fake.MetadataEndpoint()))
+
+	instanceID, err := client.InstanceID()
+
+	if err != nil || instanceID != "i-0123456789abcdef0" {
+		t.Errorf("Expected the instance ID, but got %s, %v", instanceID, err)
+	}
+
+	region, err := client.Region()
+
+	if err != nil || region != "erewhon" {
+		t.Errorf("Expected the region, but got %s, %v", region, err)
+	}
+
+	if _, err := client.GetMetadata("tags/instance"); err == nil {
+		t.Error("Tags shouldn't be in the instance metadata unless allowed")
+	}
+}
+
+func TestScenarioStepsAreTakenInTurn(t *testing.T) {
+
+	fake, svc := newFake(t)
+
+	fake.Script(ec2fake.Scenario{
+		Action:   "DescribeVolumes",
+		VolumeID: "vol-11111111",
+		Steps: []ec2fake.Step{
+			{Times: 2, Err: ec2fake.Throttled()},
+			{},
+			{Err: ec2fake.IncorrectState("vol-11111111", "creating")},
+		},
+	})
+
+	describe := func(volumeID string) string {
+
+		_, err := svc.DescribeVolumes(&ec2.DescribeVolumesInput{VolumeIds: aws.StringSlice([]string{volumeID})})
+
+		if aerr, ok := err.(awserr.Error); ok {
+			return aerr.Code()
+		}
+
+		return "ok"
+	}
+
+	expected := []string{"ok", "RequestLimitExceeded", "RequestLimitExceeded", "ok", "IncorrectState", "ok"}
+
+	for i, want := range expected {
+
+		volumeID := "vol-11111111"
+
+		if i == 0 {
+			volumeID = "vol-22222222"
+		}
+
+		if got := describe(volumeID); got != want {
+			t.Errorf("Call %d expected %s, but got %s", i, want, got)
+		}
+	}
+}
+
+func TestScenarioTransitionsVolume(t *testing.T) {
+
+	fake, svc := newFake(t)
+
+	// The attach takes effect, though the response is lost
+	fake.Script(ec2fake.Scenario{
+		Action:   "AttachVolume",
+		VolumeID: "vol-11111111",
+		Steps:    []ec2fake.Step{{Err: &ec2fake.Error{StatusCode: 500, Code: "InternalError", Message: "An internal error has occurred"}, Transition: "attached"}},
+	})
+
+	_, err := svc.AttachVolume(&ec2.AttachVolumeInput{
+		VolumeId: aws.String("vol-11111111"), InstanceId: aws.String("i-0123456789abcdef0"), Device: aws.String("/dev/sdf"),
+	})
+
+	if err == nil {
+		t.Error("Attaching should have failed")
+	}
+
+	volume := fake.Volume("vol-11111111")
+
+	if *volume.State != ec2.VolumeStateInUse || *volume.Attachments[0].Device != "/dev/sdf" || *volume.Attachments[0].State != "attached" {
+		t.Errorf("Expected the volume to be attached, but got %v", volume)
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+
+	fake, _ := newFake(t)
+	fake.AddRole(ec2fake.Role{ARN: "arn:aws:iam::123456789012:role/ebs-volumes", ExternalID: "secret-handshake"})
+
+	svc := sts.New(session.New(fake.AWSConfig()))
+
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String("arn:aws:iam::123456789012:role/ebs-volumes"),
+		RoleSessionName: aws.String("test"),
+		ExternalId:      aws.String("wrong"),
+	}
+
+	if _, err := svc.AssumeRole(input); err == nil || err.(awserr.Error).Code() != "AccessDenied" {
+		t.Errorf("Expected the wrong external ID to be denied, but got %v", err)
+	}
+
+	input.ExternalId = aws.String("secret-handshake")
+
+	resp, err := svc.AssumeRole(input)
+
+	if err != nil {
+		t.Fatalf("Assuming the role shouldn't have failed, but I got %v", err)
+	}
+
+	if arn := aws.StringValue(resp.AssumedRoleUser.Arn); arn != "arn:aws:sts::123456789012:assumed-role/ebs-volumes/test" {
+		t.Errorf("Unexpected assumed role ARN %s", arn)
+	}
+
+	sessions := fake.AssumedRoles()
+
+	if len(sessions) != 1 || aws.StringValue(resp.Credentials.AccessKeyId) != sessions[0].AccessKeyID || !resp.Credentials.Expiration.Equal(sessions[0].Expiration) {
+		t.Errorf("Expected the session %v to be recorded, but got %v", resp.Credentials, sessions)
+	}
+
+	if key := fake.AccessKey("AssumeRole"); key != "AKIDEC2FAKE" {
+		t.Errorf("Expected the role to be assumed with the fake's credentials, but it was signed by %s", key)
+	}
+}
+
+func TestGetCallerIdentity(t *testing.T) {
+
+	fake, _ := newFake(t)
+	fake.AddRole(ec2fake.Role{ARN: "arn:aws:iam::123456789012:role/ebs-volumes"})
+
+	svc := sts.New(session.New(fake.AWSConfig()))
+
+	identity, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+
+	if err != nil {
+		t.Fatalf("Getting the caller identity shouldn't have failed, but I got %v", err)
+	}
+
+	if aws.StringValue(identity.Arn) != ec2fake.CallerARN || aws.StringValue(identity.Account) != ec2fake.AccountID {
+		t.Errorf("Expected the fake's own identity, but got %v", identity)
+	}
+
+	resp, err := svc.AssumeRole(&sts.AssumeRoleInput{RoleArn: aws.String("arn:aws:iam::123456789012:role/ebs-volumes"), RoleSessionName: aws.String("test")})
+
+	if err != nil {
+		t.Fatalf("Assuming the role shouldn't have failed, but I got %v", err)
+	}
+
+	creds := resp.Credentials
+	assumed := sts.New(session.New(fake.AWSConfig(), aws.NewConfig().WithCredentials(
+		credentials.NewStaticCredentials(*creds.AccessKeyId, *creds.SecretAccessKey, *creds.SessionToken))))
+
+	if identity, err = assumed.GetCallerIdentity(&sts.GetCallerIdentityInput{}); err != nil || aws.StringValue(identity.Arn) != "arn:aws:sts::123456789012:assumed-role/ebs-volumes/test" {
+		t.Errorf("Expected the identity of the assumed role, but got %v, %v", identity, err)
+	}
+}
+
+func TestOnlyPermittedActionsAreAllowed(t *testing.T) {
+
+	fake, svc := newFake(t)
+
+	fake.Permit("ec2:DescribeVolumes")
+
+	if _, err := svc.DescribeVolumes(&ec2.DescribeVolumesInput{}); err != nil {
+		t.Errorf("DescribeVolumes should have been permitted, but I got %v", err)
+	}
+
+	_, err := svc.DescribeVolumesModifications(&ec2ext.DescribeVolumesModificationsInput{DryRun: aws.Bool(true)})
+
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "UnauthorizedOperation" {
+		t.Errorf("DescribeVolumesModifications shouldn't have been permitted, but I got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package ec2fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// metadataToken is the only session token the instance metadata service issues
+const metadataToken = "ec2fake-token"
+
+const (
+	instanceTagsPath = "/latest/meta-data/tags/instance"
+	identityPath     = "/latest/dynamic/instance-identity/document"
+)
+
+// serveMetadata answers instance metadata requests for the chosen instance, requiring a session token (IMDSv2)
+func (s *Server) serveMetadata(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" {
+		w.Write([]byte(metadataToken))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Header.Get("X-aws-ec2-metadata-token") != metadataToken {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instance, ok := s.instances[s.metadataInstance]
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch p := r.URL.Path; {
+	case p == identityPath:
+		json.NewEncoder(w).Encode(map[string]string{
+			"accountId":        AccountID,
+			"instanceId":       instance.ID,
+			"region":           s.region,
+			"availabilityZone": instance.AvailabilityZone,
+		})
+	case p == "/latest/meta-data/instance-id":
+		w.Write([]byte(instance.ID))
+	case p == "/latest/meta-data/placement/availability-zone":
+		w.Write([]byte(instance.AvailabilityZone))
+	case p == "/latest/meta-data/block-device-mapping/root":
+		w.Write([]byte(instance.RootDeviceName))
+	case p == instanceTagsPath && instance.TagsInMetadata:
+		w.Write([]byte(strings.Join(sortedKeys(instance.Tags), "\n")))
+	case strings.HasPrefix(p, instanceTagsPath+"/") && instance.TagsInMetadata:
+		value, ok := instance.Tags[p[len(instanceTagsPath)+1:]]
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(value))
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,195 @@
+package ec2fake
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Scenario scripts the responses to calls of an action, such as to reproduce throttling or eventual
+// consistency. Successive calls matching the scenario take successive steps, and once the steps are
+// used up calls are answered as usual. When more than one scenario matches a call, the first
+// scripted is used.
+type Scenario struct {
+	// Action is the EC2 action scripted, such as DescribeVolumes
+	Action string
+	// VolumeID restricts the scenario to calls about a volume, being those naming it and DescribeVolumes
+	// calls describing every volume
+	VolumeID string
+	Steps    []Step
+}
+
+// Step is a scripted response to calls. A step setting nothing answers calls as usual.
+type Step struct {
+	// Times is how many calls the step answers, or one when not given
+	Times int
+	// Err fails the calls with an error, without them having any other effect
+	Err *Error
+	// Stale describes the scenario's volume as it was before the last call changing it, as eventually
+	// consistent reads may. Stale steps aren't taken until the volume has been changed.
+	Stale bool
+	// Hold keeps the scenario's volume in the state it's in, however long it's been there
+	Hold bool
+	// Transition moves the scenario's volume to a state once the calls are answered, which is an attachment
+	// state (attaching, attached or detaching) or available. The instance and device of a new attachment
+	// are those given by the call.
+	Transition string
+}
+
+// scenario is a scripted scenario, and how far through its steps it is
+type scenario struct {
+	Scenario
+	step  int
+	calls int
+}
+
+// Script adds scenarios, which are played out as calls are made
+func (s *Server) Script(scenarios ...Scenario) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sc := range scenarios {
+
+		for i := range sc.Steps {
+			if err := sc.Steps[i].Err; err != nil && err.StatusCode == 0 {
+				err.StatusCode = 400
+			}
+		}
+
+		s.scenarios = append(s.scenarios, &scenario{Scenario: sc})
+	}
+}
+
+// Fail makes the next call to an action fail with an error. Errors given for the same action are
+// returned by successive calls.
+func (s *Server) Fail(action string, err *Error) {
+	s.Script(Scenario{Action: action, Steps: []Step{{Err: err}}})
+}
+
+// Throttled returns the error EC2 gives when requests are throttled
+func Throttled() *Error {
+	return &Error{StatusCode: 503, Code: "RequestLimitExceeded", Message: "Request limit exceeded."}
+}
+
+// IncorrectState returns the error EC2 gives when a volume isn't in a state allowing the call
+func IncorrectState(volumeID string, state string) *Error {
+	return &Error{StatusCode: 400, Code: "IncorrectState", Message: fmt.Sprintf("Volume '%s' is in the '%s' state.", volumeID, state)}
+}
+
+// Unauthorized returns the error EC2 gives when a call, or a dry run of it, isn't permitted
+func Unauthorized() *Error {
+	return &Error{StatusCode: 403, Code: "UnauthorizedOperation", Message: "You are not authorized to perform this operation."}
+}
+
+// Throttling throttles the next calls to an action
+func Throttling(action string, calls int) Scenario {
+	return Scenario{Action: action, Steps: []Step{{Times: calls, Err: Throttled()}}}
+}
+
+// StaleReads describes a volume as it was before it last changed for the next describes of it
+func StaleReads(volumeID string, describes int) Scenario {
+	return Scenario{Action: "DescribeVolumes", VolumeID: volumeID, Steps: []Step{{Times: describes, Stale: true}}}
+}
+
+// Stuck keeps a volume in the state it's in, such as detaching, for the next describes of it
+func Stuck(volumeID string, describes int) Scenario {
+	return Scenario{Action: "DescribeVolumes", VolumeID: volumeID, Steps: []Step{{Times: describes, Hold: true}}}
+}
+
+// call is what the scenarios script for the call being answered
+type call struct {
+	step     *Step
+	volumeID string
+}
+
+// nextStep takes the next step of the first scenario matching a call, returning nil when there's none
+func (s *Server) nextStep(action string, form url.Values) *call {
+
+	for _, sc := range s.scenarios {
+
+		if sc.Action != action || sc.step >= len(sc.Steps) || sc.VolumeID != "" && !about(sc.VolumeID, action, form) {
+			continue
+		}
+
+		step := &sc.Steps[sc.step]
+
+		if v, ok := s.volumes[sc.VolumeID]; step.Stale && (!ok || v.previous == nil) {
+			continue
+		}
+
+		times := step.Times
+
+		if times == 0 {
+			times = 1
+		}
+
+		if sc.calls++; sc.calls >= times {
+			sc.step, sc.calls = sc.step+1, 0
+		}
+
+		return &call{step: step, volumeID: sc.VolumeID}
+	}
+
+	return nil
+}
+
+// about returns true if a call is about a volume
+func about(volumeID string, action string, form url.Values) bool {
+
+	named := append(list(form, "VolumeId"), list(form, "ResourceId")...)
+
+	if id := form.Get("VolumeId"); id != "" {
+		named = append(named, id)
+	}
+
+	if action == "DescribeVolumes" && len(named) == 0 {
+		return true
+	}
+
+	for _, id := range named {
+		if id == volumeID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// holds returns true if the call holds a volume in the state it's in
+func (c *call) holds(v *volume) bool {
+	return c != nil && c.step.Hold && c.volumeID == v.ID
+}
+
+// stale returns true if the call describes a volume as it was before it last changed
+func (c *call) stale(v *volume) bool {
+	return c != nil && c.step.Stale && c.volumeID == v.ID && v.previous != nil
+}
+
+// transition moves the call's volume to the state scripted for it
+func (s *Server) transition(c *call, form url.Values) {
+
+	if c == nil || c.step.Transition == "" || c.volumeID == "" {
+		return
+	}
+
+	v, ok := s.volumes[c.volumeID]
+
+	if !ok {
+		return
+	}
+
+	v.remember()
+
+	if c.step.Transition == ec2.VolumeStateAvailable {
+		v.attachment = nil
+		return
+	}
+
+	if v.attachment == nil {
+		v.attachment = &attachment{instanceID: form.Get("InstanceId"), device: form.Get("Device")}
+	}
+
+	v.attachment.status, v.attachment.changed = c.step.Transition, s.now()
+}
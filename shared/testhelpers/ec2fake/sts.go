@@ -0,0 +1,218 @@
+package ec2fake
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stsNamespace is the XML namespace of STS Query API responses
+const stsNamespace = "https://sts.amazonaws.com/doc/2011-06-15/"
+
+// stsActions are the STS Query API operations the fake answers, at the same endpoint as EC2
+var stsActions = map[string]func(*Server, url.Values) (interface{}, *Error){
+	"AssumeRole":        (*Server).assumeRole,
+	"GetCallerIdentity": (*Server).getCallerIdentity,
+}
+
+// CallerARN is the identity of requests which aren't signed by an assumed role
+const CallerARN = "arn:aws:iam::" + AccountID + ":user/ec2fake"
+
+// Role is a role which can be assumed
+type Role struct {
+	ARN string
+	// ExternalID must be given when assuming the role, if set
+	ExternalID string
+}
+
+// AssumedRole is a session of an assumed role
+type AssumedRole struct {
+	RoleARN     string
+	SessionName string
+	ExternalID  string
+	AccessKeyID string
+	Expiration  time.Time
+}
+
+// AddRole adds a role which can be assumed
+func (s *Server) AddRole(role Role) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[role.ARN] = &role
+}
+
+// AssumedRoles returns the sessions of roles assumed, earliest first
+func (s *Server) AssumedRoles() []AssumedRole {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AssumedRole(nil), s.sessions...)
+}
+
+// AccessKey returns the access key ID signing the last call to an action
+func (s *Server) AccessKey(action string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accessKeys[action]
+}
+
+// accessKey returns the access key ID a request was signed with
+func accessKey(r *http.Request) string {
+
+	authorization := r.Header.Get("Authorization")
+	start := strings.Index(authorization, "Credential=")
+
+	if start < 0 {
+		return ""
+	}
+
+	credential := authorization[start+len("Credential="):]
+
+	if end := strings.Index(credential, "/"); end >= 0 {
+		return credential[:end]
+	}
+
+	return credential
+}
+
+// serveSTS answers an STS action
+func (s *Server) serveSTS(w http.ResponseWriter, action string, form url.Values) {
+
+	if s.current != nil && s.current.step.Err != nil {
+		s.writeSTSError(w, s.current.step.Err)
+		return
+	}
+
+	body, err := stsActions[action](s, form)
+
+	if err != nil {
+		s.writeSTSError(w, err)
+		return
+	}
+
+	s.requests++
+
+	w.Header().Set("Content-Type", "text/xml")
+
+	response := stsResponse{Result: body, RequestID: fmt.Sprintf("ec2fake-%d", s.requests)}
+	start := xml.StartElement{Name: xml.Name{Space: stsNamespace, Local: action + "Response"}}
+
+	if err := xml.NewEncoder(w).EncodeElement(response, start); err != nil {
+		panic(fmt.Sprintf("unable to encode %s response : %v", action, err))
+	}
+}
+
+type stsErrorResponse struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Type      string   `xml:"Error>Type"`
+	Code      string   `xml:"Error>Code"`
+	Message   string   `xml:"Error>Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func (s *Server) writeSTSError(w http.ResponseWriter, err *Error) {
+
+	s.requests++
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(err.StatusCode)
+
+	xml.NewEncoder(w).Encode(&stsErrorResponse{Type: "Sender", Code: err.Code, Message: err.Message, RequestID: fmt.Sprintf("ec2fake-%d", s.requests)})
+}
+
+// Responses, named as the STS Query API names them
+
+type stsResponse struct {
+	Result    interface{}
+	RequestID string `xml:"ResponseMetadata>RequestId"`
+}
+
+type credentialsItem struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+type assumeRoleResult struct {
+	XMLName       xml.Name        `xml:"AssumeRoleResult"`
+	Credentials   credentialsItem `xml:"Credentials"`
+	AssumedRoleID string          `xml:"AssumedRoleUser>AssumedRoleId"`
+	Arn           string          `xml:"AssumedRoleUser>Arn"`
+}
+
+func (s *Server) assumeRole(form url.Values) (interface{}, *Error) {
+
+	roleARN, sessionName, externalID := form.Get("RoleArn"), form.Get("RoleSessionName"), form.Get("ExternalId")
+
+	role, ok := s.roles[roleARN]
+
+	if !ok || role.ExternalID != externalID {
+		return nil, &Error{StatusCode: 403, Code: "AccessDenied", Message: fmt.Sprintf("User is not authorized to perform: sts:AssumeRole on resource: %s", roleARN)}
+	}
+
+	duration := 3600
+
+	if seconds := form.Get("DurationSeconds"); seconds != "" {
+		duration, _ = strconv.Atoi(seconds)
+	}
+
+	session := AssumedRole{
+		RoleARN:     roleARN,
+		SessionName: sessionName,
+		ExternalID:  externalID,
+		AccessKeyID: fmt.Sprintf("ASIAEC2FAKE%d", len(s.sessions)+1),
+		Expiration:  s.now().Add(time.Duration(duration) * time.Second).UTC(),
+	}
+
+	s.sessions = append(s.sessions, session)
+
+	return &assumeRoleResult{
+		Credentials: credentialsItem{
+			AccessKeyID:     session.AccessKeyID,
+			SecretAccessKey: "secret",
+			SessionToken:    "ec2fake-session-token",
+			Expiration:      session.Expiration,
+		},
+		AssumedRoleID: "AROAEC2FAKE:" + sessionName,
+		Arn:           assumedRoleARN(roleARN, sessionName),
+	}, nil
+}
+
+// assumedRoleARN returns the ARN of a session of a role, so arn:partition:iam::account:role/path/name becomes
+// arn:partition:sts::account:assumed-role/name/session
+func assumedRoleARN(roleARN string, sessionName string) string {
+
+	parts := strings.SplitN(roleARN, ":", 6)
+
+	if len(parts) != 6 {
+		return roleARN
+	}
+
+	roleName := parts[5][strings.LastIndex(parts[5], "/")+1:]
+
+	return fmt.Sprintf("arn:%s:sts::%s:assumed-role/%s/%s", parts[1], parts[4], roleName, sessionName)
+}
+
+type getCallerIdentityResult struct {
+	XMLName xml.Name `xml:"GetCallerIdentityResult"`
+	Arn     string   `xml:"Arn"`
+	UserID  string   `xml:"UserId"`
+	Account string   `xml:"Account"`
+}
+
+func (s *Server) getCallerIdentity(form url.Values) (interface{}, *Error) {
+
+	key := s.accessKeys["GetCallerIdentity"]
+
+	for _, session := range s.sessions {
+		if session.AccessKeyID == key {
+			return &getCallerIdentityResult{Arn: assumedRoleARN(session.RoleARN, session.SessionName), UserID: key, Account: AccountID}, nil
+		}
+	}
+
+	return &getCallerIdentityResult{Arn: CallerARN, UserID: key, Account: AccountID}, nil
+}
@@ -6,20 +6,34 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
 	"github.com/sneakybeaky/ebs-volumes/shared/iface"
 )
 
 // MockMetadata enables plugable behaviour for testing
 type MockMetadata struct {
 	iface.Metadata
-	instanceID string
-	region     string
+	instanceID       string
+	region           string
+	availabilityZone string
+	rootDeviceName   string
 }
 
-// NewMockMetadata returns a new MockMetadata instance
+// NewMockMetadata returns a new MockMetadata instance, in availability zone 'a' of the region
 func NewMockMetadata(instanceID string, region string) *MockMetadata {
-	return &MockMetadata{instanceID: instanceID, region: region}
+	return &MockMetadata{instanceID: instanceID, region: region, availabilityZone: region + "a"}
+}
+
+// SetAvailabilityZone sets the availability zone
+func (m *MockMetadata) SetAvailabilityZone(availabilityZone string) *MockMetadata {
+	m.availabilityZone = availabilityZone
+	return m
+}
+
+// SetRootDeviceName sets the name of the root device, which is unknown by default
+func (m *MockMetadata) SetRootDeviceName(rootDeviceName string) *MockMetadata {
+	m.rootDeviceName = rootDeviceName
+	return m
 }
 
 // InstanceID returns the instance id
@@ -27,21 +41,37 @@ func (m *MockMetadata) InstanceID() (string, error) {
 	return m.instanceID, nil
 }
 
-// Region returns the instance id
+// Region returns the region
 func (m *MockMetadata) Region() (string, error) {
 	return m.region, nil
 }
 
+// AvailabilityZone returns the availability zone
+func (m *MockMetadata) AvailabilityZone() (string, error) {
+	return m.availabilityZone, nil
+}
+
+// RootDeviceName returns the name of the root device
+func (m *MockMetadata) RootDeviceName() (string, error) {
+	return m.rootDeviceName, nil
+}
+
 // MockEC2Service enables plugable behaviour for testing
 type MockEC2Service struct {
-	ec2iface.EC2API
-	AttachVolumeFunc             func(*ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error)
-	DescribeTagsFunc             func(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error)
-	DetachVolumeFunc             func(*ec2.DetachVolumeInput) (*ec2.VolumeAttachment, error)
-	DescribeVolumesRequestFunc   func(*ec2.DescribeVolumesInput) (*request.Request, *ec2.DescribeVolumesOutput)
-	WaitUntilVolumeAvailableFunc func(*ec2.DescribeVolumesInput) error
-	WaitUntilVolumeInUseFunc     func(*ec2.DescribeVolumesInput) error
-	DescribeVolumesFunc          func(*ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error)
+	ec2ext.EC2API
+	AttachVolumeFunc                 func(*ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error)
+	DescribeTagsFunc                 func(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error)
+	DetachVolumeFunc                 func(*ec2.DetachVolumeInput) (*ec2.VolumeAttachment, error)
+	DescribeVolumesRequestFunc       func(*ec2.DescribeVolumesInput) (*request.Request, *ec2.DescribeVolumesOutput)
+	WaitUntilVolumeAvailableFunc     func(*ec2.DescribeVolumesInput) error
+	WaitUntilVolumeInUseFunc         func(*ec2.DescribeVolumesInput) error
+	DescribeVolumesFunc              func(*ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error)
+	ModifyVolumeFunc                 func(*ec2ext.ModifyVolumeInput) (*ec2ext.ModifyVolumeOutput, error)
+	DescribeVolumesModificationsFunc func(*ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error)
+	DescribeVolumesPerformanceFunc   func(*ec2.DescribeVolumesInput) (*ec2ext.DescribeVolumesPerformanceOutput, error)
+	DescribeInstancesFunc            func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	CreateTagsFunc                   func(*ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error)
+	DeleteTagsFunc                   func(*ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error)
 }
 
 // NewMockEC2Service returns a new instance of NewMockEC2Service
@@ -84,6 +114,37 @@ func (svc *MockEC2Service) DescribeVolumes(input *ec2.DescribeVolumesInput) (*ec
 	return svc.DescribeVolumesFunc(input)
 }
 
+// ModifyVolume pass through that calls the ModifyVolumeFunc on the mock
+func (svc *MockEC2Service) ModifyVolume(input *ec2ext.ModifyVolumeInput) (*ec2ext.ModifyVolumeOutput, error) {
+	return svc.ModifyVolumeFunc(input)
+}
+
+// DescribeVolumesModifications pass through that calls the DescribeVolumesModificationsFunc on the mock
+func (svc *MockEC2Service) DescribeVolumesModifications(input *ec2ext.DescribeVolumesModificationsInput) (*ec2ext.DescribeVolumesModificationsOutput, error) {
+	return svc.DescribeVolumesModificationsFunc(input)
+}
+
+// DescribeVolumesPerformance pass through that calls the DescribeVolumesPerformanceFunc on the mock
+func (svc *MockEC2Service) DescribeVolumesPerformance(input *ec2.DescribeVolumesInput) (*ec2ext.DescribeVolumesPerformanceOutput, error) {
+	return svc.DescribeVolumesPerformanceFunc(input)
+}
+
+// DescribeInstances pass through that calls the DescribeInstancesFunc on the mock
+func (svc *MockEC2Service) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return svc.DescribeInstancesFunc(input)
+}
+
+
+// CreateTags pass through that calls the CreateTagsFunc on the mock
+func (svc *MockEC2Service) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	return svc.CreateTagsFunc(input)
+}
+
+// DeleteTags pass through that calls the DeleteTagsFunc on the mock
+func (svc *MockEC2Service) DeleteTags(input *ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
+	return svc.DeleteTagsFunc(input)
+}
+
 //DescribeVolumeTagsForInstance returns a function that returns a canned response for a given instanceId
 func DescribeVolumeTagsForInstance(instanceID string, output *ec2.DescribeTagsOutput) func(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
 	return func(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
@@ -168,3 +229,16 @@ func DescribeVolumeForID(volumeID string, output *ec2.DescribeVolumesOutput) fun
 
 	}
 }
+
+//DescribeVolumePerformanceForID returns a function that returns the supplied output for the supplied volume id otherwise a non nil error
+func DescribeVolumePerformanceForID(volumeID string, output *ec2ext.DescribeVolumesPerformanceOutput) func(input *ec2.DescribeVolumesInput) (*ec2ext.DescribeVolumesPerformanceOutput, error) {
+	return func(input *ec2.DescribeVolumesInput) (*ec2ext.DescribeVolumesPerformanceOutput, error) {
+
+		if *input.VolumeIds[0] == volumeID {
+			return output, nil
+		}
+
+		return nil, fmt.Errorf("Unexpected volume id %s", *input.VolumeIds[0])
+
+	}
+}
@@ -0,0 +1,277 @@
+package shared
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
+)
+
+// Severity describes how serious a validation finding is
+type Severity int
+
+// Finding severities, from least to most serious
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "INFO"
+	case SeverityWarning:
+		return "WARNING"
+	default:
+		return "ERROR"
+	}
+}
+
+// Finding is the result of a single validation check
+type Finding struct {
+	Severity   Severity
+	VolumeID   string
+	DeviceName string
+	Message    string
+}
+
+func (f Finding) String() string {
+	if f.VolumeID == "" && f.DeviceName == "" {
+		return fmt.Sprintf("%-7s %s", f.Severity, f.Message)
+	}
+	return fmt.Sprintf("%-7s Volume ID (%s), Device Name (%s) : %s", f.Severity, f.VolumeID, f.DeviceName, f.Message)
+}
+
+// Device names EC2 accepts when attaching volumes, by virtualization type
+var deviceNamePatterns = map[string]*regexp.Regexp{
+	ec2.VirtualizationTypeHvm:         regexp.MustCompile(`^/dev/(sd[b-z]|xvd[b-z]|xvd[b-c][a-z])$`),
+	ec2.VirtualizationTypeParavirtual: regexp.MustCompile(`^/dev/sd[b-z]([1-9]|1[0-5])?$`),
+}
+
+// Error codes returned by EC2 for dry run requests
+const (
+	dryRunPermittedCode    = "DryRunOperation"
+	dryRunUnauthorizedCode = "UnauthorizedOperation"
+)
+
+// Validate checks the volumes designated via tags could be attached, detached and modified,
+// without changing anything. An error is only returned if the checks couldn't be made.
+func (e EC2Instance) Validate() ([]Finding, error) {
+
+	var findings []Finding
+
+	volumes, problems, err := e.parseAllocatedVolumes()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find allocated volumes : %w", err)
+	}
+
+	for _, problem := range problems {
+		findings = append(findings, Finding{Severity: SeverityWarning, Message: fmt.Sprintf("ignored %v", problem)})
+	}
+
+	if len(volumes) == 0 {
+		findings = append(findings, Finding{Severity: SeverityInfo, Message: "no volumes are allocated to this instance"})
+		return findings, nil
+	}
+
+	findings = append(findings, duplicateVolumeFindings(volumes)...)
+
+	instance, err := e.describeInstance()
+	if err != nil {
+		findings = append(findings, Finding{Severity: SeverityWarning,
+			Message: fmt.Sprintf("device names not checked as the instance couldn't be described : %v", err)})
+	}
+
+	for _, volume := range volumes {
+		if instance != nil {
+			findings = append(findings, volume.deviceFindings(instance)...)
+		}
+		findings = append(findings, volume.Validate()...)
+	}
+
+	return findings, nil
+}
+
+// describeInstance returns the EC2 description of this instance
+func (e EC2Instance) describeInstance() (*ec2.Instance, error) {
+
+	instanceID, err := e.metadata.InstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.svc.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reservation := range resp.Reservations {
+		for _, instance := range reservation.Instances {
+			return instance, nil
+		}
+	}
+
+	return nil, fmt.Errorf("instance (%s) not found", instanceID)
+}
+
+// duplicateVolumeFindings reports volumes allocated at more than one device
+func duplicateVolumeFindings(volumes []*AllocatedVolume) []Finding {
+
+	var findings []Finding
+	devices := make(map[string][]string)
+
+	for _, volume := range volumes {
+		devices[volume.VolumeID] = append(devices[volume.VolumeID], volume.DeviceName)
+	}
+
+	for _, volume := range volumes {
+		if len(devices[volume.VolumeID]) > 1 {
+			findings = append(findings, volume.finding(SeverityError,
+				fmt.Sprintf("volume is allocated at more than one device (%s)", strings.Join(devices[volume.VolumeID], ", "))))
+		}
+	}
+
+	return findings
+}
+
+// deviceFindings checks the device name is valid and free to use on the instance
+func (volume AllocatedVolume) deviceFindings(instance *ec2.Instance) []Finding {
+
+	var findings []Finding
+
+	virtualizationType := aws.StringValue(instance.VirtualizationType)
+
+	if pattern, ok := deviceNamePatterns[virtualizationType]; ok && !pattern.MatchString(volume.DeviceName) {
+		findings = append(findings, volume.finding(SeverityError,
+			fmt.Sprintf("device name is not valid for %s instances", virtualizationType)))
+	}
+
+	root := aws.StringValue(instance.RootDeviceName)
+
+	if collidesWithRoot(volume.DeviceName, root) {
+		findings = append(findings, volume.finding(SeverityError,
+			fmt.Sprintf("device name collides with the root device (%s)", root)))
+	}
+
+	for _, mapping := range instance.BlockDeviceMappings {
+		if aws.StringValue(mapping.DeviceName) != volume.DeviceName || mapping.Ebs == nil {
+			continue
+		}
+
+		if attached := aws.StringValue(mapping.Ebs.VolumeId); attached != volume.VolumeID {
+			findings = append(findings, volume.finding(SeverityError,
+				fmt.Sprintf("device is already in use by volume (%s)", attached)))
+		}
+	}
+
+	return findings
+}
+
+// Validate checks the volume exists, is in the right availability zone and that the
+// permissions needed to attach, detach and modify it have been granted
+func (volume AllocatedVolume) Validate() []Finding {
+
+	resp, err := volume.svc.DescribeVolumes(volume.describeVolumesInput())
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidVolume.NotFound" || err == nil && len(resp.Volumes) == 0 {
+		return []Finding{volume.finding(SeverityError, "volume not found")}
+	}
+
+	if err != nil {
+		return []Finding{volume.finding(SeverityError, fmt.Sprintf("unable to describe volume : %v", err))}
+	}
+
+	status := resp.Volumes[0]
+
+	var findings []Finding
+
+	if volume.availabilityZoneMismatch(status) {
+		findings = append(findings, volume.finding(SeverityError,
+			fmt.Sprintf("volume is in availability zone (%s) but the instance is in (%s)",
+				aws.StringValue(status.AvailabilityZone), volume.AvailabilityZone)))
+	}
+
+	if volume.authorization != nil {
+		if err := volume.authorization.check(status); err != nil {
+			findings = append(findings, volume.finding(SeverityError, err.Error()))
+		}
+	}
+
+	if volume.encryption != nil {
+		if err := volume.encryption.check(status); err != nil {
+			findings = append(findings, volume.finding(SeverityError, err.Error()))
+		} else {
+			findings = append(findings, volume.finding(SeverityInfo, "satisfies the encryption policy, "+volume.encryption.String()))
+		}
+	}
+
+	if lease := leaseFromTags(status.Tags); lease.Active() && lease.Owner != volume.InstanceID {
+		findings = append(findings, volume.finding(SeverityError, (&LeaseError{VolumeID: volume.VolumeID, Lease: lease}).Error()))
+	}
+
+	_, err = volume.svc.AttachVolume(&ec2.AttachVolumeInput{
+		DryRun:     aws.Bool(true),
+		Device:     aws.String(volume.DeviceName),
+		InstanceId: aws.String(volume.InstanceID),
+		VolumeId:   aws.String(volume.VolumeID),
+	})
+	findings = append(findings, volume.permissionFindings("ec2:AttachVolume", err)...)
+
+	_, err = volume.svc.DetachVolume(&ec2.DetachVolumeInput{
+		DryRun:     aws.Bool(true),
+		Device:     aws.String(volume.DeviceName),
+		InstanceId: aws.String(volume.InstanceID),
+		VolumeId:   aws.String(volume.VolumeID),
+	})
+	findings = append(findings, volume.permissionFindings("ec2:DetachVolume", err)...)
+
+	if volume.Size != 0 || !volume.Performance.IsZero() {
+		_, err = volume.svc.ModifyVolume(&ec2ext.ModifyVolumeInput{
+			DryRun:   aws.Bool(true),
+			VolumeId: aws.String(volume.VolumeID),
+		})
+		findings = append(findings, volume.permissionFindings("ec2:ModifyVolume", err)...)
+	}
+
+	return findings
+}
+
+// permissionFindings interprets the result of a dry run request
+func (volume AllocatedVolume) permissionFindings(action string, err error) []Finding {
+	return permissionFindings(Finding{VolumeID: volume.VolumeID, DeviceName: volume.DeviceName}, action, err)
+}
+
+// permissionFindings interprets the result of a dry run request needing a permission, with findings
+// about what the template finding describes
+func permissionFindings(template Finding, action string, err error) []Finding {
+
+	finding := func(severity Severity, message string) []Finding {
+		template.Severity, template.Message = severity, message
+		return []Finding{template}
+	}
+
+	aerr, ok := err.(awserr.Error)
+
+	switch {
+	case ok && aerr.Code() == dryRunPermittedCode:
+		return nil
+	case ok && aerr.Code() == dryRunUnauthorizedCode:
+		return finding(SeverityError, fmt.Sprintf("permission %s has not been granted", action))
+	case err == nil:
+		return finding(SeverityWarning, fmt.Sprintf("dry run of %s unexpectedly succeeded", action))
+	default:
+		return finding(SeverityWarning, fmt.Sprintf("unable to check permission %s : %v", action, err))
+	}
+}
+
+func (volume AllocatedVolume) finding(severity Severity, message string) Finding {
+	return Finding{Severity: severity, VolumeID: volume.VolumeID, DeviceName: volume.DeviceName, Message: message}
+}
@@ -0,0 +1,175 @@
+package shared
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sneakybeaky/ebs-volumes/shared/ec2ext"
+	"github.com/sneakybeaky/ebs-volumes/shared/testhelpers"
+)
+
+const (
+	goodVolume      = "vol-00000001"
+	twiceVolume     = "vol-00000002"
+	elsewhereVolume = "vol-00000003"
+	missingVolume   = "vol-00000004"
+	lockedVolume    = "vol-00000005"
+)
+
+func TestValidateFindings(t *testing.T) {
+
+	instanceID := "id-98765"
+	metadata := testhelpers.NewMockMetadata(instanceID, "erewhon")
+
+	mockEC2Service := newValidationMock(instanceID, map[string]string{
+		goodVolume:      "erewhona",
+		twiceVolume:     "erewhona",
+		elsewhereVolume: "erewhonb",
+		lockedVolume:    "erewhona",
+	})
+
+	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
+		testhelpers.NewDescribeTagsOutputBuilder().
+			WithVolume("/dev/sdf", instanceID, goodVolume).
+			WithVolume("/dev/sdg", instanceID, twiceVolume).
+			WithVolume("/dev/sdh", instanceID, twiceVolume).
+			WithVolume("/dev/sda", instanceID, elsewhereVolume).
+			WithVolume("/dev/sdk1", instanceID, missingVolume).
+			WithVolume("/dev/sdj", instanceID, lockedVolume).
+			WithSize("/dev/sdf", instanceID, "huge").Build())
+
+	mockEC2Service.AttachVolumeFunc = func(input *ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error) {
+		if *input.VolumeId == lockedVolume {
+			return nil, awserr.New(dryRunUnauthorizedCode, "not allowed", nil)
+		}
+		return nil, awserr.New(dryRunPermittedCode, "would have succeeded", nil)
+	}
+
+	underTest := NewEC2Instance(metadata, mockEC2Service)
+
+	findings, err := underTest.Validate()
+
+	if err != nil {
+		t.Fatalf("Validating shouldn't have failed, but I got %v", err)
+	}
+
+	expected := []struct {
+		severity Severity
+		volumeID string
+		message  string
+	}{
+		{SeverityWarning, "", "'huge' is not a size in GiB"},
+		{SeverityError, twiceVolume, "more than one device (/dev/sdg, /dev/sdh)"},
+		{SeverityError, elsewhereVolume, "collides with the root device (/dev/sda1)"},
+		{SeverityError, elsewhereVolume, "availability zone (erewhonb)"},
+		{SeverityError, missingVolume, "not valid for hvm instances"},
+		{SeverityError, missingVolume, "not found"},
+		{SeverityError, lockedVolume, "ec2:AttachVolume has not been granted"},
+	}
+
+	for _, e := range expected {
+		if !containsFinding(findings, e.severity, e.volumeID, e.message) {
+			t.Errorf("Expected %s finding for volume '%s' containing '%s', but got %v", e.severity, e.volumeID, e.message, findings)
+		}
+	}
+
+	for _, finding := range findings {
+		if finding.VolumeID == goodVolume {
+			t.Errorf("No findings were expected for %s, but got %s", goodVolume, finding)
+		}
+	}
+}
+
+func TestValidateWithoutInstanceDescription(t *testing.T) {
+
+	instanceID := "id-98765"
+	metadata := testhelpers.NewMockMetadata(instanceID, "erewhon")
+
+	mockEC2Service := newValidationMock(instanceID, map[string]string{goodVolume: "erewhona"})
+	mockEC2Service.DescribeTagsFunc = testhelpers.DescribeVolumeTagsForInstance(instanceID,
+		testhelpers.NewDescribeTagsOutputBuilder().WithVolume("/dev/sdf", instanceID, goodVolume).Build())
+	mockEC2Service.DescribeInstancesFunc = func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+		return nil, errors.New("whoops")
+	}
+
+	findings, err := NewEC2Instance(metadata, mockEC2Service).Validate()
+
+	if err != nil {
+		t.Fatalf("Validating shouldn't have failed, but I got %v", err)
+	}
+
+	if !containsFinding(findings, SeverityWarning, "", "device names not checked") {
+		t.Errorf("Expected a warning that device names weren't checked, but got %v", findings)
+	}
+}
+
+func TestValidateErrorsWhenTagsUnavailable(t *testing.T) {
+
+	metadata := testhelpers.NewMockMetadata("id-98765", "erewhon")
+
+	mockEC2Service := testhelpers.NewMockEC2Service()
+	mockEC2Service.DescribeTagsFunc = func(*ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+		return nil, errors.New("whoops")
+	}
+
+	if _, err := NewEC2Instance(metadata, mockEC2Service).Validate(); err == nil {
+		t.Error("Validating should have failed when tags couldn't be read")
+	}
+}
+
+func containsFinding(findings []Finding, severity Severity, volumeID string, message string) bool {
+	for _, finding := range findings {
+		if finding.Severity == severity && finding.VolumeID == volumeID && strings.Contains(finding.Message, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// newValidationMock returns a mock of an hvm instance with a root device of /dev/sda1, where the
+// volumes exist in the supplied availability zones and every dry run is permitted
+func newValidationMock(instanceID string, volumes map[string]string) *testhelpers.MockEC2Service {
+
+	permitted := awserr.New(dryRunPermittedCode, "would have succeeded", nil)
+
+	return &testhelpers.MockEC2Service{
+		DescribeInstancesFunc: func(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{
+					{
+						Instances: []*ec2.Instance{
+							{
+								InstanceId:         aws.String(instanceID),
+								VirtualizationType: aws.String(ec2.VirtualizationTypeHvm),
+								RootDeviceName:     aws.String("/dev/sda1"),
+							},
+						},
+					},
+				},
+			}, nil
+		},
+		DescribeVolumesFunc: func(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+			availabilityZone, ok := volumes[*input.VolumeIds[0]]
+			if !ok {
+				return nil, awserr.New("InvalidVolume.NotFound", "no such volume", nil)
+			}
+
+			return &ec2.DescribeVolumesOutput{
+				Volumes: []*ec2.Volume{testhelpers.NewVolumeBuilder().SetAvailabilityZone(availabilityZone).Build()},
+			}, nil
+		},
+		AttachVolumeFunc: func(input *ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error) {
+			return nil, permitted
+		},
+		DetachVolumeFunc: func(input *ec2.DetachVolumeInput) (*ec2.VolumeAttachment, error) {
+			return nil, permitted
+		},
+		ModifyVolumeFunc: func(input *ec2ext.ModifyVolumeInput) (*ec2ext.ModifyVolumeOutput, error) {
+			return nil, permitted
+		},
+	}
+}